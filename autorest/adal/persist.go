@@ -84,6 +84,14 @@ func SaveToken(path string, mode os.FileMode, token Token) error {
 	return nil
 }
 
+// SaveTokenOnRefresh returns a TokenRefreshCallback that persists every refreshed token at the given
+// location on disk with SaveToken, so that rotated refresh tokens are kept in sync with the file.
+func SaveTokenOnRefresh(path string, mode os.FileMode) TokenRefreshCallback {
+	return func(token Token) error {
+		return SaveToken(path, mode, token)
+	}
+}
+
 // DecodePfxCertificateData extracts the x509 certificate and RSA private key from the provided PFX data.
 // The PFX data must contain a private key along with a certificate whose public key matches that of the
 // private key or an error is returned.
@@ -18,10 +18,16 @@ import (
 	"encoding/json"
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/date"
+	"github.com/Azure/go-autorest/autorest/mocks"
 )
 
 const MockTokenJSON string = `{
@@ -145,6 +151,31 @@ func TestSaveToken(t *testing.T) {
 	}
 }
 
+func TestSaveTokenOnRefresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json")
+	spt := newServicePrincipalToken(SaveTokenOnRefresh(path, 0600))
+
+	expiresOn := strconv.Itoa(int(time.Now().Add(3600 * time.Second).Sub(date.UnixEpoch()).Seconds()))
+
+	sender := mocks.NewSender()
+	sender.AppendResponse(mocks.NewResponseWithContent(newTokenJSON(`"3600"`, expiresOn, "resource")))
+	spt.SetSender(sender)
+	if err := spt.Refresh(); err != nil {
+		t.Fatalf("adal: ServicePrincipalToken#Refresh returned an unexpected error (%v)", err)
+	}
+
+	saved, err := LoadToken(path)
+	if err != nil {
+		t.Fatalf("adal: unexpected error loading the saved token: %v", err)
+	}
+	if !reflect.DeepEqual(*saved, spt.Token()) {
+		t.Fatalf("adal: SaveTokenOnRefresh saved the wrong token -- expected %v, received %v", spt.Token(), *saved)
+	}
+	if saved.RefreshToken != "ABC123" {
+		t.Fatalf("adal: SaveTokenOnRefresh failed to save the refresh token -- %q", saved.RefreshToken)
+	}
+}
+
 func TestSaveTokenFailsNoPermission(t *testing.T) {
 	pathWhereWeShouldntHavePermission := "/usr/thiswontwork/atall"
 	if runtime.GOOS == "windows" {
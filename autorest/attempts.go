@@ -0,0 +1,117 @@
+package autorest
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AttemptInfo describes one attempt to send a request, as recorded by DoTimeAttempts.
+type AttemptInfo struct {
+	// Attempt is the 1-based number of the attempt.
+	Attempt int
+
+	// Start is the time the attempt was sent.
+	Start time.Time
+
+	// End is the time the attempt completed.
+	End time.Time
+
+	// Duration is the time the attempt took.
+	Duration time.Duration
+
+	// StatusCode is the status code of the response, or zero if no response was received.
+	StatusCode int
+
+	// Err is the error returned by the attempt, if any.
+	Err error
+}
+
+// used as a key type in context.WithValue()
+type ctxAttemptLog struct{}
+
+type attemptLog struct {
+	mu       sync.Mutex
+	attempts []AttemptInfo
+}
+
+func (al *attemptLog) add(attempt int, start time.Time, resp *http.Response, err error) {
+	end := DefaultClock.Now()
+	ai := AttemptInfo{
+		Attempt:  attempt,
+		Start:    start,
+		End:      end,
+		Duration: end.Sub(start),
+		Err:      err,
+	}
+	if resp != nil {
+		ai.StatusCode = resp.StatusCode
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	al.attempts = append(al.attempts, ai)
+}
+
+func (al *attemptLog) get() []AttemptInfo {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	return append([]AttemptInfo(nil), al.attempts...)
+}
+
+// DoTimeAttempts returns a SendDecorator that records the timing and outcome of every attempt
+// made to send the request, retrievable with GetAttempts. Place it after the retry decorators in
+// the list passed to SendWithSender, so that it encloses them; the attempts made by
+// DoRetryForAttempts, DoRetryForDuration, DoRetryUntil, DoRetryForStatusCodes and DoFailover are
+// then recorded individually. Without retry decorators, the single attempt is recorded.
+func DoTimeAttempts() SendDecorator {
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			if _, ok := r.Context().Value(ctxAttemptLog{}).(*attemptLog); ok {
+				return s.Do(r)
+			}
+			log := &attemptLog{}
+			r = r.WithContext(context.WithValue(r.Context(), ctxAttemptLog{}, log))
+			start := DefaultClock.Now()
+			resp, err := s.Do(r)
+			if len(log.get()) == 0 {
+				log.add(requestAttempt(r), start, resp, err)
+			}
+			return resp, err
+		})
+	}
+}
+
+// GetAttempts returns the attempts recorded by DoTimeAttempts for the request that produced the
+// passed response. If the response is nil, the response of a DetailedError in the passed error's
+// chain is used. It returns nil if no attempts were recorded.
+func GetAttempts(resp *http.Response, err error) []AttemptInfo {
+	if resp == nil {
+		var de DetailedError
+		if errors.As(err, &de) {
+			resp = de.Response
+		}
+	}
+	if resp == nil || resp.Request == nil {
+		return nil
+	}
+	if log, ok := resp.Request.Context().Value(ctxAttemptLog{}).(*attemptLog); ok {
+		return log.get()
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package autorest
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+func TestDoTimeAttempts(t *testing.T) {
+	client := mocks.NewSender()
+	client.AppendAndRepeatResponse(mocks.NewResponseWithStatus("503 Service Unavailable", http.StatusServiceUnavailable), 2)
+	client.AppendResponse(mocks.NewResponseWithStatus("200 OK", http.StatusOK))
+
+	r, err := SendWithSender(client, mocks.NewRequest(),
+		DoRetryForStatusCodes(5, 0, http.StatusServiceUnavailable),
+		DoTimeAttempts())
+	if err != nil {
+		t.Fatalf("autorest: DoTimeAttempts returned an unexpected error (%v)", err)
+	}
+	attempts := GetAttempts(r, err)
+	if len(attempts) != 3 {
+		t.Fatalf("autorest: DoTimeAttempts recorded an unexpected number of attempts -- expected 3, actual %v", len(attempts))
+	}
+	for i, a := range attempts {
+		expected := http.StatusServiceUnavailable
+		if i == 2 {
+			expected = http.StatusOK
+		}
+		if a.Attempt != i+1 || a.StatusCode != expected || a.End.Before(a.Start) || a.Duration != a.End.Sub(a.Start) {
+			t.Fatalf("autorest: DoTimeAttempts recorded an unexpected attempt -- %+v", a)
+		}
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestDoTimeAttemptsWithoutRetries(t *testing.T) {
+	client := mocks.NewSender()
+
+	r, err := SendWithSender(client, mocks.NewRequest(), DoTimeAttempts())
+	if err != nil {
+		t.Fatalf("autorest: DoTimeAttempts returned an unexpected error (%v)", err)
+	}
+	if attempts := GetAttempts(r, err); len(attempts) != 1 || attempts[0].Attempt != 1 || attempts[0].StatusCode != http.StatusOK {
+		t.Fatalf("autorest: DoTimeAttempts recorded unexpected attempts -- %+v", attempts)
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestDoTimeAttemptsRecordsErrors(t *testing.T) {
+	client := mocks.NewSender()
+	client.SetAndRepeatError(fmt.Errorf("Faux Error"), 2)
+
+	r, err := SendWithSender(client, mocks.NewRequest(),
+		DoRetryForAttempts(3, 0),
+		DoTimeAttempts())
+	if err != nil {
+		t.Fatalf("autorest: DoTimeAttempts returned an unexpected error (%v)", err)
+	}
+	attempts := GetAttempts(r, err)
+	if len(attempts) != 3 || attempts[0].Err == nil || attempts[1].Err == nil || attempts[2].Err != nil {
+		t.Fatalf("autorest: DoTimeAttempts recorded unexpected attempts -- %+v", attempts)
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestGetAttemptsFromDetailedError(t *testing.T) {
+	client := mocks.NewSender()
+	client.AppendResponse(mocks.NewResponseWithStatus("400 Bad Request", http.StatusBadRequest))
+
+	r, err := SendWithSender(client, mocks.NewRequest(),
+		DoErrorUnlessStatusCode(http.StatusOK),
+		DoTimeAttempts())
+	if err == nil {
+		t.Fatal("autorest: DoErrorUnlessStatusCode failed to return an error")
+	}
+	if attempts := GetAttempts(nil, err); len(attempts) != 1 || attempts[0].StatusCode != http.StatusBadRequest {
+		t.Fatalf("autorest: GetAttempts returned unexpected attempts -- %+v", attempts)
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestGetAttemptsWithoutDoTimeAttempts(t *testing.T) {
+	client := mocks.NewSender()
+
+	r, err := SendWithSender(client, mocks.NewRequest())
+	if attempts := GetAttempts(r, err); attempts != nil {
+		t.Fatalf("autorest: GetAttempts returned unexpected attempts -- %+v", attempts)
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
@@ -17,13 +17,24 @@ package autorest
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 )
 
 // SASTokenAuthorizer implements an authorization for SAS Token Authentication
 // this can be used for interaction with Blob Storage Endpoints
 type SASTokenAuthorizer struct {
-	sasToken string
+	sasToken  string
+	expiry    time.Time
+	allowHTTP bool
+}
+
+// the layouts accepted for the signed expiry (se) field of a SAS token
+var sasExpiryLayouts = []string{
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
 }
 
 // NewSASTokenAuthorizer creates a SASTokenAuthorizer using the given credentials
@@ -37,13 +48,45 @@ func NewSASTokenAuthorizer(sasToken string) (*SASTokenAuthorizer, error) {
 		token = strings.TrimPrefix(sasToken, "?")
 	}
 
+	params, err := url.ParseQuery(token)
+	if err != nil {
+		return nil, fmt.Errorf("malformed sasToken: %v", err)
+	}
+
+	var expiry time.Time
+	if se := params.Get("se"); se != "" {
+		for _, layout := range sasExpiryLayouts {
+			if expiry, err = time.Parse(layout, se); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed sasToken expiry %q: %v", se, err)
+		}
+	}
+
+	// an omitted signed protocol (spr) field permits both HTTPS and HTTP
+	allowHTTP := true
+	if spr := params.Get("spr"); spr != "" {
+		allowHTTP = false
+		for _, protocol := range strings.Split(spr, ",") {
+			if strings.TrimSpace(protocol) == "http" {
+				allowHTTP = true
+			}
+		}
+	}
+
 	return &SASTokenAuthorizer{
-		sasToken: token,
+		sasToken:  token,
+		expiry:    expiry,
+		allowHTTP: allowHTTP,
 	}, nil
 }
 
 // WithAuthorization returns a PrepareDecorator that adds a shared access signature token to the
 // URI's query parameters.  This can be used for the Blob, Queue, and File Services.
+// An error is returned if the token has expired, or if the request does not use HTTPS and the
+// token's signed protocol (spr) is limited to HTTPS.
 //
 // See https://docs.microsoft.com/en-us/rest/api/storageservices/delegate-access-with-shared-access-signature
 func (sas *SASTokenAuthorizer) WithAuthorization() PrepareDecorator {
@@ -54,6 +97,13 @@ func (sas *SASTokenAuthorizer) WithAuthorization() PrepareDecorator {
 				return r, err
 			}
 
+			if !sas.allowHTTP && !strings.EqualFold(r.URL.Scheme, "https") {
+				return r, NewError("autorest", "SASTokenAuthorizer.WithAuthorization", "SAS tokens can only be sent over HTTPS, got scheme %q", r.URL.Scheme)
+			}
+			if !sas.expiry.IsZero() && DefaultClock.Now().After(sas.expiry) {
+				return r, NewError("autorest", "SASTokenAuthorizer.WithAuthorization", "SAS token expired at %s", sas.expiry.Format(time.RFC3339))
+			}
+
 			if r.URL.RawQuery == "" {
 				r.URL.RawQuery = sas.sasToken
 			} else if !strings.Contains(r.URL.RawQuery, sas.sasToken) {
@@ -18,6 +18,7 @@ import (
 	"net/http"
 	"net/url"
 	"testing"
+	"time"
 )
 
 func TestSasNewSasAuthorizerEmptyToken(t *testing.T) {
@@ -111,3 +112,77 @@ func TestSasAuthorizerRequest(t *testing.T) {
 		}
 	}
 }
+
+func TestSasNewSasAuthorizerMalformedExpiry(t *testing.T) {
+	auth, err := NewSASTokenAuthorizer("sv=2019-12-12&se=tomorrow&sig=abc")
+	if err == nil {
+		t.Fatalf("azure: SASTokenAuthorizer#NewSASTokenAuthorizer didn't return an error")
+	}
+
+	if auth != nil {
+		t.Fatalf("azure: SASTokenAuthorizer#NewSASTokenAuthorizer returned an authorizer")
+	}
+}
+
+func TestSasAuthorizerRequestValidation(t *testing.T) {
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	testData := []struct {
+		name    string
+		token   string
+		input   string
+		success bool
+	}{
+		{
+			name:    "unexpired token over https",
+			token:   "se=" + future + "&sig=abc",
+			input:   "https://example.com/foo/bar",
+			success: true,
+		},
+		{
+			name:    "date only expiry",
+			token:   "se=2999-01-01&sig=abc",
+			input:   "https://example.com/foo/bar",
+			success: true,
+		},
+		{
+			name:    "expired token",
+			token:   "se=" + past + "&sig=abc",
+			input:   "https://example.com/foo/bar",
+			success: false,
+		},
+		{
+			name:    "token without signed protocol over http",
+			token:   "sig=abc",
+			input:   "http://example.com/foo/bar",
+			success: true,
+		},
+		{
+			name:    "https only token over http",
+			token:   "spr=https&sig=abc",
+			input:   "http://example.com/foo/bar",
+			success: false,
+		},
+		{
+			name:    "token permitting http",
+			token:   "spr=https,http&sig=abc",
+			input:   "http://example.com/foo/bar",
+			success: true,
+		},
+	}
+
+	for _, v := range testData {
+		t.Logf("[DEBUG] Testing Case %q..", v.name)
+		auth, err := NewSASTokenAuthorizer(v.token)
+		if err != nil {
+			t.Fatalf("azure: SASTokenAuthorizer#NewSASTokenAuthorizer returned an error (%v)", err)
+		}
+		url, _ := url.ParseRequestURI(v.input)
+		_, err = Prepare(&http.Request{URL: url}, auth.WithAuthorization())
+		if v.success && err != nil {
+			t.Fatalf("azure: SASTokenAuthorizer#WithAuthorization returned an error (%v)", err)
+		} else if !v.success && err == nil {
+			t.Fatal("azure: SASTokenAuthorizer#WithAuthorization didn't return an error")
+		}
+	}
+}
@@ -112,6 +112,18 @@ func (sk *SharedKeyAuthorizer) WithAuthorization() PrepareDecorator {
 	}
 }
 
+// WithSharedKeySigning returns a PrepareDecorator that signs the http.Request using the SharedKey
+// scheme for the specified storage account. The account key must be base64 encoded; a malformed
+// key is reported as an error when the request is prepared. Use NewSharedKeyAuthorizer to select
+// one of the other SharedKeyType schemes.
+func WithSharedKeySigning(accountName, accountKey string) PrepareDecorator {
+	sk, err := NewSharedKeyAuthorizer(accountName, accountKey, SharedKey)
+	if err != nil {
+		return withError(err)
+	}
+	return sk.WithAuthorization()
+}
+
 func buildSharedKey(accName string, accKey []byte, req *http.Request, keyType SharedKeyType) (string, error) {
 	canRes, err := buildCanonicalizedResource(accName, req.URL.String(), keyType)
 	if err != nil {
@@ -260,12 +272,24 @@ func buildCanonicalizedString(verb string, headers http.Header, canonicalizedRes
 }
 
 func buildCanonicalizedHeader(headers http.Header) string {
-	cm := make(map[string]string)
+	return CanonicalizeHeaders(headers, "x-ms-")
+}
+
+// CanonicalizeHeaders returns the canonical form of the headers whose names start with the passed
+// prefix, as used by shared key and similar HMAC signing schemes. Header names are lower-cased and
+// sorted, whitespace in values is folded into single spaces, multiple values are joined with a
+// comma and each header is written as "name:value" on its own line. Prefix matching is not case
+// sensitive; an empty prefix selects all headers.
+func CanonicalizeHeaders(headers http.Header, prefix string) string {
+	prefix = strings.ToLower(prefix)
+	cm := make(map[string][]string)
 
-	for k := range headers {
+	for k, v := range headers {
 		headerName := strings.TrimSpace(strings.ToLower(k))
-		if strings.HasPrefix(headerName, "x-ms-") {
-			cm[headerName] = headers.Get(k)
+		if strings.HasPrefix(headerName, prefix) {
+			for _, value := range v {
+				cm[headerName] = append(cm[headerName], strings.Join(strings.Fields(value), " "))
+			}
 		}
 	}
 
@@ -285,13 +309,40 @@ func buildCanonicalizedHeader(headers http.Header) string {
 	for _, key := range keys {
 		ch.WriteString(key)
 		ch.WriteRune(':')
-		ch.WriteString(cm[key])
+		ch.WriteString(strings.Join(cm[key], ","))
 		ch.WriteRune('\n')
 	}
 
 	return strings.TrimSuffix(ch.String(), "\n")
 }
 
+// HeaderSigner returns the value of the Authorization header for the passed http.Request, given
+// the canonical form of its headers as produced by CanonicalizeHeaders.
+type HeaderSigner func(r *http.Request, canonicalHeaders string) (string, error)
+
+// WithHeaderSigning returns a PrepareDecorator that signs the http.Request with a custom signing
+// scheme. The headers whose names start with the passed prefix (e.g. "x-ms-") are canonicalized
+// with CanonicalizeHeaders and passed to the HeaderSigner, and the Authorization header is set to
+// the value it returns. It should follow the decorators setting the signed headers.
+func WithHeaderSigning(prefix string, signer HeaderSigner) PrepareDecorator {
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err != nil {
+				return r, err
+			}
+			if r.Header == nil {
+				r.Header = http.Header{}
+			}
+			auth, err := signer(r, CanonicalizeHeaders(r.Header, prefix))
+			if err != nil {
+				return r, NewErrorWithError(err, "autorest", "WithHeaderSigning", nil, "Failure signing the request")
+			}
+			return Prepare(r, WithHeader(headerAuthorization, auth))
+		})
+	}
+}
+
 func createAuthorizationHeader(accountName string, accountKey []byte, canonicalizedString string, keyType SharedKeyType) string {
 	h := hmac.New(sha256.New, accountKey)
 	h.Write([]byte(canonicalizedString))
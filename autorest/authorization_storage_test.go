@@ -15,8 +15,11 @@ package autorest
 //  limitations under the License.
 
 import (
+	"errors"
 	"net/http"
 	"testing"
+
+	"github.com/Azure/go-autorest/autorest/mocks"
 )
 
 func TestNewSharedKeyAuthorizer(t *testing.T) {
@@ -44,6 +47,37 @@ func TestNewSharedKeyAuthorizer(t *testing.T) {
 	}
 }
 
+func TestWithSharedKeySigning(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://golangrocksonazure.blob.core.windows.net/some/blob.dat", nil)
+	if err != nil {
+		t.Fatalf("create HTTP request: %v", err)
+	}
+	req.Header.Add(headerAcceptCharset, "UTF-8")
+	req.Header.Add(headerContentType, "application/json")
+	req.Header.Add(headerXMSDate, "Wed, 23 Sep 2015 16:40:05 GMT")
+	req.Header.Add(headerContentLength, "0")
+	req.Header.Add(headerXMSVersion, "2015-02-21")
+	req.Header.Add(headerAccept, "application/json;odata=nometadata")
+	req, err = Prepare(req, WithSharedKeySigning("golangrocksonazure", "YmFy"))
+	if err != nil {
+		t.Fatalf("prepare HTTP request: %v", err)
+	}
+	const expected = "SharedKey golangrocksonazure:nYRqgbumDOTPs+Vv1FLH+hm0KPjwwt+Fmj/i16W+lO0="
+	if auth := req.Header.Get(headerAuthorization); auth != expected {
+		t.Fatalf("expected: %s, go %s", expected, auth)
+	}
+}
+
+func TestWithSharedKeySigningMalformedKey(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://golangrocksonazure.blob.core.windows.net/some/blob.dat", nil)
+	if err != nil {
+		t.Fatalf("create HTTP request: %v", err)
+	}
+	if _, err = Prepare(req, WithSharedKeySigning("golangrocksonazure", "not base64!")); err == nil {
+		t.Fatal("expected an error for a malformed account key")
+	}
+}
+
 func TestNewSharedKeyAuthorizerWithRoot(t *testing.T) {
 	auth, err := NewSharedKeyAuthorizer("golangrocksonazure", "YmFy", SharedKey)
 	if err != nil {
@@ -170,3 +204,54 @@ func TestNewSharedKeyLiteForTableAuthorizer(t *testing.T) {
 		t.Fatalf("expected: %s, go %s", expected, auth)
 	}
 }
+
+func TestCanonicalizeHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("X-MS-Version", "2019-02-02")
+	h.Set("x-ms-date", "Wed, 23 Oct 2019 21:32:46 GMT")
+	h.Add("X-Ms-Meta-Tags", "  a   b ")
+	h.Add("X-Ms-Meta-Tags", "c\n\td")
+	h.Set("Content-Type", "text/plain")
+
+	expected := "x-ms-date:Wed, 23 Oct 2019 21:32:46 GMT\nx-ms-meta-tags:a b,c d\nx-ms-version:2019-02-02"
+	if c := CanonicalizeHeaders(h, "X-MS-"); c != expected {
+		t.Fatalf("CanonicalizeHeaders returned %q, expected %q", c, expected)
+	}
+	if c := CanonicalizeHeaders(h, "x-foo-"); c != "" {
+		t.Fatalf("CanonicalizeHeaders returned %q for an unmatched prefix", c)
+	}
+	if c := CanonicalizeHeaders(http.Header{"Content-Type": {"text/plain"}}, ""); c != "content-type:text/plain" {
+		t.Fatalf("CanonicalizeHeaders returned %q for an empty prefix", c)
+	}
+}
+
+func TestWithHeaderSigning(t *testing.T) {
+	var canonical string
+	r, err := Prepare(mocks.NewRequest(),
+		WithHeader("X-Custom-Date", "Wed, 23 Oct 2019 21:32:46 GMT"),
+		WithHeader("x-custom-nonce", "  abc "),
+		WithHeader("Content-Type", "text/plain"),
+		WithHeaderSigning("x-custom-", func(r *http.Request, canonicalHeaders string) (string, error) {
+			canonical = canonicalHeaders
+			return "HMAC signature", nil
+		}))
+	if err != nil {
+		t.Fatalf("WithHeaderSigning failed (%v)", err)
+	}
+	if expected := "x-custom-date:Wed, 23 Oct 2019 21:32:46 GMT\nx-custom-nonce:abc"; canonical != expected {
+		t.Fatalf("WithHeaderSigning passed %q, expected %q", canonical, expected)
+	}
+	if auth := r.Header.Get(headerAuthorization); auth != "HMAC signature" {
+		t.Fatalf("WithHeaderSigning set Authorization to %q", auth)
+	}
+}
+
+func TestWithHeaderSigningReturnsSignerError(t *testing.T) {
+	_, err := Prepare(mocks.NewRequest(),
+		WithHeaderSigning("x-ms-", func(*http.Request, string) (string, error) {
+			return "", errors.New("no key")
+		}))
+	if err == nil {
+		t.Fatal("WithHeaderSigning failed to return the signer error")
+	}
+}
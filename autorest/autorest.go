@@ -72,8 +72,13 @@ package autorest
 //  limitations under the License.
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -81,8 +86,18 @@ const (
 	// HeaderLocation specifies the HTTP Location header.
 	HeaderLocation = "Location"
 
+	// HeaderAzureAsyncOperation specifies the Azure-AsyncOperation header, which holds the URL of
+	// the status monitor of a long-running operation.
+	HeaderAzureAsyncOperation = "Azure-AsyncOperation"
+
 	// HeaderRetryAfter specifies the HTTP Retry-After header.
 	HeaderRetryAfter = "Retry-After"
+
+	// HeaderRetryAfterMs specifies the retry-after-ms header, a millisecond precision Retry-After.
+	HeaderRetryAfterMs = "retry-after-ms"
+
+	// HeaderXMSRetryAfterMs specifies the Azure extension of the retry-after-ms header.
+	HeaderXMSRetryAfterMs = "x-ms-retry-after-ms"
 )
 
 // ResponseHasStatusCode returns true if the status code in the HTTP Response is in the passed set
@@ -94,25 +109,194 @@ func ResponseHasStatusCode(resp *http.Response, codes ...int) bool {
 	return containsInt(codes, resp.StatusCode)
 }
 
+// StatusCodeRange is an inclusive range of HTTP status codes, such as StatusCodeRange{500, 599} for
+// all server errors.
+type StatusCodeRange struct {
+	Min int
+	Max int
+}
+
+// Contains returns true if the passed status code is within the range.
+func (scr StatusCodeRange) Contains(code int) bool {
+	return code >= scr.Min && code <= scr.Max
+}
+
+// ResponseHasStatusCodeInRange returns true if the status code in the HTTP Response is within one
+// of the passed ranges, false otherwise.
+func ResponseHasStatusCodeInRange(resp *http.Response, ranges ...StatusCodeRange) bool {
+	if resp == nil {
+		return false
+	}
+	for _, scr := range ranges {
+		if scr.Contains(resp.StatusCode) {
+			return true
+		}
+	}
+	return false
+}
+
 // GetLocation retrieves the URL from the Location header of the passed response.
 func GetLocation(resp *http.Response) string {
 	return resp.Header.Get(HeaderLocation)
 }
 
-// GetRetryAfter extracts the retry delay from the Retry-After header of the passed response. If
-// the header is absent or is malformed, it will return the supplied default delay time.Duration.
-func GetRetryAfter(resp *http.Response, defaultDelay time.Duration) time.Duration {
-	retry := resp.Header.Get(HeaderRetryAfter)
-	if retry == "" {
-		return defaultDelay
+// GetPollingLocation retrieves the URL at which to poll a long-running operation from the passed
+// response. Per the Azure Resource Manager long-running operation guidelines, the
+// Azure-AsyncOperation header is preferred over the Location header.
+func GetPollingLocation(resp *http.Response) string {
+	if location := resp.Header.Get(HeaderAzureAsyncOperation); location != "" {
+		return location
 	}
+	return GetLocation(resp)
+}
+
+// The values of OperationStatus.Status reported by Azure-AsyncOperation status monitors.
+const (
+	OperationStatusInProgress = "InProgress"
+	OperationStatusSucceeded  = "Succeeded"
+	OperationStatusFailed     = "Failed"
+	OperationStatusCanceled   = "Canceled"
+)
+
+// OperationStatus is the status of a long-running operation returned by the URL in the
+// Azure-AsyncOperation header.
+type OperationStatus struct {
+	ID     string                `json:"id,omitempty"`
+	Name   string                `json:"name,omitempty"`
+	Status string                `json:"status"`
+	Error  *OperationStatusError `json:"error,omitempty"`
+}
+
+// OperationStatusError describes why a long-running operation failed.
+type OperationStatusError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// IsTerminal returns true if the operation has Succeeded, Failed or been Canceled.
+func (status OperationStatus) IsTerminal() bool {
+	return status.HasSucceeded() || strings.EqualFold(status.Status, OperationStatusFailed) || strings.EqualFold(status.Status, OperationStatusCanceled)
+}
+
+// HasSucceeded returns true if the operation has Succeeded.
+func (status OperationStatus) HasSucceeded() bool {
+	return strings.EqualFold(status.Status, OperationStatusSucceeded)
+}
+
+// ResponseRequiresPolling returns true if the passed response starts a long-running operation that
+// must be polled: its status code is among those passed (202 Accepted if none are passed) and it
+// has an Azure-AsyncOperation or Location header, or it is a 201 Created with a Location header,
+// as many Azure Resource Manager PUT operations return while the resource is provisioned.
+func ResponseRequiresPolling(resp *http.Response, codes ...int) bool {
+	if resp == nil || GetPollingLocation(resp) == "" {
+		return false
+	}
+	if len(codes) == 0 {
+		codes = []int{http.StatusAccepted}
+	}
+	return ResponseHasStatusCode(resp, codes...) || isCreatedWithLocation(resp)
+}
 
-	d, err := time.ParseDuration(retry + "s")
+// isCreatedWithLocation returns true if the passed response is a 201 Created whose Location header,
+// in the absence of an Azure-AsyncOperation header, locates the resource being provisioned.
+func isCreatedWithLocation(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusCreated && GetLocation(resp) != "" && resp.Header.Get(HeaderAzureAsyncOperation) == ""
+}
+
+// GetOperationStatus parses the OperationStatus returned in the body of the passed response by an
+// Azure-AsyncOperation status monitor. The body is left readable.
+func GetOperationStatus(resp *http.Response) (OperationStatus, error) {
+	var status OperationStatus
+	if resp == nil || resp.Body == nil {
+		return status, NewError("autorest", "GetOperationStatus", "response has no body")
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return status, NewErrorWithError(err, "autorest", "GetOperationStatus", resp, "Failure reading the response body")
+	}
+	if err = json.Unmarshal(bytes.TrimPrefix(b, []byte("\xef\xbb\xbf")), &status); err != nil {
+		return status, NewErrorWithError(err, "autorest", "GetOperationStatus", resp, "Failure unmarshalling the operation status")
+	}
+	if status.Status == "" {
+		return status, NewErrorWithResponse("autorest", "GetOperationStatus", resp, "operation status missing from response body")
+	}
+	return status, nil
+}
+
+// GetProvisioningState returns the properties.provisioningState of the resource returned in the
+// body of the passed response, or the empty string if it has none. The body is left readable.
+func GetProvisioningState(resp *http.Response) (string, error) {
+	if resp == nil || resp.Body == nil {
+		return "", nil
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(b))
 	if err != nil {
-		return defaultDelay
+		return "", NewErrorWithError(err, "autorest", "GetProvisioningState", resp, "Failure reading the response body")
+	}
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))
+	if len(bytes.TrimSpace(b)) == 0 {
+		return "", nil
+	}
+	var resource struct {
+		Properties struct {
+			ProvisioningState string `json:"provisioningState"`
+		} `json:"properties"`
+	}
+	if err = json.Unmarshal(b, &resource); err != nil {
+		return "", NewErrorWithError(err, "autorest", "GetProvisioningState", resp, "Failure unmarshalling the resource")
+	}
+	return resource.Properties.ProvisioningState, nil
+}
+
+// IsTerminalProvisioningState returns true if the passed provisioning state is Succeeded, Failed or
+// Canceled.
+func IsTerminalProvisioningState(state string) bool {
+	return OperationStatus{Status: state}.IsTerminal()
+}
+
+// GetRetryAfter extracts the retry delay from the retry-after-ms, x-ms-retry-after-ms or Retry-After
+// header, in that order, of the passed response. Retry-After may be given in delta-seconds or as an
+// HTTP-date. If the headers are absent or malformed, it will return the supplied default delay
+// time.Duration.
+func GetRetryAfter(resp *http.Response, defaultDelay time.Duration) time.Duration {
+	if d, ok := retryAfter(resp); ok {
+		return d
+	}
+	return defaultDelay
+}
+
+// ParseRetryAfter extracts the retry delay from the headers of the passed response as
+// GetRetryAfter does, returning false if the headers are absent or malformed.
+func ParseRetryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
 	}
+	return retryAfter(resp)
+}
 
-	return d
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	for _, header := range []string{HeaderRetryAfterMs, HeaderXMSRetryAfterMs} {
+		if ms, err := strconv.ParseInt(resp.Header.Get(header), 10, 64); err == nil {
+			return time.Duration(ms) * time.Millisecond, true
+		}
+	}
+	retry := resp.Header.Get(HeaderRetryAfter)
+	if retry == "" {
+		return 0, false
+	}
+	if d, err := time.ParseDuration(retry + "s"); err == nil {
+		return d, true
+	}
+	for _, layout := range []string{http.TimeFormat, time.RFC1123, time.RFC850, time.ANSIC} {
+		if t, err := time.Parse(layout, retry); err == nil {
+			return t.Sub(DefaultClock.Now()), true
+		}
+	}
+	return 0, false
 }
 
 // NewPollingRequest allocates and returns a new http.Request to poll for the passed response.
@@ -133,15 +317,23 @@ func NewPollingRequest(resp *http.Response, cancel <-chan struct{}) (*http.Reque
 }
 
 // NewPollingRequestWithContext allocates and returns a new http.Request with the specified context to poll for the passed response.
+// Headers and query parameters named with WithPollingCarryForward are copied from the request of the passed response.
 func NewPollingRequestWithContext(ctx context.Context, resp *http.Response) (*http.Request, error) {
 	location := GetLocation(resp)
 	if location == "" {
 		return nil, NewErrorWithResponse("autorest", "NewPollingRequestWithContext", resp, "Location header missing from response that requires polling")
 	}
+	return newPollingRequest(ctx, resp, location)
+}
 
+// newPollingRequest allocates and returns a new GET http.Request with the specified context for the
+// passed URL, copying the headers and query parameters named with WithPollingCarryForward from the
+// request of the passed response.
+func newPollingRequest(ctx context.Context, resp *http.Response, location string) (*http.Request, error) {
 	req, err := Prepare((&http.Request{}).WithContext(ctx),
 		AsGet(),
-		WithBaseURL(location))
+		WithBaseURL(location),
+		WithCarriedForward(ctx, resp.Request))
 	if err != nil {
 		return nil, NewErrorWithError(err, "autorest", "NewPollingRequestWithContext", nil, "Failure creating poll request to %s", location)
 	}
@@ -15,8 +15,11 @@ package autorest
 //  limitations under the License.
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/Azure/go-autorest/autorest/mocks"
 )
@@ -37,6 +40,23 @@ func TestResponseHasStatusCodeNotPresent(t *testing.T) {
 	}
 }
 
+func TestResponseHasStatusCodeInRange(t *testing.T) {
+	ranges := []StatusCodeRange{{200, 299}, {500, 599}}
+	for _, code := range []int{http.StatusOK, http.StatusNoContent, http.StatusServiceUnavailable} {
+		if !ResponseHasStatusCodeInRange(&http.Response{StatusCode: code}, ranges...) {
+			t.Fatalf("autorest: ResponseHasStatusCodeInRange failed to find %v in %v", code, ranges)
+		}
+	}
+	for _, code := range []int{http.StatusMovedPermanently, http.StatusNotFound} {
+		if ResponseHasStatusCodeInRange(&http.Response{StatusCode: code}, ranges...) {
+			t.Fatalf("autorest: ResponseHasStatusCodeInRange unexpectedly found %v in %v", code, ranges)
+		}
+	}
+	if ResponseHasStatusCodeInRange(nil, ranges...) {
+		t.Fatal("autorest: ResponseHasStatusCodeInRange found a status code in a nil response")
+	}
+}
+
 func TestNewPollingRequestDoesNotReturnARequestWhenLocationHeaderIsMissing(t *testing.T) {
 	resp := mocks.NewResponseWithStatus("500 InternalServerError", http.StatusInternalServerError)
 
@@ -88,6 +108,38 @@ func TestNewPollingRequestProvidesTheURL(t *testing.T) {
 	}
 }
 
+func TestNewPollingRequestWithContextCarriesForward(t *testing.T) {
+	req := mocks.NewRequestWithParams(http.MethodPut, mocks.TestURL+"?api-version=2020-01-01&other=x", nil)
+	req.Header.Set("x-ms-client-request-id", "id-1")
+	req.Header.Set("x-ms-correlation-request-id", "id-2")
+	resp := mocks.NewResponseWithStatus("202 Accepted", http.StatusAccepted)
+	mocks.SetAcceptedHeaders(resp)
+	resp.Request = req
+
+	ctx := WithPollingCarryForward(context.Background(), []string{"x-ms-client-request-id"}, []string{"api-version"})
+	r, err := NewPollingRequestWithContext(ctx, resp)
+	if err != nil {
+		t.Fatalf("autorest: NewPollingRequestWithContext returned an unexpected error (%v)", err)
+	}
+	if r.Header.Get("x-ms-client-request-id") != "id-1" || r.Header.Get("x-ms-correlation-request-id") != "" {
+		t.Fatalf("autorest: NewPollingRequestWithContext carried forward the wrong headers -- %v", r.Header)
+	}
+	if q := r.URL.Query(); q.Get("api-version") != "2020-01-01" || q.Get("other") != "" {
+		t.Fatalf("autorest: NewPollingRequestWithContext carried forward the wrong query parameters -- %v", r.URL)
+	}
+
+	mocks.SetResponseHeader(resp, HeaderLocation, mocks.TestURL+"?api-version=2021-01-01")
+	r, err = NewPollingRequestWithContext(ctx, resp)
+	if err != nil || r.URL.Query().Get("api-version") != "2021-01-01" {
+		t.Fatalf("autorest: NewPollingRequestWithContext replaced a query parameter of the polling URL -- %v (%v)", r.URL, err)
+	}
+
+	r, err = NewPollingRequestWithContext(context.Background(), resp)
+	if err != nil || r.Header.Get("x-ms-client-request-id") != "" {
+		t.Fatalf("autorest: NewPollingRequestWithContext carried forward headers without being asked to -- %v (%v)", r.Header, err)
+	}
+}
+
 func TestGetLocation(t *testing.T) {
 	resp := mocks.NewResponseWithStatus("202 Accepted", http.StatusAccepted)
 	mocks.SetAcceptedHeaders(resp)
@@ -107,6 +159,89 @@ func TestGetLocationReturnsEmptyStringForMissingLocation(t *testing.T) {
 	}
 }
 
+func TestGetPollingLocationPrefersAzureAsyncOperation(t *testing.T) {
+	resp := mocks.NewResponseWithStatus("202 Accepted", http.StatusAccepted)
+	mocks.SetAcceptedHeaders(resp)
+	if l := GetPollingLocation(resp); l != mocks.TestURL {
+		t.Fatalf("autorest: GetPollingLocation returned the wrong URL -- expected %v, received %v", mocks.TestURL, l)
+	}
+
+	mocks.SetResponseHeader(resp, HeaderAzureAsyncOperation, mocks.TestAzureAsyncURL)
+	if l := GetPollingLocation(resp); l != mocks.TestAzureAsyncURL {
+		t.Fatalf("autorest: GetPollingLocation did not prefer Azure-AsyncOperation -- expected %v, received %v", mocks.TestAzureAsyncURL, l)
+	}
+	req, err := NewPollingRequest(resp, nil)
+	if err != nil || req.URL.String() != mocks.TestURL {
+		t.Fatalf("autorest: NewPollingRequest did not poll the Location URL -- received %v (%v)", req, err)
+	}
+}
+
+func TestResponseRequiresPolling(t *testing.T) {
+	resp := mocks.NewResponseWithStatus("202 Accepted", http.StatusAccepted)
+	if ResponseRequiresPolling(resp) {
+		t.Fatal("autorest: ResponseRequiresPolling returned true for a response without a polling URL")
+	}
+	mocks.SetAcceptedHeaders(resp)
+	if !ResponseRequiresPolling(resp) {
+		t.Fatal("autorest: ResponseRequiresPolling returned false for a 202 Accepted with a Location header")
+	}
+	if ResponseRequiresPolling(resp, http.StatusOK) {
+		t.Fatal("autorest: ResponseRequiresPolling returned true for a status code not among those passed")
+	}
+
+	resp = mocks.NewResponseWithStatus("201 Created", http.StatusCreated)
+	mocks.SetResponseHeader(resp, HeaderLocation, mocks.TestURL)
+	if !ResponseRequiresPolling(resp) {
+		t.Fatal("autorest: ResponseRequiresPolling returned false for a 201 Created with a Location header")
+	}
+	resp = mocks.NewResponseWithStatus("200 OK", http.StatusOK)
+	mocks.SetResponseHeader(resp, HeaderLocation, mocks.TestURL)
+	if ResponseRequiresPolling(resp) {
+		t.Fatal("autorest: ResponseRequiresPolling returned true for a 200 OK")
+	}
+}
+
+func TestGetOperationStatus(t *testing.T) {
+	body := `{"status": "Failed", "error": {"code": "Conflict", "message": "busy"}}`
+	resp := mocks.NewResponseWithContent(body)
+	status, err := GetOperationStatus(resp)
+	if err != nil {
+		t.Fatalf("autorest: GetOperationStatus returned an unexpected error (%v)", err)
+	}
+	if !status.IsTerminal() || status.HasSucceeded() || status.Error == nil || status.Error.Code != "Conflict" {
+		t.Fatalf("autorest: GetOperationStatus returned the wrong status -- %+v", status)
+	}
+	if b, _ := io.ReadAll(resp.Body); string(b) != body {
+		t.Fatalf("autorest: GetOperationStatus did not leave the body readable -- %q", string(b))
+	}
+
+	status, err = GetOperationStatus(mocks.NewResponseWithContent(`{"status": "InProgress"}`))
+	if err != nil || status.IsTerminal() {
+		t.Fatalf("autorest: GetOperationStatus reported InProgress as terminal -- %+v (%v)", status, err)
+	}
+	if _, err = GetOperationStatus(mocks.NewResponseWithContent(`{}`)); err == nil {
+		t.Fatal("autorest: GetOperationStatus failed to return an error for a missing status")
+	}
+}
+
+func TestGetProvisioningState(t *testing.T) {
+	body := `{"properties": {"provisioningState": "Canceled"}}`
+	resp := mocks.NewResponseWithContent(body)
+	state, err := GetProvisioningState(resp)
+	if err != nil || state != "Canceled" || !IsTerminalProvisioningState(state) {
+		t.Fatalf("autorest: GetProvisioningState returned the wrong state -- %q (%v)", state, err)
+	}
+	if b, _ := io.ReadAll(resp.Body); string(b) != body {
+		t.Fatalf("autorest: GetProvisioningState did not leave the body readable -- %q", string(b))
+	}
+	if state, err = GetProvisioningState(mocks.NewResponseWithContent(`{"id": "r1"}`)); err != nil || state != "" {
+		t.Fatalf("autorest: GetProvisioningState returned a state for a resource without one -- %q (%v)", state, err)
+	}
+	if IsTerminalProvisioningState("Updating") {
+		t.Fatal("autorest: IsTerminalProvisioningState reported Updating as terminal")
+	}
+}
+
 func TestGetRetryAfter(t *testing.T) {
 	resp := mocks.NewResponseWithStatus("202 Accepted", http.StatusAccepted)
 	mocks.SetAcceptedHeaders(resp)
@@ -138,3 +273,27 @@ func TestGetRetryAfterReturnsDefaultDelayIfRetryHeaderIsMalformed(t *testing.T)
 			DefaultPollingDelay, d)
 	}
 }
+
+func TestGetRetryAfterMilliseconds(t *testing.T) {
+	resp := mocks.NewResponseWithStatus("429 Too Many Requests", http.StatusTooManyRequests)
+	mocks.SetAcceptedHeaders(resp)
+	mocks.SetResponseHeader(resp, HeaderXMSRetryAfterMs, "1500")
+
+	if d := GetRetryAfter(resp, DefaultPollingDelay); d != 1500*time.Millisecond {
+		t.Fatalf("autorest: GetRetryAfter failed to prefer %s -- expected %v, received %v", HeaderXMSRetryAfterMs, 1500*time.Millisecond, d)
+	}
+
+	mocks.SetResponseHeader(resp, HeaderRetryAfterMs, "250")
+	if d := GetRetryAfter(resp, DefaultPollingDelay); d != 250*time.Millisecond {
+		t.Fatalf("autorest: GetRetryAfter failed to prefer %s -- expected %v, received %v", HeaderRetryAfterMs, 250*time.Millisecond, d)
+	}
+}
+
+func TestGetRetryAfterHTTPDate(t *testing.T) {
+	resp := mocks.NewResponseWithStatus("503 Service Unavailable", http.StatusServiceUnavailable)
+	mocks.SetResponseHeader(resp, HeaderRetryAfter, time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+
+	if d := GetRetryAfter(resp, DefaultPollingDelay); d <= 58*time.Second || d > time.Minute {
+		t.Fatalf("autorest: GetRetryAfter failed to parse an HTTP-date -- received %v", d)
+	}
+}
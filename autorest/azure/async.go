@@ -32,6 +32,8 @@ import (
 
 const (
 	headerAsyncOperation = "Azure-AsyncOperation"
+	headerETag           = "ETag"
+	headerIfNoneMatch    = "If-None-Match"
 )
 
 const (
@@ -93,6 +95,16 @@ var _ FutureAPI = (*Future)(nil)
 // Since futures are stateful they should be passed by value to avoid race conditions.
 type Future struct {
 	pt pollingTracker
+
+	// StatusCodes, if not nil, classifies the responses to polling requests by status code, for
+	// services that signal progress through the status code alone. Responses whose status code
+	// is not listed are interpreted as usual. It is not persisted by MarshalJSON.
+	StatusCodes *autorest.PollingStatusCodes
+
+	// ConditionalPolling, if true, sends the ETag of the latest status response in the
+	// If-None-Match header of each status request, treating a 304 Not Modified as no change, to
+	// avoid transferring large status bodies that have not changed.
+	ConditionalPolling bool
 }
 
 // NewFutureFromResponse returns a new Future object initialized
@@ -142,10 +154,19 @@ func (f *Future) DoneWithContext(ctx context.Context, sender autorest.Sender) (d
 		return false, autorest.NewError("Future", "Done", "future is not initialized")
 	}
 	if f.pt.hasTerminated() {
-		return true, f.pt.pollingError()
+		return true, operationError(f.pt)
+	}
+	if f.ConditionalPolling {
+		ctx = context.WithValue(ctx, ctxConditionalPolling{}, true)
 	}
 	if err := f.pt.pollForStatus(ctx, sender); err != nil {
-		return false, err
+		if f.StatusCodes == nil || !f.pt.updateStateFromStatusCode(*f.StatusCodes) {
+			return false, err
+		}
+		return f.pt.hasTerminated(), operationError(f.pt)
+	}
+	if f.StatusCodes != nil && f.pt.updateStateFromStatusCode(*f.StatusCodes) {
+		return f.pt.hasTerminated(), operationError(f.pt)
 	}
 	if err := f.pt.checkForErrors(); err != nil {
 		return f.pt.hasTerminated(), err
@@ -159,32 +180,60 @@ func (f *Future) DoneWithContext(ctx context.Context, sender autorest.Sender) (d
 	if err := f.pt.updatePollingMethod(); err != nil {
 		return false, err
 	}
-	return f.pt.hasTerminated(), f.pt.pollingError()
+	return f.pt.hasTerminated(), operationError(f.pt)
+}
+
+// used as a key type in context.WithValue()
+type ctxConditionalPolling struct{}
+
+// operationError returns the error of an operation that Failed or was Canceled as a *RequestError
+// holding the ServiceError parsed from the operation's error object (code, message, details), and
+// the status code and request id of the latest response.
+func operationError(pt pollingTracker) error {
+	err := pt.pollingError()
+	se, ok := err.(*ServiceError)
+	if !ok || !pt.hasFailed() {
+		return err
+	}
+	resp := pt.latestResponse()
+	re := NewErrorWithError(se, "Future", "DoneWithContext", resp, "long-running operation %s", pt.pollingStatus())
+	re.ServiceError = se
+	if resp != nil {
+		re.RequestID = ExtractRequestID(resp)
+	}
+	return &re
 }
 
+// MinPollingDelay and MaxPollingDelay, when positive, bound the delay returned by
+// Future.GetPollingDelay, protecting against services that ask to be polled without delay or
+// only after an unreasonably long time.
+var (
+	MinPollingDelay time.Duration
+	MaxPollingDelay time.Duration
+)
+
 // GetPollingDelay returns a duration the application should wait before checking
 // the status of the asynchronous request and true; this value is returned from
-// the service via the Retry-After response header.  If the header wasn't returned
-// then the function returns the zero-value time.Duration and false.
+// the service via the retry-after-ms, x-ms-retry-after-ms or Retry-After response
+// header, and is clamped between MinPollingDelay and MaxPollingDelay.  If the header
+// wasn't returned then the function returns the zero-value time.Duration and false.
 func (f Future) GetPollingDelay() (time.Duration, bool) {
 	if f.pt == nil {
 		return 0, false
 	}
-	resp := f.pt.latestResponse()
-	if resp == nil {
+	d, ok := autorest.ParseRetryAfter(f.pt.latestResponse())
+	if !ok {
 		return 0, false
 	}
-
-	retry := resp.Header.Get(autorest.HeaderRetryAfter)
-	if retry == "" {
-		return 0, false
+	if d < MinPollingDelay {
+		d = MinPollingDelay
 	}
-
-	d, err := time.ParseDuration(retry + "s")
-	if err != nil {
-		panic(err)
+	if MaxPollingDelay > 0 && d > MaxPollingDelay {
+		d = MaxPollingDelay
+	}
+	if d < 0 {
+		d = 0
 	}
-
 	return d, true
 }
 
@@ -196,7 +245,14 @@ func (f Future) GetPollingDelay() (time.Duration, bool) {
 // used to determine if a default deadline should be used.
 // If PollingDuration is greater than zero the value will be used as the context's timeout.
 // If PollingDuration is zero then no default deadline will be used.
-func (f *Future) WaitForCompletionRef(ctx context.Context, client autorest.Client) (err error) {
+// If the client has a PollingStrategy it chooses the delay between polls.
+func (f *Future) WaitForCompletionRef(ctx context.Context, client autorest.Client) error {
+	return f.waitForCompletion(ctx, client, nil)
+}
+
+// waitForCompletion implements WaitForCompletionRef, calling onPoll, if not nil, after each poll
+// with the time it started, the time waited before it and its error.
+func (f *Future) waitForCompletion(ctx context.Context, client autorest.Client, onPoll func(start time.Time, delay time.Duration, err error)) (err error) {
 	ctx = tracing.StartSpan(ctx, "github.com/Azure/go-autorest/autorest/azure/async.WaitForCompletionRef")
 	defer func() {
 		sc := -1
@@ -214,16 +270,31 @@ func (f *Future) WaitForCompletionRef(ctx context.Context, client autorest.Clien
 		cancelCtx, cancel = context.WithTimeout(ctx, d)
 		defer cancel()
 	}
+	// the delay preceding a poll is measured from the end of the previous one
+	waitStart := autorest.DefaultClock.Now()
+	pollOnce := func() (bool, error) {
+		start := autorest.DefaultClock.Now()
+		// poll with the cancellable context so that in-flight polls are abandoned when it's done
+		done, err := f.DoneWithContext(cancelCtx, client)
+		if onPoll != nil {
+			onPoll(start, start.Sub(waitStart), err)
+		}
+		waitStart = autorest.DefaultClock.Now()
+		return done, err
+	}
 	// if the initial response has a Retry-After, sleep for the specified amount of time before starting to poll
 	if delay, ok := f.GetPollingDelay(); ok {
 		logger.Instance.Writeln(logger.LogInfo, "WaitForCompletionRef: initial polling delay")
-		if delayElapsed := autorest.DelayForBackoff(delay, 0, cancelCtx.Done()); !delayElapsed {
-			err = cancelCtx.Err()
+		if err = autorest.DelayForBackoffWithContext(cancelCtx, delay, 0, 0); err != nil {
 			return
 		}
 	}
-	done, err := f.DoneWithContext(ctx, client)
-	for attempts := 0; !done; done, err = f.DoneWithContext(ctx, client) {
+	done, err := pollOnce()
+	polls := 0
+	for attempts := 0; !done; done, err = pollOnce() {
+		if ctxErr := cancelCtx.Err(); ctxErr != nil {
+			return autorest.NewErrorWithError(ctxErr, "Future", "WaitForCompletion", f.pt.latestResponse(), "context has been cancelled")
+		}
 		if attempts >= client.RetryAttempts {
 			return autorest.NewErrorWithError(err, "Future", "WaitForCompletion", f.pt.latestResponse(), "the number of retries has been exceeded")
 		}
@@ -231,7 +302,10 @@ func (f *Future) WaitForCompletionRef(ctx context.Context, client autorest.Clien
 		// that DelayForBackoff doesn't perform exponential back-off
 		var delayAttempt int
 		var delay time.Duration
-		if err == nil {
+		if err == nil && client.PollingStrategy != nil {
+			polls++
+			delay = client.PollingStrategy.NextDelay(polls, f.Response())
+		} else if err == nil {
 			// check for Retry-After delay, if not present use the client's polling delay
 			var ok bool
 			delay, ok = f.GetPollingDelay()
@@ -249,9 +323,8 @@ func (f *Future) WaitForCompletionRef(ctx context.Context, client autorest.Clien
 			attempts++
 		}
 		// wait until the delay elapses or the context is cancelled
-		delayElapsed := autorest.DelayForBackoff(delay, delayAttempt, cancelCtx.Done())
-		if !delayElapsed {
-			return autorest.NewErrorWithError(cancelCtx.Err(), "Future", "WaitForCompletion", f.pt.latestResponse(), "context has been cancelled")
+		if ctxErr := autorest.DelayForBackoffWithContext(cancelCtx, delay, 0, delayAttempt); ctxErr != nil {
+			return autorest.NewErrorWithError(ctxErr, "Future", "WaitForCompletion", f.pt.latestResponse(), "context has been cancelled")
 		}
 	}
 	return
@@ -301,6 +374,11 @@ func (f Future) PollingURL() string {
 // GetResult should be called once polling has completed successfully.
 // It makes the final GET call to retrieve the resultant payload.
 func (f Future) GetResult(sender autorest.Sender) (*http.Response, error) {
+	return f.getResultWithContext(context.Background(), sender)
+}
+
+// getResultWithContext is GetResult making the final GET call with the passed context.
+func (f Future) getResultWithContext(ctx context.Context, sender autorest.Sender) (*http.Response, error) {
 	if f.pt.finalGetURL() == "" {
 		// we can end up in this situation if the async operation returns a 200
 		// with no polling URLs.  in that case return the response which should
@@ -310,7 +388,7 @@ func (f Future) GetResult(sender autorest.Sender) (*http.Response, error) {
 		}
 		return nil, autorest.NewError("Future", "GetResult", "missing URL for retrieving result")
 	}
-	req, err := http.NewRequest(http.MethodGet, f.pt.finalGetURL(), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.pt.finalGetURL(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -380,6 +458,13 @@ type pollingTracker interface {
 
 	// returns the cached HTTP response after a call to pollForStatus(), can be nil
 	latestResponse() *http.Response
+
+	// returns the unmarshalled body of the latest response, can be empty
+	latestBody() map[string]interface{}
+
+	// updates the polling state from the status code of the latest response, returning false if
+	// the status code is not among those passed
+	updateStateFromStatusCode(codes autorest.PollingStatusCodes) bool
 }
 
 type pollingTrackerBase struct {
@@ -392,6 +477,9 @@ type pollingTrackerBase struct {
 	// rawBody is the raw JSON response body
 	rawBody map[string]interface{}
 
+	// etag is the ETag of the latest status response
+	etag string
+
 	// denotes if polling is using async-operation or location header
 	Pm PollingMethodType `json:"pollingMethod"`
 
@@ -483,18 +571,34 @@ func (pt *pollingTrackerBase) pollForStatus(ctx context.Context, sender autorest
 	}
 
 	req = req.WithContext(ctx)
-	preparer := autorest.CreatePreparer(autorest.GetPrepareDecorators(ctx)...)
+	decorators := autorest.GetPrepareDecorators(ctx)
+	if pt.resp != nil {
+		decorators = append([]autorest.PrepareDecorator{autorest.WithCarriedForward(ctx, pt.resp.Request)}, decorators...)
+	}
+	preparer := autorest.CreatePreparer(decorators...)
 	req, err = preparer.Prepare(req)
 	if err != nil {
 		return autorest.NewErrorWithError(err, "pollingTrackerBase", "pollForStatus", nil, "failed preparing HTTP request")
 	}
-	pt.resp, err = sender.Do(req)
+	conditional, _ := ctx.Value(ctxConditionalPolling{}).(bool)
+	conditional = conditional && pt.etag != "" && pt.resp != nil
+	if conditional {
+		req.Header.Set(headerIfNoneMatch, pt.etag)
+	}
+	resp, err := sender.Do(req)
+	if err == nil && conditional && resp.StatusCode == http.StatusNotModified {
+		// the status hasn't changed, keep the latest response and its body
+		autorest.DrainResponseBody(resp)
+		return nil
+	}
+	pt.resp = resp
 	if err != nil {
 		return autorest.NewErrorWithError(err, "pollingTrackerBase", "pollForStatus", nil, "failed to send HTTP request")
 	}
 	if autorest.ResponseHasStatusCode(pt.resp, pollingCodes[:]...) {
 		// reset the service error on success case
 		pt.Err = nil
+		pt.etag = pt.resp.Header.Get(headerETag)
 		err = pt.updateRawBody()
 	} else {
 		// check response body for error content
@@ -583,6 +687,23 @@ func (pt *pollingTrackerBase) updatePollingState(provStateApl bool) error {
 	return nil
 }
 
+func (pt *pollingTrackerBase) updateStateFromStatusCode(codes autorest.PollingStatusCodes) bool {
+	switch {
+	case autorest.ResponseHasStatusCode(pt.resp, codes.InProgress...):
+		pt.State = operationInProgress
+		pt.Err = nil
+	case autorest.ResponseHasStatusCode(pt.resp, codes.Succeeded...):
+		pt.State = operationSucceeded
+		pt.Err = nil
+	case autorest.ResponseHasStatusCode(pt.resp, codes.Failed...):
+		pt.State = operationFailed
+		pt.updateErrorFromResponse()
+	default:
+		return false
+	}
+	return true
+}
+
 func (pt pollingTrackerBase) pollingError() error {
 	if pt.Err == nil {
 		return nil
@@ -622,6 +743,10 @@ func (pt pollingTrackerBase) latestResponse() *http.Response {
 	return pt.resp
 }
 
+func (pt pollingTrackerBase) latestBody() map[string]interface{} {
+	return pt.rawBody
+}
+
 // error checking common to all trackers
 func (pt pollingTrackerBase) baseCheckForErrors() error {
 	// for Azure-AsyncOperations the response body cannot be nil or empty
@@ -497,6 +497,29 @@ func TestPollPutTrackerSuccessNoHeaders(t *testing.T) {
 	}
 }
 
+func TestPollForStatusCarriesForward(t *testing.T) {
+	req := mocks.NewRequestWithParams(http.MethodPut, mocks.TestURL+"?api-version=2020-01-01", nil)
+	req.Header.Set("x-ms-client-request-id", "id-1")
+	resp := newAsyncResp(req, http.StatusAccepted, nil)
+	mocks.SetResponseHeader(resp, autorest.HeaderLocation, mocks.TestLocationURL)
+	pt, err := createPollingTracker(resp)
+	if err != nil {
+		t.Fatalf("failed to create tracker: %v", err)
+	}
+	var polled *http.Request
+	sender := autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+		polled = r
+		return newProvisioningStatusResponse("InProgress"), nil
+	})
+	ctx := autorest.WithPollingCarryForward(context.Background(), []string{"x-ms-client-request-id"}, []string{"api-version"})
+	if err = pt.pollForStatus(ctx, sender); err != nil {
+		t.Fatalf("failed to poll for status: %v", err)
+	}
+	if polled.Header.Get("x-ms-client-request-id") != "id-1" || polled.URL.Query().Get("api-version") != "2020-01-01" {
+		t.Fatalf("polling request did not carry forward the header and query parameter -- %v %v", polled.URL, polled.Header)
+	}
+}
+
 func TestPollPutTrackerFailNoHeadersEmptyBody(t *testing.T) {
 	resp := newAsyncResp(newAsyncReq(http.MethodPut, nil), http.StatusAccepted, nil)
 	pt, err := createPollingTracker(resp)
@@ -592,6 +615,126 @@ func TestLocationPollingReturnsUnwrappedError(t *testing.T) {
 	}
 }
 
+func TestFuture_FailedOperationReturnsRequestError(t *testing.T) {
+	body := `{"status": "Failed", "error": {"code": "Conflict", "message": "resource is busy", "details": [{"code": "Locked"}]}}`
+	resp := mocks.NewResponseWithBodyAndStatus(mocks.NewBody(body), http.StatusOK, "200 OK")
+	mocks.SetResponseHeader(resp, HeaderRequestID, "request-1")
+	sender := mocks.NewSender()
+	sender.AppendResponse(resp)
+
+	future, err := NewFutureFromResponse(newSimpleAsyncResp())
+	if err != nil {
+		t.Fatalf("failed to create future: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		done, err := future.DoneWithContext(context.Background(), sender)
+		if !done {
+			t.Fatal("failed operation was not reported as done")
+		}
+		var re *RequestError
+		if !errors.As(err, &re) {
+			t.Fatalf("unexpected error type %T: %v", err, err)
+		}
+		if re.ServiceError == nil || re.ServiceError.Code != "Conflict" || re.ServiceError.Message != "resource is busy" ||
+			len(re.ServiceError.Details) != 1 || re.ServiceError.Details[0]["code"] != "Locked" {
+			t.Fatalf("unexpected service error: %v", re.ServiceError)
+		}
+		if re.RequestID != "request-1" || re.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected request id %q or status code %v", re.RequestID, re.StatusCode)
+		}
+	}
+	if sender.Attempts() != 1 {
+		t.Fatalf("polled a terminated operation -- %d attempts", sender.Attempts())
+	}
+	var re *RequestError
+	if err := (Poller{Future: future}).Err(); !errors.As(err, &re) {
+		t.Fatalf("Poller.Err returned an unexpected error type %T: %v", err, err)
+	}
+}
+
+func TestFuture_StatusCodes(t *testing.T) {
+	codes := &autorest.PollingStatusCodes{
+		InProgress: []int{http.StatusOK},
+		Succeeded:  []int{http.StatusNoContent},
+		Failed:     []int{http.StatusConflict},
+	}
+	for _, final := range []int{http.StatusNoContent, http.StatusConflict} {
+		resp := newAsyncResp(newAsyncReq(http.MethodPost, nil), http.StatusAccepted, nil)
+		mocks.SetResponseHeader(resp, autorest.HeaderLocation, mocks.TestLocationURL)
+		future, err := NewFutureFromResponse(resp)
+		if err != nil {
+			t.Fatalf("failed to create future: %v", err)
+		}
+		future.StatusCodes = codes
+
+		sender := mocks.NewSender()
+		sender.AppendAndRepeatResponse(newProvisioningStatusResponse(operationSucceeded), 2)
+		sender.AppendResponse(mocks.NewResponseWithBodyAndStatus(mocks.NewBody(errorResponse), final, http.StatusText(final)))
+		var done bool
+		for done, err = false, nil; !done && err == nil; {
+			done, err = future.DoneWithContext(context.Background(), sender)
+		}
+		if sender.Attempts() != 3 {
+			t.Fatalf("status %d: stopped polling after %d attempts", final, sender.Attempts())
+		}
+		var re *RequestError
+		if final == http.StatusNoContent && (err != nil || future.Status() != operationSucceeded) {
+			t.Fatalf("status %d: unexpected status %q (%v)", final, future.Status(), err)
+		} else if final == http.StatusConflict && (!errors.As(err, &re) || re.ServiceError.Code != "InvalidParameter" || future.Status() != operationFailed) {
+			t.Fatalf("status %d: unexpected status %q (%v)", final, future.Status(), err)
+		}
+	}
+}
+
+func TestFuture_ConditionalPolling(t *testing.T) {
+	for _, conditional := range []bool{true, false} {
+		future, err := NewFutureFromResponse(newSimpleAsyncResp())
+		if err != nil {
+			t.Fatalf("failed to create future: %v", err)
+		}
+		future.ConditionalPolling = conditional
+
+		var ifNoneMatch []string
+		sender := autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+			ifNoneMatch = append(ifNoneMatch, r.Header.Get(headerIfNoneMatch))
+			var resp *http.Response
+			switch len(ifNoneMatch) {
+			case 1:
+				resp = newOperationResourceResponse(operationInProgress)
+				mocks.SetResponseHeader(resp, headerETag, `"v1"`)
+			case 2:
+				if r.Header.Get(headerIfNoneMatch) != "" {
+					resp = mocks.NewResponseWithStatus("304 Not Modified", http.StatusNotModified)
+				} else {
+					resp = newOperationResourceResponse(operationInProgress)
+				}
+			default:
+				resp = newOperationResourceResponse(operationSucceeded)
+			}
+			resp.Request = r
+			return resp, nil
+		})
+		for i := 0; i < 2; i++ {
+			if done, err := future.DoneWithContext(context.Background(), sender); done || err != nil {
+				t.Fatalf("conditional=%v: unexpected result of poll %d: %v (%v)", conditional, i, done, err)
+			}
+			if future.Status() != operationInProgress || future.Response().StatusCode != http.StatusOK {
+				t.Fatalf("conditional=%v: unexpected status %q after poll %d", conditional, future.Status(), i)
+			}
+		}
+		if done, err := future.DoneWithContext(context.Background(), sender); !done || err != nil {
+			t.Fatalf("conditional=%v: operation did not complete: %v (%v)", conditional, done, err)
+		}
+		expected := []string{"", "", ""}
+		if conditional {
+			expected = []string{"", `"v1"`, `"v1"`}
+		}
+		if !reflect.DeepEqual(ifNoneMatch, expected) {
+			t.Fatalf("conditional=%v: unexpected If-None-Match headers %q", conditional, ifNoneMatch)
+		}
+	}
+}
+
 func TestFuture_PollsUntilProvisioningStatusSucceeds(t *testing.T) {
 	r2 := newOperationResourceResponse("busy")
 	r3 := newOperationResourceResponse(operationSucceeded)
@@ -1074,3 +1217,46 @@ func newProvisioningStatusUnwrappedErrorResponse(status string) *http.Response {
 func setAsyncOpHeader(resp *http.Response, location string) {
 	mocks.SetResponseHeader(resp, http.CanonicalHeaderKey(headerAsyncOperation), location)
 }
+
+func TestFuture_GetPollingDelayIsClamped(t *testing.T) {
+	defer func(min, max time.Duration) {
+		MinPollingDelay, MaxPollingDelay = min, max
+	}(MinPollingDelay, MaxPollingDelay)
+	MinPollingDelay, MaxPollingDelay = time.Second, time.Minute
+
+	cases := []struct {
+		header   string
+		value    string
+		expected time.Duration
+	}{
+		{autorest.HeaderRetryAfter, "0", time.Second},
+		{autorest.HeaderRetryAfter, "30", 30 * time.Second},
+		{autorest.HeaderRetryAfter, "86400", time.Minute},
+		{autorest.HeaderRetryAfterMs, "2500", 2500 * time.Millisecond},
+		{autorest.HeaderXMSRetryAfterMs, "10", time.Second},
+	}
+	for _, c := range cases {
+		resp := newSimpleAsyncResp()
+		mocks.SetResponseHeader(resp, c.header, c.value)
+		future, err := NewFutureFromResponse(resp)
+		if err != nil {
+			t.Fatalf("failed to create future: %v", err)
+		}
+		delay, ok := future.GetPollingDelay()
+		if !ok || delay != c.expected {
+			t.Fatalf("wrong polling delay for %s: %s -- expected %v, got %v", c.header, c.value, c.expected, delay)
+		}
+	}
+}
+
+func TestFuture_GetPollingDelayIgnoresMalformedHeader(t *testing.T) {
+	resp := newSimpleAsyncResp()
+	mocks.SetResponseHeader(resp, autorest.HeaderRetryAfter, "soon")
+	future, err := NewFutureFromResponse(resp)
+	if err != nil {
+		t.Fatalf("failed to create future: %v", err)
+	}
+	if _, ok := future.GetPollingDelay(); ok {
+		t.Fatal("expected no polling delay for a malformed Retry-After header")
+	}
+}
@@ -25,6 +25,8 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync"
+	"time"
 	"unicode/utf16"
 
 	"github.com/Azure/go-autorest/autorest"
@@ -46,6 +48,7 @@ const (
 	CertificatePassword     = "AZURE_CERTIFICATE_PASSWORD"
 	Username                = "AZURE_USERNAME"
 	Password                = "AZURE_PASSWORD"
+	FederatedTokenFile      = "AZURE_FEDERATED_TOKEN_FILE"
 	EnvironmentName         = "AZURE_ENVIRONMENT"
 	Resource                = "AZURE_AD_RESOURCE"
 	ActiveDirectoryEndpoint = "ActiveDirectoryEndpoint"
@@ -60,7 +63,8 @@ const (
 // 1. Client credentials
 // 2. Client certificate
 // 3. Username password
-// 4. MSI
+// 4. Federated token file
+// 5. MSI
 func NewAuthorizerFromEnvironment() (autorest.Authorizer, error) {
 	logger.Instance.Writeln(logger.LogInfo, "NewAuthorizerFromEnvironment() determining authentication mechanism")
 	settings, err := GetSettingsFromEnvironment()
@@ -74,7 +78,8 @@ func NewAuthorizerFromEnvironment() (autorest.Authorizer, error) {
 // 1. Client credentials
 // 2. Client certificate
 // 3. Username password
-// 4. MSI
+// 4. Federated token file
+// 5. MSI
 func NewAuthorizerFromEnvironmentWithResource(resource string) (autorest.Authorizer, error) {
 	logger.Instance.Writeln(logger.LogInfo, "NewAuthorizerFromEnvironmentWithResource() determining authentication mechanism")
 	settings, err := GetSettingsFromEnvironment()
@@ -105,6 +110,7 @@ func GetSettingsFromEnvironment() (s EnvironmentSettings, err error) {
 	s.setValue(CertificatePassword)
 	s.setValue(Username)
 	s.setValue(Password)
+	s.setValue(FederatedTokenFile)
 	s.setValue(EnvironmentName)
 	s.setValue(Resource)
 	if v := s.Values[EnvironmentName]; v == "" {
@@ -191,6 +197,22 @@ func (settings EnvironmentSettings) GetUsernamePassword() (UsernamePasswordConfi
 	return config, nil
 }
 
+// GetFederatedToken creates a config object from the available federated token file, such as the
+// one projected into a pod by Azure AD workload identity.
+// An error is returned if no federated token file is available.
+func (settings EnvironmentSettings) GetFederatedToken() (FederatedTokenConfig, error) {
+	tokenFile := settings.Values[FederatedTokenFile]
+	if tokenFile == "" {
+		logger.Instance.Writeln(logger.LogInfo, "EnvironmentSettings.GetFederatedToken() missing federated token file")
+		return FederatedTokenConfig{}, errors.New("missing federated token file")
+	}
+	clientID, tenantID := settings.getClientAndTenant()
+	config := NewFederatedTokenConfig(tokenFile, clientID, tenantID)
+	config.AADEndpoint = settings.Environment.ActiveDirectoryEndpoint
+	config.Resource = settings.Values[Resource]
+	return config, nil
+}
+
 // GetMSI creates a MSI config object from the available client ID.
 func (settings EnvironmentSettings) GetMSI() MSIConfig {
 	config := NewMSIConfig()
@@ -212,7 +234,8 @@ func (settings EnvironmentSettings) GetDeviceFlow() DeviceFlowConfig {
 // 1. Client credentials
 // 2. Client certificate
 // 3. Username password
-// 4. MSI
+// 4. Federated token file
+// 5. MSI
 func (settings EnvironmentSettings) GetAuthorizer() (autorest.Authorizer, error) {
 	//1.Client Credentials
 	if c, e := settings.GetClientCredentials(); e == nil {
@@ -232,7 +255,13 @@ func (settings EnvironmentSettings) GetAuthorizer() (autorest.Authorizer, error)
 		return c.Authorizer()
 	}
 
-	// 4. MSI
+	// 4. Federated Token File
+	if c, e := settings.GetFederatedToken(); e == nil {
+		logger.Instance.Writeln(logger.LogInfo, "EnvironmentSettings.GetAuthorizer() using federated token file credentials")
+		return c.Authorizer()
+	}
+
+	// 5. MSI
 	if !adal.MSIAvailable(context.Background(), nil) {
 		return nil, errors.New("MSI not available")
 	}
@@ -303,18 +332,84 @@ func NewAuthorizerFromCLI() (autorest.Authorizer, error) {
 }
 
 // NewAuthorizerFromCLIWithResource creates an Authorizer configured from Azure CLI 2.0 for local development scenarios.
+// The token is cached and Azure CLI is invoked again for a new one when it is within five minutes of expiring.
 func NewAuthorizerFromCLIWithResource(resource string) (autorest.Authorizer, error) {
-	token, err := cli.GetTokenFromCLI(resource)
-	if err != nil {
+	tp := &cliTokenProvider{resource: resource}
+	if err := tp.RefreshWithContext(context.Background()); err != nil {
 		return nil, err
 	}
+	return autorest.NewBearerAuthorizer(tp), nil
+}
+
+// cliRefreshWithin is how long before its expiry a token from Azure CLI is replaced.
+const cliRefreshWithin = 5 * time.Minute
+
+// getTokenFromCLI invokes Azure CLI, and is replaced in tests.
+var getTokenFromCLI = cli.GetTokenFromCLI
 
+// cliTokenProvider is an adal.OAuthTokenProvider that caches the token from Azure CLI until it is
+// about to expire.
+type cliTokenProvider struct {
+	mu       sync.RWMutex
+	resource string
+	token    adal.Token
+}
+
+// OAuthToken implements the adal.OAuthTokenProvider interface.
+func (tp *cliTokenProvider) OAuthToken() string {
+	tp.mu.RLock()
+	defer tp.mu.RUnlock()
+	return tp.token.OAuthToken()
+}
+
+// EnsureFreshWithContext gets a new token from Azure CLI if the cached one is about to expire.
+// Concurrent callers share a single invocation of Azure CLI.
+func (tp *cliTokenProvider) EnsureFreshWithContext(ctx context.Context) error {
+	tp.mu.RLock()
+	fresh := !tp.token.WillExpireIn(cliRefreshWithin)
+	tp.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	// another caller may have refreshed the token while we waited for the lock
+	if !tp.token.WillExpireIn(cliRefreshWithin) {
+		return nil
+	}
+	return tp.refreshLocked(ctx)
+}
+
+// RefreshWithContext gets a new token from Azure CLI.
+func (tp *cliTokenProvider) RefreshWithContext(ctx context.Context) error {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return tp.refreshLocked(ctx)
+}
+
+// RefreshExchangeWithContext gets a new token for the passed resource from Azure CLI.
+func (tp *cliTokenProvider) RefreshExchangeWithContext(ctx context.Context, resource string) error {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.resource = resource
+	return tp.refreshLocked(ctx)
+}
+
+// refreshLocked gets a new token from Azure CLI; tp.mu must be held for writing.
+func (tp *cliTokenProvider) refreshLocked(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	token, err := getTokenFromCLI(tp.resource)
+	if err != nil {
+		return err
+	}
 	adalToken, err := token.ToADALToken()
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	return autorest.NewBearerAuthorizer(&adalToken), nil
+	tp.token = adalToken
+	return nil
 }
 
 // GetSettingsFromFile returns the available authentication settings from an Azure CLI authentication file.
@@ -552,6 +647,18 @@ func NewUsernamePasswordConfig(username string, password string, clientID string
 	}
 }
 
+// NewFederatedTokenConfig creates a FederatedTokenConfig object configured to obtain an Authorizer through a federated token file.
+// Defaults to Public Cloud and Resource Manager Endpoint.
+func NewFederatedTokenConfig(tokenFilePath string, clientID string, tenantID string) FederatedTokenConfig {
+	return FederatedTokenConfig{
+		TokenFilePath: tokenFilePath,
+		ClientID:      clientID,
+		TenantID:      tenantID,
+		Resource:      azure.PublicCloud.ResourceManagerEndpoint,
+		AADEndpoint:   azure.PublicCloud.ActiveDirectoryEndpoint,
+	}
+}
+
 // NewMSIConfig creates an MSIConfig object configured to obtain an Authorizer through MSI.
 func NewMSIConfig() MSIConfig {
 	return MSIConfig{
@@ -744,6 +851,50 @@ func (ups UsernamePasswordConfig) Authorizer() (autorest.Authorizer, error) {
 	return autorest.NewBearerAuthorizer(spToken), nil
 }
 
+// FederatedTokenConfig provides the options to get a bearer authorizer from a federated token file.
+// The file is read again on every token refresh, so tokens rotated by the platform are picked up.
+type FederatedTokenConfig struct {
+	ClientID      string
+	TenantID      string
+	TokenFilePath string
+	AADEndpoint   string
+	Resource      string
+}
+
+// ServicePrincipalToken creates a ServicePrincipalToken from a federated token file.
+func (ftc FederatedTokenConfig) ServicePrincipalToken() (*adal.ServicePrincipalToken, error) {
+	oauthConfig, err := adal.NewOAuthConfig(ftc.AADEndpoint, ftc.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	if ftc.TokenFilePath == "" {
+		return nil, errors.New("missing federated token file path")
+	}
+	return adal.NewServicePrincipalTokenFromFederatedTokenCallback(*oauthConfig, ftc.ClientID, ftc.readToken, ftc.Resource)
+}
+
+// readToken reads the federated token from the token file.
+func (ftc FederatedTokenConfig) readToken() (string, error) {
+	b, err := os.ReadFile(ftc.TokenFilePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read federated token file %s: %w", ftc.TokenFilePath, err)
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("federated token file %s is empty", ftc.TokenFilePath)
+	}
+	return token, nil
+}
+
+// Authorizer gets the authorizer from a federated token file.
+func (ftc FederatedTokenConfig) Authorizer() (autorest.Authorizer, error) {
+	spToken, err := ftc.ServicePrincipalToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get oauth token from federated token file: %v", err)
+	}
+	return autorest.NewBearerAuthorizer(spToken), nil
+}
+
 // MSIConfig provides the options to get a bearer authorizer through MSI.
 type MSIConfig struct {
 	Resource string
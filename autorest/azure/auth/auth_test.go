@@ -15,13 +15,24 @@
 package auth
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
 	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/azure/cli"
 )
 
 var (
@@ -144,6 +155,83 @@ func TestEnvGetUsernamePassword(t *testing.T) {
 	}
 }
 
+func TestEnvGetFederatedToken(t *testing.T) {
+	setDefaultEnv()
+	os.Setenv(FederatedTokenFile, "/var/run/secrets/azure/tokens/azure-identity-token")
+	defer os.Unsetenv(FederatedTokenFile)
+	settings, err := GetSettingsFromEnvironment()
+	if err != nil {
+		t.Logf("failed to get settings: %v", err)
+		t.Fail()
+	}
+	cfg, err := settings.GetFederatedToken()
+	if err != nil {
+		t.Logf("failed to get config for federated token: %v", err)
+		t.Fail()
+	}
+	if cfg.TokenFilePath != "/var/run/secrets/azure/tokens/azure-identity-token" {
+		t.Log("bad token file path")
+		t.Fail()
+	}
+	if cfg.ClientID != expectedEnvironment.Values[ClientID] || cfg.TenantID != expectedEnvironment.Values[TenantID] {
+		t.Log("bad client or tenant ID")
+		t.Fail()
+	}
+	// the token file is only read when the token is refreshed
+	_, err = cfg.Authorizer()
+	if err != nil {
+		t.Logf("failed to get authorizer for federated token: %v", err)
+		t.Fail()
+	}
+}
+
+func TestFederatedTokenConfigReadsTokenFileOnRefresh(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.FormValue("client_assertion") {
+		case "aaa.aaa":
+			w.Write([]byte(`{"access_token":"A","expires_in":"3600"}`))
+		case "bbb.bbb":
+			w.Write([]byte(`{"access_token":"B","expires_in":"3600"}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	cfg := NewFederatedTokenConfig(tokenFile, "client", "tenant")
+	cfg.AADEndpoint = server.URL
+	spt, err := cfg.ServicePrincipalToken()
+	if err != nil {
+		t.Fatalf("failed to create service principal token: %v", err)
+	}
+	if err := spt.Refresh(); err == nil {
+		t.Fatal("expected an error for a missing token file")
+	}
+	for _, token := range []string{"aaa.aaa\n", "bbb.bbb"} {
+		if err := os.WriteFile(tokenFile, []byte(token), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := spt.Refresh(); err != nil {
+			t.Fatalf("failed to refresh token: %v", err)
+		}
+		if expected := strings.ToUpper(token[:1]); spt.OAuthToken() != expected {
+			t.Fatalf("expected access token %s, got %s", expected, spt.OAuthToken())
+		}
+	}
+	if err := os.WriteFile(tokenFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := spt.Refresh(); err == nil {
+		t.Fatal("expected an error for an empty token file")
+	}
+
+	cfg.TokenFilePath = ""
+	if _, err := cfg.ServicePrincipalToken(); err == nil {
+		t.Fatal("expected an error for a missing token file path")
+	}
+}
+
 func TestEnvGetMSI(t *testing.T) {
 	setDefaultEnv()
 	settings, err := GetSettingsFromEnvironment()
@@ -320,3 +408,97 @@ func TestMultitenantClientCredentials(t *testing.T) {
 		t.Fatal("authorizer doesn't implement MultiTenantServicePrincipalTokenAuthorizer")
 	}
 }
+
+func TestNewAuthorizerFromCLIRefreshesNearExpiry(t *testing.T) {
+	expiresOn := []time.Duration{time.Minute, time.Hour}
+	calls := 0
+	getTokenFromCLI = func(resource string) (*cli.Token, error) {
+		if resource != "https://vault.azure.net" {
+			t.Fatalf("unexpected resource %s", resource)
+		}
+		token := &cli.Token{
+			AccessToken: fmt.Sprintf("token%d", calls),
+			ExpiresOn:   time.Now().Add(expiresOn[calls]).Format(time.RFC3339),
+			TokenType:   "Bearer",
+		}
+		calls++
+		return token, nil
+	}
+	defer func() { getTokenFromCLI = cli.GetTokenFromCLI }()
+
+	a, err := NewAuthorizerFromCLIWithResource("https://vault.azure.net")
+	if err != nil {
+		t.Fatalf("failed to get authorizer: %v", err)
+	}
+	tp := a.(*autorest.BearerAuthorizer).TokenProvider()
+	if tp.OAuthToken() != "token0" {
+		t.Fatalf("unexpected token %s", tp.OAuthToken())
+	}
+
+	// the first token expires within the refresh window so Azure CLI is invoked again
+	refresher := tp.(adal.RefresherWithContext)
+	if err := refresher.EnsureFreshWithContext(context.Background()); err != nil {
+		t.Fatalf("failed to refresh token: %v", err)
+	}
+	if tp.OAuthToken() != "token1" {
+		t.Fatalf("unexpected token %s", tp.OAuthToken())
+	}
+
+	// the second token is cached
+	if err := refresher.EnsureFreshWithContext(context.Background()); err != nil {
+		t.Fatalf("failed to refresh token: %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 calls to Azure CLI, got %d", calls)
+	}
+}
+
+func TestNewAuthorizerFromCLIConcurrentRefresh(t *testing.T) {
+	var calls int32
+	getTokenFromCLI = func(string) (*cli.Token, error) {
+		n := atomic.AddInt32(&calls, 1)
+		expiresIn := time.Hour
+		if n == 1 {
+			expiresIn = time.Minute
+		}
+		time.Sleep(10 * time.Millisecond)
+		return &cli.Token{
+			AccessToken: fmt.Sprintf("token%d", n),
+			ExpiresOn:   time.Now().Add(expiresIn).Format(time.RFC3339),
+			TokenType:   "Bearer",
+		}, nil
+	}
+	defer func() { getTokenFromCLI = cli.GetTokenFromCLI }()
+
+	a, err := NewAuthorizerFromCLIWithResource("https://vault.azure.net")
+	if err != nil {
+		t.Fatalf("failed to get authorizer: %v", err)
+	}
+	refresher := a.(*autorest.BearerAuthorizer).TokenProvider().(adal.RefresherWithContext)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := refresher.EnsureFreshWithContext(context.Background()); err != nil {
+				t.Errorf("failed to refresh token: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 2 {
+		t.Fatalf("expected 2 calls to Azure CLI, got %d", calls)
+	}
+}
+
+func TestNewAuthorizerFromCLIFails(t *testing.T) {
+	getTokenFromCLI = func(string) (*cli.Token, error) {
+		return nil, errors.New("az not found")
+	}
+	defer func() { getTokenFromCLI = cli.GetTokenFromCLI }()
+
+	if _, err := NewAuthorizerFromCLIWithResource("https://vault.azure.net"); err == nil {
+		t.Fatal("unexpected nil error")
+	}
+}
@@ -40,6 +40,9 @@ const (
 	// HeaderContentType is the type of the content in the HTTP response.
 	HeaderContentType = "Content-Type"
 
+	// HeaderContentLanguage is the language of the content in the HTTP response.
+	HeaderContentLanguage = "Content-Language"
+
 	// HeaderRequestID is the Azure extension header of the service generated request ID returned
 	// in the response.
 	HeaderRequestID = "x-ms-request-id"
@@ -54,6 +57,10 @@ type ServiceError struct {
 	Details        []map[string]interface{} `json:"details"`
 	InnerError     map[string]interface{}   `json:"innererror"`
 	AdditionalInfo []map[string]interface{} `json:"additionalInfo"`
+
+	// Language is the language of Message when reported by the service, either in the error
+	// body or through the response's Content-Language header.
+	Language string `json:"-"`
 }
 
 func (se ServiceError) Error() string {
@@ -96,7 +103,6 @@ func (se *ServiceError) UnmarshalJSON(b []byte) error {
 
 	type serviceErrorInternal struct {
 		Code           string                   `json:"code"`
-		Message        string                   `json:"message"`
 		Target         *string                  `json:"target,omitempty"`
 		AdditionalInfo []map[string]interface{} `json:"additionalInfo,omitempty"`
 		// not all services conform to the OData v4 spec.
@@ -107,6 +113,9 @@ func (se *ServiceError) UnmarshalJSON(b []byte) error {
 
 		// spec calls for map[string]interface{} but have seen []map[string]interface{} and string
 		InnerError interface{} `json:"innererror,omitempty"`
+
+		// spec calls for string but OData v3 services send a localized {"lang", "value"} object
+		Message interface{} `json:"message,omitempty"`
 	}
 
 	sei := serviceErrorInternal{}
@@ -117,9 +126,15 @@ func (se *ServiceError) UnmarshalJSON(b []byte) error {
 	// copy the fields we know to be correct
 	se.AdditionalInfo = sei.AdditionalInfo
 	se.Code = sei.Code
-	se.Message = sei.Message
 	se.Target = sei.Target
 
+	if c, ok := sei.Message.(string); ok {
+		se.Message = c
+	} else if c, ok := sei.Message.(map[string]interface{}); ok {
+		se.Message, _ = c["value"].(string)
+		se.Language, _ = c["lang"].(string)
+	}
+
 	// converts an []interface{} to []map[string]interface{}
 	arrayOfObjs := func(v interface{}) ([]map[string]interface{}, bool) {
 		arrayOf, ok := v.([]interface{})
@@ -375,6 +390,9 @@ func WithErrorUnlessStatusCode(codes ...int) autorest.RespondDecorator {
 						e.ServiceError.Details = []map[string]interface{}{rawBody}
 					}
 				}
+				if e.ServiceError.Language == "" {
+					e.ServiceError.Language = resp.Header.Get(HeaderContentLanguage)
+				}
 				e.Response = resp
 				e.RequestID = ExtractRequestID(resp)
 				if e.StatusCode == nil {
@@ -315,6 +315,57 @@ func TestWithErrorUnlessStatusCode_FoundAzureFullError(t *testing.T) {
 
 }
 
+func TestWithErrorUnlessStatusCode_LocalizedMessage(t *testing.T) {
+	j := `{"error": {"code": "ResourceNotFound", "message": "Ressource introuvable."}}`
+	r := mocks.NewResponseWithContent(j)
+	mocks.SetResponseHeader(r, HeaderContentLanguage, "fr-FR")
+	r.Request = mocks.NewRequest()
+	r.StatusCode = http.StatusNotFound
+	r.Status = http.StatusText(r.StatusCode)
+
+	err := autorest.Respond(r,
+		WithErrorUnlessStatusCode(http.StatusOK),
+		autorest.ByClosing())
+
+	azErr, ok := err.(*RequestError)
+	if !ok {
+		t.Fatalf("azure: returned error is not azure.RequestError: %T", err)
+	}
+	if expected := "Ressource introuvable."; azErr.ServiceError.Message != expected {
+		t.Fatalf("azure: wrong error message. expected=%q; got=%q", expected, azErr.ServiceError.Message)
+	}
+	if expected := "fr-FR"; azErr.ServiceError.Language != expected {
+		t.Fatalf("azure: wrong error language. expected=%q; got=%q", expected, azErr.ServiceError.Language)
+	}
+	if strings.Contains(azErr.Error(), "fr-FR") {
+		t.Fatalf("azure: error string unexpectedly contains the language: %s", azErr.Error())
+	}
+}
+
+func TestWithErrorUnlessStatusCode_ODataV3LocalizedMessage(t *testing.T) {
+	j := `{"error": {"code": "PoolNotFound", "message": {"lang": "de-DE", "value": "Der Pool wurde nicht gefunden."}}}`
+	r := mocks.NewResponseWithContent(j)
+	mocks.SetResponseHeader(r, HeaderContentLanguage, "en-US")
+	r.Request = mocks.NewRequest()
+	r.StatusCode = http.StatusNotFound
+	r.Status = http.StatusText(r.StatusCode)
+
+	err := autorest.Respond(r,
+		WithErrorUnlessStatusCode(http.StatusOK),
+		autorest.ByClosing())
+
+	azErr, ok := err.(*RequestError)
+	if !ok {
+		t.Fatalf("azure: returned error is not azure.RequestError: %T", err)
+	}
+	if expected := "Der Pool wurde nicht gefunden."; azErr.ServiceError.Message != expected {
+		t.Fatalf("azure: wrong error message. expected=%q; got=%q", expected, azErr.ServiceError.Message)
+	}
+	if expected := "de-DE"; azErr.ServiceError.Language != expected {
+		t.Fatalf("azure: wrong error language. expected=%q; got=%q", expected, azErr.ServiceError.Language)
+	}
+}
+
 func TestWithErrorUnlessStatusCode_LiteralNullValueInResponse(t *testing.T) {
 	// As found in the Log Analytics Cluster API
 	// API Bug: https://github.com/Azure/azure-rest-api-specs/issues/12331
@@ -0,0 +1,407 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// Poller is a Future bound to the Client used to poll it, as created by ByCreatingPoller. Poll
+// checks the status of the operation once, so callers can drive polling incrementally (e.g. from
+// a reconciliation loop), while PollUntilDone blocks until the operation completes.
+type Poller struct {
+	Future
+
+	// Client is used to poll the operation and retrieve its result.
+	Client autorest.Client
+
+	// Decoder, if not nil, returns the RespondDecorator that Result and ResultInto use to unmarshal
+	// the result, e.g. autorest.ByUnmarshallingXML. JSON is used by default.
+	Decoder func(v interface{}) autorest.RespondDecorator
+
+	history []PollAttempt
+}
+
+// PollAttempt describes one poll of a long-running operation, as returned by Poller.History.
+type PollAttempt struct {
+	// Time is the time the poll was sent.
+	Time time.Time
+
+	// Delay is the time waited before the poll, from the end of the previous poll or, for the
+	// first poll made by PollUntilDone, from the call.
+	Delay time.Duration
+
+	// StatusCode is the status code of the response, or zero if no response was received.
+	StatusCode int
+
+	// Status is the status of the operation reported by the service.
+	Status string
+
+	// Err is the error returned by the poll, if any.
+	Err error
+}
+
+// OperationProgress describes the progress of a long-running operation as reported in the body of
+// an Azure-AsyncOperation status monitor. Fields not reported by the service are left zero.
+type OperationProgress struct {
+	// Name is the name of the operation.
+	Name string
+
+	// Status is the status of the operation, e.g. InProgress or Succeeded.
+	Status string
+
+	// PercentComplete is the completed percentage of the operation, or nil if not reported.
+	PercentComplete *float64
+
+	// StartTime is the time the operation started.
+	StartTime time.Time
+
+	// EndTime is the time the operation completed.
+	EndTime time.Time
+}
+
+// Started returns true if the Poller was created from a long-running operation response.
+func (p Poller) Started() bool {
+	return p.pt != nil
+}
+
+// Done returns true if the operation has completed, successfully or not. It does not query the
+// service; use Poll to update the status.
+func (p Poller) Done() bool {
+	return p.pt != nil && p.pt.hasTerminated()
+}
+
+// Err returns the error with which the operation failed, a *RequestError when the service reported
+// it, or nil if it has not failed or has not completed.
+func (p Poller) Err() error {
+	if !p.Done() {
+		return nil
+	}
+	return operationError(p.pt)
+}
+
+// Poll queries the service once for the status of the operation, unless it has already completed,
+// and returns the latest response. Use Done to check whether the operation has completed.
+func (p *Poller) Poll(ctx context.Context) (*http.Response, error) {
+	if p.Done() {
+		return p.Response(), p.Err()
+	}
+	start := autorest.DefaultClock.Now()
+	_, err := p.DoneWithContext(ctx, p.Client)
+	p.record(start, 0, err)
+	return p.Response(), err
+}
+
+// PollUntilDone polls the service until the operation has completed, the context is cancelled, or
+// the client's polling duration has been exceeded (see Future.WaitForCompletionRef), and returns
+// the latest response.
+func (p *Poller) PollUntilDone(ctx context.Context) (*http.Response, error) {
+	err := p.waitForCompletion(ctx, p.Client, p.record)
+	return p.Response(), err
+}
+
+// Progress returns the operation name, status, percentComplete, startTime and endTime reported
+// in the latest Azure-AsyncOperation status body, which may be used to show progress or estimate
+// completion. It returns false if the operation isn't polled through an Azure-AsyncOperation
+// header or the latest response contains no status.
+func (p Poller) Progress() (OperationProgress, bool) {
+	if p.pt == nil || p.pt.pollingMethod() != PollingAsyncOperation {
+		return OperationProgress{}, false
+	}
+	body := p.pt.latestBody()
+	status, ok := body["status"].(string)
+	if !ok {
+		return OperationProgress{}, false
+	}
+	progress := OperationProgress{Status: status}
+	progress.Name, _ = body["name"].(string)
+	if pc, ok := body["percentComplete"].(float64); ok {
+		progress.PercentComplete = &pc
+	}
+	progress.StartTime = parseOperationTime(body["startTime"])
+	progress.EndTime = parseOperationTime(body["endTime"])
+	return progress, true
+}
+
+// parseOperationTime parses an RFC 3339 time, with or without a time zone (assumed to be UTC),
+// returning the zero time if it is missing or malformed.
+func parseOperationTime(v interface{}) time.Time {
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// PollingEvent reports the state of a long-running operation, as sent by Poller.Watch.
+type PollingEvent struct {
+	// PollAttempt describes the poll that produced the event. Err is also set, without a poll,
+	// when polling stops before the operation completes, e.g. once the client's polling duration
+	// has been exceeded.
+	PollAttempt
+
+	// Done is true if the operation has completed, successfully or not.
+	Done bool
+}
+
+// Watch polls the service as PollUntilDone does, in a new goroutine, and sends a PollingEvent on
+// the returned channel after each poll, so callers can follow the operation in a select loop. The
+// channel is closed once the operation has completed, polling has stopped with an error, or the
+// context is done. The Poller must not be used until the channel is closed.
+func (p *Poller) Watch(ctx context.Context) <-chan PollingEvent {
+	events := make(chan PollingEvent)
+	send := func(e PollingEvent) {
+		select {
+		case events <- e:
+		case <-ctx.Done():
+		}
+	}
+	go func() {
+		defer close(events)
+		err := p.waitForCompletion(ctx, p.Client, func(start time.Time, delay time.Duration, err error) {
+			p.record(start, delay, err)
+			send(PollingEvent{PollAttempt: p.history[len(p.history)-1], Done: p.Done()})
+		})
+		if err != nil && !p.Done() && ctx.Err() == nil {
+			send(PollingEvent{PollAttempt: PollAttempt{Time: autorest.DefaultClock.Now(), Status: p.Status(), Err: err}})
+		}
+	}()
+	return events
+}
+
+// History returns the polls made by Poll and PollUntilDone, oldest first.
+func (p Poller) History() []PollAttempt {
+	return append([]PollAttempt(nil), p.history...)
+}
+
+// record adds a poll to the history.
+func (p *Poller) record(start time.Time, delay time.Duration, err error) {
+	attempt := PollAttempt{
+		Time:   start,
+		Delay:  delay,
+		Status: p.Status(),
+		Err:    err,
+	}
+	if resp := p.Response(); resp != nil {
+		attempt.StatusCode = resp.StatusCode
+	}
+	p.history = append(p.history, attempt)
+}
+
+// Result unmarshals the body of the terminal response of the completed operation into the value
+// pointed to by v, using the Decoder. The body of the response remains readable. It returns an
+// error if the operation has not completed or has failed. Use ResultInto for operations whose
+// resource must be retrieved with a final GET.
+func (p Poller) Result(v interface{}) (*http.Response, error) {
+	resp := p.Response()
+	if !p.Done() {
+		return resp, autorest.NewError("azure", "Result", "the operation has not completed")
+	}
+	if err := p.Err(); err != nil {
+		return resp, err
+	}
+	if resp == nil || resp.Body == nil {
+		return resp, autorest.NewError("azure", "Result", "the operation has no response body")
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return resp, autorest.NewErrorWithError(err, "azure", "Result", resp, "Failure reading the response body")
+	}
+	decode := *resp
+	decode.Body = io.NopCloser(bytes.NewReader(b))
+	if err = autorest.Respond(&decode, p.decoder(v)); err != nil {
+		return resp, autorest.NewErrorWithError(err, "azure", "Result", resp, "Failure unmarshalling the result")
+	}
+	return resp, nil
+}
+
+// decoder returns the RespondDecorator that unmarshals results into v.
+func (p Poller) decoder(v interface{}) autorest.RespondDecorator {
+	if p.Decoder == nil {
+		return autorest.ByUnmarshallingJSON(v)
+	}
+	return p.Decoder(v)
+}
+
+// ResultInto makes the final GET call for the completed operation, against the URL chosen by the
+// HTTP method of the original request (the original resource URL for PUT and PATCH, the Location
+// target for POST and DELETE), and unmarshals the returned resource into the value pointed to by
+// v using the Decoder. It returns an error if the operation has not completed or has failed.
+func (p Poller) ResultInto(ctx context.Context, v interface{}) (*http.Response, error) {
+	if !p.Done() {
+		return p.Response(), autorest.NewError("azure", "ResultInto", "the operation has not completed")
+	}
+	if err := p.Err(); err != nil {
+		return p.Response(), err
+	}
+	resp, err := p.getResultWithContext(ctx, p.Client)
+	if err != nil {
+		return resp, autorest.NewErrorWithError(err, "azure", "ResultInto", resp, "Failure retrieving the result")
+	}
+	err = autorest.Respond(resp,
+		WithErrorUnlessStatusCode(http.StatusOK, http.StatusCreated),
+		p.decoder(v),
+		autorest.ByClosing())
+	if err != nil {
+		return resp, autorest.NewErrorWithError(err, "azure", "ResultInto", resp, "Failure responding to the result request")
+	}
+	return resp, nil
+}
+
+// MarshalJSON implements the json.Marshaler interface. It saves the polling URL, method and state
+// of the operation, but not the Client, so polling can be resumed with ResumePoller, e.g. by a
+// process that restarted mid-operation.
+func (p Poller) MarshalJSON() ([]byte, error) {
+	return p.Future.MarshalJSON()
+}
+
+// ResumePoller returns a Poller restored from the passed data, as produced by Poller.MarshalJSON,
+// bound to a new Client that authorizes its polling requests with the passed Authorizer. The
+// Client has the default polling and retry settings, which may be changed before polling.
+func ResumePoller(data []byte, authorizer autorest.Authorizer) (Poller, error) {
+	var f Future
+	if err := f.UnmarshalJSON(data); err != nil {
+		return Poller{}, autorest.NewErrorWithError(err, "azure", "ResumePoller", nil, "Failure restoring the polling state")
+	}
+	client := autorest.NewClientWithUserAgent("")
+	client.Authorizer = authorizer
+	return Poller{Future: f, Client: client}, nil
+}
+
+// ByCreatingPoller returns a RespondDecorator that sets the Poller pointed to by p from a 201
+// Created or 202 Accepted response carrying a Location or Azure-AsyncOperation header, binding it
+// to the passed Client. Other responses leave the Poller unchanged, so Started reports whether the
+// operation needs polling.
+func ByCreatingPoller(client autorest.Client, p *Poller) autorest.RespondDecorator {
+	return func(r autorest.Responder) autorest.Responder {
+		return autorest.ResponderFunc(func(resp *http.Response) error {
+			err := r.Respond(resp)
+			if err != nil || !autorest.ResponseHasStatusCode(resp, http.StatusCreated, http.StatusAccepted) {
+				return err
+			}
+			if resp.Header.Get(autorest.HeaderLocation) == "" && resp.Header.Get(headerAsyncOperation) == "" {
+				return nil
+			}
+			future, err := NewFutureFromResponse(resp)
+			if err != nil {
+				return err
+			}
+			*p = Poller{Future: future, Client: client}
+			return nil
+		})
+	}
+}
+
+// DefaultWaitForAllParallelism is the number of operations WaitForAll polls concurrently.
+const DefaultWaitForAllParallelism = 16
+
+// PollerResult is the outcome of waiting for one Poller with WaitForAllResults.
+type PollerResult struct {
+	// Poller is the Poller waited for.
+	Poller *Poller
+
+	// Response is the latest response of the operation.
+	Response *http.Response
+
+	// Err is the error with which waiting for the operation failed, if any.
+	Err error
+}
+
+// WaitForAllError is returned by WaitForAll when waiting for one or more operations failed.
+type WaitForAllError struct {
+	// Failures holds the results of the failed operations, in the order their Pollers were passed.
+	Failures []PollerResult
+}
+
+func (e WaitForAllError) Error() string {
+	msgs := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		msgs[i] = f.Err.Error()
+	}
+	return fmt.Sprintf("azure: %d long-running operations failed: %s", len(e.Failures), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the errors of the failed operations.
+func (e WaitForAllError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// WaitForAll calls PollUntilDone on the passed Pollers, polling up to
+// DefaultWaitForAllParallelism operations concurrently, and returns once all have completed. It
+// returns a WaitForAllError listing the operations that failed, if any.
+func WaitForAll(ctx context.Context, pollers ...*Poller) error {
+	var failures []PollerResult
+	for _, r := range WaitForAllResults(ctx, DefaultWaitForAllParallelism, pollers...) {
+		if r.Err != nil {
+			failures = append(failures, r)
+		}
+	}
+	if len(failures) > 0 {
+		return WaitForAllError{Failures: failures}
+	}
+	return nil
+}
+
+// WaitForAllResults calls PollUntilDone on the passed Pollers, polling up to parallelism operations
+// concurrently, and returns the result for each, in the order the Pollers were passed. A
+// parallelism less than one polls every operation concurrently. Operations not yet started when the
+// context is done fail with the context's error.
+func WaitForAllResults(ctx context.Context, parallelism int, pollers ...*Poller) []PollerResult {
+	if parallelism < 1 || parallelism > len(pollers) {
+		parallelism = len(pollers)
+	}
+	results := make([]PollerResult, len(pollers))
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, p := range pollers {
+		results[i].Poller = p
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func(r *PollerResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			r.Response, r.Err = r.Poller.PollUntilDone(ctx)
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,47 @@
+//go:build go1.18
+// +build go1.18
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package azure
+
+import (
+	"context"
+)
+
+// TypedFuture is a Poller for a long-running operation whose result is a T. WaitForCompletion
+// returns the result itself, so callers need not retrieve and unmarshal it with a second call. It
+// is named TypedFuture as Future predates generics; operations without a result should use the
+// Poller directly.
+type TypedFuture[T any] struct {
+	Poller
+}
+
+// NewTypedFuture returns a TypedFuture for the operation tracked by the passed Poller, as created
+// by ByCreatingPoller.
+func NewTypedFuture[T any](p Poller) *TypedFuture[T] {
+	return &TypedFuture[T]{Poller: p}
+}
+
+// WaitForCompletion polls until the operation has completed (see Poller.PollUntilDone) and returns
+// its result, retrieved and unmarshalled as Poller.ResultInto does.
+func (f *TypedFuture[T]) WaitForCompletion(ctx context.Context) (T, error) {
+	var result T
+	if _, err := f.PollUntilDone(ctx); err != nil {
+		return result, err
+	}
+	_, err := f.ResultInto(ctx, &result)
+	return result, err
+}
@@ -0,0 +1,77 @@
+//go:build go1.18
+// +build go1.18
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package azure
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+type typedResource struct {
+	ID         string `json:"id"`
+	Properties struct {
+		ProvisioningState string `json:"provisioningState"`
+	} `json:"properties"`
+}
+
+func TestTypedFutureWaitForCompletion(t *testing.T) {
+	sender := mocks.NewSender()
+	sender.AppendResponse(newOperationResourceResponse("busy"))
+	sender.AppendResponse(newOperationResourceResponse(operationSucceeded))
+	sender.AppendResponse(mocks.NewResponseWithContent(`{"id": "r1", "properties": {"provisioningState": "Succeeded"}}`))
+	client := autorest.Client{
+		PollingDelay:    time.Millisecond,
+		PollingDuration: autorest.DefaultPollingDuration,
+		RetryAttempts:   autorest.DefaultRetryAttempts,
+		Sender:          sender,
+	}
+	defaultClock := autorest.DefaultClock
+	autorest.DefaultClock = autorest.NewFakeClock(time.Now())
+	defer func() { autorest.DefaultClock = defaultClock }()
+
+	var p Poller
+	if err := autorest.Respond(newSimpleAsyncResp(), ByCreatingPoller(client, &p)); err != nil {
+		t.Fatalf("ByCreatingPoller returned an unexpected error (%v)", err)
+	}
+	resource, err := NewTypedFuture[typedResource](p).WaitForCompletion(context.Background())
+	if err != nil {
+		t.Fatalf("TypedFuture.WaitForCompletion returned an unexpected error (%v)", err)
+	}
+	if resource.ID != "r1" || resource.Properties.ProvisioningState != operationSucceeded {
+		t.Fatalf("TypedFuture.WaitForCompletion returned an unexpected result %+v", resource)
+	}
+}
+
+func TestTypedFutureWaitForCompletionFails(t *testing.T) {
+	sender := mocks.NewSender()
+	sender.AppendResponse(newOperationResourceResponse(operationFailed))
+	client := autorest.Client{Sender: sender, RetryAttempts: autorest.DefaultRetryAttempts}
+
+	var p Poller
+	if err := autorest.Respond(newSimpleAsyncResp(), ByCreatingPoller(client, &p)); err != nil {
+		t.Fatalf("ByCreatingPoller returned an unexpected error (%v)", err)
+	}
+	resource, err := NewTypedFuture[*typedResource](p).WaitForCompletion(context.Background())
+	if err == nil || resource != nil {
+		t.Fatalf("TypedFuture.WaitForCompletion failed to return the operation error -- %v (%v)", resource, err)
+	}
+}
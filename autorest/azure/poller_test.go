@@ -0,0 +1,485 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+func TestByCreatingPoller(t *testing.T) {
+	sender := mocks.NewSender()
+	sender.AppendResponse(newOperationResourceResponse(operationSucceeded))
+	client := autorest.Client{Sender: sender}
+
+	var p Poller
+	if err := autorest.Respond(newSimpleAsyncResp(), ByCreatingPoller(client, &p)); err != nil {
+		t.Fatalf("ByCreatingPoller returned an unexpected error (%v)", err)
+	}
+	if !p.Started() {
+		t.Fatal("ByCreatingPoller failed to create a poller")
+	}
+	if p.PollingMethod() != PollingAsyncOperation || p.PollingURL() != mocks.TestAzureAsyncURL {
+		t.Fatalf("ByCreatingPoller created a poller with the wrong polling URL %q", p.PollingURL())
+	}
+	if p.Done() {
+		t.Fatal("Poller.Done reported the operation as done before polling")
+	}
+	resp, err := p.Poll(context.Background())
+	if err != nil {
+		t.Fatalf("Poller.Poll returned an unexpected error (%v)", err)
+	}
+	if !p.Done() || p.Err() != nil || resp != p.Response() {
+		t.Fatal("Poller.Poll failed to report the operation as done")
+	}
+}
+
+func TestPollerPollIncrementally(t *testing.T) {
+	sender := mocks.NewSender()
+	sender.AppendResponse(newOperationResourceResponse("busy"))
+	sender.AppendResponse(newOperationResourceResponse(operationFailed))
+	client := autorest.Client{Sender: sender}
+
+	var p Poller
+	if err := autorest.Respond(newSimpleAsyncResp(), ByCreatingPoller(client, &p)); err != nil {
+		t.Fatalf("ByCreatingPoller returned an unexpected error (%v)", err)
+	}
+	if _, err := p.Poll(context.Background()); err != nil || p.Done() {
+		t.Fatalf("Poller.Poll reported a busy operation as done (%v)", err)
+	}
+	if _, err := p.Poll(context.Background()); err == nil || !p.Done() || p.Err() == nil {
+		t.Fatalf("Poller.Poll failed to report the failed operation (%v)", err)
+	}
+	if sender.Attempts() != 2 {
+		t.Fatalf("Poller.Poll polled %d times, expected 2", sender.Attempts())
+	}
+	if _, err := p.Poll(context.Background()); err == nil || sender.Attempts() != 2 {
+		t.Fatal("Poller.Poll polled a completed operation")
+	}
+}
+
+func TestPollerPollUntilDone(t *testing.T) {
+	sender := mocks.NewSender()
+	sender.AppendAndRepeatResponse(newOperationResourceResponse("busy"), 2)
+	sender.AppendResponse(newOperationResourceResponse(operationSucceeded))
+	client := autorest.Client{
+		PollingDelay:    time.Millisecond,
+		PollingDuration: autorest.DefaultPollingDuration,
+		RetryAttempts:   autorest.DefaultRetryAttempts,
+		Sender:          sender,
+	}
+	defaultClock := autorest.DefaultClock
+	autorest.DefaultClock = autorest.NewFakeClock(time.Now())
+	defer func() { autorest.DefaultClock = defaultClock }()
+
+	var p Poller
+	if err := autorest.Respond(newSimpleAsyncResp(), ByCreatingPoller(client, &p)); err != nil {
+		t.Fatalf("ByCreatingPoller returned an unexpected error (%v)", err)
+	}
+	resp, err := p.PollUntilDone(context.Background())
+	if err != nil {
+		t.Fatalf("Poller.PollUntilDone returned an unexpected error (%v)", err)
+	}
+	if !p.Done() || resp == nil || sender.Attempts() != 3 {
+		t.Fatalf("Poller.PollUntilDone stopped before the operation completed -- %d attempts", sender.Attempts())
+	}
+}
+
+func TestByCreatingPollerIgnoresCompletedResponses(t *testing.T) {
+	var p Poller
+	resp := newAsyncResp(newAsyncReq(http.MethodPut, nil), http.StatusOK, mocks.NewBody(""))
+	if err := autorest.Respond(resp, ByCreatingPoller(autorest.Client{}, &p)); err != nil {
+		t.Fatalf("ByCreatingPoller returned an unexpected error (%v)", err)
+	}
+	if p.Started() {
+		t.Fatal("ByCreatingPoller created a poller for a completed response")
+	}
+
+	resp = newAsyncResp(newAsyncReq(http.MethodPut, nil), http.StatusAccepted, mocks.NewBody(""))
+	if err := autorest.Respond(resp, ByCreatingPoller(autorest.Client{}, &p)); err != nil || p.Started() {
+		t.Fatalf("ByCreatingPoller created a poller for a response without polling headers (%v)", err)
+	}
+}
+
+func TestResumePoller(t *testing.T) {
+	var p Poller
+	if err := autorest.Respond(newSimpleAsyncResp(), ByCreatingPoller(autorest.Client{}, &p)); err != nil {
+		t.Fatalf("ByCreatingPoller returned an unexpected error (%v)", err)
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("failed to marshal the poller: %v", err)
+	}
+
+	resumed, err := ResumePoller(data, autorest.NewAPIKeyAuthorizerWithHeaders(map[string]interface{}{"x-api-key": "secret"}))
+	if err != nil {
+		t.Fatalf("ResumePoller returned an unexpected error (%v)", err)
+	}
+	if !resumed.Started() || resumed.PollingURL() != p.PollingURL() || resumed.PollingMethod() != p.PollingMethod() {
+		t.Fatalf("ResumePoller restored the wrong state -- %q", resumed.PollingURL())
+	}
+
+	var key string
+	resumed.Client.Sender = autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+		key = r.Header.Get("x-api-key")
+		resp := newOperationResourceResponse(operationSucceeded)
+		resp.Request = r
+		return resp, nil
+	})
+	if _, err = resumed.Poll(context.Background()); err != nil {
+		t.Fatalf("Poller.Poll returned an unexpected error (%v)", err)
+	}
+	if !resumed.Done() || key != "secret" {
+		t.Fatalf("resumed Poller failed to poll with the authorizer -- key %q", key)
+	}
+
+	if _, err = ResumePoller([]byte(`{}`), nil); err == nil {
+		t.Fatal("ResumePoller failed to return an error for invalid data")
+	}
+}
+
+func TestPollerPollUntilDoneAbandonsInFlightPoll(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	client := autorest.Client{
+		PollingDelay:  time.Millisecond,
+		RetryAttempts: autorest.DefaultRetryAttempts,
+		Sender: autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+			cancel()
+			<-r.Context().Done()
+			return nil, r.Context().Err()
+		}),
+	}
+
+	var p Poller
+	if err := autorest.Respond(newSimpleAsyncResp(), ByCreatingPoller(client, &p)); err != nil {
+		t.Fatalf("ByCreatingPoller returned an unexpected error (%v)", err)
+	}
+	if _, err := p.PollUntilDone(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Poller.PollUntilDone returned an unexpected error -- expected %v, received %v", context.Canceled, err)
+	}
+}
+
+func TestPollerPollUntilDoneUsesPollingStrategy(t *testing.T) {
+	sender := mocks.NewSender()
+	sender.AppendAndRepeatResponse(newOperationResourceResponse("busy"), 3)
+	sender.AppendResponse(newOperationResourceResponse(operationSucceeded))
+	client := autorest.Client{
+		PollingDelay:    time.Hour,
+		PollingStrategy: autorest.LinearPolling(time.Second, time.Second, 0),
+		RetryAttempts:   autorest.DefaultRetryAttempts,
+		Sender:          sender,
+	}
+	defaultClock := autorest.DefaultClock
+	fc := autorest.NewFakeClock(time.Now())
+	autorest.DefaultClock = fc
+	defer func() { autorest.DefaultClock = defaultClock }()
+	start := fc.Now()
+
+	resp := newSimpleAsyncResp()
+	resp.Header.Del(autorest.HeaderRetryAfter)
+	var p Poller
+	if err := autorest.Respond(resp, ByCreatingPoller(client, &p)); err != nil {
+		t.Fatalf("ByCreatingPoller returned an unexpected error (%v)", err)
+	}
+	if _, err := p.PollUntilDone(context.Background()); err != nil {
+		t.Fatalf("Poller.PollUntilDone returned an unexpected error (%v)", err)
+	}
+	if elapsed := fc.Now().Sub(start); elapsed != 6*time.Second {
+		t.Fatalf("Poller.PollUntilDone waited %v, expected %v", elapsed, 6*time.Second)
+	}
+}
+
+func TestPollerHistory(t *testing.T) {
+	sender := mocks.NewSender()
+	sender.AppendAndRepeatResponse(newOperationResourceResponse("busy"), 2)
+	sender.AppendResponse(newOperationResourceResponse(operationSucceeded))
+	client := autorest.Client{
+		PollingStrategy: autorest.LinearPolling(time.Second, time.Second, 0),
+		RetryAttempts:   autorest.DefaultRetryAttempts,
+		Sender:          sender,
+	}
+	defaultClock := autorest.DefaultClock
+	fc := autorest.NewFakeClock(time.Now())
+	autorest.DefaultClock = fc
+	defer func() { autorest.DefaultClock = defaultClock }()
+	start := fc.Now()
+
+	resp := newSimpleAsyncResp()
+	resp.Header.Del(autorest.HeaderRetryAfter)
+	var p Poller
+	if err := autorest.Respond(resp, ByCreatingPoller(client, &p)); err != nil {
+		t.Fatalf("ByCreatingPoller returned an unexpected error (%v)", err)
+	}
+	if _, err := p.Poll(context.Background()); err != nil {
+		t.Fatalf("Poller.Poll returned an unexpected error (%v)", err)
+	}
+	if _, err := p.PollUntilDone(context.Background()); err != nil {
+		t.Fatalf("Poller.PollUntilDone returned an unexpected error (%v)", err)
+	}
+
+	h := p.History()
+	expected := []PollAttempt{
+		{Time: start, Delay: 0, StatusCode: http.StatusOK, Status: "busy"},
+		{Time: start, Delay: 0, StatusCode: http.StatusOK, Status: "busy"},
+		{Time: start.Add(time.Second), Delay: time.Second, StatusCode: http.StatusOK, Status: operationSucceeded},
+	}
+	if len(h) != len(expected) {
+		t.Fatalf("Poller.History returned %d attempts, expected %d -- %v", len(h), len(expected), h)
+	}
+	for i := range expected {
+		if !h[i].Time.Equal(expected[i].Time) || h[i].Delay != expected[i].Delay || h[i].StatusCode != expected[i].StatusCode ||
+			h[i].Status != expected[i].Status || h[i].Err != nil {
+			t.Fatalf("Poller.History returned an unexpected attempt %d -- expected %+v, received %+v", i, expected[i], h[i])
+		}
+	}
+	h[0].Status = "changed"
+	if p.History()[0].Status != "busy" {
+		t.Fatal("Poller.History returned the poller's own slice")
+	}
+}
+
+func TestPollerProgress(t *testing.T) {
+	sender := mocks.NewSender()
+	sender.AppendResponse(newOperationResourceResponse(operationInProgress))
+	sender.AppendResponse(mocks.NewResponseWithBodyAndStatus(mocks.NewBody(`{"status": "Succeeded", "endTime": "2006-01-02T16:04:05.1234567"}`), http.StatusOK, "200 OK"))
+	client := autorest.Client{Sender: sender}
+
+	var p Poller
+	if err := autorest.Respond(newSimpleAsyncResp(), ByCreatingPoller(client, &p)); err != nil {
+		t.Fatalf("ByCreatingPoller returned an unexpected error (%v)", err)
+	}
+	if _, err := p.Poll(context.Background()); err != nil {
+		t.Fatalf("Poller.Poll returned an unexpected error (%v)", err)
+	}
+	progress, ok := p.Progress()
+	if !ok || progress.Name != "sameguid" || progress.Status != operationInProgress || progress.PercentComplete == nil || *progress.PercentComplete != 50 {
+		t.Fatalf("Poller.Progress returned unexpected progress %+v", progress)
+	}
+	if start := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC); !progress.StartTime.Equal(start) || !progress.EndTime.Equal(start.Add(time.Hour)) {
+		t.Fatalf("Poller.Progress returned unexpected times %v - %v", progress.StartTime, progress.EndTime)
+	}
+
+	if _, err := p.Poll(context.Background()); err != nil {
+		t.Fatalf("Poller.Poll returned an unexpected error (%v)", err)
+	}
+	progress, ok = p.Progress()
+	if !ok || progress.Status != operationSucceeded || progress.PercentComplete != nil || progress.Name != "" ||
+		!progress.StartTime.IsZero() || !progress.EndTime.Equal(time.Date(2006, 1, 2, 16, 4, 5, 123456700, time.UTC)) {
+		t.Fatalf("Poller.Progress returned unexpected progress %+v", progress)
+	}
+
+	resp := newAsyncResp(newAsyncReq(http.MethodDelete, nil), http.StatusAccepted, nil)
+	mocks.SetResponseHeader(resp, autorest.HeaderLocation, mocks.TestLocationURL)
+	var lp Poller
+	if err := autorest.Respond(resp, ByCreatingPoller(client, &lp)); err != nil {
+		t.Fatalf("ByCreatingPoller returned an unexpected error (%v)", err)
+	}
+	if _, ok := lp.Progress(); ok {
+		t.Fatal("Poller.Progress returned progress for an operation polled through its Location")
+	}
+}
+
+func TestPollerWatch(t *testing.T) {
+	sender := mocks.NewSender()
+	sender.AppendAndRepeatResponse(newOperationResourceResponse("busy"), 2)
+	sender.AppendResponse(newOperationResourceResponse(operationSucceeded))
+	client := autorest.Client{
+		PollingDelay:    time.Second,
+		PollingDuration: autorest.DefaultPollingDuration,
+		RetryAttempts:   autorest.DefaultRetryAttempts,
+		Sender:          sender,
+	}
+	defaultClock := autorest.DefaultClock
+	autorest.DefaultClock = autorest.NewFakeClock(time.Now())
+	defer func() { autorest.DefaultClock = defaultClock }()
+
+	var p Poller
+	if err := autorest.Respond(newSimpleAsyncResp(), ByCreatingPoller(client, &p)); err != nil {
+		t.Fatalf("ByCreatingPoller returned an unexpected error (%v)", err)
+	}
+	var statuses []string
+	var done []bool
+	for e := range p.Watch(context.Background()) {
+		if e.Err != nil {
+			t.Fatalf("Poller.Watch sent an unexpected error (%v)", e.Err)
+		}
+		statuses = append(statuses, e.Status)
+		done = append(done, e.Done)
+	}
+	if expected := []string{"busy", "busy", operationSucceeded}; !reflect.DeepEqual(statuses, expected) {
+		t.Fatalf("Poller.Watch sent unexpected statuses -- expected %v, received %v", expected, statuses)
+	}
+	if expected := []bool{false, false, true}; !reflect.DeepEqual(done, expected) {
+		t.Fatalf("Poller.Watch sent unexpected completion flags -- expected %v, received %v", expected, done)
+	}
+	if !p.Done() || len(p.History()) != 3 {
+		t.Fatalf("Poller.Watch left the poller incomplete -- %d polls recorded", len(p.History()))
+	}
+}
+
+func TestPollerWatchStopsWhenContextIsDone(t *testing.T) {
+	sender := mocks.NewSender()
+	sender.AppendAndRepeatResponse(newOperationResourceResponse("busy"), 100)
+	client := autorest.Client{
+		PollingDelay:    time.Millisecond,
+		PollingDuration: autorest.DefaultPollingDuration,
+		RetryAttempts:   autorest.DefaultRetryAttempts,
+		Sender:          sender,
+	}
+
+	var p Poller
+	resp := newSimpleAsyncResp()
+	resp.Header.Del(autorest.HeaderRetryAfter)
+	if err := autorest.Respond(resp, ByCreatingPoller(client, &p)); err != nil {
+		t.Fatalf("ByCreatingPoller returned an unexpected error (%v)", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	events := p.Watch(ctx)
+	if e := <-events; e.Done || e.Status != "busy" {
+		t.Fatalf("Poller.Watch sent an unexpected event %+v", e)
+	}
+	cancel()
+	for range events {
+	}
+	if p.Done() {
+		t.Fatal("Poller.Watch completed an operation that is still running")
+	}
+}
+
+func TestPollerResultInto(t *testing.T) {
+	sender := mocks.NewSender()
+	sender.AppendResponse(newOperationResourceResponse(operationSucceeded))
+	sender.AppendResponse(mocks.NewResponseWithContent(`{"id": "r1", "properties": {"provisioningState": "Succeeded"}}`))
+	client := autorest.Client{Sender: sender}
+
+	var p Poller
+	if err := autorest.Respond(newSimpleAsyncResp(), ByCreatingPoller(client, &p)); err != nil {
+		t.Fatalf("ByCreatingPoller returned an unexpected error (%v)", err)
+	}
+	var resource struct {
+		ID string `json:"id"`
+	}
+	if _, err := p.ResultInto(context.Background(), &resource); err == nil {
+		t.Fatal("Poller.ResultInto failed to return an error before the operation completed")
+	}
+	if _, err := p.Poll(context.Background()); err != nil || !p.Done() {
+		t.Fatalf("Poller.Poll failed to complete the operation (%v)", err)
+	}
+	resp, err := p.ResultInto(context.Background(), &resource)
+	if err != nil {
+		t.Fatalf("Poller.ResultInto returned an unexpected error (%v)", err)
+	}
+	if resource.ID != "r1" {
+		t.Fatalf("Poller.ResultInto failed to unmarshal the resource -- %+v", resource)
+	}
+	if resp.Request.Method != http.MethodGet || resp.Request.URL.String() != mocks.TestURL {
+		t.Fatalf("Poller.ResultInto sent the final GET to the wrong URL %s %s", resp.Request.Method, resp.Request.URL)
+	}
+}
+
+func TestWaitForAll(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	newPoller := func(status string) *Poller {
+		client := autorest.Client{
+			RetryAttempts: autorest.DefaultRetryAttempts,
+			Sender: autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+				mu.Lock()
+				inFlight++
+				if inFlight > maxInFlight {
+					maxInFlight = inFlight
+				}
+				mu.Unlock()
+				time.Sleep(5 * time.Millisecond)
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+				resp := newOperationResourceResponse(status)
+				resp.Request = r
+				return resp, nil
+			}),
+		}
+		p := &Poller{}
+		if err := autorest.Respond(newSimpleAsyncResp(), ByCreatingPoller(client, p)); err != nil {
+			t.Fatalf("ByCreatingPoller returned an unexpected error (%v)", err)
+		}
+		return p
+	}
+
+	pollers := []*Poller{}
+	for i := 0; i < 6; i++ {
+		pollers = append(pollers, newPoller(operationSucceeded))
+	}
+	results := WaitForAllResults(context.Background(), 2, pollers...)
+	for i, r := range results {
+		if r.Err != nil || r.Poller != pollers[i] || !r.Poller.Done() {
+			t.Fatalf("WaitForAllResults returned an unexpected result for poller %d -- %+v", i, r)
+		}
+	}
+	if maxInFlight > 2 {
+		t.Fatalf("WaitForAllResults polled %d operations concurrently, expected at most 2", maxInFlight)
+	}
+
+	failed := newPoller(operationFailed)
+	err := WaitForAll(context.Background(), newPoller(operationSucceeded), failed, newPoller(operationSucceeded))
+	var wfae WaitForAllError
+	if !errors.As(err, &wfae) || len(wfae.Failures) != 1 || wfae.Failures[0].Poller != failed {
+		t.Fatalf("WaitForAll returned an unexpected error -- %v", err)
+	}
+}
+
+func TestPollerResult(t *testing.T) {
+	sender := mocks.NewSender()
+	sender.AppendResponse(newOperationResourceResponse(operationSucceeded))
+	var p Poller
+	if err := autorest.Respond(newSimpleAsyncResp(), ByCreatingPoller(autorest.Client{Sender: sender}, &p)); err != nil {
+		t.Fatalf("ByCreatingPoller returned an unexpected error (%v)", err)
+	}
+	var status struct {
+		Status string `json:"status"`
+	}
+	if _, err := p.Result(&status); err == nil {
+		t.Fatal("Poller.Result failed to return an error before the operation completed")
+	}
+	if _, err := p.Poll(context.Background()); err != nil {
+		t.Fatalf("Poller.Poll returned an unexpected error (%v)", err)
+	}
+	resp, err := p.Result(&status)
+	if err != nil || status.Status != operationSucceeded {
+		t.Fatalf("Poller.Result failed to unmarshal the terminal response -- %+v (%v)", status, err)
+	}
+	if b, _ := io.ReadAll(resp.Body); len(b) == 0 {
+		t.Fatal("Poller.Result consumed the response body")
+	}
+
+	decoded := false
+	p.Decoder = func(v interface{}) autorest.RespondDecorator {
+		decoded = true
+		return autorest.ByUnmarshallingJSON(v)
+	}
+	if _, err = p.Result(&status); err != nil || !decoded {
+		t.Fatalf("Poller.Result did not use the Decoder (%v)", err)
+	}
+}
@@ -15,12 +15,13 @@
 package azure
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
 
 	"github.com/Azure/go-autorest/autorest"
 )
@@ -30,14 +31,14 @@ import (
 func DoRetryWithRegistration(client autorest.Client) autorest.SendDecorator {
 	return func(s autorest.Sender) autorest.Sender {
 		return autorest.SenderFunc(func(r *http.Request) (resp *http.Response, err error) {
-			rr := autorest.NewRetriableRequest(r)
 			for currentAttempt := 0; currentAttempt < client.RetryAttempts; currentAttempt++ {
-				err = rr.Prepare()
+				var req *http.Request
+				req, err = autorest.CloneRequest(r)
 				if err != nil {
 					return resp, err
 				}
 
-				resp, err = autorest.SendWithSender(s, rr.Request(),
+				resp, err = autorest.SendWithSender(s, req,
 					autorest.DoRetryForStatusCodes(client.RetryAttempts, client.RetryDuration, autorest.StatusCodesForRetry...),
 				)
 				if err != nil {
@@ -48,24 +49,33 @@ func DoRetryWithRegistration(client autorest.Client) autorest.SendDecorator {
 					return resp, err
 				}
 
+				// keep a copy of the body so that conflicts other than a missing registration
+				// are returned intact to the caller's responders
+				var b []byte
+				b, err = io.ReadAll(resp.Body)
+				resp.Body.Close()
+				resp.Body = io.NopCloser(bytes.NewReader(b))
+				if err != nil {
+					return resp, err
+				}
+
 				var re RequestError
+				encodedAs := autorest.EncodedAsJSON
+				var v interface{} = &re
 				if strings.Contains(r.Header.Get("Content-Type"), "xml") {
 					// XML errors (e.g. Storage Data Plane) only return the inner object
-					err = autorest.Respond(resp, autorest.ByUnmarshallingXML(&re.ServiceError))
-				} else {
-					err = autorest.Respond(resp, autorest.ByUnmarshallingJSON(&re))
+					encodedAs = autorest.EncodedAsXML
+					v = &re.ServiceError
 				}
-
-				if err != nil {
-					return resp, err
+				if decodeErr := autorest.NewDecoder(encodedAs, bytes.NewReader(b)).Decode(v); decodeErr != nil ||
+					re.ServiceError == nil || re.ServiceError.Code != "MissingSubscriptionRegistration" {
+					return resp, nil
 				}
 				err = re
 
-				if re.ServiceError != nil && re.ServiceError.Code == "MissingSubscriptionRegistration" {
-					regErr := register(client, r, re)
-					if regErr != nil {
-						return resp, fmt.Errorf("failed auto registering Resource Provider: %s. Original error: %w", regErr, err)
-					}
+				regErr := register(client, r, re)
+				if regErr != nil {
+					return resp, fmt.Errorf("failed auto registering Resource Provider: %s. Original error: %w", regErr, err)
 				}
 			}
 			return resp, err
@@ -144,8 +154,8 @@ func register(client autorest.Client, originalReq *http.Request, re RequestError
 	}
 
 	// poll for registered provisioning state
-	registrationStartTime := time.Now()
-	for err == nil && (client.PollingDuration == 0 || (client.PollingDuration != 0 && time.Since(registrationStartTime) < client.PollingDuration)) {
+	registrationStartTime := autorest.DefaultClock.Now()
+	for err == nil && (client.PollingDuration == 0 || (client.PollingDuration != 0 && autorest.DefaultClock.Now().Sub(registrationStartTime) < client.PollingDuration)) {
 		// taken from the resources SDK
 		// https://github.com/Azure/azure-sdk-for-go/blob/9f366792afa3e0ddaecdc860e793ba9d75e76c27/arm/resources/resources/providers.go#L45
 		preparer := autorest.CreatePreparer(
@@ -183,11 +193,13 @@ func register(client autorest.Client, originalReq *http.Request, re RequestError
 		}
 
 		delayed := autorest.DelayWithRetryAfter(resp, originalReq.Context().Done())
-		if !delayed && !autorest.DelayForBackoff(client.PollingDelay, 0, originalReq.Context().Done()) {
-			return originalReq.Context().Err()
+		if !delayed {
+			if ctxErr := autorest.DelayForBackoffWithContext(originalReq.Context(), client.PollingDelay, 0, 0); ctxErr != nil {
+				return ctxErr
+			}
 		}
 	}
-	if client.PollingDuration != 0 && !(time.Since(registrationStartTime) < client.PollingDuration) {
+	if client.PollingDuration != 0 && !(autorest.DefaultClock.Now().Sub(registrationStartTime) < client.PollingDuration) {
 		return errors.New("polling for resource provider registration has exceeded the polling duration")
 	}
 	return err
@@ -127,6 +127,42 @@ func TestDoRetrySkipRegistration(t *testing.T) {
 	}
 }
 
+func TestDoRetryWithRegistration_OtherConflict(t *testing.T) {
+	body := `{"error":{"code":"ResourceGroupBeingDeleted","message":"The resource group is being deleted."}}`
+	client := mocks.NewSender()
+	client.AppendResponse(mocks.NewResponseWithBodyAndStatus(mocks.NewBody(body), http.StatusConflict, "409 Conflict"))
+	client.AppendResponse(mocks.NewResponseWithStatus("200 OK", http.StatusOK))
+
+	req := mocks.NewRequestForURL("https://lol/subscriptions/rofl")
+	r, err := autorest.SendWithSender(client, req,
+		DoRetryWithRegistration(autorest.Client{
+			PollingDelay:    time.Second,
+			PollingDuration: time.Second * 10,
+			RetryAttempts:   5,
+			RetryDuration:   time.Second,
+			Sender:          client,
+		}),
+	)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if client.Attempts() != 1 {
+		t.Fatalf("azure: Sender#DoRetryWithRegistration -- retried a conflict other than a missing registration %v times", client.Attempts()-1)
+	}
+
+	var re RequestError
+	err = autorest.Respond(r,
+		autorest.ByUnmarshallingJSON(&re),
+		autorest.ByClosing(),
+	)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if re.ServiceError == nil || re.ServiceError.Code != "ResourceGroupBeingDeleted" {
+		t.Fatalf("azure: Sender#DoRetryWithRegistration -- the response body was not preserved: %v", re.ServiceError)
+	}
+}
+
 func TestDoRetryWithRegistration_CanBeCancelled(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	delay := 5 * time.Second
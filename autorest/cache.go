@@ -0,0 +1,323 @@
+package autorest
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	headerCacheControl = "Cache-Control"
+	headerExpires      = "Expires"
+	headerLastModified = "Last-Modified"
+	headerVary         = "Vary"
+)
+
+// CachedResponse is a response saved by DoCache.
+type CachedResponse struct {
+	// StatusCode is the status code of the saved response.
+	StatusCode int
+
+	// Header holds the headers of the saved response.
+	Header http.Header
+
+	// Body holds the content of the saved response.
+	Body []byte
+
+	// Expires is the time after which the saved response must be revalidated.
+	Expires time.Time
+}
+
+// CacheStore is the interface that stores the responses saved by DoCache, keyed by request method,
+// URL, a digest of the Authorization header and the values of the request headers named by the
+// response's Vary header. Implementations must be safe for concurrent use.
+type CacheStore interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, entry *CachedResponse)
+	Delete(key string)
+}
+
+// DoCache returns a SendDecorator that caches responses to GET requests in the passed CacheStore.
+// Fresh responses, per their Cache-Control max-age or Expires header, are served without sending
+// the request. Stale responses with an ETag or Last-Modified header are revalidated by sending the
+// request with If-None-Match or If-Modified-Since; a 304 Not Modified response refreshes the saved
+// response, which is then returned in its place. Responses are saved separately for each
+// Authorization header and for each set of values of the request headers named by their Vary
+// header. Responses with Cache-Control no-store or private, or with Vary: *, are not saved.
+func DoCache(store CacheStore) SendDecorator {
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			if r.Method != http.MethodGet || hasCacheDirective(r.Header, "no-store") {
+				return s.Do(r)
+			}
+			// the entry saved under the key without Vary'd headers names the headers the
+			// response varies by, if any
+			baseKey := cacheKey(r, nil)
+			key := baseKey
+			entry, ok := store.Get(baseKey)
+			if ok {
+				if vary := entry.Header.Values(headerVary); len(vary) > 0 {
+					key = cacheKey(r, vary)
+					entry, ok = store.Get(key)
+				}
+			}
+			if ok && !hasCacheDirective(r.Header, "no-cache") && DefaultClock.Now().Before(entry.Expires) {
+				return entry.response(r), nil
+			}
+			req := r
+			if ok {
+				req = r.Clone(r.Context())
+				if etag := entry.Header.Get(headerETag); etag != "" && req.Header.Get(headerIfNoneMatch) == "" {
+					req.Header.Set(headerIfNoneMatch, etag)
+				}
+				if lm := entry.Header.Get(headerLastModified); lm != "" && req.Header.Get(headerIfModifiedSince) == "" {
+					req.Header.Set(headerIfModifiedSince, lm)
+				}
+			}
+			resp, err := s.Do(req)
+			if err != nil {
+				return resp, err
+			}
+			switch {
+			case resp.StatusCode == http.StatusNotModified && ok && req != r:
+				DrainResponseBody(resp)
+				updated := *entry
+				updated.Header = entry.Header.Clone()
+				for k, v := range resp.Header {
+					updated.Header[k] = v
+				}
+				updated.Expires = cacheExpiry(updated.Header)
+				setCached(store, r, baseKey, &updated)
+				return updated.response(r), nil
+			case resp.StatusCode == http.StatusOK:
+				if hasCacheDirective(resp.Header, "no-store") || hasCacheDirective(resp.Header, "private") || varyAll(resp.Header) {
+					store.Delete(key)
+					return resp, nil
+				}
+				b, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					return resp, NewErrorWithError(err, "autorest", "DoCache", resp, "Failure reading the response body")
+				}
+				resp.Body = io.NopCloser(bytes.NewReader(b))
+				entry = &CachedResponse{
+					StatusCode: resp.StatusCode,
+					Header:     resp.Header.Clone(),
+					Body:       b,
+					Expires:    cacheExpiry(resp.Header),
+				}
+				if DefaultClock.Now().Before(entry.Expires) || entry.Header.Get(headerETag) != "" || entry.Header.Get(headerLastModified) != "" {
+					setCached(store, r, baseKey, entry)
+				} else {
+					store.Delete(key)
+				}
+			}
+			return resp, err
+		})
+	}
+}
+
+// cacheKey returns the key for the passed request, made of its method, URL, a digest of its
+// Authorization header and the values of the request headers named in vary.
+func cacheKey(r *http.Request, vary []string) string {
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteString(" ")
+	b.WriteString(r.URL.String())
+	if auth := r.Header.Values(headerAuthorization); len(auth) > 0 {
+		sum := sha256.Sum256([]byte(strings.Join(auth, "\n")))
+		b.WriteString(" ")
+		b.WriteString(hex.EncodeToString(sum[:]))
+	}
+	names := varyNames(vary)
+	for _, name := range names {
+		b.WriteString("\n")
+		b.WriteString(name)
+		b.WriteString(": ")
+		b.WriteString(strings.Join(r.Header.Values(name), ", "))
+	}
+	return b.String()
+}
+
+// setCached saves the passed response under the key of the passed request. A response varying by
+// request headers is saved under the key without them too, recording the headers to look up.
+func setCached(store CacheStore, r *http.Request, baseKey string, entry *CachedResponse) {
+	key := cacheKey(r, entry.Header.Values(headerVary))
+	store.Set(key, entry)
+	if key != baseKey {
+		store.Set(baseKey, entry)
+	}
+}
+
+// varyNames returns the sorted, canonical header names listed in the passed Vary header values.
+func varyNames(vary []string) []string {
+	var names []string
+	for _, v := range vary {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// varyAll reports whether the Vary header includes "*", meaning the response cannot be reused.
+func varyAll(h http.Header) bool {
+	for _, name := range varyNames(h.Values(headerVary)) {
+		if name == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// response returns a new http.Response, for the passed request, built from the saved response.
+func (cr *CachedResponse) response(r *http.Request) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(cr.StatusCode) + " " + http.StatusText(cr.StatusCode),
+		StatusCode:    cr.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        cr.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(cr.Body)),
+		ContentLength: int64(len(cr.Body)),
+		Request:       r,
+	}
+}
+
+// cacheExpiry returns the time after which a response with the passed headers is stale. Responses
+// without freshness information, or with Cache-Control no-cache, are stale immediately.
+func cacheExpiry(h http.Header) time.Time {
+	now := DefaultClock.Now()
+	if hasCacheDirective(h, "no-cache") {
+		return now
+	}
+	for _, v := range h.Values(headerCacheControl) {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSpace(d)
+			if len(d) > len("max-age=") && strings.EqualFold(d[:len("max-age=")], "max-age=") {
+				if secs, err := strconv.Atoi(d[len("max-age="):]); err == nil {
+					return now.Add(time.Duration(secs) * time.Second)
+				}
+			}
+		}
+	}
+	if t, err := http.ParseTime(h.Get(headerExpires)); err == nil {
+		return t
+	}
+	return now
+}
+
+// hasCacheDirective reports whether the Cache-Control headers include the passed directive.
+func hasCacheDirective(h http.Header, directive string) bool {
+	for _, v := range h.Values(headerCacheControl) {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSpace(d)
+			if i := strings.IndexByte(d, '='); i >= 0 {
+				d = d[:i]
+			}
+			if strings.EqualFold(d, directive) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MemoryCache is a CacheStore that holds up to a fixed number of responses in memory, evicting the
+// least recently used response when full.
+type MemoryCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key   string
+	entry *CachedResponse
+}
+
+// NewMemoryCache creates a MemoryCache holding up to capacity responses. A capacity less than one
+// is treated as one.
+func NewMemoryCache(capacity int) *MemoryCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &MemoryCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+// Get returns the response saved for the passed key, marking it as recently used.
+func (mc *MemoryCache) Get(key string) (*CachedResponse, bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	e, ok := mc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	mc.order.MoveToFront(e)
+	return e.Value.(*memoryCacheEntry).entry, true
+}
+
+// Set saves the response for the passed key, evicting the least recently used response if full.
+func (mc *MemoryCache) Set(key string, entry *CachedResponse) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if e, ok := mc.entries[key]; ok {
+		e.Value.(*memoryCacheEntry).entry = entry
+		mc.order.MoveToFront(e)
+		return
+	}
+	mc.entries[key] = mc.order.PushFront(&memoryCacheEntry{key: key, entry: entry})
+	if mc.order.Len() > mc.capacity {
+		oldest := mc.order.Back()
+		mc.order.Remove(oldest)
+		delete(mc.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+}
+
+// Delete removes the response saved for the passed key.
+func (mc *MemoryCache) Delete(key string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if e, ok := mc.entries[key]; ok {
+		mc.order.Remove(e)
+		delete(mc.entries, key)
+	}
+}
+
+// Len returns the number of saved responses.
+func (mc *MemoryCache) Len() int {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.order.Len()
+}
@@ -0,0 +1,216 @@
+package autorest
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+func readCachedBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("autorest: failed to read the response body (%v)", err)
+	}
+	resp.Body.Close()
+	return string(b)
+}
+
+func TestDoCacheServesFreshResponses(t *testing.T) {
+	client := mocks.NewSender()
+	resp := mocks.NewResponseWithBodyAndStatus(mocks.NewBody("cached"), http.StatusOK, "200 OK")
+	mocks.SetResponseHeader(resp, headerCacheControl, "max-age=60")
+	client.AppendResponse(resp)
+
+	store := NewMemoryCache(10)
+	for i := 0; i < 3; i++ {
+		r, err := SendWithSender(client, mocks.NewRequest(), DoCache(store))
+		if err != nil {
+			t.Fatalf("autorest: DoCache returned an unexpected error (%v)", err)
+		}
+		if body := readCachedBody(t, r); body != "cached" {
+			t.Fatalf("autorest: DoCache returned an unexpected body -- expected %q, received %q", "cached", body)
+		}
+	}
+	if client.Attempts() != 1 {
+		t.Fatalf("autorest: DoCache failed to serve fresh responses locally -- attempts %v", client.Attempts())
+	}
+}
+
+func TestDoCacheRevalidates(t *testing.T) {
+	var ifNoneMatch string
+	attempts := 0
+	s := SenderFunc(func(r *http.Request) (*http.Response, error) {
+		attempts++
+		ifNoneMatch = r.Header.Get(headerIfNoneMatch)
+		if ifNoneMatch == `"v1"` {
+			return mocks.NewResponseWithStatus("304 Not Modified", http.StatusNotModified), nil
+		}
+		resp := mocks.NewResponseWithBodyAndStatus(mocks.NewBody("content"), http.StatusOK, "200 OK")
+		mocks.SetResponseHeader(resp, headerETag, `"v1"`)
+		return resp, nil
+	})
+
+	store := NewMemoryCache(10)
+	r, err := SendWithSender(s, mocks.NewRequest(), DoCache(store))
+	if err != nil {
+		t.Fatalf("autorest: DoCache returned an unexpected error (%v)", err)
+	}
+	readCachedBody(t, r)
+
+	r, err = SendWithSender(s, mocks.NewRequest(), DoCache(store))
+	if err != nil {
+		t.Fatalf("autorest: DoCache returned an unexpected error (%v)", err)
+	}
+	if ifNoneMatch != `"v1"` {
+		t.Fatalf("autorest: DoCache failed to send If-None-Match -- received %q", ifNoneMatch)
+	}
+	if attempts != 2 {
+		t.Fatalf("autorest: DoCache made an unexpected number of attempts -- expected 2, actual %v", attempts)
+	}
+	if r.StatusCode != http.StatusOK {
+		t.Fatalf("autorest: DoCache returned an unexpected status code -- expected %v, received %v", http.StatusOK, r.StatusCode)
+	}
+	if body := readCachedBody(t, r); body != "content" {
+		t.Fatalf("autorest: DoCache returned an unexpected body -- expected %q, received %q", "content", body)
+	}
+}
+
+func TestDoCacheHonorsNoStore(t *testing.T) {
+	client := mocks.NewSender()
+	resp := mocks.NewResponseWithBodyAndStatus(mocks.NewBody("secret"), http.StatusOK, "200 OK")
+	mocks.SetResponseHeader(resp, headerCacheControl, "no-store, max-age=60")
+	client.AppendAndRepeatResponse(resp, 2)
+
+	store := NewMemoryCache(10)
+	r, err := SendWithSender(client, mocks.NewRequest(), DoCache(store))
+	if err != nil {
+		t.Fatalf("autorest: DoCache returned an unexpected error (%v)", err)
+	}
+	Respond(r, ByDiscardingBody(), ByClosing())
+	if store.Len() != 0 {
+		t.Fatal("autorest: DoCache saved a response marked no-store")
+	}
+}
+
+func TestDoCacheSeparatesAuthorization(t *testing.T) {
+	s := SenderFunc(func(r *http.Request) (*http.Response, error) {
+		resp := mocks.NewResponseWithBodyAndStatus(mocks.NewBody(r.Header.Get(headerAuthorization)), http.StatusOK, "200 OK")
+		mocks.SetResponseHeader(resp, headerCacheControl, "max-age=60")
+		return resp, nil
+	})
+
+	store := NewMemoryCache(10)
+	for _, token := range []string{"Bearer alice", "Bearer bob", "Bearer alice"} {
+		req := mocks.NewRequest()
+		req.Header.Set(headerAuthorization, token)
+		r, err := SendWithSender(s, req, DoCache(store))
+		if err != nil {
+			t.Fatalf("autorest: DoCache returned an unexpected error (%v)", err)
+		}
+		if body := readCachedBody(t, r); body != token {
+			t.Fatalf("autorest: DoCache served a response for another Authorization -- expected %q, received %q", token, body)
+		}
+	}
+	if store.Len() != 2 {
+		t.Fatalf("autorest: DoCache saved %d responses, expected 2", store.Len())
+	}
+}
+
+func TestDoCacheHonorsVary(t *testing.T) {
+	attempts := 0
+	s := SenderFunc(func(r *http.Request) (*http.Response, error) {
+		attempts++
+		resp := mocks.NewResponseWithBodyAndStatus(mocks.NewBody(r.Header.Get("Accept-Language")), http.StatusOK, "200 OK")
+		mocks.SetResponseHeader(resp, headerCacheControl, "max-age=60")
+		mocks.SetResponseHeader(resp, headerVary, "Accept-Language")
+		return resp, nil
+	})
+
+	store := NewMemoryCache(10)
+	for _, lang := range []string{"en-US", "fr-FR", "en-US", "fr-FR"} {
+		req := mocks.NewRequest()
+		req.Header.Set("Accept-Language", lang)
+		r, err := SendWithSender(s, req, DoCache(store))
+		if err != nil {
+			t.Fatalf("autorest: DoCache returned an unexpected error (%v)", err)
+		}
+		if body := readCachedBody(t, r); body != lang {
+			t.Fatalf("autorest: DoCache ignored the Vary header -- expected %q, received %q", lang, body)
+		}
+	}
+	if attempts != 2 {
+		t.Fatalf("autorest: DoCache sent %d requests, expected 2", attempts)
+	}
+}
+
+func TestDoCacheSkipsPrivate(t *testing.T) {
+	client := mocks.NewSender()
+	resp := mocks.NewResponseWithBodyAndStatus(mocks.NewBody("mine"), http.StatusOK, "200 OK")
+	mocks.SetResponseHeader(resp, headerCacheControl, "private, max-age=60")
+	client.AppendResponse(resp)
+
+	store := NewMemoryCache(10)
+	r, err := SendWithSender(client, mocks.NewRequest(), DoCache(store))
+	if err != nil {
+		t.Fatalf("autorest: DoCache returned an unexpected error (%v)", err)
+	}
+	Respond(r, ByDiscardingBody(), ByClosing())
+	if store.Len() != 0 {
+		t.Fatal("autorest: DoCache saved a response marked private")
+	}
+}
+
+func TestDoCacheIgnoresOtherMethods(t *testing.T) {
+	client := mocks.NewSender()
+	resp := mocks.NewResponseWithStatus("200 OK", http.StatusOK)
+	mocks.SetResponseHeader(resp, headerCacheControl, "max-age=60")
+	client.AppendAndRepeatResponse(resp, 2)
+
+	store := NewMemoryCache(10)
+	for i := 0; i < 2; i++ {
+		r, err := SendWithSender(client, mocks.NewRequestWithParams(http.MethodPost, mocks.TestURL, nil), DoCache(store))
+		if err != nil {
+			t.Fatalf("autorest: DoCache returned an unexpected error (%v)", err)
+		}
+		Respond(r, ByDiscardingBody(), ByClosing())
+	}
+	if client.Attempts() != 2 || store.Len() != 0 {
+		t.Fatalf("autorest: DoCache cached a POST request -- attempts %v, entries %v", client.Attempts(), store.Len())
+	}
+}
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	mc := NewMemoryCache(2)
+	mc.Set("a", &CachedResponse{})
+	mc.Set("b", &CachedResponse{})
+	mc.Get("a")
+	mc.Set("c", &CachedResponse{})
+
+	if _, ok := mc.Get("b"); ok {
+		t.Fatal("autorest: MemoryCache failed to evict the least recently used entry")
+	}
+	if _, ok := mc.Get("a"); !ok {
+		t.Fatal("autorest: MemoryCache evicted a recently used entry")
+	}
+	mc.Delete("a")
+	if mc.Len() != 1 {
+		t.Fatalf("autorest: MemoryCache has an unexpected length -- expected 1, actual %v", mc.Len())
+	}
+}
@@ -17,6 +17,7 @@ package autorest
 import (
 	"bytes"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
@@ -161,6 +162,10 @@ type Client struct {
 	// PollingDelay sets the polling frequency used in absence of a Retry-After HTTP header
 	PollingDelay time.Duration
 
+	// PollingStrategy, if not nil, chooses the delay before each poll in place of PollingDelay and
+	// the Retry-After header; use RetryAfterPolling to honour the header.
+	PollingStrategy PollingStrategy
+
 	// PollingDuration sets the maximum polling time after which an error is returned.
 	// Setting this to zero will use the provided context to control the duration.
 	PollingDuration time.Duration
@@ -176,8 +181,17 @@ type Client struct {
 	// through the Do method.
 	UserAgent string
 
+	// Jar, if not nil, replaces the cookie jar of the Sender, so that it supplies the cookies added
+	// to requests sent through the Do method and stores the cookies received in their responses
+	// (e.g. session affinity cookies). It is used when the Sender is an *http.Client, as it is for
+	// the default Sender and the Sender set by NewClientWithUserAgent and NewClientWithOptions; for
+	// any other Sender configure its cookie jar instead.
 	Jar http.CookieJar
 
+	// HTTPSOnly, when true, causes the Do method to fail for requests whose URL scheme is not
+	// https, before any authorization is applied.
+	HTTPSOnly bool
+
 	// Set to true to skip attempted registration of resource providers (false by default).
 	SkipResourceProviderRegistration bool
 
@@ -185,12 +199,29 @@ type Client struct {
 	// This can be used to specify things like a custom retry SendDecorator.
 	// Set this to an empty slice to use no SendDecorators.
 	SendDecorators []SendDecorator
+
+	// RetryBudget, if not nil, limits the retries made by the retry SendDecorators for all requests
+	// sent through the Send method (see WithRetryBudget).
+	RetryBudget *RetryBudget
+
+	// ThrottleState, if not nil, makes requests sent through the Send method wait while a previous
+	// request to the same host was throttled with a Retry-After (see DoThrottle). Share it between
+	// clients of the same subscription to share the throttling.
+	ThrottleState *ThrottleState
+
+	// middleware holds the SendDecorators added with Use.
+	middleware []SendDecorator
+
+	// requestInspectors and responseInspectors hold the inspectors added with AddRequestInspector
+	// and AddResponseInspector.
+	requestInspectors  []PrepareDecorator
+	responseInspectors []RespondDecorator
 }
 
 // NewClientWithUserAgent returns an instance of a Client with the UserAgent set to the passed
 // string.
 func NewClientWithUserAgent(ua string) Client {
-	return newClient(ua, tls.RenegotiateNever)
+	return newClient(ClientOptions{UserAgent: ua, Renegotiation: tls.RenegotiateNever})
 }
 
 // ClientOptions contains various Client configuration options.
@@ -200,14 +231,21 @@ type ClientOptions struct {
 
 	// Renegotiation is an optional setting to control client-side TLS renegotiation.
 	Renegotiation tls.RenegotiationSupport
+
+	// Certificates are optional client certificates presented to servers that request one.
+	Certificates []tls.Certificate
+
+	// RootCAs are optional certificate authorities trusted, in addition to the system roots, to
+	// verify server certificates (e.g. for private endpoints or TLS inspecting proxies).
+	RootCAs []*x509.Certificate
 }
 
 // NewClientWithOptions returns an instance of a Client with the specified values.
 func NewClientWithOptions(options ClientOptions) Client {
-	return newClient(options.UserAgent, options.Renegotiation)
+	return newClient(options)
 }
 
-func newClient(ua string, renegotiation tls.RenegotiationSupport) Client {
+func newClient(options ClientOptions) Client {
 	c := Client{
 		PollingDelay:    DefaultPollingDelay,
 		PollingDuration: DefaultPollingDuration,
@@ -215,8 +253,16 @@ func newClient(ua string, renegotiation tls.RenegotiationSupport) Client {
 		RetryDuration:   DefaultRetryDuration,
 		UserAgent:       UserAgent(),
 	}
-	c.Sender = c.sender(renegotiation)
-	c.AddToUserAgent(ua)
+	if len(options.Certificates) > 0 || len(options.RootCAs) > 0 {
+		// the shared default senders can't hold per-client TLS settings
+		c.Sender = NewSender(
+			WithSenderRenegotiation(options.Renegotiation),
+			WithSenderClientCertificate(options.Certificates...),
+			WithSenderRootCAs(options.RootCAs...))
+	} else {
+		c.Sender = c.sender(options.Renegotiation)
+	}
+	c.AddToUserAgent(options.UserAgent)
 	return c
 }
 
@@ -229,9 +275,10 @@ func (c *Client) AddToUserAgent(extension string) error {
 	return fmt.Errorf("Extension was empty, User Agent stayed as %s", c.UserAgent)
 }
 
-// Do implements the Sender interface by invoking the active Sender after applying authorization.
-// If Sender is not set, it uses a new instance of http.Client. In both cases it will, if UserAgent
-// is set, apply set the User-Agent header.
+// Do implements the Sender interface by invoking the active Sender after applying the hooks
+// registered with RegisterPrepareHook and authorization. If Sender is not set, it uses a new
+// instance of http.Client. In both cases it will, if UserAgent is set, apply set the User-Agent
+// header.
 func (c Client) Do(r *http.Request) (*http.Response, error) {
 	if r.UserAgent() == "" {
 		r, _ = Prepare(r,
@@ -239,6 +286,8 @@ func (c Client) Do(r *http.Request) (*http.Response, error) {
 	}
 	// NOTE: c.WithInspection() must be last in the list so that it can inspect all preceding operations
 	r, err := Prepare(r,
+		c.withHTTPSOnly(),
+		WithRegisteredHooks(),
 		c.WithAuthorization(),
 		c.WithInspection())
 	if err != nil {
@@ -270,10 +319,17 @@ func (c Client) Do(r *http.Request) (*http.Response, error) {
 
 // sender returns the Sender to which to send requests.
 func (c Client) sender(renengotiation tls.RenegotiationSupport) Sender {
-	if c.Sender == nil {
-		return sender(renengotiation)
+	s := c.Sender
+	if s == nil {
+		s = sender(renengotiation)
+	}
+	if hc, ok := s.(*http.Client); ok && c.Jar != nil && hc.Jar != c.Jar {
+		// share the transport, and so its connection pool, but use the Client's jar
+		jc := *hc
+		jc.Jar = c.Jar
+		return &jc
 	}
-	return c.Sender
+	return s
 }
 
 // WithAuthorization is a convenience method that returns the WithAuthorization PrepareDecorator
@@ -290,22 +346,76 @@ func (c Client) authorizer() Authorizer {
 	return c.Authorizer
 }
 
-// WithInspection is a convenience method that passes the request to the supplied RequestInspector,
-// if present, or returns the WithNothing PrepareDecorator otherwise.
-func (c Client) WithInspection() PrepareDecorator {
-	if c.RequestInspector == nil {
+// withHTTPSOnly returns the WithHTTPSOnly PrepareDecorator if HTTPSOnly is set, or the WithNothing
+// PrepareDecorator otherwise.
+func (c Client) withHTTPSOnly() PrepareDecorator {
+	if !c.HTTPSOnly {
 		return WithNothing()
 	}
-	return c.RequestInspector
+	return WithHTTPSOnly()
+}
+
+// WithInspection is a convenience method that passes the request to the supplied RequestInspector
+// and then to the inspectors added with AddRequestInspector, in order, if present, or returns the
+// WithNothing PrepareDecorator otherwise.
+func (c Client) WithInspection() PrepareDecorator {
+	if len(c.requestInspectors) == 0 {
+		if c.RequestInspector == nil {
+			return WithNothing()
+		}
+		return c.RequestInspector
+	}
+	inspectors := c.requestInspectors
+	if c.RequestInspector != nil {
+		inspectors = append([]PrepareDecorator{c.RequestInspector}, inspectors...)
+	}
+	return func(p Preparer) Preparer {
+		return DecoratePreparer(p, inspectors...)
+	}
 }
 
-// ByInspecting is a convenience method that passes the response to the supplied ResponseInspector,
-// if present, or returns the ByIgnoring RespondDecorator otherwise.
+// ByInspecting is a convenience method that passes the response to the supplied ResponseInspector
+// and then to the inspectors added with AddResponseInspector, in order, if present, or returns the
+// ByIgnoring RespondDecorator otherwise.
 func (c Client) ByInspecting() RespondDecorator {
-	if c.ResponseInspector == nil {
-		return ByIgnoring()
+	if len(c.responseInspectors) == 0 {
+		if c.ResponseInspector == nil {
+			return ByIgnoring()
+		}
+		return c.ResponseInspector
 	}
-	return c.ResponseInspector
+	inspectors := c.responseInspectors
+	if c.ResponseInspector != nil {
+		inspectors = append([]RespondDecorator{c.ResponseInspector}, inspectors...)
+	}
+	return func(r Responder) Responder {
+		return DecorateResponder(r, inspectors...)
+	}
+}
+
+// AddRequestInspector adds the passed PrepareDecorators to the chain of request inspectors. They
+// are applied, in order, after the RequestInspector, so several inspectors (e.g. tracing and
+// auditing) can coexist without being composed by hand.
+func (c *Client) AddRequestInspector(inspectors ...PrepareDecorator) {
+	// copy so that clients copied before the call don't share the new inspectors
+	c.requestInspectors = append(c.requestInspectors[:len(c.requestInspectors):len(c.requestInspectors)], inspectors...)
+}
+
+// AddResponseInspector adds the passed RespondDecorators to the chain of response inspectors. They
+// are applied, in order, after the ResponseInspector, so several inspectors (e.g. tracing and
+// error enrichment) can coexist without being composed by hand.
+func (c *Client) AddResponseInspector(inspectors ...RespondDecorator) {
+	// copy so that clients copied before the call don't share the new inspectors
+	c.responseInspectors = append(c.responseInspectors[:len(c.responseInspectors):len(c.responseInspectors)], inspectors...)
+}
+
+// Use adds the passed SendDecorators to the chain applied to every request sent with Send, in
+// addition to the SendDecorators selected for the request. They are applied first, so they wrap the
+// Sender directly and are invoked for each attempt made by retrying SendDecorators, making them
+// suited to logging, tracing or metrics.
+func (c *Client) Use(decorators ...SendDecorator) {
+	// copy so that clients copied before the call don't share the new decorators
+	c.middleware = append(c.middleware[:len(c.middleware):len(c.middleware)], decorators...)
 }
 
 // Send sends the provided http.Request using the client's Sender or the default sender.
@@ -315,6 +425,8 @@ func (c Client) ByInspecting() RespondDecorator {
 // 1. In a request's context via WithSendDecorators()
 // 2. Specified on the client in SendDecorators
 // 3. The default values specified in this method
+// The SendDecorators added with Use, and DoThrottle if ThrottleState is set, are always applied
+// before them.
 func (c Client) Send(req *http.Request, decorators ...SendDecorator) (*http.Response, error) {
 	if c.SendDecorators != nil {
 		decorators = c.SendDecorators
@@ -323,5 +435,14 @@ func (c Client) Send(req *http.Request, decorators ...SendDecorator) (*http.Resp
 	if sd, ok := inCtx.([]SendDecorator); ok {
 		decorators = sd
 	}
+	if len(c.middleware) > 0 {
+		decorators = append(append([]SendDecorator{}, c.middleware...), decorators...)
+	}
+	if c.ThrottleState != nil {
+		decorators = append([]SendDecorator{DoThrottle(c.ThrottleState)}, decorators...)
+	}
+	if c.RetryBudget != nil {
+		req = req.WithContext(WithRetryBudget(req.Context(), c.RetryBudget))
+	}
 	return SendWithSender(c, req, decorators...)
 }
@@ -23,7 +23,9 @@ import (
 	"log"
 	"math/rand"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"testing"
 	"time"
@@ -191,6 +193,23 @@ func TestAddToUserAgent(t *testing.T) {
 	}
 }
 
+func TestNewClientWithOptionsTLS(t *testing.T) {
+	cert := newTestClientCertificate(t)
+	c := NewClientWithOptions(ClientOptions{
+		Certificates: []tls.Certificate{cert},
+	})
+	config := c.Sender.(*http.Client).Transport.(*http.Transport).TLSClientConfig
+	if len(config.Certificates) != 1 {
+		t.Fatalf("expected 1 client certificate, got %d", len(config.Certificates))
+	}
+	if c.Sender == sender(tls.RenegotiateNever) {
+		t.Fatal("expected a dedicated sender for the client certificate")
+	}
+	if d := NewClientWithOptions(ClientOptions{}); d.Sender != sender(tls.RenegotiateNever) {
+		t.Fatal("expected the shared default sender without TLS options")
+	}
+}
+
 func TestClientSenderReturnsHttpClientByDefault(t *testing.T) {
 	c := Client{}
 
@@ -250,6 +269,18 @@ func TestClientDoSetsAuthorization(t *testing.T) {
 	}
 }
 
+func TestClientDoAppliesRegisteredHooks(t *testing.T) {
+	RegisterPrepareHook("cost-center", WithHeader("x-cost-center", "42"))
+	defer RegisterPrepareHook("cost-center", nil)
+
+	r := mocks.NewRequest()
+	c := Client{Sender: mocks.NewSender()}
+	c.Do(r)
+	if r.Header.Get("x-cost-center") != "42" {
+		t.Fatalf("autorest: Client#Do failed to apply registered hooks -- x-cost-center=%s", r.Header.Get("x-cost-center"))
+	}
+}
+
 func TestClientDoInvokesRequestInspector(t *testing.T) {
 	r := mocks.NewRequest()
 	s := mocks.NewSender()
@@ -298,6 +329,23 @@ func TestClientDoDoesNotSendIfPrepareFails(t *testing.T) {
 	}
 }
 
+func TestClientDoHTTPSOnly(t *testing.T) {
+	s := mocks.NewSender()
+	c := Client{Sender: s, HTTPSOnly: true, Authorizer: mockAuthorizer{}}
+
+	_, err := c.Do(mocks.NewRequestForURL("http://microsoft.com/"))
+	if err == nil {
+		t.Fatal("autorest: Client#Do failed to reject an http URL when HTTPSOnly is set")
+	}
+	if s.Attempts() != 0 {
+		t.Fatal("autorest: Client#Do sent a request to an http URL when HTTPSOnly is set")
+	}
+
+	if _, err = c.Do(mocks.NewRequestForURL("https://microsoft.com/")); err != nil {
+		t.Fatalf("autorest: Client#Do rejected an https URL (%v)", err)
+	}
+}
+
 func TestClientAuthorizerReturnsNullAuthorizerByDefault(t *testing.T) {
 	c := Client{}
 
@@ -422,6 +470,63 @@ func TestCookies(t *testing.T) {
 	}
 }
 
+func TestClientDoUsesJar(t *testing.T) {
+	var cookies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.Redirect(w, r, "/app/session", http.StatusFound)
+		case "/app/session":
+			http.SetCookie(w, &http.Cookie{Name: "affinity", Value: "node1"})
+		default:
+			cookies = r.Header.Values("Cookie")
+		}
+	}))
+	defer server.Close()
+
+	for name, c := range map[string]Client{
+		"zero value":             {},
+		"NewClientWithUserAgent": NewClientWithUserAgent("test"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			testClientDoUsesJar(t, c, server.URL, &cookies)
+		})
+	}
+}
+
+func testClientDoUsesJar(t *testing.T, c Client, serverURL string, cookies *[]string) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("autorest: failed to create cookie jar: %s", err)
+	}
+	c.Jar = jar
+	*cookies = nil
+	do := func(path string) {
+		r, err := http.NewRequest(http.MethodGet, serverURL+path, nil)
+		if err != nil {
+			t.Fatalf("autorest: failed to create request: %s", err)
+		}
+		resp, err := c.Do(r)
+		if err != nil {
+			t.Fatalf("autorest: request to %s failed: %s", path, err)
+		}
+		Respond(resp, ByDiscardingBody(), ByClosing())
+	}
+
+	// the cookie is set by the response to the redirected request
+	do("/login")
+	u, _ := url.Parse(serverURL + "/app/session")
+	if len(jar.Cookies(u)) != 1 {
+		t.Fatalf("autorest: Client.Do failed to store the cookie in the jar -- %v", jar.Cookies(u))
+	}
+	for i := 0; i < 2; i++ {
+		do("/app/check")
+		if len(*cookies) != 1 || (*cookies)[0] != "affinity=node1" {
+			t.Fatalf("autorest: Client.Do sent unexpected cookies -- %v", *cookies)
+		}
+	}
+}
+
 func TestResponseIsHTTPStatus(t *testing.T) {
 	r := Response{}
 	if r.IsHTTPStatus(http.StatusBadRequest) {
@@ -522,6 +627,92 @@ func TestClientSendMethod(t *testing.T) {
 	}
 }
 
+func TestClientUse(t *testing.T) {
+	sender := mocks.NewSender()
+	unavailable := mocks.NewResponseWithStatus("503 Service Unavailable", http.StatusServiceUnavailable)
+	unavailable.Header = http.Header{}
+	sender.AppendResponse(unavailable)
+	sender.AppendResponse(newAcceptedResponse())
+	client := Client{
+		Sender: sender,
+	}
+	attempts := 0
+	client.Use(func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			attempts++
+			return s.Do(r)
+		})
+	})
+	copied := client
+	client.Use(ClientSendDecorator())
+
+	req, err := http.NewRequest(http.MethodGet, mocks.TestURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Send(req, DoRetryForStatusCodes(2, 0, http.StatusServiceUnavailable), DefaultSendDecorator())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Fatalf("expected the middleware to see 2 attempts, got %d", attempts)
+	}
+	if v := resp.Header.Get("client-decorator"); v != "true" {
+		t.Fatal("didn't find client-decorator header in response")
+	}
+	if v := resp.Header.Get("default-decorator"); v != "true" {
+		t.Fatal("didn't find default-decorator header in response")
+	}
+	if len(copied.middleware) != 1 {
+		t.Fatalf("expected the copied client to keep 1 middleware, got %d", len(copied.middleware))
+	}
+}
+
+func TestClientRetryBudget(t *testing.T) {
+	sender := mocks.NewSender()
+	sender.SetAndRepeatError(fmt.Errorf("Faux Error"), -1)
+	client := Client{
+		Sender:      sender,
+		RetryBudget: NewRetryBudget(0.001, 1),
+	}
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodGet, mocks.TestURL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = client.Send(req, DoRetryForAttempts(3, 0)); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+	// one retry for the first request, none for the second
+	if sender.Attempts() != 3 {
+		t.Fatalf("expected 3 attempts, got %d", sender.Attempts())
+	}
+}
+
+func TestClientThrottleState(t *testing.T) {
+	sender := mocks.NewSender()
+	throttled := mocks.NewResponseWithStatus("429 Too Many Requests", http.StatusTooManyRequests)
+	mocks.SetResponseHeader(throttled, HeaderRetryAfter, "60")
+	sender.AppendResponse(throttled)
+	client := Client{
+		Sender:        sender,
+		ThrottleState: NewThrottleState(),
+	}
+	req, err := http.NewRequest(http.MethodGet, mocks.TestURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Send(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Respond(resp, ByDiscardingBody(), ByClosing())
+	if d := client.ThrottleState.Wait(req.URL.Host); d <= 0 {
+		t.Fatal("expected the client to record the throttling")
+	}
+}
+
 func DefaultSendDecorator() SendDecorator {
 	return func(s Sender) Sender {
 		return SenderFunc(func(r *http.Request) (*http.Response, error) {
@@ -551,3 +742,43 @@ func ContextSendDecorator() SendDecorator {
 		})
 	}
 }
+
+func TestClientAddInspectors(t *testing.T) {
+	order := []string{}
+	withMark := func(name string) PrepareDecorator {
+		return func(p Preparer) Preparer {
+			return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+				r, err := p.Prepare(r)
+				order = append(order, name)
+				return r, err
+			})
+		}
+	}
+	byMark := func(name string) RespondDecorator {
+		return func(r Responder) Responder {
+			return ResponderFunc(func(resp *http.Response) error {
+				err := r.Respond(resp)
+				order = append(order, name)
+				return err
+			})
+		}
+	}
+	c := Client{
+		RequestInspector:  withMark("request"),
+		ResponseInspector: byMark("response"),
+	}
+	c.AddRequestInspector(withMark("request1"), withMark("request2"))
+	c.AddResponseInspector(byMark("response1"))
+	c.AddResponseInspector(byMark("response2"))
+
+	if _, err := Prepare(mocks.NewRequest(), c.WithInspection()); err != nil {
+		t.Fatal(err)
+	}
+	if err := Respond(mocks.NewResponse(), c.ByInspecting()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"request", "request1", "request2", "response", "response1", "response2"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Fatalf("autorest: Client inspectors ran out of order -- expected %v, received %v", expected, order)
+	}
+}
@@ -0,0 +1,88 @@
+package autorest
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the interface that provides the current time and waits for durations to elapse.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+}
+
+// DefaultClock is the Clock used by AfterDelay, DelayForBackoff, DelayWithRetryAfter, DoRateLimit
+// and the retry and polling SendDecorators. Replace it with a FakeClock in tests to avoid waiting
+// on the wall clock; it should not be changed while requests are being sent.
+var DefaultClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// FakeClock is a Clock whose time only moves when it is advanced. Sleep and After advance the
+// time by the requested duration and return immediately, so delays take no real time while the
+// total elapsed time remains observable through Now.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock returns a FakeClock set to the passed time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the current time of the FakeClock.
+func (fc *FakeClock) Now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.now
+}
+
+// Sleep advances the FakeClock by the passed duration.
+func (fc *FakeClock) Sleep(d time.Duration) {
+	fc.Advance(d)
+}
+
+// After advances the FakeClock by the passed duration and returns a channel holding the new time.
+func (fc *FakeClock) After(d time.Duration) <-chan time.Time {
+	c := make(chan time.Time, 1)
+	c <- fc.Advance(d)
+	return c
+}
+
+// Advance moves the FakeClock forward by the passed duration, if positive, and returns the new time.
+func (fc *FakeClock) Advance(d time.Duration) time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if d > 0 {
+		fc.now = fc.now.Add(d)
+	}
+	return fc.now
+}
@@ -0,0 +1,205 @@
+package autorest
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+func withFakeClock(t *testing.T) *FakeClock {
+	t.Helper()
+	fc := NewFakeClock(time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC))
+	original := DefaultClock
+	DefaultClock = fc
+	t.Cleanup(func() {
+		DefaultClock = original
+	})
+	return fc
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	fc := NewFakeClock(start)
+
+	fc.Sleep(time.Second)
+	if got := <-fc.After(time.Minute); !got.Equal(start.Add(time.Minute + time.Second)) {
+		t.Fatalf("autorest: FakeClock.After returned an unexpected time -- %v", got)
+	}
+	fc.Advance(-time.Hour)
+	if elapsed := fc.Now().Sub(start); elapsed != time.Minute+time.Second {
+		t.Fatalf("autorest: FakeClock reported an unexpected elapsed time -- expected %v, received %v", time.Minute+time.Second, elapsed)
+	}
+}
+
+func TestAfterDelayUsesDefaultClock(t *testing.T) {
+	fc := withFakeClock(t)
+	start := fc.Now()
+	client := mocks.NewSender()
+
+	realStart := time.Now()
+	r, err := SendWithSender(client, mocks.NewRequest(), AfterDelay(time.Hour))
+	if err != nil {
+		t.Fatalf("autorest: AfterDelay returned an unexpected error (%v)", err)
+	}
+	if time.Since(realStart) > time.Second {
+		t.Fatal("autorest: AfterDelay waited on the wall clock")
+	}
+	if elapsed := fc.Now().Sub(start); elapsed != time.Hour {
+		t.Fatalf("autorest: AfterDelay delayed for an unexpected duration -- expected %v, received %v", time.Hour, elapsed)
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestDoRetryForAttemptsUsesDefaultClock(t *testing.T) {
+	fc := withFakeClock(t)
+	start := fc.Now()
+	client := mocks.NewSender()
+	client.SetAndRepeatError(fmt.Errorf("Faux Error"), 3)
+
+	r, err := SendWithSender(client, mocks.NewRequest(), DoRetryForAttempts(4, time.Minute))
+	if err != nil {
+		t.Fatalf("autorest: DoRetryForAttempts returned an unexpected error (%v)", err)
+	}
+	// backoff of 1, 2 and 4 minutes
+	if elapsed := fc.Now().Sub(start); elapsed != 7*time.Minute {
+		t.Fatalf("autorest: DoRetryForAttempts delayed for an unexpected duration -- expected %v, received %v", 7*time.Minute, elapsed)
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestDoRetryForDurationUsesDefaultClock(t *testing.T) {
+	fc := withFakeClock(t)
+	start := fc.Now()
+	client := mocks.NewSender()
+	client.SetAndRepeatError(fmt.Errorf("Faux Error"), -1)
+
+	r, err := SendWithSender(client, mocks.NewRequest(),
+		DoRetryForDuration(time.Hour, time.Minute),
+		DoCloseIfError())
+	if err == nil {
+		t.Fatal("autorest: DoRetryForDuration failed to return an error")
+	}
+	// backoff of 1, 2, 4, 8 and 16 minutes fits, the next 32 minutes does not
+	if elapsed := fc.Now().Sub(start); elapsed != 31*time.Minute {
+		t.Fatalf("autorest: DoRetryForDuration delayed for an unexpected duration -- expected %v, received %v", 31*time.Minute, elapsed)
+	}
+	if client.Attempts() != 6 {
+		t.Fatalf("autorest: DoRetryForDuration made an unexpected number of attempts -- expected 6, actual %v", client.Attempts())
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestDoCacheExpiresWithDefaultClock(t *testing.T) {
+	fc := withFakeClock(t)
+	client := mocks.NewSender()
+	resp := mocks.NewResponseWithBodyAndStatus(mocks.NewBody("cached"), http.StatusOK, "200 OK")
+	mocks.SetResponseHeader(resp, headerCacheControl, "max-age=60")
+	client.AppendAndRepeatResponse(resp, 2)
+
+	store := NewMemoryCache(10)
+	for _, wait := range []time.Duration{0, 30 * time.Second, time.Minute} {
+		fc.Advance(wait)
+		r, err := SendWithSender(client, mocks.NewRequest(), DoCache(store))
+		if err != nil {
+			t.Fatalf("autorest: DoCache returned an unexpected error (%v)", err)
+		}
+		Respond(r,
+			ByDiscardingBody(),
+			ByClosing())
+	}
+	if client.Attempts() != 2 {
+		t.Fatalf("autorest: DoCache made an unexpected number of attempts -- expected 2, actual %v", client.Attempts())
+	}
+}
+
+func TestDoTimeAttemptsUsesDefaultClock(t *testing.T) {
+	fc := withFakeClock(t)
+	start := fc.Now()
+	client := mocks.NewSender()
+	client.SetAndRepeatError(fmt.Errorf("Faux Error"), 1)
+
+	r, err := SendWithSender(client, mocks.NewRequest(),
+		DoRetryForAttempts(2, time.Minute),
+		DoTimeAttempts())
+	if err != nil {
+		t.Fatalf("autorest: DoTimeAttempts returned an unexpected error (%v)", err)
+	}
+	attempts := GetAttempts(r, err)
+	if len(attempts) != 2 {
+		t.Fatalf("autorest: DoTimeAttempts recorded an unexpected number of attempts -- expected 2, actual %v", len(attempts))
+	}
+	if !attempts[0].Start.Equal(start) || !attempts[1].Start.Equal(start.Add(time.Minute)) {
+		t.Fatalf("autorest: DoTimeAttempts recorded times from the wall clock -- %+v", attempts)
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestDoCollectMetricsUsesDefaultClock(t *testing.T) {
+	fc := withFakeClock(t)
+	var latency time.Duration
+	m := MetricsFunc(func(method, host string, code, attempt int, l time.Duration) {
+		latency = l
+	})
+	advance := func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			fc.Advance(time.Minute)
+			return s.Do(r)
+		})
+	}
+
+	r, err := SendWithSender(mocks.NewSender(), mocks.NewRequest(), advance, DoCollectMetrics(m))
+	if err != nil {
+		t.Fatalf("autorest: DoCollectMetrics returned an unexpected error (%v)", err)
+	}
+	if latency != time.Minute {
+		t.Fatalf("autorest: DoCollectMetrics reported an unexpected latency -- expected %v, received %v", time.Minute, latency)
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestSASTokenAuthorizerExpiresWithDefaultClock(t *testing.T) {
+	fc := withFakeClock(t)
+	auth, err := NewSASTokenAuthorizer("se=" + fc.Now().Add(time.Hour).Format(time.RFC3339) + "&sig=abc")
+	if err != nil {
+		t.Fatalf("autorest: NewSASTokenAuthorizer returned an unexpected error (%v)", err)
+	}
+	if _, err := Prepare(mocks.NewRequest(), auth.WithAuthorization()); err != nil {
+		t.Fatalf("autorest: SASTokenAuthorizer rejected an unexpired token (%v)", err)
+	}
+	fc.Advance(2 * time.Hour)
+	if _, err := Prepare(mocks.NewRequest(), auth.WithAuthorization()); err == nil {
+		t.Fatal("autorest: SASTokenAuthorizer accepted a token expired by DefaultClock")
+	}
+}
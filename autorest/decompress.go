@@ -0,0 +1,181 @@
+package autorest
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const headerAcceptEncoding = "Accept-Encoding"
+
+// Decompressor returns a reader of the decompressed content of the passed reader.
+type Decompressor func(r io.Reader) (io.ReadCloser, error)
+
+var decompressors = struct {
+	sync.RWMutex
+	m map[string]Decompressor
+}{
+	m: map[string]Decompressor{
+		"gzip": func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+		"deflate": decompressDeflate,
+	},
+}
+
+// RegisterDecompressor registers the Decompressor used by DoDecompress for the passed content coding
+// (e.g. "br"), replacing any Decompressor already registered for it. Matching is not case sensitive.
+// Registering a nil Decompressor removes the content coding. Decompressors for gzip and deflate are
+// registered by default.
+func RegisterDecompressor(encoding string, d Decompressor) {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	decompressors.Lock()
+	defer decompressors.Unlock()
+	if d == nil {
+		delete(decompressors.m, encoding)
+		return
+	}
+	decompressors.m[encoding] = d
+}
+
+// DoDecompress returns a SendDecorator that decompresses response bodies according to their
+// Content-Encoding header, using the Decompressors registered with RegisterDecompressor. Requests
+// without an Accept-Encoding header are sent advertising the registered content codings. The
+// Content-Encoding and Content-Length headers are removed from decompressed responses. Responses
+// using a content coding without a registered Decompressor are returned unchanged.
+func DoDecompress() SendDecorator {
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			if r.Header.Get(headerAcceptEncoding) == "" {
+				r = r.Clone(r.Context())
+				r.Header.Set(headerAcceptEncoding, acceptEncoding())
+			}
+			resp, err := s.Do(r)
+			if err != nil || resp == nil || resp.Body == nil || resp.Body == http.NoBody {
+				return resp, err
+			}
+			return resp, decompressResponse(resp, "DoDecompress")
+		})
+	}
+}
+
+// ByDecompressing returns a RespondDecorator that decompresses the response Body according to its
+// Content-Encoding header, as DoDecompress does, for responses that reach the Respond stage still
+// compressed (e.g. because the transport's own decompression was bypassed). Responses using a
+// content coding without a registered Decompressor are left unchanged.
+func ByDecompressing() RespondDecorator {
+	return func(r Responder) Responder {
+		return ResponderFunc(func(resp *http.Response) error {
+			err := r.Respond(resp)
+			if err != nil || resp == nil || resp.Body == nil || resp.Body == http.NoBody {
+				return err
+			}
+			return decompressResponse(resp, "ByDecompressing")
+		})
+	}
+}
+
+// decompressResponse replaces the body of the passed response with its decompressed content,
+// removing the Content-Encoding and Content-Length headers.
+func decompressResponse(resp *http.Response, funcName string) error {
+	encodings := []string{}
+	for _, v := range resp.Header.Values(headerContentEncoding) {
+		for _, e := range strings.Split(v, ",") {
+			if e = strings.ToLower(strings.TrimSpace(e)); e != "" && e != "identity" {
+				encodings = append(encodings, e)
+			}
+		}
+	}
+	if len(encodings) == 0 {
+		return nil
+	}
+	decompressors.RLock()
+	ds := make([]Decompressor, len(encodings))
+	for i, e := range encodings {
+		ds[i] = decompressors.m[e]
+	}
+	decompressors.RUnlock()
+	for _, d := range ds {
+		if d == nil {
+			return nil
+		}
+	}
+	// codings are listed in the order they were applied, so undo them in reverse
+	body := &decompressedBody{closers: []io.Closer{resp.Body}}
+	var rc io.Reader = resp.Body
+	for i := len(ds) - 1; i >= 0; i-- {
+		dr, err := ds[i](rc)
+		if err != nil {
+			body.Close()
+			return NewErrorWithError(err, "autorest", funcName, resp, "Failure decompressing the %s response body", encodings[i])
+		}
+		body.closers = append(body.closers, dr)
+		rc = dr
+	}
+	body.Reader = rc
+	resp.Body = body
+	resp.Header.Del(headerContentEncoding)
+	resp.Header.Del(headerContentLength)
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// acceptEncoding returns the registered content codings as an Accept-Encoding header value.
+func acceptEncoding() string {
+	decompressors.RLock()
+	defer decompressors.RUnlock()
+	encodings := make([]string, 0, len(decompressors.m))
+	for e := range decompressors.m {
+		encodings = append(encodings, e)
+	}
+	sort.Strings(encodings)
+	return strings.Join(encodings, ", ")
+}
+
+// decompressDeflate reads the deflate content coding, which is meant to be zlib wrapped but is
+// sent as raw deflate by some servers.
+func decompressDeflate(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	if b, err := br.Peek(2); err == nil && b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// decompressedBody reads the decompressed content and closes the decompressors and the original
+// body.
+type decompressedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (db *decompressedBody) Close() error {
+	var err error
+	for i := len(db.closers) - 1; i >= 0; i-- {
+		if cerr := db.closers[i].Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,174 @@
+package autorest
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+func compressedResponse(t *testing.T, encoding string, content string) *http.Response {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+		encoding = "deflate"
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatalf("autorest: failed to compress content (%v)", err)
+	}
+	w.Close()
+	resp := mocks.NewResponseWithBodyAndStatus(mocks.NewBody(buf.String()), http.StatusOK, "200 OK")
+	mocks.SetResponseHeader(resp, headerContentEncoding, encoding)
+	mocks.SetResponseHeader(resp, headerContentLength, "42")
+	return resp
+}
+
+func TestDoDecompress(t *testing.T) {
+	for _, encoding := range []string{"gzip", "deflate", "raw-deflate"} {
+		t.Run(encoding, func(t *testing.T) {
+			client := mocks.NewSender()
+			client.AppendResponse(compressedResponse(t, encoding, "decompressed content"))
+
+			r, err := SendWithSender(client, mocks.NewRequest(), DoDecompress())
+			if err != nil {
+				t.Fatalf("autorest: DoDecompress returned an unexpected error (%v)", err)
+			}
+			b, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Fatalf("autorest: DoDecompress returned an unreadable body (%v)", err)
+			}
+			if string(b) != "decompressed content" {
+				t.Fatalf("autorest: DoDecompress returned an unexpected body -- expected %q, received %q", "decompressed content", string(b))
+			}
+			if r.Header.Get(headerContentEncoding) != "" || r.Header.Get(headerContentLength) != "" || !r.Uncompressed {
+				t.Fatalf("autorest: DoDecompress failed to fix up the response headers -- %v", r.Header)
+			}
+			if err := r.Body.Close(); err != nil {
+				t.Fatalf("autorest: DoDecompress returned an unexpected error closing the body (%v)", err)
+			}
+		})
+	}
+}
+
+func TestDoDecompressSetsAcceptEncoding(t *testing.T) {
+	var accept string
+	s := SenderFunc(func(r *http.Request) (*http.Response, error) {
+		accept = r.Header.Get(headerAcceptEncoding)
+		return mocks.NewResponse(), nil
+	})
+
+	r, err := SendWithSender(s, mocks.NewRequest(), DoDecompress())
+	if err != nil {
+		t.Fatalf("autorest: DoDecompress returned an unexpected error (%v)", err)
+	}
+	if !strings.Contains(accept, "gzip") || !strings.Contains(accept, "deflate") {
+		t.Fatalf("autorest: DoDecompress sent an unexpected Accept-Encoding -- %q", accept)
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestDoDecompressIgnoresUnknownEncodings(t *testing.T) {
+	client := mocks.NewSender()
+	resp := mocks.NewResponseWithBodyAndStatus(mocks.NewBody("compressed"), http.StatusOK, "200 OK")
+	mocks.SetResponseHeader(resp, headerContentEncoding, "br")
+	client.AppendResponse(resp)
+
+	r, err := SendWithSender(client, mocks.NewRequest(), DoDecompress())
+	if err != nil {
+		t.Fatalf("autorest: DoDecompress returned an unexpected error (%v)", err)
+	}
+	if r.Header.Get(headerContentEncoding) != "br" {
+		t.Fatal("autorest: DoDecompress modified a response with an unknown content coding")
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestRegisterDecompressor(t *testing.T) {
+	RegisterDecompressor("Upper", func(r io.Reader) (io.ReadCloser, error) {
+		b, err := io.ReadAll(r)
+		return io.NopCloser(strings.NewReader(strings.ToUpper(string(b)))), err
+	})
+	defer RegisterDecompressor("upper", nil)
+
+	client := mocks.NewSender()
+	resp := mocks.NewResponseWithBodyAndStatus(mocks.NewBody("content"), http.StatusOK, "200 OK")
+	mocks.SetResponseHeader(resp, headerContentEncoding, "upper")
+	client.AppendResponse(resp)
+
+	r, err := SendWithSender(client, mocks.NewRequest(), DoDecompress())
+	if err != nil {
+		t.Fatalf("autorest: DoDecompress returned an unexpected error (%v)", err)
+	}
+	b, _ := io.ReadAll(r.Body)
+	if string(b) != "CONTENT" {
+		t.Fatalf("autorest: DoDecompress failed to use the registered Decompressor -- received %q", string(b))
+	}
+	r.Body.Close()
+}
+
+func TestDoDecompressReturnsErrorForCorruptContent(t *testing.T) {
+	client := mocks.NewSender()
+	resp := mocks.NewResponseWithBodyAndStatus(mocks.NewBody("not gzip"), http.StatusOK, "200 OK")
+	mocks.SetResponseHeader(resp, headerContentEncoding, "gzip")
+	client.AppendResponse(resp)
+
+	r, err := SendWithSender(client, mocks.NewRequest(), DoDecompress())
+	if err == nil {
+		t.Fatal("autorest: DoDecompress failed to return an error for corrupt content")
+	}
+	if r == nil {
+		t.Fatal("autorest: DoDecompress failed to return the response")
+	}
+}
+
+func TestByDecompressing(t *testing.T) {
+	var b []byte
+	resp := compressedResponse(t, "gzip", "decompressed content")
+	if err := Respond(resp, ByDecompressing(), ByUnmarshallingBytes(&b), ByClosing()); err != nil {
+		t.Fatalf("autorest: ByDecompressing returned an unexpected error (%v)", err)
+	}
+	if string(b) != "decompressed content" {
+		t.Fatalf("autorest: ByDecompressing returned an unexpected body -- expected %q, received %q", "decompressed content", string(b))
+	}
+	if resp.Header.Get(headerContentEncoding) != "" || !resp.Uncompressed {
+		t.Fatalf("autorest: ByDecompressing failed to fix up the response headers -- %v", resp.Header)
+	}
+
+	resp = mocks.NewResponseWithContent("plain content")
+	if err := Respond(resp, ByDecompressing(), ByUnmarshallingBytes(&b), ByClosing()); err != nil || string(b) != "plain content" {
+		t.Fatalf("autorest: ByDecompressing altered an uncompressed body -- %q (%v)", string(b), err)
+	}
+}
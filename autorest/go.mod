@@ -11,3 +11,5 @@ require (
 	github.com/golang-jwt/jwt/v4 v4.5.0 // indirect
 	golang.org/x/crypto v0.17.0
 )
+
+replace github.com/Azure/go-autorest/autorest/mocks => ./mocks
@@ -0,0 +1,97 @@
+package autorest
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Metrics is the interface that wraps the ObserveRequest method.
+//
+// ObserveRequest is called once for every attempt to send a request. code is the status code of
+// the response, or zero if no response was received, and attempt is the 1-based number of the
+// attempt when the request is retried by DoRetryForAttempts, DoRetryForDuration, DoRetryUntil,
+// DoRetryForStatusCodes or DoFailover. Implementations must be safe for concurrent use.
+type Metrics interface {
+	ObserveRequest(method, host string, code, attempt int, latency time.Duration)
+}
+
+// MetricsFunc is a method that implements the Metrics interface.
+type MetricsFunc func(method, host string, code, attempt int, latency time.Duration)
+
+// ObserveRequest implements the Metrics interface on MetricsFunc.
+func (mf MetricsFunc) ObserveRequest(method, host string, code, attempt int, latency time.Duration) {
+	mf(method, host, code, attempt, latency)
+}
+
+// DoCollectMetrics returns a SendDecorator that reports every request it sends to the passed
+// Metrics. List it before the retry decorators passed to SendWithSender, which then enclose it, so
+// that each attempt is observed with its own attempt number and latency.
+func DoCollectMetrics(m Metrics) SendDecorator {
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			start := DefaultClock.Now()
+			resp, err := s.Do(r)
+			code := 0
+			if resp != nil {
+				code = resp.StatusCode
+			}
+			host := ""
+			if r.URL != nil {
+				host = r.URL.Host
+			}
+			m.ObserveRequest(r.Method, host, code, requestAttempt(r), DefaultClock.Now().Sub(start))
+			return resp, err
+		})
+	}
+}
+
+// ExpvarMetrics is a Metrics implementation that publishes its counters with the expvar package.
+// Under its name it publishes a map holding:
+//   - "requests", the number of requests keyed by "METHOD host code"
+//   - "retries", the number of attempts after the first keyed by host
+//   - "latency_ms", the total latency in milliseconds keyed by host
+type ExpvarMetrics struct {
+	requests *expvar.Map
+	retries  *expvar.Map
+	latency  *expvar.Map
+}
+
+// NewExpvarMetrics creates an ExpvarMetrics published under the passed name. Like expvar.NewMap,
+// it panics if the name is already in use.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	em := &ExpvarMetrics{
+		requests: new(expvar.Map).Init(),
+		retries:  new(expvar.Map).Init(),
+		latency:  new(expvar.Map).Init(),
+	}
+	m := expvar.NewMap(name)
+	m.Set("requests", em.requests)
+	m.Set("retries", em.retries)
+	m.Set("latency_ms", em.latency)
+	return em
+}
+
+// ObserveRequest implements the Metrics interface on ExpvarMetrics.
+func (em *ExpvarMetrics) ObserveRequest(method, host string, code, attempt int, latency time.Duration) {
+	em.requests.Add(fmt.Sprintf("%s %s %d", method, host, code), 1)
+	if attempt > 1 {
+		em.retries.Add(host, 1)
+	}
+	em.latency.AddFloat(host, float64(latency)/float64(time.Millisecond))
+}
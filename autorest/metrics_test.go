@@ -0,0 +1,80 @@
+package autorest
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"expvar"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+func TestDoCollectMetrics(t *testing.T) {
+	client := mocks.NewSender()
+	client.AppendAndRepeatResponse(mocks.NewResponseWithStatus("503 Service Unavailable", http.StatusServiceUnavailable), 2)
+	client.AppendResponse(mocks.NewResponseWithStatus("200 OK", http.StatusOK))
+
+	type observation struct {
+		method, host  string
+		code, attempt int
+	}
+	var observed []observation
+	m := MetricsFunc(func(method, host string, code, attempt int, latency time.Duration) {
+		observed = append(observed, observation{method, host, code, attempt})
+	})
+
+	r, err := SendWithSender(client, mocks.NewRequestWithParams(http.MethodGet, "https://microsoft.com/a", nil),
+		DoCollectMetrics(m),
+		DoRetryForStatusCodes(3, 0, http.StatusServiceUnavailable))
+	if err != nil {
+		t.Fatalf("autorest: DoCollectMetrics returned an unexpected error (%v)", err)
+	}
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+
+	expected := []observation{
+		{"GET", "microsoft.com", http.StatusServiceUnavailable, 1},
+		{"GET", "microsoft.com", http.StatusServiceUnavailable, 2},
+		{"GET", "microsoft.com", http.StatusOK, 3},
+	}
+	if len(observed) != len(expected) {
+		t.Fatalf("autorest: DoCollectMetrics observed %v, expected %v", observed, expected)
+	}
+	for i := range expected {
+		if observed[i] != expected[i] {
+			t.Fatalf("autorest: DoCollectMetrics observed %v, expected %v", observed, expected)
+		}
+	}
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	m := NewExpvarMetrics("autorest_test_metrics")
+	m.ObserveRequest("GET", "microsoft.com", http.StatusOK, 1, 2*time.Millisecond)
+	m.ObserveRequest("GET", "microsoft.com", http.StatusOK, 2, 3*time.Millisecond)
+
+	published := expvar.Get("autorest_test_metrics").(*expvar.Map)
+	if v := published.Get("requests").(*expvar.Map).Get("GET microsoft.com 200").String(); v != "2" {
+		t.Fatalf("autorest: ExpvarMetrics counted %s requests, expected 2", v)
+	}
+	if v := published.Get("retries").(*expvar.Map).Get("microsoft.com").String(); v != "1" {
+		t.Fatalf("autorest: ExpvarMetrics counted %s retries, expected 1", v)
+	}
+	if v := published.Get("latency_ms").(*expvar.Map).Get("microsoft.com").String(); v != "5" {
+		t.Fatalf("autorest: ExpvarMetrics recorded %s ms of latency, expected 5", v)
+	}
+}
@@ -60,6 +60,50 @@ func (body *Body) Read(b []byte) (n int, err error) {
 	return n, nil
 }
 
+// ReadAt reads len(b) bytes into the passed byte slice starting at offset off.
+func (body *Body) ReadAt(b []byte, off int64) (n int, err error) {
+	if !body.IsOpen() {
+		return 0, fmt.Errorf("ERROR: Body has been closed")
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("ERROR: negative offset")
+	}
+	if off >= int64(len(body.src)) {
+		return 0, io.EOF
+	}
+	n = copy(b, body.src[off:])
+	if n < len(b) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Seek sets the offset for the next Read.
+func (body *Body) Seek(offset int64, whence int) (int64, error) {
+	if !body.IsOpen() {
+		return 0, fmt.Errorf("ERROR: Body has been closed")
+	}
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = int64(len(body.src)-len(body.buf)) + offset
+	case io.SeekEnd:
+		pos = int64(len(body.src)) + offset
+	default:
+		return 0, fmt.Errorf("ERROR: invalid whence")
+	}
+	if pos < 0 {
+		return 0, fmt.Errorf("ERROR: negative position")
+	}
+	if pos > int64(len(body.src)) {
+		pos = int64(len(body.src))
+	}
+	body.buf = body.src[pos:]
+	return pos, nil
+}
+
 // Close closes the body.
 func (body *Body) Close() error {
 	if body.isOpen {
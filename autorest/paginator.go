@@ -0,0 +1,72 @@
+package autorest
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"context"
+	"net/http"
+)
+
+// PageFunc is called by Paginator for each page of results. page is the 1-based number of the
+// page and resp is its response, whose Body may be read (e.g. with ByUnmarshallingJSON); it is
+// closed by Paginator. Return false to stop paging.
+type PageFunc func(page int, resp *http.Response) (bool, error)
+
+// Paginator iterates the pages of a list operation returning JSON lists of the form
+// {"value": [...], "nextLink": "..."}, sending a GET request to each nextLink until there is none.
+type Paginator struct {
+	// Sender sends the requests for each page.
+	Sender Sender
+
+	// PrepareDecorators are applied to the requests for the pages after the first, e.g. to add
+	// authorization. The request URL is set to the nextLink before they are applied.
+	PrepareDecorators []PrepareDecorator
+
+	// SendDecorators are applied when sending the request for each page, e.g. to retry.
+	SendDecorators []SendDecorator
+}
+
+// Do sends the passed request for the first page and calls onPage for it and each following page.
+// It stops, returning the error, when a request fails, a response does not have status code 200
+// or onPage returns an error, and stops, returning the context's error, when the passed context is
+// canceled.
+func (p Paginator) Do(ctx context.Context, req *http.Request, onPage PageFunc) error {
+	req = req.WithContext(ctx)
+	for page := 1; ; page++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		resp, err := SendWithSender(p.Sender, req, p.SendDecorators...)
+		if err != nil {
+			Respond(resp, ByDiscardingBody(), ByClosing())
+			return NewErrorWithError(err, "autorest", "Paginator.Do", resp, "Failure sending request for page %d", page)
+		}
+		var next string
+		if err = Respond(resp, WithErrorUnlessOK(), ByExtractingNextLink(&next)); err != nil {
+			Respond(resp, ByDiscardingBody(), ByClosing())
+			return err
+		}
+		more, err := onPage(page, resp)
+		Respond(resp, ByDiscardingBody(), ByClosing())
+		if err != nil || !more || next == "" {
+			return err
+		}
+		req, err = Prepare(&http.Request{}, append([]PrepareDecorator{AsGet(), WithBaseURL(next)}, p.PrepareDecorators...)...)
+		if err != nil {
+			return NewErrorWithError(err, "autorest", "Paginator.Do", nil, "Failure preparing request for page %d", page+1)
+		}
+		req = req.WithContext(ctx)
+	}
+}
@@ -0,0 +1,133 @@
+package autorest
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+type testPage struct {
+	Value []string `json:"value"`
+}
+
+func newPagingSender(pages map[string]string) Sender {
+	return SenderFunc(func(r *http.Request) (*http.Response, error) {
+		body, ok := pages[r.URL.Query().Get("page")]
+		if !ok {
+			return mocks.NewResponseWithStatus("404 Not Found", http.StatusNotFound), nil
+		}
+		resp := mocks.NewResponseWithContent(body)
+		resp.Request = r
+		return resp, nil
+	})
+}
+
+func TestPaginator(t *testing.T) {
+	s := newPagingSender(map[string]string{
+		"1": `{"value":["a","b"],"nextLink":"https://microsoft.com/list?page=2"}`,
+		"2": `{"value":["c"],"nextLink":"https://microsoft.com/list?page=3"}`,
+		"3": `{"value":["d"]}`,
+	})
+	var items []string
+	var pages []int
+	var authorized int
+	p := Paginator{
+		Sender:            s,
+		PrepareDecorators: []PrepareDecorator{WithHeader(headerAuthorization, "Bearer token")},
+		SendDecorators: []SendDecorator{func(s Sender) Sender {
+			return SenderFunc(func(r *http.Request) (*http.Response, error) {
+				if r.Header.Get(headerAuthorization) != "" {
+					authorized++
+				}
+				return s.Do(r)
+			})
+		}},
+	}
+	err := p.Do(context.Background(), mocks.NewRequestForURL("https://microsoft.com/list?page=1"), func(page int, resp *http.Response) (bool, error) {
+		var tp testPage
+		if err := Respond(resp, ByUnmarshallingJSON(&tp)); err != nil {
+			return false, err
+		}
+		pages = append(pages, page)
+		items = append(items, tp.Value...)
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("autorest: Paginator returned an unexpected error (%v)", err)
+	}
+	if expected := []string{"a", "b", "c", "d"}; !reflect.DeepEqual(items, expected) {
+		t.Fatalf("autorest: Paginator returned unexpected items -- expected %v, received %v", expected, items)
+	}
+	if expected := []int{1, 2, 3}; !reflect.DeepEqual(pages, expected) {
+		t.Fatalf("autorest: Paginator returned unexpected pages -- expected %v, received %v", expected, pages)
+	}
+	if authorized != 2 {
+		t.Fatalf("autorest: Paginator failed to prepare the follow-up requests -- authorized %v", authorized)
+	}
+}
+
+func TestPaginatorStops(t *testing.T) {
+	s := newPagingSender(map[string]string{
+		"1": `{"value":["a"],"nextLink":"https://microsoft.com/list?page=2"}`,
+		"2": `{"value":["b"],"nextLink":"https://microsoft.com/list?page=3"}`,
+	})
+	p := Paginator{Sender: s}
+	count := 0
+	err := p.Do(context.Background(), mocks.NewRequestForURL("https://microsoft.com/list?page=1"), func(page int, resp *http.Response) (bool, error) {
+		count++
+		return false, nil
+	})
+	if err != nil || count != 1 {
+		t.Fatalf("autorest: Paginator failed to stop -- pages %v, error %v", count, err)
+	}
+
+	err = p.Do(context.Background(), mocks.NewRequestForURL("https://microsoft.com/list?page=1"), func(page int, resp *http.Response) (bool, error) {
+		return true, fmt.Errorf("Faux Error")
+	})
+	if err == nil || err.Error() != "Faux Error" {
+		t.Fatalf("autorest: Paginator failed to return the callback error -- received %v", err)
+	}
+
+	// page 3 is missing
+	err = p.Do(context.Background(), mocks.NewRequestForURL("https://microsoft.com/list?page=1"), func(page int, resp *http.Response) (bool, error) {
+		return true, nil
+	})
+	if err == nil {
+		t.Fatal("autorest: Paginator failed to return an error for a failed page")
+	}
+}
+
+func TestPaginatorCancels(t *testing.T) {
+	s := newPagingSender(map[string]string{
+		"1": `{"value":["a"],"nextLink":"https://microsoft.com/list?page=2"}`,
+		"2": `{"value":["b"]}`,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	count := 0
+	err := Paginator{Sender: s}.Do(ctx, mocks.NewRequestForURL("https://microsoft.com/list?page=1"), func(page int, resp *http.Response) (bool, error) {
+		count++
+		cancel()
+		return true, nil
+	})
+	if err != context.Canceled || count != 1 {
+		t.Fatalf("autorest: Paginator failed to cancel -- pages %v, error %v", count, err)
+	}
+}
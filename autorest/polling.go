@@ -0,0 +1,122 @@
+package autorest
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// PollingStatusCodes classifies the status codes of the responses to polling requests, for services
+// that signal the progress of a long-running operation through the status code alone, e.g. 200 OK
+// while it is running and 204 No Content once it is done.
+type PollingStatusCodes struct {
+	// InProgress lists the status codes meaning the operation is still running.
+	InProgress []int
+
+	// Succeeded lists the status codes meaning the operation has succeeded.
+	Succeeded []int
+
+	// Failed lists the status codes meaning the operation has failed.
+	Failed []int
+}
+
+// PollingStrategy is the interface that decides how long to wait before each poll of a
+// long-running operation.
+type PollingStrategy interface {
+	// NextDelay returns the delay before the passed poll, numbered from one, given the latest
+	// response.
+	NextDelay(poll int, resp *http.Response) time.Duration
+}
+
+// PollingStrategyFunc is a method that implements the PollingStrategy interface.
+type PollingStrategyFunc func(poll int, resp *http.Response) time.Duration
+
+// NextDelay implements the PollingStrategy interface on PollingStrategyFunc.
+func (psf PollingStrategyFunc) NextDelay(poll int, resp *http.Response) time.Duration {
+	return psf(poll, resp)
+}
+
+// FixedPolling returns a PollingStrategy that waits the passed delay before every poll.
+func FixedPolling(delay time.Duration) PollingStrategy {
+	return PollingStrategyFunc(func(int, *http.Response) time.Duration {
+		return delay
+	})
+}
+
+// LinearPolling returns a PollingStrategy that waits initial before the first poll and increment
+// longer before each subsequent poll, up to max. A max of zero leaves the delay unbounded.
+func LinearPolling(initial, increment, max time.Duration) PollingStrategy {
+	return PollingStrategyFunc(func(poll int, _ *http.Response) time.Duration {
+		if poll < 1 {
+			poll = 1
+		}
+		return capDelay(initial+time.Duration(poll-1)*increment, max)
+	})
+}
+
+// ExponentialPolling returns a PollingStrategy that waits initial before the first poll and twice
+// as long before each subsequent poll, up to max. A max of zero leaves the delay unbounded.
+func ExponentialPolling(initial, max time.Duration) PollingStrategy {
+	return PollingStrategyFunc(func(poll int, _ *http.Response) time.Duration {
+		d := initial
+		for i := 1; i < poll && (max <= 0 || d < max) && d < time.Duration(1<<62); i++ {
+			d *= 2
+		}
+		return capDelay(d, max)
+	})
+}
+
+// RetryAfterPolling returns a PollingStrategy that waits for the delay in the retry-after-ms,
+// x-ms-retry-after-ms or Retry-After header of the latest response (see GetRetryAfter), or for the
+// delay chosen by the passed PollingStrategy if the headers are absent.
+func RetryAfterPolling(fallback PollingStrategy) PollingStrategy {
+	return PollingStrategyFunc(func(poll int, resp *http.Response) time.Duration {
+		if resp != nil {
+			if d, ok := retryAfter(resp); ok {
+				return d
+			}
+		}
+		return fallback.NextDelay(poll, resp)
+	})
+}
+
+// JitteredPolling returns a PollingStrategy that randomly lengthens or shortens each delay chosen by
+// the passed PollingStrategy by up to the passed fraction of it, e.g. 0.2 for up to 20%, so that many
+// clients polling the same kind of operation don't synchronize their polls at multiples of the
+// Retry-After interval. The fraction is limited to the range zero to one.
+func JitteredPolling(strategy PollingStrategy, fraction float64) PollingStrategy {
+	if fraction < 0 {
+		fraction = 0
+	} else if fraction > 1 {
+		fraction = 1
+	}
+	return PollingStrategyFunc(func(poll int, resp *http.Response) time.Duration {
+		d := strategy.NextDelay(poll, resp)
+		return d + time.Duration(float64(d)*fraction*(2*jitter()-1))
+	})
+}
+
+// jitter returns a pseudo-random number in [0.0,1.0), and is replaced in tests.
+var jitter = rand.Float64
+
+// capDelay returns d, limited to max if max is positive.
+func capDelay(d, max time.Duration) time.Duration {
+	if max > 0 && d > max {
+		return max
+	}
+	return d
+}
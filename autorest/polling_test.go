@@ -0,0 +1,155 @@
+package autorest
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+func TestPollingStrategies(t *testing.T) {
+	cases := []struct {
+		name     string
+		strategy PollingStrategy
+		expected []time.Duration
+	}{
+		{"fixed", FixedPolling(time.Second), []time.Duration{time.Second, time.Second, time.Second}},
+		{"linear", LinearPolling(time.Second, 2*time.Second, 4*time.Second), []time.Duration{time.Second, 3 * time.Second, 4 * time.Second}},
+		{"exponential", ExponentialPolling(time.Second, 3*time.Second), []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}},
+		{"exponential unbounded", ExponentialPolling(time.Second, 0), []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}},
+	}
+	for _, c := range cases {
+		for i, expected := range c.expected {
+			if d := c.strategy.NextDelay(i+1, nil); d != expected {
+				t.Fatalf("autorest: %s polling returned the wrong delay for poll %d -- expected %v, received %v", c.name, i+1, expected, d)
+			}
+		}
+	}
+}
+
+func TestRetryAfterPolling(t *testing.T) {
+	strategy := RetryAfterPolling(FixedPolling(time.Minute))
+	resp := mocks.NewResponseWithStatus("202 Accepted", http.StatusAccepted)
+	if d := strategy.NextDelay(1, resp); d != time.Minute {
+		t.Fatalf("autorest: RetryAfterPolling did not fall back without Retry-After -- received %v", d)
+	}
+	mocks.SetResponseHeader(resp, HeaderRetryAfter, "5")
+	if d := strategy.NextDelay(1, resp); d != 5*time.Second {
+		t.Fatalf("autorest: RetryAfterPolling ignored Retry-After -- received %v", d)
+	}
+}
+
+func TestDoPollWithStrategy(t *testing.T) {
+	fc := withFakeClock(t)
+	start := fc.Now()
+	accepted := mocks.NewResponseWithStatus("202 Accepted", http.StatusAccepted)
+	mocks.SetLocationHeader(accepted, mocks.TestURL)
+	client := mocks.NewSender()
+	client.AppendAndRepeatResponse(accepted, 4)
+
+	r, err := SendWithSender(client, mocks.NewRequest(),
+		DoPollWithStrategy(ExponentialPolling(time.Second, time.Minute), http.StatusAccepted))
+	if err != nil {
+		t.Fatalf("autorest: DoPollWithStrategy returned an unexpected error (%v)", err)
+	}
+	if r.StatusCode != http.StatusOK || client.Attempts() != 5 {
+		t.Fatalf("autorest: DoPollWithStrategy stopped polling early -- %d attempts", client.Attempts())
+	}
+	if elapsed := fc.Now().Sub(start); elapsed != 15*time.Second {
+		t.Fatalf("autorest: DoPollWithStrategy waited the wrong time -- expected %v, received %v", 15*time.Second, elapsed)
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestPollUntilDoneWithStrategyReturnsContextError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	accepted := mocks.NewResponseWithStatus("202 Accepted", http.StatusAccepted)
+	mocks.SetLocationHeader(accepted, mocks.TestURL)
+	if _, err := PollUntilDoneWithStrategy(ctx, mocks.NewSender(), accepted, FixedPolling(0), http.StatusAccepted); err != context.Canceled {
+		t.Fatalf("autorest: PollUntilDoneWithStrategy returned an unexpected error -- expected %v, received %v", context.Canceled, err)
+	}
+}
+
+func TestDoPollWithStatusCodes(t *testing.T) {
+	inProgress := func() *http.Response {
+		resp := mocks.NewResponseWithContent(`{"status": "Running"}`)
+		mocks.SetLocationHeader(resp, mocks.TestURL)
+		return resp
+	}
+	codes := PollingStatusCodes{
+		InProgress: []int{http.StatusOK},
+		Succeeded:  []int{http.StatusNoContent},
+		Failed:     []int{http.StatusConflict},
+	}
+	cases := []struct {
+		name     string
+		final    *http.Response
+		expected bool
+	}{
+		{"succeeded", mocks.NewResponseWithStatus("204 No Content", http.StatusNoContent), true},
+		{"failed", mocks.NewResponseWithStatus("409 Conflict", http.StatusConflict), false},
+		{"unexpected", mocks.NewResponseWithStatus("500 Internal Server Error", http.StatusInternalServerError), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := mocks.NewSender()
+			client.AppendAndRepeatResponse(inProgress(), 3)
+			client.AppendResponse(c.final)
+
+			r, err := SendWithSender(client, mocks.NewRequest(),
+				DoPollWithStatusCodes(FixedPolling(0), codes))
+			if (err == nil) != c.expected {
+				t.Fatalf("autorest: DoPollWithStatusCodes returned an unexpected error (%v)", err)
+			}
+			if r.StatusCode != c.final.StatusCode || client.Attempts() != 4 {
+				t.Fatalf("autorest: DoPollWithStatusCodes stopped polling early -- %d attempts, status %v", client.Attempts(), r.StatusCode)
+			}
+
+			Respond(r,
+				ByDiscardingBody(),
+				ByClosing())
+		})
+	}
+}
+
+func TestJitteredPolling(t *testing.T) {
+	defer func(f func() float64) { jitter = f }(jitter)
+	cases := []struct {
+		random   float64
+		fraction float64
+		expected time.Duration
+	}{
+		{0, 0.2, 8 * time.Second},
+		{0.5, 0.2, 10 * time.Second},
+		{0.75, 0.2, 11 * time.Second},
+		{0, 2, 0},
+		{0.9, -1, 10 * time.Second},
+	}
+	for _, c := range cases {
+		random := c.random
+		jitter = func() float64 { return random }
+		if d := JitteredPolling(FixedPolling(10*time.Second), c.fraction).NextDelay(1, nil); d != c.expected {
+			t.Fatalf("autorest: JitteredPolling returned the wrong delay for %v jitter of %v -- expected %v, received %v", c.random, c.fraction, c.expected, d)
+		}
+	}
+}
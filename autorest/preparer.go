@@ -16,25 +16,40 @@ package autorest
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/url"
+	"os"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
+	mimeTypeCSV         = "text/csv"
 	mimeTypeJSON        = "application/json"
 	mimeTypeOctetStream = "application/octet-stream"
+	mimeTypeXML         = "application/xml"
 	mimeTypeFormPost    = "application/x-www-form-urlencoded"
+	mimeTypeProtobuf    = "application/x-protobuf"
+	mimeTypeNDJSON      = "application/x-ndjson"
 
+	headerAcceptLanguage   = "Accept-Language"
 	headerAuthorization    = "Authorization"
 	headerAuxAuthorization = "x-ms-authorization-auxiliary"
+	headerContentRange     = "Content-Range"
 	headerContentType      = "Content-Type"
+	headerETag             = "ETag"
+	headerIdempotencyKey   = "Idempotency-Key"
 	headerUserAgent        = "User-Agent"
 )
 
@@ -59,6 +74,125 @@ func GetPrepareDecorators(ctx context.Context, defaultPrepareDecorators ...Prepa
 	return defaultPrepareDecorators
 }
 
+// used as a key type in context.WithValue()
+type ctxPollingCarryForward struct{}
+
+type pollingCarryForward struct {
+	headers         []string
+	queryParameters []string
+}
+
+// WithPollingCarryForward returns a copy of the provided context that makes polling requests carry
+// forward the named headers (e.g. x-ms-client-request-id) and query parameters (e.g. api-version)
+// from the request that started the long-running operation. See WithCarriedForward.
+func WithPollingCarryForward(ctx context.Context, headers []string, queryParameters []string) context.Context {
+	if len(headers) == 0 && len(queryParameters) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxPollingCarryForward{}, pollingCarryForward{headers: headers, queryParameters: queryParameters})
+}
+
+// WithCarriedForward returns a PrepareDecorator that copies into the http.Request the headers and
+// query parameters named in the provided context by WithPollingCarryForward from the passed
+// request. Headers replace any values already present; query parameters are only added when the
+// http.Request URL does not already carry them, since a polling URL returned by the service takes
+// precedence. NewPollingRequestWithContext applies it to the request of the response being polled.
+func WithCarriedForward(ctx context.Context, from *http.Request) PrepareDecorator {
+	cf, ok := ctx.Value(ctxPollingCarryForward{}).(pollingCarryForward)
+	if !ok || from == nil {
+		return WithNothing()
+	}
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err != nil {
+				return r, err
+			}
+			for _, name := range cf.headers {
+				name = http.CanonicalHeaderKey(name)
+				if values := from.Header[name]; len(values) > 0 {
+					if r.Header == nil {
+						r.Header = make(http.Header)
+					}
+					r.Header[name] = append([]string(nil), values...)
+				}
+			}
+			if len(cf.queryParameters) == 0 || from.URL == nil {
+				return r, nil
+			}
+			if r.URL == nil {
+				return r, NewError("autorest", "WithCarriedForward", "Invoked with a nil URL")
+			}
+			source := from.URL.Query()
+			v := r.URL.Query()
+			added := false
+			for _, key := range cf.queryParameters {
+				if _, ok := v[key]; !ok && len(source[key]) > 0 {
+					v[key] = source[key]
+					added = true
+				}
+			}
+			if added {
+				r.URL.RawQuery = v.Encode()
+			}
+			return r, nil
+		})
+	}
+}
+
+type prepareHook struct {
+	name      string
+	decorator PrepareDecorator
+}
+
+var prepareHooks = struct {
+	sync.RWMutex
+	hooks []prepareHook
+}{}
+
+// RegisterPrepareHook registers a PrepareDecorator, under the passed name, that WithRegisteredHooks
+// applies to every request; Client.Do applies the hooks to all requests it sends. Hooks are applied
+// in registration order. Registering a name again replaces its decorator in place and registering
+// a nil PrepareDecorator removes the hook.
+func RegisterPrepareHook(name string, d PrepareDecorator) {
+	prepareHooks.Lock()
+	defer prepareHooks.Unlock()
+	for i, h := range prepareHooks.hooks {
+		if h.name == name {
+			if d == nil {
+				prepareHooks.hooks = append(prepareHooks.hooks[:i:i], prepareHooks.hooks[i+1:]...)
+			} else {
+				prepareHooks.hooks[i].decorator = d
+			}
+			return
+		}
+	}
+	if d != nil {
+		prepareHooks.hooks = append(prepareHooks.hooks, prepareHook{name: name, decorator: d})
+	}
+}
+
+// WithRegisteredHooks returns a PrepareDecorator that applies the PrepareDecorators registered
+// with RegisterPrepareHook. The hooks are looked up each time the request is prepared, so hooks
+// registered later apply to existing Preparers as well.
+func WithRegisteredHooks() PrepareDecorator {
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err == nil {
+				prepareHooks.RLock()
+				decorators := make([]PrepareDecorator, len(prepareHooks.hooks))
+				for i, h := range prepareHooks.hooks {
+					decorators[i] = h.decorator
+				}
+				prepareHooks.RUnlock()
+				r, err = Prepare(r, decorators...)
+			}
+			return r, err
+		})
+	}
+}
+
 // Preparer is the interface that wraps the Prepare method.
 //
 // Prepare accepts and possibly modifies an http.Request (e.g., adding Headers). Implementations
@@ -92,6 +226,8 @@ func CreatePreparer(decorators ...PrepareDecorator) Preparer {
 // applies to the Preparer. Decorators are applied in the order received, but their affect upon the
 // request depends on whether they are a pre-decorator (change the http.Request and then pass it
 // along) or a post-decorator (pass the http.Request along and alter it on return).
+// The passed Preparer is not modified, so a base Preparer (e.g. applying the base URL and
+// authorization) may be built once and shared by the Preparers derived from it.
 func DecoratePreparer(p Preparer, decorators ...PrepareDecorator) Preparer {
 	for _, decorate := range decorators {
 		p = decorate(p)
@@ -118,6 +254,98 @@ func WithNothing() PrepareDecorator {
 	}
 }
 
+// WithContext returns a PrepareDecorator that binds the passed context to the http.Request so
+// its cancellation and deadline propagate to the Sender. The request's previous context is
+// replaced, including values attached by earlier decorators such as WithHostOverride, so
+// WithContext should precede them.
+func WithContext(ctx context.Context) PrepareDecorator {
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err == nil {
+				if ctx == nil {
+					return r, NewError("autorest", "WithContext", "Invoked with a nil context")
+				}
+				r = r.WithContext(ctx)
+			}
+			return r, err
+		})
+	}
+}
+
+// PrepareIf returns a PrepareDecorator that applies the passed PrepareDecorator only when condition
+// returns true. The condition is evaluated against the http.Request produced by the Preparers it
+// wraps, so it observes the changes made by decorators earlier in the chain.
+func PrepareIf(condition func(*http.Request) bool, decorator PrepareDecorator) PrepareDecorator {
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err == nil && condition(r) {
+				return CreatePreparer(decorator).Prepare(r)
+			}
+			return r, err
+		})
+	}
+}
+
+// WithCurlDump returns a PrepareDecorator that writes to w a curl command equivalent to the
+// prepared http.Request, which is useful when reproducing service-side issues. When redactAuth is
+// true the values of the Authorization and x-ms-authorization-auxiliary headers are replaced with
+// "REDACTED". The request body is included and remains readable by subsequent decorators.
+func WithCurlDump(w io.Writer, redactAuth bool) PrepareDecorator {
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err != nil {
+				return r, err
+			}
+			if r.URL == nil {
+				return r, NewError("autorest", "WithCurlDump", "Invoked with a nil URL")
+			}
+			clone, err := CloneRequest(r)
+			if err != nil {
+				return r, err
+			}
+			var b strings.Builder
+			method := r.Method
+			if method == "" {
+				method = http.MethodGet
+			}
+			fmt.Fprintf(&b, "curl -X %s %s", method, shellQuote(r.URL.String()))
+			names := make([]string, 0, len(r.Header))
+			for name := range r.Header {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				for _, value := range r.Header[name] {
+					if redactAuth && (strings.EqualFold(name, headerAuthorization) || strings.EqualFold(name, headerAuxAuthorization)) {
+						value = "REDACTED"
+					}
+					fmt.Fprintf(&b, " -H %s", shellQuote(name+": "+value))
+				}
+			}
+			if clone.Body != nil && clone.Body != http.NoBody {
+				body, err := io.ReadAll(clone.Body)
+				if err != nil {
+					return r, err
+				}
+				if len(body) > 0 {
+					fmt.Fprintf(&b, " --data-binary %s", shellQuote(string(body)))
+				}
+			}
+			b.WriteString("\n")
+			_, err = io.WriteString(w, b.String())
+			return r, err
+		})
+	}
+}
+
+// shellQuote quotes s for use as a single argument in a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
 // WithHeader returns a PrepareDecorator that sets the specified HTTP header of the http.Request to
 // the passed value. It canonicalizes the passed header name (via http.CanonicalHeaderKey) before
 // adding the header.
@@ -135,7 +363,8 @@ func WithHeader(header string, value string) PrepareDecorator {
 
 // WithHeaders returns a PrepareDecorator that sets the specified HTTP headers of the http.Request to
 // the passed value. It canonicalizes the passed headers name (via http.CanonicalHeaderKey) before
-// adding them.
+// adding them. Any values already present for those headers are replaced; use WithAppendedHeaders
+// to keep them.
 func WithHeaders(headers map[string]interface{}) PrepareDecorator {
 	h := ensureValueStrings(headers)
 	return func(p Preparer) Preparer {
@@ -155,12 +384,112 @@ func WithHeaders(headers map[string]interface{}) PrepareDecorator {
 	}
 }
 
+// WithAppendedHeaders returns a PrepareDecorator that adds the specified HTTP headers to the
+// http.Request, keeping any values already present for those headers. Slice and array values add
+// one header value per element. It canonicalizes the passed headers name (via
+// http.CanonicalHeaderKey) before adding them.
+func WithAppendedHeaders(headers map[string]interface{}) PrepareDecorator {
+	h := MapToValues(headers)
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err == nil {
+				if r.Header == nil {
+					r.Header = make(http.Header)
+				}
+
+				for name, values := range h {
+					for _, value := range values {
+						r.Header.Add(http.CanonicalHeaderKey(name), value)
+					}
+				}
+			}
+			return r, err
+		})
+	}
+}
+
+// WithCookie returns a PrepareDecorator that adds the passed cookie to the http.Request's Cookie
+// header.
+func WithCookie(cookie *http.Cookie) PrepareDecorator {
+	return WithCookies([]*http.Cookie{cookie})
+}
+
+// WithCookies returns a PrepareDecorator that adds the passed cookies to the http.Request's Cookie
+// header.
+func WithCookies(cookies []*http.Cookie) PrepareDecorator {
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err == nil {
+				if r.Header == nil {
+					r.Header = make(http.Header)
+				}
+				for _, cookie := range cookies {
+					r.AddCookie(cookie)
+				}
+			}
+			return r, err
+		})
+	}
+}
+
 // WithBearerAuthorization returns a PrepareDecorator that adds an HTTP Authorization header whose
 // value is "Bearer " followed by the supplied token.
 func WithBearerAuthorization(token string) PrepareDecorator {
 	return WithHeader(headerAuthorization, fmt.Sprintf("Bearer %s", token))
 }
 
+// WithIfMatch returns a PrepareDecorator that adds an HTTP If-Match header whose value is the passed
+// ETag. Use "*" to require that the resource exists.
+func WithIfMatch(etag string) PrepareDecorator {
+	return WithHeader(headerIfMatch, etag)
+}
+
+// WithIfNoneMatch returns a PrepareDecorator that adds an HTTP If-None-Match header whose value is
+// the passed ETag. Use "*" to require that the resource does not exist.
+func WithIfNoneMatch(etag string) PrepareDecorator {
+	return WithHeader(headerIfNoneMatch, etag)
+}
+
+// WithIfModifiedSince returns a PrepareDecorator that adds an HTTP If-Modified-Since header whose
+// value is the passed time formatted per RFC 7231 (i.e., http.TimeFormat).
+func WithIfModifiedSince(t time.Time) PrepareDecorator {
+	return WithHeader(headerIfModifiedSince, t.UTC().Format(http.TimeFormat))
+}
+
+// WithIdempotencyKey returns a PrepareDecorator that adds an HTTP Idempotency-Key header whose value
+// is a newly generated random UUID. If the http.Request already carries the header, e.g. because it
+// is prepared again before being retried, the existing key is kept so that every attempt of the
+// logical operation is sent with the same key.
+func WithIdempotencyKey() PrepareDecorator {
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err == nil && r.Header.Get(headerIdempotencyKey) == "" {
+				var key string
+				if key, err = newUUID(); err != nil {
+					return r, err
+				}
+				setHeader(r, headerIdempotencyKey, key)
+			}
+			return r, err
+		})
+	}
+}
+
+// WithRange returns a PrepareDecorator that adds an HTTP Range header requesting the bytes from
+// start to end, inclusive (e.g., "bytes=0-1023").
+func WithRange(start, end int64) PrepareDecorator {
+	return WithHeader(headerRange, fmt.Sprintf("bytes=%d-%d", start, end))
+}
+
+// WithRangeFrom returns a PrepareDecorator that adds an HTTP Range header requesting all bytes from
+// the passed offset to the end of the resource (e.g., "bytes=1024-").
+func WithRangeFrom(offset int64) PrepareDecorator {
+	return WithHeader(headerRange, fmt.Sprintf("bytes=%d-", offset))
+}
+
 // AsContentType returns a PrepareDecorator that adds an HTTP Content-Type header whose value
 // is the passed contentType.
 func AsContentType(contentType string) PrepareDecorator {
@@ -173,12 +502,60 @@ func WithUserAgent(ua string) PrepareDecorator {
 	return WithHeader(headerUserAgent, ua)
 }
 
+// WithAppendedUserAgent returns a PrepareDecorator that appends the passed string to the HTTP
+// User-Agent header, separated by a space, so that library, SDK and application identifiers can be
+// stacked. The string is not appended again if the User-Agent already contains it as a whole
+// space-separated token. Use WithUserAgent to replace the User-Agent instead.
+func WithAppendedUserAgent(ua string) PrepareDecorator {
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err == nil && ua != "" {
+				existing := r.UserAgent()
+				if existing == "" {
+					setHeader(r, headerUserAgent, ua)
+				} else if !containsUserAgent(existing, ua) {
+					setHeader(r, headerUserAgent, existing+" "+ua)
+				}
+			}
+			return r, err
+		})
+	}
+}
+
+// containsUserAgent reports whether the passed User-Agent already holds ua as a whole sequence of
+// space-separated tokens.
+func containsUserAgent(existing, ua string) bool {
+	tokens := strings.Fields(existing)
+	want := strings.Fields(ua)
+	for i := 0; i+len(want) <= len(tokens); i++ {
+		match := true
+		for j := range want {
+			if tokens[i+j] != want[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
 // AsFormURLEncoded returns a PrepareDecorator that adds an HTTP Content-Type header whose value is
 // "application/x-www-form-urlencoded".
 func AsFormURLEncoded() PrepareDecorator {
 	return AsContentType(mimeTypeFormPost)
 }
 
+// WithAcceptLanguage returns a PrepareDecorator that sets the HTTP Accept-Language header to the
+// passed language (e.g. "fr-FR" or "de-DE, de;q=0.9"). Services that honor the header, such as
+// Azure Resource Manager, return localized error messages.
+func WithAcceptLanguage(lang string) PrepareDecorator {
+	return WithHeader(headerAcceptLanguage, lang)
+}
+
 // AsJSON returns a PrepareDecorator that adds an HTTP Content-Type header whose value is
 // "application/json".
 func AsJSON() PrepareDecorator {
@@ -255,6 +632,26 @@ func WithBaseURL(baseURL string) PrepareDecorator {
 	}
 }
 
+// WithHTTPSOnly returns a PrepareDecorator that fails if the http.Request URL does not use the
+// https scheme, ensuring credentials are never sent in clear text. It should precede any
+// decorators that add credentials to the request.
+func WithHTTPSOnly() PrepareDecorator {
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err == nil {
+				if r.URL == nil {
+					return r, NewError("autorest", "WithHTTPSOnly", "Invoked with a nil URL")
+				}
+				if !strings.EqualFold(r.URL.Scheme, "https") {
+					return r, NewError("autorest", "WithHTTPSOnly", "the scheme of URL %s is not https", r.URL.Redacted())
+				}
+			}
+			return r, err
+		})
+	}
+}
+
 // WithBytes returns a PrepareDecorator that takes a list of bytes
 // which passes the bytes directly to the body
 func WithBytes(input *[]byte) PrepareDecorator {
@@ -268,24 +665,122 @@ func WithBytes(input *[]byte) PrepareDecorator {
 
 				r.ContentLength = int64(len(*input))
 				r.Body = io.NopCloser(bytes.NewReader(*input))
+				r.GetBody = func() (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(*input)), nil
+				}
 			}
 			return r, err
 		})
 	}
 }
 
+// WithBody returns a PrepareDecorator that sets the passed io.Reader as the request body without
+// buffering it. When the reader is a *bytes.Reader or a regular *os.File, the Content-Length is set
+// to the number of unread bytes and http.Request.GetBody is populated so the body can be replayed
+// when the request is retried. The caller retains ownership of the reader (e.g. closing the file).
+func WithBody(body io.Reader) PrepareDecorator {
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err == nil {
+				if body == nil {
+					return r, NewError("autorest", "WithBody", "Invoked with a nil io.Reader")
+				}
+				var sr *io.SectionReader
+				if sr, err = sectionReaderFor(body); err != nil {
+					return r, err
+				}
+				if sr == nil {
+					r.Body = io.NopCloser(body)
+					return r, nil
+				}
+				r.ContentLength = sr.Size()
+				r.Body = io.NopCloser(sr)
+				r.GetBody = func() (io.ReadCloser, error) {
+					return io.NopCloser(io.NewSectionReader(sr, 0, sr.Size())), nil
+				}
+			}
+			return r, err
+		})
+	}
+}
+
+// sectionReaderFor returns a reader over the unread portion of body if its length is known and it
+// can be read at arbitrary offsets, else nil.
+func sectionReaderFor(body io.Reader) (*io.SectionReader, error) {
+	switch b := body.(type) {
+	case *bytes.Reader:
+		off, err := b.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		return io.NewSectionReader(b, off, b.Size()-off), nil
+	case *os.File:
+		fi, err := b.Stat()
+		if err != nil {
+			return nil, err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil, nil
+		}
+		off, err := b.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		return io.NewSectionReader(b, off, fi.Size()-off), nil
+	}
+	return nil, nil
+}
+
 // WithCustomBaseURL returns a PrepareDecorator that replaces brace-enclosed keys within the
 // request base URL (i.e., http.Request.URL) with the corresponding values from the passed map.
+// Keys within the host portion of the template (e.g., "https://{accountName}.vault.azure.net")
+// must expand to host name labels; values containing URL delimiters are rejected so they cannot
+// redirect the request to a different host.
 func WithCustomBaseURL(baseURL string, urlParameters map[string]interface{}) PrepareDecorator {
 	parameters := ensureValueStrings(urlParameters)
+	host := templateHost(baseURL)
 	for key, value := range parameters {
-		baseURL = strings.Replace(baseURL, "{"+key+"}", value, -1)
+		placeholder := "{" + key + "}"
+		if strings.Contains(host, placeholder) && strings.ContainsAny(value, "/\\?#@: ") {
+			return withError(NewError("autorest", "WithCustomBaseURL", "invalid value %q for host parameter %s", value, key))
+		}
+		baseURL = strings.Replace(baseURL, placeholder, value, -1)
 	}
 	return WithBaseURL(baseURL)
 }
 
-// WithFormData returns a PrepareDecoratore that "URL encodes" (e.g., bar=baz&foo=quux) into the
-// http.Request body.
+// templateHost returns the authority portion of a URL template or an empty string if the template
+// does not start with a scheme.
+func templateHost(template string) string {
+	i := strings.Index(template, "://")
+	if i < 0 {
+		return ""
+	}
+	host := template[i+3:]
+	if j := strings.IndexAny(host, "/?#"); j >= 0 {
+		host = host[:j]
+	}
+	return host
+}
+
+// withError returns a PrepareDecorator that fails with the passed error after invoking the
+// Preparers it wraps. It is used to report errors detected while constructing a decorator.
+func withError(err error) PrepareDecorator {
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, perr := p.Prepare(r)
+			if perr != nil {
+				return r, perr
+			}
+			return r, err
+		})
+	}
+}
+
+// WithFormData returns a PrepareDecorator that "URL encodes" (e.g., bar=baz&foo=quux) into the
+// http.Request body. It also sets the Content-Type header to "application/x-www-form-urlencoded"
+// and the Content-Length of the request to the length of the encoded values.
 func WithFormData(v url.Values) PrepareDecorator {
 	return func(p Preparer) Preparer {
 		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
@@ -296,14 +791,19 @@ func WithFormData(v url.Values) PrepareDecorator {
 				setHeader(r, http.CanonicalHeaderKey(headerContentType), mimeTypeFormPost)
 				r.ContentLength = int64(len(s))
 				r.Body = io.NopCloser(strings.NewReader(s))
+				r.GetBody = func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader(s)), nil
+				}
 			}
 			return r, err
 		})
 	}
 }
 
-// WithMultiPartFormData returns a PrepareDecoratore that "URL encodes" (e.g., bar=baz&foo=quux) form parameters
-// into the http.Request body.
+// WithMultiPartFormData returns a PrepareDecorator that encodes the passed form parameters as a
+// multipart/form-data body. Values implementing io.Reader are written as file parts named after
+// their key, all other values are written as fields using their string representation. The
+// Content-Type header (including the boundary) and the Content-Length are set accordingly.
 func WithMultiPartFormData(formDataParameters map[string]interface{}) PrepareDecorator {
 	return func(p Preparer) Preparer {
 		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
@@ -312,12 +812,12 @@ func WithMultiPartFormData(formDataParameters map[string]interface{}) PrepareDec
 				var body bytes.Buffer
 				writer := multipart.NewWriter(&body)
 				for key, value := range formDataParameters {
-					if rc, ok := value.(io.ReadCloser); ok {
+					if rd, ok := value.(io.Reader); ok {
 						var fd io.Writer
 						if fd, err = writer.CreateFormFile(key, key); err != nil {
 							return r, err
 						}
-						if _, err = io.Copy(fd, rc); err != nil {
+						if _, err = io.Copy(fd, rd); err != nil {
 							return r, err
 						}
 					} else {
@@ -330,8 +830,12 @@ func WithMultiPartFormData(formDataParameters map[string]interface{}) PrepareDec
 					return r, err
 				}
 				setHeader(r, http.CanonicalHeaderKey(headerContentType), writer.FormDataContentType())
-				r.Body = io.NopCloser(bytes.NewReader(body.Bytes()))
-				r.ContentLength = int64(body.Len())
+				b := body.Bytes()
+				r.Body = io.NopCloser(bytes.NewReader(b))
+				r.GetBody = func() (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(b)), nil
+				}
+				r.ContentLength = int64(len(b))
 				return r, err
 			}
 			return r, err
@@ -350,6 +854,9 @@ func WithFile(f io.ReadCloser) PrepareDecorator {
 					return r, err
 				}
 				r.Body = io.NopCloser(bytes.NewReader(b))
+				r.GetBody = func() (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(b)), nil
+				}
 				r.ContentLength = int64(len(b))
 			}
 			return r, err
@@ -357,6 +864,80 @@ func WithFile(f io.ReadCloser) PrepareDecorator {
 	}
 }
 
+// WithGzipCompression returns a PrepareDecorator that compresses the http.Request body using gzip
+// and sets the Content-Encoding header to "gzip". The compressed body is buffered so that the
+// Content-Length is accurate and the body can be replayed when the request is retried. Since it
+// operates on the body set by earlier decorators, it must follow them in the chain. Requests
+// without a body are left unmodified.
+func WithGzipCompression() PrepareDecorator {
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err == nil && r.Body != nil && r.Body != http.NoBody {
+				var b bytes.Buffer
+				zw := gzip.NewWriter(&b)
+				if _, err = io.Copy(zw, r.Body); err != nil {
+					return r, err
+				}
+				if err = r.Body.Close(); err != nil {
+					return r, err
+				}
+				if err = zw.Close(); err != nil {
+					return r, err
+				}
+				compressed := b.Bytes()
+				setHeader(r, headerContentEncoding, "gzip")
+				if r.Header.Get(headerContentLength) != "" {
+					r.Header.Set(headerContentLength, fmt.Sprintf("%d", len(compressed)))
+				}
+				r.ContentLength = int64(len(compressed))
+				r.Body = io.NopCloser(bytes.NewReader(compressed))
+				r.GetBody = func() (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(compressed)), nil
+				}
+			}
+			return r, err
+		})
+	}
+}
+
+// WithMaxBodySize returns a PrepareDecorator that fails if the http.Request body is larger than n
+// bytes. When the Content-Length is unknown at most n+1 bytes of the body are read to determine
+// its size; bodies within the limit are then buffered and their Content-Length set. Since it
+// operates on the body set by earlier decorators, it must follow them in the chain.
+func WithMaxBodySize(n int64) PrepareDecorator {
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err != nil || r.Body == nil || r.Body == http.NoBody {
+				return r, err
+			}
+			if r.ContentLength > 0 {
+				if r.ContentLength > n {
+					return r, NewError("autorest", "WithMaxBodySize", "request body of %d bytes exceeds the limit of %d bytes", r.ContentLength, n)
+				}
+				return r, nil
+			}
+			b, err := io.ReadAll(io.LimitReader(r.Body, n+1))
+			if err != nil {
+				return r, err
+			}
+			if int64(len(b)) > n {
+				return r, NewError("autorest", "WithMaxBodySize", "request body exceeds the limit of %d bytes", n)
+			}
+			if err = r.Body.Close(); err != nil {
+				return r, err
+			}
+			r.ContentLength = int64(len(b))
+			r.Body = io.NopCloser(bytes.NewReader(b))
+			r.GetBody = func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(b)), nil
+			}
+			return r, nil
+		})
+	}
+}
+
 // WithBool returns a PrepareDecorator that encodes the passed bool into the body of the request
 // and sets the Content-Length header.
 func WithBool(v bool) PrepareDecorator {
@@ -396,6 +977,9 @@ func WithString(v string) PrepareDecorator {
 			if err == nil {
 				r.ContentLength = int64(len(v))
 				r.Body = io.NopCloser(strings.NewReader(v))
+				r.GetBody = func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader(v)), nil
+				}
 			}
 			return r, err
 		})
@@ -413,6 +997,9 @@ func WithJSON(v interface{}) PrepareDecorator {
 				if err == nil {
 					r.ContentLength = int64(len(b))
 					r.Body = io.NopCloser(bytes.NewReader(b))
+					r.GetBody = func() (io.ReadCloser, error) {
+						return io.NopCloser(bytes.NewReader(b)), nil
+					}
 				}
 			}
 			return r, err
@@ -436,6 +1023,146 @@ func WithXML(v interface{}) PrepareDecorator {
 					r.ContentLength = int64(len(bytesWithHeader))
 					setHeader(r, headerContentLength, fmt.Sprintf("%d", len(bytesWithHeader)))
 					r.Body = io.NopCloser(bytes.NewReader(bytesWithHeader))
+					r.GetBody = func() (io.ReadCloser, error) {
+						return io.NopCloser(bytes.NewReader(bytesWithHeader)), nil
+					}
+				}
+			}
+			return r, err
+		})
+	}
+}
+
+// ProtobufMarshaler is the interface implemented by Protocol Buffers messages that can encode
+// themselves into the wire format, such as messages generated by gogo/protobuf. Messages from
+// google.golang.org/protobuf can be adapted with ProtobufMarshalerFunc, e.g.
+//
+//	autorest.ProtobufMarshalerFunc(func() ([]byte, error) { return proto.Marshal(m) })
+type ProtobufMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+// ProtobufMarshalerFunc is a method that implements the ProtobufMarshaler interface.
+type ProtobufMarshalerFunc func() ([]byte, error)
+
+// Marshal implements the ProtobufMarshaler interface on ProtobufMarshalerFunc.
+func (pmf ProtobufMarshalerFunc) Marshal() ([]byte, error) {
+	return pmf()
+}
+
+// WithProtobuf returns a PrepareDecorator that encodes the passed Protocol Buffers message into the
+// body of the request, sets the Content-Length and sets the Content-Type header to
+// "application/x-protobuf".
+func WithProtobuf(m ProtobufMarshaler) PrepareDecorator {
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err == nil {
+				if m == nil {
+					return r, NewError("autorest", "WithProtobuf", "Invoked with a nil message")
+				}
+				b, err := m.Marshal()
+				if err != nil {
+					return r, err
+				}
+				setHeader(r, http.CanonicalHeaderKey(headerContentType), mimeTypeProtobuf)
+				r.ContentLength = int64(len(b))
+				r.Body = io.NopCloser(bytes.NewReader(b))
+				r.GetBody = func() (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(b)), nil
+				}
+			}
+			return r, err
+		})
+	}
+}
+
+// BodyEncoder is the interface that wraps the Encode method.
+//
+// Encode returns the wire representation of the passed value for use as a request body.
+type BodyEncoder interface {
+	Encode(v interface{}) ([]byte, error)
+}
+
+// BodyEncoderFunc is a method that implements the BodyEncoder interface.
+type BodyEncoderFunc func(v interface{}) ([]byte, error)
+
+// Encode implements the BodyEncoder interface on BodyEncoderFunc.
+func (bef BodyEncoderFunc) Encode(v interface{}) ([]byte, error) {
+	return bef(v)
+}
+
+var bodyEncoders = struct {
+	sync.RWMutex
+	m map[string]BodyEncoder
+}{
+	m: map[string]BodyEncoder{
+		mimeTypeJSON: BodyEncoderFunc(json.Marshal),
+		mimeTypeXML:  BodyEncoderFunc(encodeXML),
+		"text/xml":   BodyEncoderFunc(encodeXML),
+		mimeTypeProtobuf: BodyEncoderFunc(func(v interface{}) ([]byte, error) {
+			m, ok := v.(ProtobufMarshaler)
+			if !ok {
+				return nil, fmt.Errorf("autorest: %T does not implement ProtobufMarshaler", v)
+			}
+			return m.Marshal()
+		}),
+	},
+}
+
+func encodeXML(v interface{}) ([]byte, error) {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+// RegisterBodyEncoder registers the BodyEncoder used by WithEncodedBody for the passed media type
+// (e.g. "application/yaml"), replacing any encoder already registered for it. Media type
+// parameters are ignored and matching is not case sensitive. Registering a nil BodyEncoder removes
+// the media type. Encoders for JSON, XML and protobuf are registered by default.
+func RegisterBodyEncoder(contentType string, e BodyEncoder) {
+	mediaType := bodyMediaType(contentType)
+	bodyEncoders.Lock()
+	defer bodyEncoders.Unlock()
+	if e == nil {
+		delete(bodyEncoders.m, mediaType)
+		return
+	}
+	bodyEncoders.m[mediaType] = e
+}
+
+func bodyMediaType(contentType string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// WithEncodedBody returns a PrepareDecorator that encodes the passed value into the request body
+// using the BodyEncoder registered for the media type of the passed content type, which is also
+// used as the Content-Type header. It returns an error if no BodyEncoder is registered.
+func WithEncodedBody(v interface{}, contentType string) PrepareDecorator {
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err == nil {
+				bodyEncoders.RLock()
+				e, ok := bodyEncoders.m[bodyMediaType(contentType)]
+				bodyEncoders.RUnlock()
+				if !ok {
+					return r, NewError("autorest", "WithEncodedBody", "No BodyEncoder registered for content type %q", contentType)
+				}
+				b, err := e.Encode(v)
+				if err != nil {
+					return r, err
+				}
+				setHeader(r, http.CanonicalHeaderKey(headerContentType), contentType)
+				r.ContentLength = int64(len(b))
+				r.Body = io.NopCloser(bytes.NewReader(b))
+				r.GetBody = func() (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(b)), nil
 				}
 			}
 			return r, err
@@ -443,6 +1170,98 @@ func WithXML(v interface{}) PrepareDecorator {
 	}
 }
 
+// WithCSV returns a PrepareDecorator that encodes the passed records as CSV (RFC 4180) into the
+// request body and sets the Content-Type header to "text/csv".
+func WithCSV(records [][]string) PrepareDecorator {
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err == nil {
+				var buf bytes.Buffer
+				if err = csv.NewWriter(&buf).WriteAll(records); err == nil {
+					b := buf.Bytes()
+					setHeader(r, http.CanonicalHeaderKey(headerContentType), mimeTypeCSV)
+					r.ContentLength = int64(len(b))
+					r.Body = io.NopCloser(bytes.NewReader(b))
+					r.GetBody = func() (io.ReadCloser, error) {
+						return io.NopCloser(bytes.NewReader(b)), nil
+					}
+				}
+			}
+			return r, err
+		})
+	}
+}
+
+// WithJSONStream returns a PrepareDecorator that streams the values received from the passed
+// channel as newline-delimited JSON (NDJSON) using chunked transfer encoding. Each value is encoded
+// as it arrives and the body ends once the channel is closed, so the producer must close it. If a
+// value cannot be encoded, or the request body is closed early, the request body fails with the
+// error and the remaining values are drained so the producer does not block.
+//
+// The body can only be read once; streamed requests cannot be retried.
+func WithJSONStream(items <-chan interface{}) PrepareDecorator {
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err == nil {
+				if items == nil {
+					return r, NewError("autorest", "WithJSONStream", "Invoked with a nil channel")
+				}
+				pr, pw := io.Pipe()
+				go func() {
+					enc := json.NewEncoder(pw)
+					for item := range items {
+						if err := enc.Encode(item); err != nil {
+							pw.CloseWithError(err)
+							for range items {
+							}
+							return
+						}
+					}
+					pw.Close()
+				}()
+				setHeader(r, http.CanonicalHeaderKey(headerContentType), mimeTypeNDJSON)
+				r.ContentLength = -1
+				r.TransferEncoding = []string{"chunked"}
+				r.Body = pr
+				r.GetBody = nil
+			}
+			return r, err
+		})
+	}
+}
+
+// used as a key type in context.WithValue()
+type ctxHostOverride struct{}
+
+// WithHostOverride returns a PrepareDecorator that connects to the passed host (optionally with a
+// port) instead of the host named in the request URL. The URL is left unchanged so the Host header
+// and TLS server name, and with it certificate validation, still refer to the original host. This
+// is useful to reach private endpoints by IP address when DNS cannot resolve them.
+//
+// The override is honored by SendWithSender, and so by Client, when the Sender is an http.Client
+// with an http.Transport, such as the default sender and those created with NewSender. Requests
+// with an override are sent through a transport dedicated to the override host, so connections to
+// it are never reused by other requests, and bypass any proxy. The dedicated transports belong to
+// the returned PrepareDecorator: reuse it for requests to the same host so that they share
+// connections.
+func WithHostOverride(host string) PrepareDecorator {
+	ho := &hostOverride{host: host}
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err == nil {
+				if host == "" {
+					return r, NewError("autorest", "WithHostOverride", "Invoked with an empty host")
+				}
+				r = r.WithContext(context.WithValue(r.Context(), ctxHostOverride{}, ho))
+			}
+			return r, err
+		})
+	}
+}
+
 // WithPath returns a PrepareDecorator that adds the supplied path to the request URL. If the path
 // is absolute (that is, it begins with a "/"), it replaces the existing path.
 func WithPath(path string) PrepareDecorator {
@@ -474,10 +1293,7 @@ func WithEscapedPathParameters(path string, pathParameters map[string]interface{
 				if r.URL == nil {
 					return r, NewError("autorest", "WithEscapedPathParameters", "Invoked with a nil URL")
 				}
-				for key, value := range parameters {
-					path = strings.Replace(path, "{"+key+"}", value, -1)
-				}
-				if r.URL, err = parseURL(r.URL, path); err != nil {
+				if r.URL, err = parseURL(r.URL, expandPathParameters(path, parameters)); err != nil {
 					return r, err
 				}
 			}
@@ -497,11 +1313,7 @@ func WithPathParameters(path string, pathParameters map[string]interface{}) Prep
 				if r.URL == nil {
 					return r, NewError("autorest", "WithPathParameters", "Invoked with a nil URL")
 				}
-				for key, value := range parameters {
-					path = strings.Replace(path, "{"+key+"}", value, -1)
-				}
-
-				if r.URL, err = parseURL(r.URL, path); err != nil {
+				if r.URL, err = parseURL(r.URL, expandPathParameters(path, parameters)); err != nil {
 					return r, err
 				}
 			}
@@ -510,6 +1322,15 @@ func WithPathParameters(path string, pathParameters map[string]interface{}) Prep
 	}
 }
 
+// expandPathParameters returns a copy of path with each brace-enclosed key replaced by its value.
+// The template itself is never modified so Preparers built from it can be re-used.
+func expandPathParameters(path string, parameters map[string]string) string {
+	for key, value := range parameters {
+		path = strings.Replace(path, "{"+key+"}", value, -1)
+	}
+	return path
+}
+
 func parseURL(u *url.URL, path string) (*url.URL, error) {
 	p := strings.TrimRight(u.String(), "/")
 	if !strings.HasPrefix(path, "/") {
@@ -518,8 +1339,38 @@ func parseURL(u *url.URL, path string) (*url.URL, error) {
 	return url.Parse(p + path)
 }
 
-// WithQueryParameters returns a PrepareDecorators that encodes and applies the query parameters
-// given in the supplied map (i.e., key=value).
+// WithDefaultQueryParameters returns a PrepareDecorator that adds the query parameters given in
+// the supplied map only when the request URL does not already carry them. Unlike
+// WithQueryParameters, the values are used as-is and are not expected to be escaped. This allows
+// a client-wide default, such as api-version, to be overridden by individual operations.
+func WithDefaultQueryParameters(queryParameters map[string]string) PrepareDecorator {
+	return func(p Preparer) Preparer {
+		return PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err == nil {
+				if r.URL == nil {
+					return r, NewError("autorest", "WithDefaultQueryParameters", "Invoked with a nil URL")
+				}
+				v := r.URL.Query()
+				added := false
+				for key, value := range queryParameters {
+					if _, ok := v[key]; !ok {
+						v.Set(key, value)
+						added = true
+					}
+				}
+				if added {
+					r.URL.RawQuery = v.Encode()
+				}
+			}
+			return r, err
+		})
+	}
+}
+
+// WithQueryParameters returns a PrepareDecorator that encodes and applies the query parameters
+// given in the supplied map (i.e., key=value). The parameters are merged into any query string
+// already present on the request URL, replacing existing values for the same keys.
 func WithQueryParameters(queryParameters map[string]interface{}) PrepareDecorator {
 	parameters := MapToValues(queryParameters)
 	return func(p Preparer) Preparer {
@@ -531,14 +1382,17 @@ func WithQueryParameters(queryParameters map[string]interface{}) PrepareDecorato
 				}
 				v := r.URL.Query()
 				for key, value := range parameters {
+					// unescape into a new slice so the captured parameters are left untouched
+					// and the Preparer can safely be re-used
+					unescaped := make([]string, len(value))
 					for i := range value {
 						d, err := url.QueryUnescape(value[i])
 						if err != nil {
 							return r, err
 						}
-						value[i] = d
+						unescaped[i] = d
 					}
-					v[key] = value
+					v[key] = unescaped
 				}
 				r.URL.RawQuery = v.Encode()
 			}
@@ -15,15 +15,23 @@ package autorest
 //  limitations under the License.
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/xml"
 	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/url"
+	"os"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Azure/go-autorest/autorest/mocks"
 )
@@ -132,6 +140,28 @@ func ExampleCreatePreparer_chain() {
 	// Output: https://microsoft.com/a/b/c/
 }
 
+// Derive per-operation Preparers from a shared base Preparer
+func ExampleDecoratePreparer() {
+	base := CreatePreparer(
+		WithBaseURL("https://microsoft.com/"),
+		WithHeader("x-ms-version", "2020-01-01"))
+
+	get := DecoratePreparer(base, AsGet(), WithPath("a"))
+	del := DecoratePreparer(base, AsDelete(), WithPath("b"))
+
+	for _, p := range []Preparer{get, del} {
+		r, err := p.Prepare(&http.Request{})
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+		} else {
+			fmt.Println(r.Method, r.URL)
+		}
+	}
+	// Output:
+	// GET https://microsoft.com/a
+	// DELETE https://microsoft.com/b
+}
+
 // Create and prepare an http.Request in one call
 func ExamplePrepare() {
 	r, err := Prepare(&http.Request{},
@@ -189,6 +219,105 @@ func TestWithBytes(t *testing.T) {
 	}
 }
 
+func TestWithBytesSetsGetBody(t *testing.T) {
+	input := []byte("Hello Gopher")
+
+	r, err := Prepare(&http.Request{},
+		WithBytes(&input))
+	if err != nil {
+		t.Fatalf("autorest: WithBytes failed with error (%v)", err)
+	}
+	if r.GetBody == nil {
+		t.Fatal("autorest: WithBytes failed to set GetBody")
+	}
+	rc, err := r.GetBody()
+	if err != nil {
+		t.Fatalf("autorest: GetBody failed with error (%v)", err)
+	}
+	b, _ := io.ReadAll(rc)
+	if string(b) != string(input) {
+		t.Fatalf("autorest: GetBody returned %q, expected %q", b, input)
+	}
+}
+
+func TestWithBodyBytesReader(t *testing.T) {
+	br := bytes.NewReader([]byte("xxHello Gopher"))
+	br.Seek(2, io.SeekStart)
+
+	r, err := Prepare(&http.Request{},
+		WithBody(br))
+	if err != nil {
+		t.Fatalf("autorest: WithBody failed with error (%v)", err)
+	}
+	if r.ContentLength != int64(len("Hello Gopher")) {
+		t.Fatalf("autorest: WithBody set Content-Length to %v, expected %v", r.ContentLength, len("Hello Gopher"))
+	}
+	for i := 0; i < 2; i++ {
+		b, _ := io.ReadAll(r.Body)
+		if string(b) != "Hello Gopher" {
+			t.Fatalf("autorest: WithBody body was %q on read %d, expected %q", b, i+1, "Hello Gopher")
+		}
+		if r.Body, err = r.GetBody(); err != nil {
+			t.Fatalf("autorest: GetBody failed with error (%v)", err)
+		}
+	}
+}
+
+func TestWithBodyFile(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "body")
+	if err != nil {
+		t.Fatalf("autorest: failed to create temp file (%v)", err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString("Hello Gopher"); err != nil {
+		t.Fatalf("autorest: failed to write temp file (%v)", err)
+	}
+	f.Seek(0, io.SeekStart)
+
+	r, err := Prepare(&http.Request{},
+		WithBody(f))
+	if err != nil {
+		t.Fatalf("autorest: WithBody failed with error (%v)", err)
+	}
+	if r.ContentLength != int64(len("Hello Gopher")) {
+		t.Fatalf("autorest: WithBody set Content-Length to %v, expected %v", r.ContentLength, len("Hello Gopher"))
+	}
+	if r.GetBody == nil {
+		t.Fatal("autorest: WithBody failed to set GetBody for a file")
+	}
+	r.Body.Close()
+	rc, err := r.GetBody()
+	if err != nil {
+		t.Fatalf("autorest: GetBody failed with error (%v)", err)
+	}
+	b, _ := io.ReadAll(rc)
+	if string(b) != "Hello Gopher" {
+		t.Fatalf("autorest: GetBody returned %q, expected %q", b, "Hello Gopher")
+	}
+}
+
+func TestWithBodyStreamsUnknownReaders(t *testing.T) {
+	r, err := Prepare(&http.Request{},
+		WithBody(strings.NewReader("Hello Gopher")))
+	if err != nil {
+		t.Fatalf("autorest: WithBody failed with error (%v)", err)
+	}
+	if r.ContentLength != 0 || r.GetBody != nil {
+		t.Fatal("autorest: WithBody unexpectedly set Content-Length or GetBody for a streaming reader")
+	}
+	b, _ := io.ReadAll(r.Body)
+	if string(b) != "Hello Gopher" {
+		t.Fatalf("autorest: WithBody body was %q, expected %q", b, "Hello Gopher")
+	}
+}
+
+func TestWithBodyCatchesNilReader(t *testing.T) {
+	_, err := Prepare(&http.Request{}, WithBody(nil))
+	if err == nil {
+		t.Fatal("autorest: WithBody failed to catch a nil io.Reader")
+	}
+}
+
 func ExampleWithCustomBaseURL() {
 	r, err := Prepare(&http.Request{},
 		WithCustomBaseURL("https://{account}.{service}.core.windows.net/",
@@ -351,6 +480,31 @@ func TestWithCustomBaseURL(t *testing.T) {
 	}
 }
 
+func TestWithCustomBaseURLRejectsInvalidHostParameters(t *testing.T) {
+	for _, account := range []string{"evil.com/", "evil.com?", "user@evil.com", "evil.com:8080", "evil com"} {
+		_, err := Prepare(&http.Request{}, WithCustomBaseURL("https://{account}.vault.azure.net/",
+			map[string]interface{}{
+				"account": account,
+			}))
+		if err == nil {
+			t.Fatalf("autorest: WithCustomBaseURL accepted host parameter %q", account)
+		}
+	}
+}
+
+func TestWithCustomBaseURLAllowsFullURLParameters(t *testing.T) {
+	r, err := Prepare(&http.Request{}, WithCustomBaseURL("{vaultBaseUrl}",
+		map[string]interface{}{
+			"vaultBaseUrl": "https://myvault.vault.azure.net",
+		}))
+	if err != nil {
+		t.Fatalf("autorest: WithCustomBaseURL failed (%v)", err)
+	}
+	if r.URL.String() != "https://myvault.vault.azure.net" {
+		t.Fatalf("autorest: WithCustomBaseURL expected https://myvault.vault.azure.net, got %s", r.URL)
+	}
+}
+
 func TestWithCustomBaseURLwithInvalidURL(t *testing.T) {
 	_, err := Prepare(&http.Request{}, WithCustomBaseURL("hello/{account}.{service}.core.windows.net/",
 		map[string]interface{}{
@@ -458,6 +612,51 @@ func TestWithNothing(t *testing.T) {
 	}
 }
 
+func TestWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := Prepare(mocks.NewRequest(), WithContext(ctx))
+	if err != nil {
+		t.Fatalf("autorest: WithContext returned an unexpected error (%v)", err)
+	}
+	cancel()
+	if r.Context().Err() != context.Canceled {
+		t.Fatal("autorest: WithContext failed to bind the context to the request")
+	}
+}
+
+func TestWithContextNilContext(t *testing.T) {
+	if _, err := Prepare(mocks.NewRequest(), WithContext(nil)); err == nil {
+		t.Fatal("autorest: WithContext failed to return an error for a nil context")
+	}
+}
+
+func TestWithRegisteredHooks(t *testing.T) {
+	p := CreatePreparer(WithRegisteredHooks())
+
+	RegisterPrepareHook("first", WithHeader("x-order", "first"))
+	RegisterPrepareHook("second", WithAppendedHeaders(map[string]interface{}{"x-order": "second"}))
+	defer RegisterPrepareHook("first", nil)
+	defer RegisterPrepareHook("second", nil)
+
+	r, err := p.Prepare(mocks.NewRequest())
+	if err != nil {
+		t.Fatalf("autorest: WithRegisteredHooks returned an unexpected error (%v)", err)
+	}
+	if v := r.Header["X-Order"]; !reflect.DeepEqual(v, []string{"first", "second"}) {
+		t.Fatalf("autorest: WithRegisteredHooks applied hooks as %v, expected [first second]", v)
+	}
+
+	RegisterPrepareHook("first", WithHeader("x-order", "replaced"))
+	RegisterPrepareHook("second", nil)
+	r, err = p.Prepare(mocks.NewRequest())
+	if err != nil {
+		t.Fatalf("autorest: WithRegisteredHooks returned an unexpected error (%v)", err)
+	}
+	if v := r.Header["X-Order"]; !reflect.DeepEqual(v, []string{"replaced"}) {
+		t.Fatalf("autorest: WithRegisteredHooks applied hooks as %v, expected [replaced]", v)
+	}
+}
+
 func TestWithBearerAuthorization(t *testing.T) {
 	r, err := Prepare(mocks.NewRequest(), WithBearerAuthorization("SOME-TOKEN"))
 	if err != nil {
@@ -468,6 +667,94 @@ func TestWithBearerAuthorization(t *testing.T) {
 	}
 }
 
+func TestWithIfMatch(t *testing.T) {
+	r, err := Prepare(mocks.NewRequest(), WithIfMatch(`"0x8D1"`))
+	if err != nil {
+		t.Fatalf("autorest: WithIfMatch failed (%v)", err)
+	}
+	if r.Header.Get(headerIfMatch) != `"0x8D1"` {
+		t.Fatalf("autorest: WithIfMatch failed to add header (%s=%s)", headerIfMatch, r.Header.Get(headerIfMatch))
+	}
+}
+
+func TestWithIfNoneMatch(t *testing.T) {
+	r, err := Prepare(mocks.NewRequest(), WithIfNoneMatch("*"))
+	if err != nil {
+		t.Fatalf("autorest: WithIfNoneMatch failed (%v)", err)
+	}
+	if r.Header.Get(headerIfNoneMatch) != "*" {
+		t.Fatalf("autorest: WithIfNoneMatch failed to add header (%s=%s)", headerIfNoneMatch, r.Header.Get(headerIfNoneMatch))
+	}
+}
+
+func TestWithIfModifiedSince(t *testing.T) {
+	since := time.Date(2015, time.October, 21, 7, 28, 0, 0, time.FixedZone("PDT", -7*60*60))
+	r, err := Prepare(mocks.NewRequest(), WithIfModifiedSince(since))
+	if err != nil {
+		t.Fatalf("autorest: WithIfModifiedSince failed (%v)", err)
+	}
+	if expected := "Wed, 21 Oct 2015 14:28:00 GMT"; r.Header.Get(headerIfModifiedSince) != expected {
+		t.Fatalf("autorest: WithIfModifiedSince set %s=%s, expected %s", headerIfModifiedSince, r.Header.Get(headerIfModifiedSince), expected)
+	}
+}
+
+func TestWithIdempotencyKey(t *testing.T) {
+	p := CreatePreparer(WithIdempotencyKey())
+	r, err := p.Prepare(mocks.NewRequest())
+	if err != nil {
+		t.Fatalf("autorest: WithIdempotencyKey failed (%v)", err)
+	}
+	key := r.Header.Get(headerIdempotencyKey)
+	if matched, _ := regexp.MatchString("^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$", key); !matched {
+		t.Fatalf("autorest: WithIdempotencyKey set an invalid key %q", key)
+	}
+
+	if r, err = p.Prepare(r); err != nil {
+		t.Fatalf("autorest: WithIdempotencyKey failed (%v)", err)
+	}
+	if r.Header.Get(headerIdempotencyKey) != key {
+		t.Fatalf("autorest: WithIdempotencyKey regenerated the key for the same request, got %q expected %q", r.Header.Get(headerIdempotencyKey), key)
+	}
+
+	other, err := p.Prepare(mocks.NewRequest())
+	if err != nil {
+		t.Fatalf("autorest: WithIdempotencyKey failed (%v)", err)
+	}
+	if other.Header.Get(headerIdempotencyKey) == key {
+		t.Fatal("autorest: WithIdempotencyKey reused the key for a different request")
+	}
+}
+
+func TestWithRange(t *testing.T) {
+	r, err := Prepare(mocks.NewRequest(), WithRange(0, 1023))
+	if err != nil {
+		t.Fatalf("autorest: WithRange failed (%v)", err)
+	}
+	if r.Header.Get(headerRange) != "bytes=0-1023" {
+		t.Fatalf("autorest: WithRange set %s=%s, expected bytes=0-1023", headerRange, r.Header.Get(headerRange))
+	}
+}
+
+func TestWithRangeFrom(t *testing.T) {
+	r, err := Prepare(mocks.NewRequest(), WithRangeFrom(1024))
+	if err != nil {
+		t.Fatalf("autorest: WithRangeFrom failed (%v)", err)
+	}
+	if r.Header.Get(headerRange) != "bytes=1024-" {
+		t.Fatalf("autorest: WithRangeFrom set %s=%s, expected bytes=1024-", headerRange, r.Header.Get(headerRange))
+	}
+}
+
+func TestWithAcceptLanguage(t *testing.T) {
+	r, err := Prepare(mocks.NewRequest(), WithAcceptLanguage("fr-FR"))
+	if err != nil {
+		t.Fatalf("autorest: WithAcceptLanguage failed (%v)", err)
+	}
+	if r.Header.Get(headerAcceptLanguage) != "fr-FR" {
+		t.Fatalf("autorest: WithAcceptLanguage set %s=%s, expected fr-FR", headerAcceptLanguage, r.Header.Get(headerAcceptLanguage))
+	}
+}
+
 func TestWithUserAgent(t *testing.T) {
 	ua := "User Agent Go"
 	r, err := Prepare(mocks.NewRequest(), WithUserAgent(ua))
@@ -479,6 +766,57 @@ func TestWithUserAgent(t *testing.T) {
 	}
 }
 
+func TestWithUserAgentReplaces(t *testing.T) {
+	r, err := Prepare(mocks.NewRequest(),
+		WithUserAgent("sdk/1.0"),
+		WithUserAgent("myapp"))
+	if err != nil {
+		t.Fatalf("autorest: WithUserAgent failed (%v)", err)
+	}
+	if r.UserAgent() != "myapp" {
+		t.Fatalf("autorest: WithUserAgent produced %q, expected %q", r.UserAgent(), "myapp")
+	}
+}
+
+func TestWithAppendedUserAgent(t *testing.T) {
+	r, err := Prepare(mocks.NewRequest(),
+		WithUserAgent("Go-http-client/1.1"),
+		WithAppendedUserAgent("sdk/1.0"),
+		WithAppendedUserAgent("myapp"))
+	if err != nil {
+		t.Fatalf("autorest: WithAppendedUserAgent failed (%v)", err)
+	}
+	if expected := "Go-http-client/1.1 sdk/1.0 myapp"; r.UserAgent() != expected {
+		t.Fatalf("autorest: WithAppendedUserAgent produced %q, expected %q", r.UserAgent(), expected)
+	}
+}
+
+func TestWithAppendedUserAgentDoesNotRepeat(t *testing.T) {
+	p := CreatePreparer(WithAppendedUserAgent("myapp"))
+	r, err := p.Prepare(mocks.NewRequest())
+	if err == nil {
+		r, err = p.Prepare(r)
+	}
+	if err != nil {
+		t.Fatalf("autorest: WithAppendedUserAgent failed (%v)", err)
+	}
+	if r.UserAgent() != "myapp" {
+		t.Fatalf("autorest: WithAppendedUserAgent produced %q, expected %q", r.UserAgent(), "myapp")
+	}
+}
+
+func TestWithAppendedUserAgentMatchesWholeTokens(t *testing.T) {
+	r, err := Prepare(mocks.NewRequest(),
+		WithUserAgent("foo-myapp"),
+		WithAppendedUserAgent("myapp"))
+	if err != nil {
+		t.Fatalf("autorest: WithAppendedUserAgent failed (%v)", err)
+	}
+	if expected := "foo-myapp myapp"; r.UserAgent() != expected {
+		t.Fatalf("autorest: WithAppendedUserAgent produced %q, expected %q", r.UserAgent(), expected)
+	}
+}
+
 func TestWithMethod(t *testing.T) {
 	r, _ := Prepare(mocks.NewRequest(), WithMethod("HEAD"))
 	if r.Method != "HEAD" {
@@ -623,6 +961,50 @@ func TestWithMultiPartFormDataWithNoFile(t *testing.T) {
 	}
 }
 
+func TestWithMultiPartFormDataWithReader(t *testing.T) {
+	v := map[string]interface{}{
+		"file": strings.NewReader("Hello Gopher"),
+		"age":  "42",
+	}
+
+	r, err := Prepare(&http.Request{},
+		WithMultiPartFormData(v))
+	if err != nil {
+		t.Fatalf("autorest: WithMultiPartFormData failed with error (%v)", err)
+	}
+
+	mt, params, err := mime.ParseMediaType(r.Header.Get(headerContentType))
+	if err != nil {
+		t.Fatalf("autorest: WithMultiPartFormData set an invalid Content-Type (%v)", err)
+	}
+	if mt != "multipart/form-data" {
+		t.Fatalf("autorest: WithMultiPartFormData set Content-Type to %v, expected multipart/form-data", mt)
+	}
+
+	form, err := multipart.NewReader(r.Body, params["boundary"]).ReadForm(1024)
+	if err != nil {
+		t.Fatalf("autorest: WithMultiPartFormData failed to produce a valid multipart body (%v)", err)
+	}
+	if form.Value["age"][0] != "42" {
+		t.Fatalf("autorest: WithMultiPartFormData wrote field age as %v, expected 42", form.Value["age"])
+	}
+	if len(form.File["file"]) != 1 {
+		t.Fatal("autorest: WithMultiPartFormData failed to write the io.Reader as a file part")
+	}
+	f, err := form.File["file"][0].Open()
+	if err != nil {
+		t.Fatalf("autorest: WithMultiPartFormData failed with error (%v)", err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("autorest: WithMultiPartFormData failed with error (%v)", err)
+	}
+	if string(b) != "Hello Gopher" {
+		t.Fatalf("autorest: WithMultiPartFormData wrote file part %q, expected %q", string(b), "Hello Gopher")
+	}
+}
+
 func TestWithFile(t *testing.T) {
 	r, err := Prepare(&http.Request{},
 		WithFile(io.NopCloser(strings.NewReader("Hello Gopher"))))
@@ -639,6 +1021,78 @@ func TestWithFile(t *testing.T) {
 	}
 }
 
+func TestWithGzipCompression(t *testing.T) {
+	r, err := Prepare(&http.Request{},
+		WithXML(&mocks.T{Name: "Rob Pike", Age: 42}),
+		WithGzipCompression())
+	if err != nil {
+		t.Fatalf("autorest: WithGzipCompression failed with error (%v)", err)
+	}
+	if r.Header.Get(headerContentEncoding) != "gzip" {
+		t.Fatalf("autorest: WithGzipCompression set Content-Encoding to %q, expected gzip", r.Header.Get(headerContentEncoding))
+	}
+	if r.Header.Get(headerContentLength) != strconv.FormatInt(r.ContentLength, 10) {
+		t.Fatalf("autorest: WithGzipCompression left a stale Content-Length header %v, expected %v", r.Header.Get(headerContentLength), r.ContentLength)
+	}
+
+	for i := 0; i < 2; i++ {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("autorest: WithGzipCompression failed with error (%v)", err)
+		}
+		if r.ContentLength != int64(len(b)) {
+			t.Fatalf("autorest: WithGzipCompression set Content-Length to %v, expected %v", r.ContentLength, len(b))
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("autorest: WithGzipCompression produced an invalid gzip body (%v)", err)
+		}
+		v := &mocks.T{}
+		if err = xml.NewDecoder(zr).Decode(v); err != nil || v.Name != "Rob Pike" || v.Age != 42 {
+			t.Fatalf("autorest: WithGzipCompression produced an unexpected body %v (%v)", v, err)
+		}
+		if r.Body, err = r.GetBody(); err != nil {
+			t.Fatalf("autorest: GetBody failed with error (%v)", err)
+		}
+	}
+}
+
+func TestWithGzipCompressionWithoutBody(t *testing.T) {
+	r, err := Prepare(&http.Request{}, WithGzipCompression())
+	if err != nil {
+		t.Fatalf("autorest: WithGzipCompression failed with error (%v)", err)
+	}
+	if r.Body != nil || r.Header.Get(headerContentEncoding) != "" {
+		t.Fatal("autorest: WithGzipCompression modified a request without a body")
+	}
+}
+
+func TestWithMaxBodySize(t *testing.T) {
+	if _, err := Prepare(&http.Request{}, WithString("Hello Gopher"), WithMaxBodySize(12)); err != nil {
+		t.Fatalf("autorest: WithMaxBodySize rejected a body within the limit (%v)", err)
+	}
+	if _, err := Prepare(&http.Request{}, WithString("Hello Gopher"), WithMaxBodySize(11)); err == nil {
+		t.Fatal("autorest: WithMaxBodySize failed to reject a body exceeding the limit")
+	}
+}
+
+func TestWithMaxBodySizeUnknownLength(t *testing.T) {
+	if _, err := Prepare(&http.Request{}, WithBody(strings.NewReader("Hello Gopher")), WithMaxBodySize(11)); err == nil {
+		t.Fatal("autorest: WithMaxBodySize failed to reject a streamed body exceeding the limit")
+	}
+
+	r, err := Prepare(&http.Request{}, WithBody(strings.NewReader("Hello Gopher")), WithMaxBodySize(12))
+	if err != nil {
+		t.Fatalf("autorest: WithMaxBodySize rejected a streamed body within the limit (%v)", err)
+	}
+	if r.ContentLength != 12 {
+		t.Fatalf("autorest: WithMaxBodySize set Content-Length to %v, expected 12", r.ContentLength)
+	}
+	if b, _ := io.ReadAll(r.Body); string(b) != "Hello Gopher" {
+		t.Fatalf("autorest: WithMaxBodySize altered the body, got %q", b)
+	}
+}
+
 func TestWithBool_SetsTheBody(t *testing.T) {
 	r, err := Prepare(&http.Request{},
 		WithBool(false))
@@ -787,6 +1241,261 @@ func TestWithJSONSetsContentLength(t *testing.T) {
 	}
 }
 
+func TestPrepareIf(t *testing.T) {
+	isPost := func(r *http.Request) bool { return r.Method == http.MethodPost }
+	p := CreatePreparer(
+		PrepareIf(isPost, WithIdempotencyKey()))
+
+	r, err := p.Prepare(mocks.NewRequestWithParams(http.MethodPost, "https://microsoft.com/a/b/c/", nil))
+	if err != nil {
+		t.Fatalf("autorest: PrepareIf failed (%v)", err)
+	}
+	if r.Header.Get(headerIdempotencyKey) == "" {
+		t.Fatal("autorest: PrepareIf failed to apply the decorator when the condition was met")
+	}
+
+	r, err = p.Prepare(mocks.NewRequest())
+	if err != nil {
+		t.Fatalf("autorest: PrepareIf failed (%v)", err)
+	}
+	if r.Header.Get(headerIdempotencyKey) != "" {
+		t.Fatal("autorest: PrepareIf applied the decorator when the condition was not met")
+	}
+}
+
+func TestPrepareIfObservesEarlierDecorators(t *testing.T) {
+	hasFoo := func(r *http.Request) bool { return r.Header.Get("x-foo") != "" }
+	r, err := Prepare(mocks.NewRequest(),
+		WithHeader("x-foo", "bar"),
+		PrepareIf(hasFoo, WithHeader("x-bar", "baz")))
+	if err != nil {
+		t.Fatalf("autorest: PrepareIf failed (%v)", err)
+	}
+	if r.Header.Get("x-bar") != "baz" {
+		t.Fatal("autorest: PrepareIf failed to observe a header set by an earlier decorator")
+	}
+}
+
+func TestWithCurlDump(t *testing.T) {
+	var b bytes.Buffer
+	r, err := Prepare(mocks.NewRequestWithParams(http.MethodPut, "https://microsoft.com/a/b/c/?q=1", nil),
+		WithBearerAuthorization("secret"),
+		WithString(`{"name":"Rob's"}`),
+		AsJSON(),
+		WithCurlDump(&b, true))
+	if err != nil {
+		t.Fatalf("autorest: WithCurlDump failed (%v)", err)
+	}
+	expected := `curl -X PUT 'https://microsoft.com/a/b/c/?q=1' -H 'Authorization: REDACTED' -H 'Content-Type: application/json' --data-binary '{"name":"Rob'\''s"}'` + "\n"
+	if b.String() != expected {
+		t.Fatalf("autorest: WithCurlDump wrote\n%s\nexpected\n%s", b.String(), expected)
+	}
+	if body, _ := io.ReadAll(r.Body); string(body) != `{"name":"Rob's"}` {
+		t.Fatalf("autorest: WithCurlDump consumed the request body, got %q", body)
+	}
+}
+
+func TestWithCurlDumpWithoutRedaction(t *testing.T) {
+	var b bytes.Buffer
+	_, err := Prepare(mocks.NewRequestWithParams(http.MethodGet, "https://microsoft.com/", nil),
+		WithBearerAuthorization("secret"),
+		WithCurlDump(&b, false))
+	if err != nil {
+		t.Fatalf("autorest: WithCurlDump failed (%v)", err)
+	}
+	if !strings.Contains(b.String(), "'Authorization: Bearer secret'") {
+		t.Fatalf("autorest: WithCurlDump redacted the Authorization header, got %s", b.String())
+	}
+}
+
+func TestWithProtobuf(t *testing.T) {
+	wire := []byte{0x0a, 0x08, 'R', 'o', 'b', ' ', 'P', 'i', 'k', 'e', 0x10, 0x2a}
+	r, err := Prepare(&http.Request{},
+		WithProtobuf(ProtobufMarshalerFunc(func() ([]byte, error) { return wire, nil })))
+	if err != nil {
+		t.Fatalf("autorest: WithProtobuf failed with error (%v)", err)
+	}
+	if r.Header.Get(headerContentType) != mimeTypeProtobuf {
+		t.Fatalf("autorest: WithProtobuf set Content-Type to %q, expected %q", r.Header.Get(headerContentType), mimeTypeProtobuf)
+	}
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatalf("autorest: WithProtobuf failed with error (%v)", err)
+	}
+	if !bytes.Equal(b, wire) || r.ContentLength != int64(len(wire)) {
+		t.Fatalf("autorest: WithProtobuf set body %v with Content-Length %v, expected %v", b, r.ContentLength, wire)
+	}
+}
+
+func TestWithProtobufReturnsMarshalError(t *testing.T) {
+	_, err := Prepare(&http.Request{},
+		WithProtobuf(ProtobufMarshalerFunc(func() ([]byte, error) { return nil, fmt.Errorf("faux error") })))
+	if err == nil {
+		t.Fatal("autorest: WithProtobuf failed to return the marshalling error")
+	}
+}
+
+func TestWithEncodedBody(t *testing.T) {
+	r, err := Prepare(&http.Request{},
+		WithEncodedBody(map[string]string{"name": "Rob Pike"}, "application/json; charset=utf-8"))
+	if err != nil {
+		t.Fatalf("autorest: WithEncodedBody failed with error (%v)", err)
+	}
+	if ct := r.Header.Get(headerContentType); ct != "application/json; charset=utf-8" {
+		t.Fatalf("autorest: WithEncodedBody set Content-Type to %q", ct)
+	}
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatalf("autorest: WithEncodedBody failed with error (%v)", err)
+	}
+	if expected := `{"name":"Rob Pike"}`; string(b) != expected || r.ContentLength != int64(len(expected)) {
+		t.Fatalf("autorest: WithEncodedBody set body %q with Content-Length %v, expected %q", b, r.ContentLength, expected)
+	}
+}
+
+func TestWithEncodedBodyRegisteredEncoder(t *testing.T) {
+	RegisterBodyEncoder("Application/X-Faux", BodyEncoderFunc(func(v interface{}) ([]byte, error) {
+		return []byte(fmt.Sprintf("faux:%v", v)), nil
+	}))
+	defer RegisterBodyEncoder("application/x-faux", nil)
+
+	r, err := Prepare(&http.Request{}, WithEncodedBody(42, "application/x-faux"))
+	if err != nil {
+		t.Fatalf("autorest: WithEncodedBody failed with error (%v)", err)
+	}
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatalf("autorest: WithEncodedBody failed with error (%v)", err)
+	}
+	if string(b) != "faux:42" {
+		t.Fatalf("autorest: WithEncodedBody set body %q, expected %q", b, "faux:42")
+	}
+}
+
+func TestWithEncodedBodyUnregisteredContentType(t *testing.T) {
+	_, err := Prepare(&http.Request{}, WithEncodedBody(42, "application/x-unknown"))
+	if err == nil {
+		t.Fatal("autorest: WithEncodedBody failed to return an error for an unregistered content type")
+	}
+}
+
+func TestWithEncodedBodyProtobufRequiresMarshaler(t *testing.T) {
+	_, err := Prepare(&http.Request{}, WithEncodedBody(42, mimeTypeProtobuf))
+	if err == nil {
+		t.Fatal("autorest: WithEncodedBody failed to return an error for a non-protobuf value")
+	}
+}
+
+func TestWithCSV(t *testing.T) {
+	r, err := Prepare(&http.Request{},
+		WithCSV([][]string{{"name", "quote"}, {"Rob Pike", "Clear is better than clever, \"usually\"."}}))
+	if err != nil {
+		t.Fatalf("autorest: WithCSV failed with error (%v)", err)
+	}
+	if r.Header.Get(headerContentType) != mimeTypeCSV {
+		t.Fatalf("autorest: WithCSV set Content-Type to %q, expected %q", r.Header.Get(headerContentType), mimeTypeCSV)
+	}
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatalf("autorest: WithCSV failed with error (%v)", err)
+	}
+	expected := "name,quote\nRob Pike,\"Clear is better than clever, \"\"usually\"\".\"\n"
+	if string(b) != expected || r.ContentLength != int64(len(expected)) {
+		t.Fatalf("autorest: WithCSV set body %q with Content-Length %v, expected %q", b, r.ContentLength, expected)
+	}
+	if r.GetBody == nil {
+		t.Fatal("autorest: WithCSV failed to set GetBody")
+	}
+}
+
+func TestWithJSONStream(t *testing.T) {
+	items := make(chan interface{})
+	go func() {
+		defer close(items)
+		items <- map[string]interface{}{"name": "Rob Pike", "age": 42}
+		items <- []int{1, 2, 3}
+	}()
+	r, err := Prepare(&http.Request{}, WithJSONStream(items))
+	if err != nil {
+		t.Fatalf("autorest: WithJSONStream failed with error (%v)", err)
+	}
+	if r.Header.Get(headerContentType) != mimeTypeNDJSON {
+		t.Fatalf("autorest: WithJSONStream set Content-Type to %q, expected %q", r.Header.Get(headerContentType), mimeTypeNDJSON)
+	}
+	if r.ContentLength != -1 || len(r.TransferEncoding) != 1 || r.TransferEncoding[0] != "chunked" {
+		t.Fatalf("autorest: WithJSONStream did not use chunked transfer encoding (%v, %v)", r.ContentLength, r.TransferEncoding)
+	}
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatalf("autorest: WithJSONStream failed with error (%v)", err)
+	}
+	if expected := "{\"age\":42,\"name\":\"Rob Pike\"}\n[1,2,3]\n"; string(b) != expected {
+		t.Fatalf("autorest: WithJSONStream produced %q, expected %q", b, expected)
+	}
+}
+
+func TestWithJSONStreamReturnsEncodingError(t *testing.T) {
+	items := make(chan interface{})
+	go func() {
+		defer close(items)
+		items <- "ok"
+		items <- make(chan int)
+		items <- "drained"
+	}()
+	r, err := Prepare(&http.Request{}, WithJSONStream(items))
+	if err != nil {
+		t.Fatalf("autorest: WithJSONStream failed with error (%v)", err)
+	}
+	if _, err := io.ReadAll(r.Body); err == nil {
+		t.Fatal("autorest: WithJSONStream failed to return the encoding error")
+	}
+}
+
+func TestWithJSONStreamNilChannel(t *testing.T) {
+	if _, err := Prepare(&http.Request{}, WithJSONStream(nil)); err == nil {
+		t.Fatal("autorest: WithJSONStream failed to return an error for a nil channel")
+	}
+}
+
+func TestWithHostOverrideEmptyHost(t *testing.T) {
+	if _, err := Prepare(mocks.NewRequest(), WithHostOverride("")); err == nil {
+		t.Fatal("autorest: WithHostOverride failed to return an error for an empty host")
+	}
+}
+
+func TestWithDefaultQueryParameters(t *testing.T) {
+	defaults := WithDefaultQueryParameters(map[string]string{"api-version": "2020-01-01", "$top": "10"})
+	r, err := Prepare(mocks.NewRequestWithParams(http.MethodGet, "https://microsoft.com/a?api-version=2021-06-01", nil),
+		defaults)
+	if err != nil {
+		t.Fatalf("autorest: WithDefaultQueryParameters failed (%v)", err)
+	}
+	if expected := "https://microsoft.com/a?%24top=10&api-version=2021-06-01"; r.URL.String() != expected {
+		t.Fatalf("autorest: WithDefaultQueryParameters produced %q, expected %q", r.URL, expected)
+	}
+
+	r, err = Prepare(mocks.NewRequestWithParams(http.MethodGet, "https://microsoft.com/a", nil),
+		defaults)
+	if err != nil {
+		t.Fatalf("autorest: WithDefaultQueryParameters failed (%v)", err)
+	}
+	if expected := "https://microsoft.com/a?%24top=10&api-version=2020-01-01"; r.URL.String() != expected {
+		t.Fatalf("autorest: WithDefaultQueryParameters produced %q, expected %q", r.URL, expected)
+	}
+}
+
+func TestWithDefaultQueryParametersKeepsExistingQuery(t *testing.T) {
+	raw := "a=b%2Fc&d=e"
+	r, err := Prepare(mocks.NewRequestWithParams(http.MethodGet, "https://microsoft.com/a?"+raw, nil),
+		WithDefaultQueryParameters(map[string]string{"a": "z"}))
+	if err != nil {
+		t.Fatalf("autorest: WithDefaultQueryParameters failed (%v)", err)
+	}
+	if r.URL.RawQuery != raw {
+		t.Fatalf("autorest: WithDefaultQueryParameters rewrote query %q to %q", raw, r.URL.RawQuery)
+	}
+}
+
 func TestWithHeaderAllocatesHeaders(t *testing.T) {
 	r, err := Prepare(mocks.NewRequest(), WithHeader("x-foo", "bar"))
 	if err != nil {
@@ -797,6 +1506,57 @@ func TestWithHeaderAllocatesHeaders(t *testing.T) {
 	}
 }
 
+func TestWithHeadersReplacesExistingValues(t *testing.T) {
+	req := mocks.NewRequest()
+	req.Header.Set("X-Foo", "old")
+	r, err := Prepare(req, WithHeaders(map[string]interface{}{"x-foo": "bar", "x-count": 42}))
+	if err != nil {
+		t.Fatalf("autorest: WithHeaders failed (%v)", err)
+	}
+	if v := r.Header.Values("X-Foo"); len(v) != 1 || v[0] != "bar" {
+		t.Fatalf("autorest: WithHeaders failed to replace header X-Foo, got %v", v)
+	}
+	if r.Header.Get("X-Count") != "42" {
+		t.Fatalf("autorest: WithHeaders failed to add header X-Count, got %v", r.Header.Get("X-Count"))
+	}
+}
+
+func TestWithAppendedHeadersKeepsExistingValues(t *testing.T) {
+	req := mocks.NewRequest()
+	req.Header.Set("X-Foo", "old")
+	r, err := Prepare(req, WithAppendedHeaders(map[string]interface{}{"x-foo": []string{"bar", "baz"}}))
+	if err != nil {
+		t.Fatalf("autorest: WithAppendedHeaders failed (%v)", err)
+	}
+	if v := r.Header.Values("X-Foo"); !reflect.DeepEqual(v, []string{"old", "bar", "baz"}) {
+		t.Fatalf("autorest: WithAppendedHeaders produced %v, expected [old bar baz]", v)
+	}
+}
+
+func TestWithCookies(t *testing.T) {
+	r, err := Prepare(mocks.NewRequest(),
+		WithCookie(&http.Cookie{Name: "affinity", Value: "node1"}),
+		WithCookies([]*http.Cookie{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}}))
+	if err != nil {
+		t.Fatalf("autorest: WithCookies failed (%v)", err)
+	}
+	if c := r.Header.Get("Cookie"); c != "affinity=node1; a=1; b=2" {
+		t.Fatalf("autorest: WithCookies set Cookie header %q, expected %q", c, "affinity=node1; a=1; b=2")
+	}
+}
+
+func TestWithHTTPSOnly(t *testing.T) {
+	if _, err := Prepare(mocks.NewRequestForURL("https://microsoft.com/"), WithHTTPSOnly()); err != nil {
+		t.Fatalf("autorest: WithHTTPSOnly rejected an https URL (%v)", err)
+	}
+	if _, err := Prepare(mocks.NewRequestForURL("http://microsoft.com/"), WithHTTPSOnly()); err == nil {
+		t.Fatal("autorest: WithHTTPSOnly failed to reject an http URL")
+	}
+	if _, err := Prepare(&http.Request{}, WithHTTPSOnly()); err == nil {
+		t.Fatal("autorest: WithHTTPSOnly failed to catch a nil URL")
+	}
+}
+
 func TestWithPathCatchesNilURL(t *testing.T) {
 	_, err := Prepare(&http.Request{}, WithPath("a"))
 	if err == nil {
@@ -818,6 +1578,21 @@ func TestWithPathParametersCatchesNilURL(t *testing.T) {
 	}
 }
 
+func TestWithPathParametersPreparerIsReusable(t *testing.T) {
+	p := CreatePreparer(
+		WithBaseURL("https://microsoft.com/"),
+		WithPathParameters("/{name}", map[string]interface{}{"name": "{name}x"}))
+	for i := 0; i < 2; i++ {
+		r, err := p.Prepare(&http.Request{})
+		if err != nil {
+			t.Fatalf("autorest: WithPathParameters failed with error (%v)", err)
+		}
+		if r.URL.Path != "/{name}x" {
+			t.Fatalf("autorest: WithPathParameters produced path %q on invocation %d, expected %q", r.URL.Path, i+1, "/{name}x")
+		}
+	}
+}
+
 func TestWithQueryParametersCatchesNilURL(t *testing.T) {
 	_, err := Prepare(&http.Request{}, WithQueryParameters(map[string]interface{}{"foo": "bar"}))
 	if err == nil {
@@ -825,6 +1600,19 @@ func TestWithQueryParametersCatchesNilURL(t *testing.T) {
 	}
 }
 
+func TestWithQueryParametersPreparerIsReusable(t *testing.T) {
+	p := CreatePreparer(WithQueryParameters(map[string]interface{}{"q": "a%2520b"}))
+	for i := 0; i < 2; i++ {
+		r, err := p.Prepare(mocks.NewRequestForURL("https://bing.com/search"))
+		if err != nil {
+			t.Fatalf("autorest: WithQueryParameters failed with error (%v)", err)
+		}
+		if q := r.URL.Query().Get("q"); q != "a%20b" {
+			t.Fatalf("autorest: WithQueryParameters produced %q on invocation %d, expected %q", q, i+1, "a%20b")
+		}
+	}
+}
+
 func TestModifyingExistingRequest(t *testing.T) {
 	r, err := Prepare(mocks.NewRequestForURL("https://bing.com"), WithPath("search"), WithQueryParameters(map[string]interface{}{"q": "golang"}))
 	if err != nil {
@@ -16,12 +16,25 @@ package autorest
 
 import (
 	"bytes"
+	"crypto/md5"
+	"encoding"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"hash"
+	"hash/crc64"
 	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Responder is the interface that wraps the Respond method.
@@ -101,6 +114,138 @@ func ByCopying(b *bytes.Buffer) RespondDecorator {
 	}
 }
 
+// ErrBodyTooLarge is returned by ByLimitingBody, and by reads of the bodies it limits, when a
+// response body is larger than allowed.
+var ErrBodyTooLarge = errors.New("autorest: response body too large")
+
+// ByLimitingBody returns a RespondDecorator that limits the response Body to max bytes. It returns
+// ErrBodyTooLarge if the Content-Length exceeds max; otherwise reading more than max bytes from the
+// Body returns ErrBodyTooLarge. Place it before RespondDecorators that read the Body.
+func ByLimitingBody(max int64) RespondDecorator {
+	return func(r Responder) Responder {
+		return ResponderFunc(func(resp *http.Response) error {
+			err := r.Respond(resp)
+			if err != nil || resp.Body == nil {
+				return err
+			}
+			if resp.ContentLength > max {
+				return ErrBodyTooLarge
+			}
+			resp.Body = &limitedBody{ReadCloser: resp.Body, lr: &io.LimitedReader{R: resp.Body, N: max + 1}, max: max}
+			return nil
+		})
+	}
+}
+
+// limitedBody returns ErrBodyTooLarge once more than max bytes are read.
+type limitedBody struct {
+	io.ReadCloser
+	lr   *io.LimitedReader
+	max  int64
+	read int64
+}
+
+func (lb *limitedBody) Read(p []byte) (int, error) {
+	n, err := lb.lr.Read(p)
+	lb.read += int64(n)
+	if lb.read > lb.max {
+		n -= int(lb.read - lb.max)
+		lb.read = lb.max
+		return n, ErrBodyTooLarge
+	}
+	return n, err
+}
+
+// ByPreservingBody returns a RespondDecorator that reads the response Body into memory and replaces
+// it with one that rewinds to the start whenever it is closed, so the Body can be read again after
+// RespondDecorators that consume it (e.g. error extraction followed by ByClosing). Place it before
+// them in the list passed to Respond. The content is also available through GetPreservedBody.
+func ByPreservingBody() RespondDecorator {
+	return func(r Responder) Responder {
+		return ResponderFunc(func(resp *http.Response) error {
+			err := r.Respond(resp)
+			if err == nil && resp.Body != nil {
+				if _, ok := resp.Body.(*preservedBody); ok {
+					return nil
+				}
+				b, errInner := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				resp.Body = &preservedBody{Reader: bytes.NewReader(b), b: b}
+				if errInner != nil {
+					err = fmt.Errorf("Error occurred reading http.Response#Body - Error = '%v'", errInner)
+				}
+			}
+			return err
+		})
+	}
+}
+
+// GetPreservedBody returns the content of a response Body preserved by ByPreservingBody.
+func GetPreservedBody(resp *http.Response) ([]byte, bool) {
+	if resp == nil {
+		return nil, false
+	}
+	pb, ok := resp.Body.(*preservedBody)
+	if !ok {
+		return nil, false
+	}
+	return pb.b, true
+}
+
+// ByCapturingResponse returns a RespondDecorator that sets the response pointed to by dst to the
+// response, so results built by other RespondDecorators can carry the raw response for
+// diagnostics. The captured response shares its Body with the response; use
+// ByCapturingResponseWithBody to capture a Body that remains readable.
+func ByCapturingResponse(dst **http.Response) RespondDecorator {
+	return func(r Responder) Responder {
+		return ResponderFunc(func(resp *http.Response) error {
+			err := r.Respond(resp)
+			*dst = resp
+			return err
+		})
+	}
+}
+
+// ByCapturingResponseWithBody returns a RespondDecorator that sets the response pointed to by dst
+// to a copy of the response holding its own copy of the Body, which remains readable after
+// subsequent RespondDecorators consume and close the Body of the response. The captured Body can
+// also be retrieved with GetPreservedBody.
+func ByCapturingResponseWithBody(dst **http.Response) RespondDecorator {
+	return func(r Responder) Responder {
+		return ResponderFunc(func(resp *http.Response) error {
+			err := r.Respond(resp)
+			if resp == nil {
+				*dst = nil
+				return err
+			}
+			captured := *resp
+			if resp.Body != nil {
+				b, errInner := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				resp.Body = io.NopCloser(bytes.NewReader(b))
+				captured.Body = &preservedBody{Reader: bytes.NewReader(b), b: b}
+				if err == nil && errInner != nil {
+					err = fmt.Errorf("Error occurred reading http.Response#Body - Error = '%v'", errInner)
+				}
+			}
+			captured.Header = resp.Header.Clone()
+			*dst = &captured
+			return err
+		})
+	}
+}
+
+// preservedBody is a response body that rewinds when closed.
+type preservedBody struct {
+	*bytes.Reader
+	b []byte
+}
+
+func (pb *preservedBody) Close() error {
+	_, err := pb.Seek(0, io.SeekStart)
+	return err
+}
+
 // ByDiscardingBody returns a RespondDecorator that first invokes the passed Responder after which
 // it copies the remaining bytes (if any) in the response body to ioutil.Discard. Since the passed
 // Responder is invoked prior to discarding the response body, the decorator may occur anywhere
@@ -186,7 +331,64 @@ func ByUnmarshallingJSON(v interface{}) RespondDecorator {
 				} else if len(strings.Trim(string(b), " ")) > 0 {
 					errInner = json.Unmarshal(b, v)
 					if errInner != nil {
-						err = fmt.Errorf("Error occurred unmarshalling JSON - Error = '%v' JSON = '%s'", errInner, string(b))
+						err = newDeserializationError(resp, b, jsonErrorOffset(errInner), errInner)
+					}
+				}
+			}
+			return err
+		})
+	}
+}
+
+// ByStreamingJSONLines returns a RespondDecorator that decodes the newline-delimited JSON records
+// (NDJSON) returned in the response Body one at a time, invoking handle for each, so the Body is
+// never buffered in full. It stops at the first error returned by handle, which it returns.
+func ByStreamingJSONLines(handle func(json.RawMessage) error) RespondDecorator {
+	return func(r Responder) Responder {
+		return ResponderFunc(func(resp *http.Response) error {
+			err := r.Respond(resp)
+			if err != nil {
+				return err
+			}
+			dec := json.NewDecoder(resp.Body)
+			for record := 1; ; record++ {
+				var raw json.RawMessage
+				if errInner := dec.Decode(&raw); errInner == io.EOF {
+					return nil
+				} else if errInner != nil {
+					return fmt.Errorf("Error occurred decoding JSON record %d - Error = '%v'", record, errInner)
+				}
+				if err = handle(raw); err != nil {
+					return err
+				}
+			}
+		})
+	}
+}
+
+// ByExtractingNextLink returns a RespondDecorator that sets the string pointed to by next to the
+// "nextLink" property of the JSON list returned in the response Body, or to the empty string if
+// there is no further page. The Body is left readable for subsequent RespondDecorators.
+func ByExtractingNextLink(next *string) RespondDecorator {
+	return func(r Responder) Responder {
+		return ResponderFunc(func(resp *http.Response) error {
+			err := r.Respond(resp)
+			if err == nil {
+				*next = ""
+				b, errInner := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				resp.Body = io.NopCloser(bytes.NewReader(b))
+				b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))
+				if errInner != nil {
+					err = fmt.Errorf("Error occurred reading http.Response#Body - Error = '%v'", errInner)
+				} else if len(strings.TrimSpace(string(b))) > 0 {
+					var page struct {
+						NextLink *string `json:"nextLink"`
+					}
+					if errInner = json.Unmarshal(b, &page); errInner != nil {
+						err = newDeserializationError(resp, b, jsonErrorOffset(errInner), errInner)
+					} else if page.NextLink != nil {
+						*next = strings.TrimSpace(*page.NextLink)
 					}
 				}
 			}
@@ -206,11 +408,140 @@ func ByUnmarshallingXML(v interface{}) RespondDecorator {
 				if errInner != nil {
 					err = fmt.Errorf("Error occurred reading http.Response#Body - Error = '%v'", errInner)
 				} else {
-					errInner = xml.Unmarshal(b, v)
+					dec := xml.NewDecoder(bytes.NewReader(b))
+					errInner = dec.Decode(v)
 					if errInner != nil {
-						err = fmt.Errorf("Error occurred unmarshalling Xml - Error = '%v' Xml = '%s'", errInner, string(b))
+						err = newDeserializationError(resp, b, dec.InputOffset(), errInner)
+					}
+				}
+			}
+			return err
+		})
+	}
+}
+
+// ProtobufUnmarshaler is the interface implemented by Protocol Buffers messages that can decode
+// themselves from the wire format, such as messages generated by gogo/protobuf. Messages from
+// google.golang.org/protobuf can be adapted with ProtobufUnmarshalerFunc, e.g.
+//
+//	autorest.ProtobufUnmarshalerFunc(func(b []byte) error { return proto.Unmarshal(b, m) })
+type ProtobufUnmarshaler interface {
+	Unmarshal(b []byte) error
+}
+
+// ProtobufUnmarshalerFunc is a method that implements the ProtobufUnmarshaler interface.
+type ProtobufUnmarshalerFunc func(b []byte) error
+
+// Unmarshal implements the ProtobufUnmarshaler interface on ProtobufUnmarshalerFunc.
+func (puf ProtobufUnmarshalerFunc) Unmarshal(b []byte) error {
+	return puf(b)
+}
+
+// ByUnmarshallingProtobuf returns a RespondDecorator that decodes a Protocol Buffers message
+// returned in the response Body into the passed message. The response Content-Type, if present,
+// must be "application/x-protobuf" or "application/octet-stream".
+func ByUnmarshallingProtobuf(m ProtobufUnmarshaler) RespondDecorator {
+	return func(r Responder) Responder {
+		return ResponderFunc(func(resp *http.Response) error {
+			err := r.Respond(resp)
+			if err == nil {
+				if m == nil {
+					return NewError("autorest", "ByUnmarshallingProtobuf", "Invoked with a nil message")
+				}
+				if ct := resp.Header.Get(headerContentType); ct != "" {
+					if mt, _, errInner := mime.ParseMediaType(ct); errInner != nil || (mt != mimeTypeProtobuf && mt != mimeTypeOctetStream) {
+						return NewErrorWithResponse("autorest", "ByUnmarshallingProtobuf", resp, "unexpected Content-Type %q", ct)
 					}
 				}
+				b, errInner := io.ReadAll(resp.Body)
+				if errInner != nil {
+					err = fmt.Errorf("Error occurred reading http.Response#Body - Error = '%v'", errInner)
+				} else if errInner = m.Unmarshal(b); errInner != nil {
+					err = newDeserializationError(resp, b, -1, errInner)
+				}
+			}
+			return err
+		})
+	}
+}
+
+// deserializationSnippetLength is the maximum length of the body snippet in a DeserializationError.
+const deserializationSnippetLength = 256
+
+// DeserializationError is returned by ByUnmarshallingJSON, ByUnmarshallingXML,
+// ByUnmarshallingProtobuf, ByUnmarshallingFormURLEncoded and ByExtractingNextLink when the response
+// Body cannot be unmarshalled.
+type DeserializationError struct {
+	// ContentType is the Content-Type of the response.
+	ContentType string
+
+	// Offset is the byte offset in the Body at which unmarshalling failed, or -1 if unknown.
+	Offset int64
+
+	// Snippet is the part of the Body around Offset, truncated to at most 256 bytes.
+	Snippet string
+
+	// Err is the error returned while unmarshalling.
+	Err error
+}
+
+func (de DeserializationError) Error() string {
+	return fmt.Sprintf("Error occurred unmarshalling %q response body at offset %d - Error = '%v' Body = '%s'", de.ContentType, de.Offset, de.Err, de.Snippet)
+}
+
+// Unwrap returns the error returned while unmarshalling.
+func (de DeserializationError) Unwrap() error {
+	return de.Err
+}
+
+// newDeserializationError returns a DeserializationError for the passed body with a snippet
+// centred on offset.
+func newDeserializationError(resp *http.Response, b []byte, offset int64, err error) DeserializationError {
+	start := int64(0)
+	if offset > deserializationSnippetLength/2 {
+		start = offset - deserializationSnippetLength/2
+	}
+	if start > int64(len(b)) {
+		start = int64(len(b))
+	}
+	end := start + deserializationSnippetLength
+	if end > int64(len(b)) {
+		end = int64(len(b))
+	}
+	return DeserializationError{
+		ContentType: resp.Header.Get(headerContentType),
+		Offset:      offset,
+		Snippet:     string(b[start:end]),
+		Err:         err,
+	}
+}
+
+// jsonErrorOffset returns the byte offset reported by the passed JSON error, or -1 if it has none.
+func jsonErrorOffset(err error) int64 {
+	var se *json.SyntaxError
+	if errors.As(err, &se) {
+		return se.Offset
+	}
+	var ute *json.UnmarshalTypeError
+	if errors.As(err, &ute) {
+		return ute.Offset
+	}
+	return -1
+}
+
+// ByMappingStatusCodes returns a RespondDecorator that returns the error produced by the function
+// mapped to the response StatusCode, if any, allowing status codes to be translated into errors
+// usable with errors.Is or errors.As (e.g. 404 into a sentinel ErrNotFound). The function may read
+// the response Body. Place it before WithErrorUnlessStatusCode and similar RespondDecorators so that
+// the mapped errors take precedence over their errors.
+func ByMappingStatusCodes(mapping map[int]func(*http.Response) error) RespondDecorator {
+	return func(r Responder) Responder {
+		return ResponderFunc(func(resp *http.Response) error {
+			err := r.Respond(resp)
+			if err == nil && resp != nil {
+				if f, ok := mapping[resp.StatusCode]; ok && f != nil {
+					err = f(resp)
+				}
 			}
 			return err
 		})
@@ -248,6 +579,336 @@ func WithErrorUnlessOK() RespondDecorator {
 	return WithErrorUnlessStatusCode(http.StatusOK)
 }
 
+// WithErrorUnlessPartialContent returns a RespondDecorator that emits an error unless the response
+// StatusCode is HTTP 206 and its Content-Range header describes a byte range. If the originating
+// http.Request carried a Range header, the returned range must also start at the requested offset.
+func WithErrorUnlessPartialContent() RespondDecorator {
+	return func(r Responder) Responder {
+		return ResponderFunc(func(resp *http.Response) error {
+			err := r.Respond(resp)
+			if err != nil {
+				return err
+			}
+			if resp.StatusCode != http.StatusPartialContent {
+				return NewErrorWithResponse("autorest", "WithErrorUnlessPartialContent", resp, "expected status %d, got %s", http.StatusPartialContent, resp.Status)
+			}
+			var start, end int64
+			cr := resp.Header.Get(headerContentRange)
+			if _, err = fmt.Sscanf(cr, "bytes %d-%d/", &start, &end); err != nil || end < start {
+				return NewErrorWithResponse("autorest", "WithErrorUnlessPartialContent", resp, "malformed Content-Range header %q", cr)
+			}
+			if resp.Request != nil {
+				var requested int64
+				if _, err = fmt.Sscanf(resp.Request.Header.Get(headerRange), "bytes=%d-", &requested); err == nil && requested != start {
+					return NewErrorWithResponse("autorest", "WithErrorUnlessPartialContent", resp, "requested range starting at %d, got %q", requested, cr)
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// ByCapturingHeaders returns a RespondDecorator that sets the fields of the struct pointed to by v
+// from the response headers named by their `header:"..."` tags, e.g. `header:"x-ms-request-id"`.
+// Fields may be strings, bools, integers, floats, time.Time (parsed as an HTTP-date or RFC 3339),
+// types implementing encoding.TextUnmarshaler, or pointers to or slices of these; slices receive
+// every value of the header. Fields whose header is absent are left unchanged.
+func ByCapturingHeaders(v interface{}) RespondDecorator {
+	return func(r Responder) Responder {
+		return ResponderFunc(func(resp *http.Response) error {
+			err := r.Respond(resp)
+			if err != nil {
+				return err
+			}
+			rv := reflect.ValueOf(v)
+			if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+				return NewError("autorest", "ByCapturingHeaders", "Expected a pointer to a struct, got %T", v)
+			}
+			if name, err := setTaggedFields(rv.Elem(), "header", resp.Header.Values); err != nil {
+				return NewErrorWithError(err, "autorest", "ByCapturingHeaders", resp, "Failure converting header %s", name)
+			}
+			return nil
+		})
+	}
+}
+
+// ByUnmarshallingFormURLEncoded returns a RespondDecorator that decodes an
+// application/x-www-form-urlencoded document returned in the response Body into the value pointed
+// to by v, as returned by token endpoints and some older services. v may point to a url.Values or
+// to a struct whose fields are named by their `form:"..."` tags, which accept the same types as
+// ByCapturingHeaders; fields whose name is absent are left unchanged.
+func ByUnmarshallingFormURLEncoded(v interface{}) RespondDecorator {
+	return func(r Responder) Responder {
+		return ResponderFunc(func(resp *http.Response) error {
+			err := r.Respond(resp)
+			if err != nil {
+				return err
+			}
+			rv := reflect.ValueOf(v)
+			values, isValues := v.(*url.Values)
+			if (isValues && values == nil) || !isValues && (rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct) {
+				return NewError("autorest", "ByUnmarshallingFormURLEncoded", "Expected a pointer to a url.Values or a struct, got %T", v)
+			}
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return fmt.Errorf("Error occurred reading http.Response#Body - Error = '%v'", err)
+			}
+			parsed, err := url.ParseQuery(strings.TrimSpace(string(b)))
+			if err != nil {
+				return newDeserializationError(resp, b, -1, err)
+			}
+			if isValues {
+				*values = parsed
+				return nil
+			}
+			if name, err := setTaggedFields(rv.Elem(), "form", func(name string) []string { return parsed[name] }); err != nil {
+				return NewErrorWithError(err, "autorest", "ByUnmarshallingFormURLEncoded", resp, "Failure converting form value %s", name)
+			}
+			return nil
+		})
+	}
+}
+
+// setTaggedFields sets the fields of the passed struct named by the passed tag from the values
+// returned for their names. Slice fields receive every value, other fields the first. It returns
+// the name of the field it failed to set, if any.
+func setTaggedFields(rv reflect.Value, tag string, lookup func(name string) []string) (string, error) {
+	for i := 0; i < rv.NumField(); i++ {
+		field := rv.Type().Field(i)
+		name := field.Tag.Get(tag)
+		if name == "" || name == "-" || field.PkgPath != "" {
+			continue
+		}
+		values := lookup(name)
+		if len(values) == 0 {
+			continue
+		}
+		fv := rv.Field(i)
+		if fv.Kind() == reflect.Slice && !fv.Addr().Type().Implements(textUnmarshalerType) {
+			s := reflect.MakeSlice(fv.Type(), len(values), len(values))
+			for j, v := range values {
+				if err := setTextValue(s.Index(j), v); err != nil {
+					return name, err
+				}
+			}
+			fv.Set(s)
+		} else if err := setTextValue(fv, values[0]); err != nil {
+			return name, err
+		}
+	}
+	return "", nil
+}
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	timeType            = reflect.TypeOf(time.Time{})
+)
+
+// setTextValue converts the passed header or form value into the field.
+func setTextValue(fv reflect.Value, hv string) error {
+	if fv.Kind() == reflect.Ptr {
+		p := reflect.New(fv.Type().Elem())
+		if err := setTextValue(p.Elem(), hv); err != nil {
+			return err
+		}
+		fv.Set(p)
+		return nil
+	}
+	if fv.Type() == timeType {
+		hv = strings.TrimSpace(hv)
+		t, err := http.ParseTime(hv)
+		if err != nil {
+			if t, err = time.Parse(time.RFC3339, hv); err != nil {
+				return err
+			}
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if fv.CanAddr() && fv.Addr().Type().Implements(textUnmarshalerType) {
+		return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(hv))
+	}
+	hv = strings.TrimSpace(hv)
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(hv)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(hv)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(hv, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(hv, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(hv, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+	return nil
+}
+
+// headerContentCRC64 is the header holding the CRC-64 of content sent by Azure Storage.
+const headerContentCRC64 = "x-ms-content-crc64"
+
+// crc64Table is the table for the CRC-64 polynomial used by Azure Storage.
+var crc64Table = crc64.MakeTable(0x9A6C9329AC4BC9B5)
+
+// ChecksumError is returned while reading a response body verified by ByVerifyingContentMD5 or
+// ByVerifyingCRC64 when the digest of the body does not match the one in the response header.
+type ChecksumError struct {
+	// Header is the name of the header holding the expected digest.
+	Header string
+
+	// Expected is the base64 encoded digest in the header.
+	Expected string
+
+	// Actual is the base64 encoded digest of the body.
+	Actual string
+}
+
+func (ce ChecksumError) Error() string {
+	return fmt.Sprintf("autorest: response body digest %s does not match %s header %s", ce.Actual, ce.Header, ce.Expected)
+}
+
+// ByVerifyingContentMD5 returns a RespondDecorator that verifies the response Body against the
+// base64 encoded MD5 digest in the Content-MD5 header, if present. The Body is hashed while it is
+// read by subsequent RespondDecorators, and reading it to the end returns a ChecksumError if the
+// digests don't match.
+func ByVerifyingContentMD5() RespondDecorator {
+	return byVerifyingChecksum("ByVerifyingContentMD5", headerContentMD5, md5.New)
+}
+
+// ByVerifyingCRC64 returns a RespondDecorator that verifies the response Body against the base64
+// encoded, little-endian CRC-64 in the x-ms-content-crc64 header, if present, as sent by Azure
+// Storage. The Body is hashed while it is read by subsequent RespondDecorators, and reading it to
+// the end returns a ChecksumError if the checksums don't match.
+func ByVerifyingCRC64() RespondDecorator {
+	return byVerifyingChecksum("ByVerifyingCRC64", headerContentCRC64, func() hash.Hash {
+		return littleEndianHash{crc64.New(crc64Table)}
+	})
+}
+
+func byVerifyingChecksum(method, header string, newHash func() hash.Hash) RespondDecorator {
+	return func(r Responder) Responder {
+		return ResponderFunc(func(resp *http.Response) error {
+			err := r.Respond(resp)
+			if err != nil || resp.Body == nil {
+				return err
+			}
+			expected := strings.TrimSpace(resp.Header.Get(header))
+			if expected == "" {
+				return nil
+			}
+			if _, errInner := base64.StdEncoding.DecodeString(expected); errInner != nil {
+				return NewErrorWithError(errInner, "autorest", method, resp, "Malformed %s header %q", header, expected)
+			}
+			resp.Body = &verifyingBody{ReadCloser: resp.Body, hash: newHash(), header: header, expected: expected}
+			return nil
+		})
+	}
+}
+
+// littleEndianHash encodes the sum of a 64-bit hash as little-endian.
+type littleEndianHash struct {
+	hash.Hash64
+}
+
+func (h littleEndianHash) Sum(b []byte) []byte {
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], h.Sum64())
+	return append(b, sum[:]...)
+}
+
+// verifyingBody hashes the body as it is read and verifies the digest at EOF.
+type verifyingBody struct {
+	io.ReadCloser
+	hash     hash.Hash
+	header   string
+	expected string
+}
+
+func (vb *verifyingBody) Read(p []byte) (int, error) {
+	n, err := vb.ReadCloser.Read(p)
+	vb.hash.Write(p[:n])
+	if err == io.EOF {
+		if actual := base64.StdEncoding.EncodeToString(vb.hash.Sum(nil)); actual != vb.expected {
+			return n, ChecksumError{Header: vb.header, Expected: vb.expected, Actual: actual}
+		}
+	}
+	return n, err
+}
+
+// BySpoolingToFile returns a RespondDecorator that reads response Bodies larger than threshold
+// bytes into a temporary file in dir (or the default temporary directory if dir is empty) and
+// replaces the Body with a reader of the file, which also implements io.Seeker. The file is
+// removed when the Body is closed. Smaller Bodies are buffered in memory.
+func BySpoolingToFile(dir string, threshold int64) RespondDecorator {
+	return func(r Responder) Responder {
+		return ResponderFunc(func(resp *http.Response) error {
+			err := r.Respond(resp)
+			if err != nil || resp.Body == nil {
+				return err
+			}
+			body := resp.Body
+			defer body.Close()
+			var buf bytes.Buffer
+			n, err := io.CopyN(&buf, body, threshold+1)
+			if err == io.EOF || (err == nil && n <= threshold) {
+				resp.Body = io.NopCloser(bytes.NewReader(buf.Bytes()))
+				return nil
+			}
+			if err != nil {
+				resp.Body = io.NopCloser(io.MultiReader(bytes.NewReader(buf.Bytes()), body))
+				return NewErrorWithError(err, "autorest", "BySpoolingToFile", resp, "Failure reading the response body")
+			}
+			f, err := os.CreateTemp(dir, "autorest-body-*")
+			if err != nil {
+				resp.Body = io.NopCloser(bytes.NewReader(buf.Bytes()))
+				return NewErrorWithError(err, "autorest", "BySpoolingToFile", resp, "Failure creating the spool file")
+			}
+			spooled := &spooledBody{File: f}
+			if _, err = io.Copy(f, io.MultiReader(&buf, body)); err == nil {
+				_, err = f.Seek(0, io.SeekStart)
+			}
+			if err != nil {
+				spooled.Close()
+				resp.Body = http.NoBody
+				return NewErrorWithError(err, "autorest", "BySpoolingToFile", resp, "Failure spooling the response body")
+			}
+			resp.Body = spooled
+			return nil
+		})
+	}
+}
+
+// spooledBody reads a spooled response body and removes its file when closed.
+type spooledBody struct {
+	*os.File
+}
+
+func (sb *spooledBody) Close() error {
+	err := sb.File.Close()
+	if rerr := os.Remove(sb.File.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
 // ExtractHeader extracts all values of the specified header from the http.Response. It returns an
 // empty string slice if the passed http.Response is nil or the header does not exist.
 func ExtractHeader(header string, resp *http.Response) []string {
@@ -266,3 +927,10 @@ func ExtractHeaderValue(header string, resp *http.Response) string {
 	}
 	return ""
 }
+
+// ExtractETag extracts the value of the ETag header from the http.Response. It returns an empty
+// string if the passed http.Response is nil or the header does not exist. The returned value can
+// be passed to WithIfMatch or WithIfNoneMatch for conditional requests.
+func ExtractETag(resp *http.Response) string {
+	return ExtractHeaderValue(headerETag, resp)
+}
@@ -16,13 +16,22 @@ package autorest
 
 import (
 	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc64"
 	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Azure/go-autorest/autorest/mocks"
 )
@@ -630,6 +639,38 @@ func TestWithErrorUnlessOKEmitsErrorIfNotOK(t *testing.T) {
 	}
 }
 
+func TestWithErrorUnlessPartialContent(t *testing.T) {
+	testData := []struct {
+		name         string
+		status       int
+		requested    string
+		contentRange string
+		success      bool
+	}{
+		{"matching range", http.StatusPartialContent, "bytes=1024-2047", "bytes 1024-2047/4096", true},
+		{"no requested range", http.StatusPartialContent, "", "bytes 0-1023/*", true},
+		{"full content", http.StatusOK, "bytes=1024-", "", false},
+		{"missing content range", http.StatusPartialContent, "bytes=1024-", "", false},
+		{"mismatched range", http.StatusPartialContent, "bytes=1024-", "bytes 0-1023/4096", false},
+	}
+	for _, v := range testData {
+		r := mocks.NewResponseWithStatus(http.StatusText(v.status), v.status)
+		r.Request = mocks.NewRequest()
+		if v.requested != "" {
+			r.Request.Header.Set(headerRange, v.requested)
+		}
+		if v.contentRange != "" {
+			mocks.SetResponseHeader(r, headerContentRange, v.contentRange)
+		}
+		err := Respond(r, WithErrorUnlessPartialContent(), ByClosing())
+		if v.success && err != nil {
+			t.Fatalf("autorest: WithErrorUnlessPartialContent (%s) returned an unexpected error (%v)", v.name, err)
+		} else if !v.success && err == nil {
+			t.Fatalf("autorest: WithErrorUnlessPartialContent (%s) failed to return an error", v.name)
+		}
+	}
+}
+
 func TestExtractHeader(t *testing.T) {
 	r := mocks.NewResponse()
 	v := []string{"v1", "v2", "v3"}
@@ -651,6 +692,18 @@ func TestExtractHeaderHandlesMissingHeader(t *testing.T) {
 	}
 }
 
+func TestExtractETag(t *testing.T) {
+	r := mocks.NewResponse()
+	mocks.SetResponseHeader(r, "etag", `"0x8D1"`)
+
+	if ExtractETag(r) != `"0x8D1"` {
+		t.Fatalf("autorest: ExtractETag failed to retrieve the ETag -- expected %v, received %v", `"0x8D1"`, ExtractETag(r))
+	}
+	if ExtractETag(nil) != "" {
+		t.Fatal("autorest: ExtractETag failed to handle a nil response")
+	}
+}
+
 func TestExtractHeaderValue(t *testing.T) {
 	r := mocks.NewResponse()
 	v := "v1"
@@ -682,3 +735,513 @@ func TestExtractHeaderValueRetrievesFirstValue(t *testing.T) {
 			mocks.TestHeader, v[0], mocks.TestHeader, ExtractHeaderValue(mocks.TestHeader, r))
 	}
 }
+
+func TestByExtractingNextLink(t *testing.T) {
+	r := mocks.NewResponseWithContent(`{"value":[1],"nextLink":"https://microsoft.com/list?page=2"}`)
+	var next string
+	var page struct {
+		Value []int `json:"value"`
+	}
+	err := Respond(r,
+		ByExtractingNextLink(&next),
+		ByUnmarshallingJSON(&page),
+		ByClosing())
+	if err != nil {
+		t.Fatalf("autorest: ByExtractingNextLink failed (%v)", err)
+	}
+	if next != "https://microsoft.com/list?page=2" {
+		t.Fatalf("autorest: ByExtractingNextLink extracted an unexpected link -- %q", next)
+	}
+	if len(page.Value) != 1 {
+		t.Fatal("autorest: ByExtractingNextLink failed to leave the body readable")
+	}
+}
+
+func TestByExtractingNextLinkWithoutNextLink(t *testing.T) {
+	for _, body := range []string{`{"value":[]}`, `{"value":[],"nextLink":null}`, ``} {
+		next := "stale"
+		if err := Respond(mocks.NewResponseWithContent(body), ByExtractingNextLink(&next), ByClosing()); err != nil {
+			t.Fatalf("autorest: ByExtractingNextLink failed (%v)", err)
+		}
+		if next != "" {
+			t.Fatalf("autorest: ByExtractingNextLink extracted an unexpected link from %q -- %q", body, next)
+		}
+	}
+}
+
+func TestByExtractingNextLinkReturnsErrorForInvalidJSON(t *testing.T) {
+	var next string
+	if err := Respond(mocks.NewResponseWithContent("{"), ByExtractingNextLink(&next), ByClosing()); err == nil {
+		t.Fatal("autorest: ByExtractingNextLink failed to return an error for invalid JSON")
+	}
+}
+
+type testHeaderLevel string
+
+func (l *testHeaderLevel) UnmarshalText(b []byte) error {
+	*l = testHeaderLevel(strings.ToUpper(string(b)))
+	return nil
+}
+
+func TestByCapturingHeaders(t *testing.T) {
+	r := mocks.NewResponse()
+	mocks.SetResponseHeader(r, "x-ms-request-id", "abc-123")
+	mocks.SetResponseHeader(r, "x-ms-ratelimit-remaining-subscription-reads", "11999")
+	mocks.SetResponseHeader(r, "x-ms-preview", "true")
+	mocks.SetResponseHeader(r, "Date", "Mon, 02 Jan 2006 15:04:05 GMT")
+	mocks.SetResponseHeader(r, "x-ms-created", "2020-01-02T03:04:05Z")
+	mocks.SetResponseHeader(r, "x-ms-ratio", "0.5")
+	mocks.SetResponseHeader(r, "x-ms-level", "info")
+	r.Header.Add("x-ms-tag", "a")
+	r.Header.Add("x-ms-tag", "b")
+
+	var h struct {
+		RequestID      string          `header:"x-ms-request-id"`
+		RemainingReads *int            `header:"x-ms-ratelimit-remaining-subscription-reads"`
+		Preview        bool            `header:"x-ms-preview"`
+		Date           time.Time       `header:"Date"`
+		Created        *time.Time      `header:"x-ms-created"`
+		Ratio          float64         `header:"x-ms-ratio"`
+		Level          testHeaderLevel `header:"x-ms-level"`
+		Tags           []string        `header:"x-ms-tag"`
+		Missing        string          `header:"x-ms-missing"`
+		Untagged       string
+	}
+	h.Missing = "unchanged"
+	if err := Respond(r, ByCapturingHeaders(&h), ByClosing()); err != nil {
+		t.Fatalf("autorest: ByCapturingHeaders failed (%v)", err)
+	}
+	if h.RequestID != "abc-123" || h.RemainingReads == nil || *h.RemainingReads != 11999 || !h.Preview || h.Ratio != 0.5 {
+		t.Fatalf("autorest: ByCapturingHeaders captured unexpected values -- %+v", h)
+	}
+	if !h.Date.Equal(time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)) || h.Created == nil || !h.Created.Equal(time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Fatalf("autorest: ByCapturingHeaders captured unexpected times -- %v, %v", h.Date, h.Created)
+	}
+	if h.Level != "INFO" || !reflect.DeepEqual(h.Tags, []string{"a", "b"}) || h.Missing != "unchanged" {
+		t.Fatalf("autorest: ByCapturingHeaders captured unexpected values -- %+v", h)
+	}
+}
+
+func TestByCapturingHeadersReturnsErrorForInvalidValue(t *testing.T) {
+	r := mocks.NewResponse()
+	mocks.SetResponseHeader(r, "x-ms-count", "many")
+	var h struct {
+		Count int `header:"x-ms-count"`
+	}
+	if err := Respond(r, ByCapturingHeaders(&h), ByClosing()); err == nil {
+		t.Fatal("autorest: ByCapturingHeaders failed to return an error for an invalid integer")
+	}
+}
+
+func TestByCapturingHeadersRequiresStructPointer(t *testing.T) {
+	var s string
+	if err := Respond(mocks.NewResponse(), ByCapturingHeaders(&s), ByClosing()); err == nil {
+		t.Fatal("autorest: ByCapturingHeaders accepted a pointer to a string")
+	}
+}
+
+func TestByStreamingJSONLines(t *testing.T) {
+	r := mocks.NewResponseWithContent("{\"id\":1}\n{\"id\":2}\n\n{\"id\":3}\n")
+	var ids []int
+	err := Respond(r,
+		ByStreamingJSONLines(func(raw json.RawMessage) error {
+			var rec struct {
+				ID int `json:"id"`
+			}
+			if err := json.Unmarshal(raw, &rec); err != nil {
+				return err
+			}
+			ids = append(ids, rec.ID)
+			return nil
+		}),
+		ByClosing())
+	if err != nil {
+		t.Fatalf("autorest: ByStreamingJSONLines failed (%v)", err)
+	}
+	if !reflect.DeepEqual(ids, []int{1, 2, 3}) {
+		t.Fatalf("autorest: ByStreamingJSONLines decoded unexpected records -- %v", ids)
+	}
+}
+
+func TestByStreamingJSONLinesStopsOnHandlerError(t *testing.T) {
+	r := mocks.NewResponseWithContent("{\"id\":1}\n{\"id\":2}\n")
+	count := 0
+	err := Respond(r,
+		ByStreamingJSONLines(func(raw json.RawMessage) error {
+			count++
+			return fmt.Errorf("Faux Error")
+		}),
+		ByClosing())
+	if err == nil || count != 1 {
+		t.Fatalf("autorest: ByStreamingJSONLines failed to stop -- records %v, error %v", count, err)
+	}
+}
+
+func TestByStreamingJSONLinesReturnsErrorForInvalidJSON(t *testing.T) {
+	r := mocks.NewResponseWithContent("{\"id\":1}\n{\"id\":\n")
+	count := 0
+	err := Respond(r,
+		ByStreamingJSONLines(func(raw json.RawMessage) error {
+			count++
+			return nil
+		}),
+		ByClosing())
+	if err == nil || count != 1 {
+		t.Fatalf("autorest: ByStreamingJSONLines failed to return an error for invalid JSON -- records %v, error %v", count, err)
+	}
+}
+
+func TestByVerifyingContentMD5(t *testing.T) {
+	const content = `{"name":"blob"}`
+	sum := md5.Sum([]byte(content))
+
+	r := mocks.NewResponseWithContent(content)
+	mocks.SetResponseHeader(r, headerContentMD5, base64.StdEncoding.EncodeToString(sum[:]))
+	var v map[string]string
+	if err := Respond(r, ByVerifyingContentMD5(), ByUnmarshallingJSON(&v), ByClosing()); err != nil {
+		t.Fatalf("autorest: ByVerifyingContentMD5 failed (%v)", err)
+	}
+	if v["name"] != "blob" {
+		t.Fatalf("autorest: ByVerifyingContentMD5 altered the body -- %v", v)
+	}
+
+	r = mocks.NewResponseWithContent(content + " ")
+	mocks.SetResponseHeader(r, headerContentMD5, base64.StdEncoding.EncodeToString(sum[:]))
+	if err := Respond(r, ByVerifyingContentMD5()); err != nil {
+		t.Fatalf("autorest: ByVerifyingContentMD5 failed (%v)", err)
+	}
+	_, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	var ce ChecksumError
+	if !errors.As(err, &ce) || ce.Header != headerContentMD5 {
+		t.Fatalf("autorest: ByVerifyingContentMD5 failed to detect a mismatch -- received %v", err)
+	}
+}
+
+func TestByVerifyingCRC64(t *testing.T) {
+	const content = "Hello, World!"
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], crc64.Checksum([]byte(content), crc64.MakeTable(0x9A6C9329AC4BC9B5)))
+
+	r := mocks.NewResponseWithContent(content)
+	mocks.SetResponseHeader(r, headerContentCRC64, base64.StdEncoding.EncodeToString(sum[:]))
+	var b []byte
+	if err := Respond(r, ByVerifyingCRC64(), ByUnmarshallingBytes(&b), ByClosing()); err != nil {
+		t.Fatalf("autorest: ByVerifyingCRC64 failed (%v)", err)
+	}
+
+	r = mocks.NewResponseWithContent("Goodbye")
+	mocks.SetResponseHeader(r, headerContentCRC64, base64.StdEncoding.EncodeToString(sum[:]))
+	if err := Respond(r, ByVerifyingCRC64(), ByUnmarshallingBytes(&b), ByClosing()); err == nil {
+		t.Fatal("autorest: ByVerifyingCRC64 failed to detect a mismatch")
+	}
+}
+
+func TestByVerifyingChecksumWithoutHeader(t *testing.T) {
+	r := mocks.NewResponseWithContent("content")
+	var b []byte
+	if err := Respond(r, ByVerifyingContentMD5(), ByVerifyingCRC64(), ByUnmarshallingBytes(&b), ByClosing()); err != nil {
+		t.Fatalf("autorest: verifying a response without checksum headers failed (%v)", err)
+	}
+}
+
+func TestByVerifyingChecksumMalformedHeader(t *testing.T) {
+	r := mocks.NewResponseWithContent("content")
+	mocks.SetResponseHeader(r, headerContentMD5, "not base64!")
+	if err := Respond(r, ByVerifyingContentMD5(), ByClosing()); err == nil {
+		t.Fatal("autorest: ByVerifyingContentMD5 accepted a malformed header")
+	}
+}
+
+func TestBySpoolingToFile(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("0123456789", 100)
+	r := mocks.NewResponseWithContent(content)
+	if err := Respond(r, BySpoolingToFile(dir, 64)); err != nil {
+		t.Fatalf("autorest: BySpoolingToFile failed (%v)", err)
+	}
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("autorest: BySpoolingToFile created an unexpected number of files -- %v", len(files))
+	}
+	if _, ok := r.Body.(io.Seeker); !ok {
+		t.Fatal("autorest: BySpoolingToFile returned a body that can't seek")
+	}
+	var b []byte
+	if err := Respond(r, ByUnmarshallingBytes(&b), ByClosing()); err != nil {
+		t.Fatalf("autorest: reading the spooled body failed (%v)", err)
+	}
+	if string(b) != content {
+		t.Fatal("autorest: BySpoolingToFile altered the body")
+	}
+	if files, _ = os.ReadDir(dir); len(files) != 0 {
+		t.Fatal("autorest: BySpoolingToFile failed to remove the file when the body was closed")
+	}
+}
+
+func TestBySpoolingToFileBuffersSmallBodies(t *testing.T) {
+	dir := t.TempDir()
+	for _, content := range []string{"", "small", strings.Repeat("x", 64)} {
+		r := mocks.NewResponseWithContent(content)
+		var b []byte
+		if err := Respond(r, BySpoolingToFile(dir, 64), ByUnmarshallingBytes(&b), ByClosing()); err != nil {
+			t.Fatalf("autorest: BySpoolingToFile failed (%v)", err)
+		}
+		if string(b) != content {
+			t.Fatalf("autorest: BySpoolingToFile altered the body -- expected %q, received %q", content, string(b))
+		}
+		if files, _ := os.ReadDir(dir); len(files) != 0 {
+			t.Fatalf("autorest: BySpoolingToFile spooled a body of %d bytes", len(content))
+		}
+	}
+}
+
+func TestBySpoolingToFileReturnsErrorForMissingDir(t *testing.T) {
+	r := mocks.NewResponseWithContent(strings.Repeat("x", 100))
+	if err := Respond(r, BySpoolingToFile(filepath.Join(t.TempDir(), "missing"), 10)); err == nil {
+		t.Fatal("autorest: BySpoolingToFile failed to return an error for a missing directory")
+	}
+}
+
+func TestByPreservingBody(t *testing.T) {
+	r := mocks.NewResponseWithContent(`{"error":{"code":"Conflict"}}`)
+	var first, second map[string]interface{}
+	if err := Respond(r, ByPreservingBody(), ByUnmarshallingJSON(&first), ByClosing()); err != nil {
+		t.Fatalf("autorest: ByPreservingBody failed (%v)", err)
+	}
+	if err := Respond(r, ByUnmarshallingJSON(&second), ByClosing()); err != nil {
+		t.Fatalf("autorest: reading the preserved body failed (%v)", err)
+	}
+	if !reflect.DeepEqual(first, second) || len(second) == 0 {
+		t.Fatalf("autorest: ByPreservingBody failed to preserve the body -- %v, %v", first, second)
+	}
+	if b, ok := GetPreservedBody(r); !ok || string(b) != `{"error":{"code":"Conflict"}}` {
+		t.Fatalf("autorest: GetPreservedBody returned unexpected content -- %q", string(b))
+	}
+}
+
+func TestGetPreservedBodyWithoutPreserving(t *testing.T) {
+	if _, ok := GetPreservedBody(mocks.NewResponse()); ok {
+		t.Fatal("autorest: GetPreservedBody returned content for a body that wasn't preserved")
+	}
+	if _, ok := GetPreservedBody(nil); ok {
+		t.Fatal("autorest: GetPreservedBody returned content for a nil response")
+	}
+}
+
+func TestByLimitingBody(t *testing.T) {
+	var b []byte
+	r := mocks.NewResponseWithContent("0123456789")
+	if err := Respond(r, ByLimitingBody(10), ByUnmarshallingBytes(&b), ByClosing()); err != nil {
+		t.Fatalf("autorest: ByLimitingBody failed for a body within the limit (%v)", err)
+	}
+	if string(b) != "0123456789" {
+		t.Fatalf("autorest: ByLimitingBody altered the body -- %q", string(b))
+	}
+
+	r = mocks.NewResponseWithContent("0123456789")
+	if err := Respond(r, ByLimitingBody(5)); err != nil {
+		t.Fatalf("autorest: ByLimitingBody failed (%v)", err)
+	}
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("autorest: ByLimitingBody returned an unexpected error -- expected %v, received %v", ErrBodyTooLarge, err)
+	}
+	if string(b) != "01234" {
+		t.Fatalf("autorest: ByLimitingBody returned more than the limit -- %q", string(b))
+	}
+}
+
+func TestByLimitingBodyChecksContentLength(t *testing.T) {
+	r := mocks.NewResponseWithContent("0123456789")
+	r.ContentLength = 10
+	if err := Respond(r, ByLimitingBody(5), ByClosing()); err != ErrBodyTooLarge {
+		t.Fatalf("autorest: ByLimitingBody returned an unexpected error -- expected %v, received %v", ErrBodyTooLarge, err)
+	}
+}
+
+func TestByUnmarshallingJSON_ReturnsDeserializationError(t *testing.T) {
+	body := `{"name": "` + strings.Repeat("x", 300) + `", "value": <html>}`
+	r := mocks.NewResponseWithContent(body)
+	mocks.SetResponseHeader(r, headerContentType, mimeTypeJSON)
+	v := &mocks.T{}
+	err := Respond(r, ByUnmarshallingJSON(v), ByClosing())
+	var de DeserializationError
+	if !errors.As(err, &de) {
+		t.Fatalf("autorest: ByUnmarshallingJSON returned an unexpected error -- %v", err)
+	}
+	if de.ContentType != mimeTypeJSON {
+		t.Fatalf("autorest: DeserializationError has the wrong content type -- %q", de.ContentType)
+	}
+	if int(de.Offset) != strings.Index(body, "<")+1 {
+		t.Fatalf("autorest: DeserializationError has the wrong offset -- %d", de.Offset)
+	}
+	if len(de.Snippet) > deserializationSnippetLength || !strings.Contains(de.Snippet, "<html>") {
+		t.Fatalf("autorest: DeserializationError has the wrong snippet -- %q", de.Snippet)
+	}
+	var se *json.SyntaxError
+	if !errors.As(err, &se) {
+		t.Fatalf("autorest: DeserializationError does not unwrap to the JSON error -- %v", err)
+	}
+}
+
+func TestByUnmarshallingXML_ReturnsDeserializationError(t *testing.T) {
+	r := mocks.NewResponseWithContent("<Name>one</Value>")
+	mocks.SetResponseHeader(r, headerContentType, "application/xml")
+	v := &mocks.T{}
+	err := Respond(r, ByUnmarshallingXML(v), ByClosing())
+	var de DeserializationError
+	if !errors.As(err, &de) {
+		t.Fatalf("autorest: ByUnmarshallingXML returned an unexpected error -- %v", err)
+	}
+	if de.Offset <= 0 || de.Snippet != "<Name>one</Value>" {
+		t.Fatalf("autorest: DeserializationError has the wrong context -- %+v", de)
+	}
+}
+
+func TestByMappingStatusCodes(t *testing.T) {
+	errNotFound := errors.New("not found")
+	mapping := map[int]func(*http.Response) error{
+		http.StatusNotFound: func(*http.Response) error {
+			return errNotFound
+		},
+	}
+
+	r := mocks.NewResponseWithStatus("404 Not Found", http.StatusNotFound)
+	err := Respond(r, ByMappingStatusCodes(mapping), WithErrorUnlessOK(), ByClosing())
+	if !errors.Is(err, errNotFound) {
+		t.Fatalf("autorest: ByMappingStatusCodes returned an unexpected error -- expected %v, received %v", errNotFound, err)
+	}
+
+	r = mocks.NewResponseWithStatus("409 Conflict", http.StatusConflict)
+	err = Respond(r, ByMappingStatusCodes(mapping), WithErrorUnlessOK(), ByClosing())
+	if err == nil || errors.Is(err, errNotFound) {
+		t.Fatalf("autorest: ByMappingStatusCodes mapped an unmapped status code -- %v", err)
+	}
+
+	if err = Respond(mocks.NewResponse(), ByMappingStatusCodes(mapping), WithErrorUnlessOK(), ByClosing()); err != nil {
+		t.Fatalf("autorest: ByMappingStatusCodes returned an error for a successful response -- %v", err)
+	}
+}
+
+func TestByUnmarshallingFormURLEncoded(t *testing.T) {
+	body := "access_token=abc&expires_in=3600&scope=read&scope=write"
+
+	values := url.Values{}
+	r := mocks.NewResponseWithContent(body)
+	if err := Respond(r, ByUnmarshallingFormURLEncoded(&values), ByClosing()); err != nil {
+		t.Fatalf("autorest: ByUnmarshallingFormURLEncoded failed (%v)", err)
+	}
+	if values.Get("access_token") != "abc" || len(values["scope"]) != 2 {
+		t.Fatalf("autorest: ByUnmarshallingFormURLEncoded decoded the wrong values -- %v", values)
+	}
+
+	var token struct {
+		AccessToken string   `form:"access_token"`
+		ExpiresIn   int      `form:"expires_in"`
+		Scope       []string `form:"scope"`
+		Resource    string   `form:"resource"`
+	}
+	r = mocks.NewResponseWithContent(body)
+	if err := Respond(r, ByUnmarshallingFormURLEncoded(&token), ByClosing()); err != nil {
+		t.Fatalf("autorest: ByUnmarshallingFormURLEncoded failed (%v)", err)
+	}
+	if token.AccessToken != "abc" || token.ExpiresIn != 3600 || !reflect.DeepEqual(token.Scope, []string{"read", "write"}) || token.Resource != "" {
+		t.Fatalf("autorest: ByUnmarshallingFormURLEncoded decoded the wrong values -- %+v", token)
+	}
+}
+
+func TestByUnmarshallingFormURLEncoded_HandlesErrors(t *testing.T) {
+	var token struct {
+		ExpiresIn int `form:"expires_in"`
+	}
+	r := mocks.NewResponseWithContent("expires_in=soon")
+	if err := Respond(r, ByUnmarshallingFormURLEncoded(&token), ByClosing()); err == nil {
+		t.Fatal("autorest: ByUnmarshallingFormURLEncoded failed to return an error for an invalid value")
+	}
+
+	r = mocks.NewResponseWithContent("a=%zz")
+	var de DeserializationError
+	if err := Respond(r, ByUnmarshallingFormURLEncoded(&url.Values{}), ByClosing()); !errors.As(err, &de) {
+		t.Fatalf("autorest: ByUnmarshallingFormURLEncoded returned an unexpected error -- %v", err)
+	}
+
+	var s string
+	if err := Respond(mocks.NewResponse(), ByUnmarshallingFormURLEncoded(&s), ByClosing()); err == nil {
+		t.Fatal("autorest: ByUnmarshallingFormURLEncoded failed to return an error for an unsupported type")
+	}
+}
+
+func TestByUnmarshallingProtobuf(t *testing.T) {
+	var received []byte
+	m := ProtobufUnmarshalerFunc(func(b []byte) error {
+		received = b
+		return nil
+	})
+	r := mocks.NewResponseWithContent("\x0a\x03abc")
+	mocks.SetResponseHeader(r, headerContentType, mimeTypeProtobuf)
+	if err := Respond(r, ByUnmarshallingProtobuf(m), ByClosing()); err != nil {
+		t.Fatalf("autorest: ByUnmarshallingProtobuf failed (%v)", err)
+	}
+	if string(received) != "\x0a\x03abc" {
+		t.Fatalf("autorest: ByUnmarshallingProtobuf passed the wrong bytes -- %q", received)
+	}
+}
+
+func TestByUnmarshallingProtobuf_HandlesErrors(t *testing.T) {
+	errDecode := errors.New("decode failed")
+	m := ProtobufUnmarshalerFunc(func(b []byte) error {
+		return errDecode
+	})
+	r := mocks.NewResponseWithContent("\x0a\x03abc")
+	mocks.SetResponseHeader(r, headerContentType, mimeTypeOctetStream)
+	if err := Respond(r, ByUnmarshallingProtobuf(m), ByClosing()); !errors.Is(err, errDecode) {
+		t.Fatalf("autorest: ByUnmarshallingProtobuf returned an unexpected error -- %v", err)
+	}
+
+	r = mocks.NewResponseWithContent("{}")
+	mocks.SetResponseHeader(r, headerContentType, mimeTypeJSON)
+	if err := Respond(r, ByUnmarshallingProtobuf(m), ByClosing()); err == nil || errors.Is(err, errDecode) {
+		t.Fatalf("autorest: ByUnmarshallingProtobuf accepted an unexpected Content-Type -- %v", err)
+	}
+
+	if err := Respond(mocks.NewResponse(), ByUnmarshallingProtobuf(nil), ByClosing()); err == nil {
+		t.Fatal("autorest: ByUnmarshallingProtobuf failed to return an error for a nil message")
+	}
+}
+
+func TestByCapturingResponse(t *testing.T) {
+	var raw *http.Response
+	var v mocks.T
+	r := mocks.NewResponseWithContent(jsonT)
+	if err := Respond(r, ByCapturingResponse(&raw), ByUnmarshallingJSON(&v), ByClosing()); err != nil {
+		t.Fatalf("autorest: ByCapturingResponse failed (%v)", err)
+	}
+	if raw != r {
+		t.Fatal("autorest: ByCapturingResponse failed to capture the response")
+	}
+}
+
+func TestByCapturingResponseWithBody(t *testing.T) {
+	var raw *http.Response
+	var v mocks.T
+	r := mocks.NewResponseWithContent(jsonT)
+	mocks.SetResponseHeader(r, headerContentType, mimeTypeJSON)
+	if err := Respond(r, ByCapturingResponseWithBody(&raw), ByUnmarshallingJSON(&v), ByClosing()); err != nil {
+		t.Fatalf("autorest: ByCapturingResponseWithBody failed (%v)", err)
+	}
+	if v.Name != "Rob Pike" {
+		t.Fatalf("autorest: ByCapturingResponseWithBody consumed the body -- %+v", v)
+	}
+	if raw == nil || raw == r || raw.Header.Get(headerContentType) != mimeTypeJSON {
+		t.Fatalf("autorest: ByCapturingResponseWithBody failed to capture a copy of the response -- %v", raw)
+	}
+	b, err := io.ReadAll(raw.Body)
+	if err != nil || string(b) != jsonT {
+		t.Fatalf("autorest: ByCapturingResponseWithBody captured the wrong body -- %q (%v)", string(b), err)
+	}
+	if b, ok := GetPreservedBody(raw); !ok || string(b) != jsonT {
+		t.Fatal("autorest: ByCapturingResponseWithBody body not available from GetPreservedBody")
+	}
+}
@@ -0,0 +1,357 @@
+package autorest
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// SchemaFailure describes a part of a JSON document that does not conform to its schema.
+type SchemaFailure struct {
+	// Path locates the failing value, e.g. "$.items[2].name".
+	Path string
+
+	// Message describes the failure.
+	Message string
+}
+
+// SchemaValidationError is returned by ByValidatingAgainst and ByValidatingAgainstSchema when the
+// response Body does not conform to the schema.
+type SchemaValidationError struct {
+	// Failures lists every failure found, ordered by path.
+	Failures []SchemaFailure
+}
+
+func (sve SchemaValidationError) Error() string {
+	msgs := make([]string, len(sve.Failures))
+	for i, f := range sve.Failures {
+		msgs[i] = f.Path + ": " + f.Message
+	}
+	return fmt.Sprintf("autorest: response body does not match schema: %s", strings.Join(msgs, "; "))
+}
+
+// schemaKeywords lists the JSON Schema keywords supported by Schema, including annotations that do
+// not affect validation.
+var schemaKeywords = map[string]bool{
+	"type": true, "enum": true, "const": true, "properties": true, "required": true,
+	"additionalProperties": true, "items": true, "minItems": true, "maxItems": true,
+	"minLength": true, "maxLength": true, "pattern": true, "minimum": true, "maximum": true,
+	"allOf": true, "anyOf": true, "oneOf": true,
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"default": true, "examples": true, "readOnly": true, "writeOnly": true, "deprecated": true,
+}
+
+// Schema is a JSON Schema compiled by CompileSchema.
+type Schema struct {
+	root     interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// CompileSchema parses the passed JSON Schema. It returns an error if the schema is not valid JSON,
+// has an invalid pattern or uses a keyword other than type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, minLength, maxLength, pattern, minimum, maximum,
+// allOf, anyOf and oneOf and the annotations $schema, $id, $comment, title, description, default,
+// examples, readOnly, writeOnly and deprecated; keywords such as $ref, $defs, definitions and format
+// are not supported.
+func CompileSchema(schema []byte) (*Schema, error) {
+	sc := &Schema{patterns: map[string]*regexp.Regexp{}}
+	if err := json.Unmarshal(schema, &sc.root); err != nil {
+		return nil, err
+	}
+	if err := sc.compile(sc.root, "#"); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// compile checks the keywords of the passed schema, located at path, and compiles its patterns.
+func (sc *Schema) compile(schema interface{}, path string) error {
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		if _, ok := schema.(bool); ok {
+			return nil
+		}
+		return fmt.Errorf("autorest: schema at %s is not an object or boolean", path)
+	}
+	keywords := make([]string, 0, len(s))
+	for k := range s {
+		keywords = append(keywords, k)
+	}
+	sort.Strings(keywords)
+	for _, k := range keywords {
+		if !schemaKeywords[k] {
+			return fmt.Errorf("autorest: unsupported schema keyword %q at %s", k, path)
+		}
+	}
+	if p, ok := s["pattern"].(string); ok {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("autorest: invalid pattern %q at %s: %v", p, path, err)
+		}
+		sc.patterns[p] = re
+	}
+	if props, ok := s["properties"].(map[string]interface{}); ok {
+		names := make([]string, 0, len(props))
+		for name := range props {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := sc.compile(props[name], path+"/properties/"+name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, k := range []string{"additionalProperties", "items"} {
+		if sub, ok := s[k]; ok {
+			if err := sc.compile(sub, path+"/"+k); err != nil {
+				return err
+			}
+		}
+	}
+	for _, k := range []string{"allOf", "anyOf", "oneOf"} {
+		for i, sub := range schemaList(s[k]) {
+			if err := sc.compile(sub, fmt.Sprintf("%s/%s/%d", path, k, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ByValidatingAgainstSchema returns a RespondDecorator that validates the JSON document returned in
+// the response Body against the passed JSON Schema, as ByValidatingAgainst does. The schema is
+// compiled by CompileSchema when the decorator is created; a schema it rejects fails every
+// response, so use CompileSchema and ByValidatingAgainst to detect such a schema up front.
+func ByValidatingAgainstSchema(schema []byte) RespondDecorator {
+	sc, schemaErr := CompileSchema(schema)
+	if schemaErr != nil {
+		return func(r Responder) Responder {
+			return ResponderFunc(func(resp *http.Response) error {
+				err := r.Respond(resp)
+				if err != nil {
+					return err
+				}
+				return NewErrorWithError(schemaErr, "autorest", "ByValidatingAgainstSchema", resp, "Failure parsing the schema")
+			})
+		}
+	}
+	return ByValidatingAgainst(sc)
+}
+
+// ByValidatingAgainst returns a RespondDecorator that validates the JSON document returned in the
+// response Body against the passed Schema, returning a SchemaValidationError listing the failing
+// paths if it does not conform. The Body is left readable for subsequent RespondDecorators.
+func ByValidatingAgainst(sc *Schema) RespondDecorator {
+	return func(r Responder) Responder {
+		return ResponderFunc(func(resp *http.Response) error {
+			err := r.Respond(resp)
+			if err != nil {
+				return err
+			}
+			b, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			resp.Body = io.NopCloser(bytes.NewReader(b))
+			if err != nil {
+				return fmt.Errorf("Error occurred reading http.Response#Body - Error = '%v'", err)
+			}
+			var v interface{}
+			if err = json.Unmarshal(bytes.TrimPrefix(b, []byte("\xef\xbb\xbf")), &v); err != nil {
+				return newDeserializationError(resp, b, jsonErrorOffset(err), err)
+			}
+			failures := sc.validate(sc.root, v, "$", nil)
+			if len(failures) == 0 {
+				return nil
+			}
+			sort.SliceStable(failures, func(i, j int) bool {
+				return failures[i].Path < failures[j].Path
+			})
+			return SchemaValidationError{Failures: failures}
+		})
+	}
+}
+
+// validate appends the failures of the passed value against the passed schema.
+func (sc *Schema) validate(schema interface{}, v interface{}, path string, failures []SchemaFailure) []SchemaFailure {
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		if b, ok := schema.(bool); ok && !b {
+			failures = append(failures, SchemaFailure{Path: path, Message: "no value is allowed"})
+		}
+		return failures
+	}
+	fail := func(format string, args ...interface{}) {
+		failures = append(failures, SchemaFailure{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if t, ok := s["type"]; ok && !matchesSchemaType(t, v) {
+		fail("expected type %v, got %s", t, jsonTypeOf(v))
+		return failures
+	}
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("value is not one of the enumerated values")
+		}
+	}
+	if c, ok := s["const"]; ok && !reflect.DeepEqual(c, v) {
+		fail("value does not equal %v", c)
+	}
+	for _, sub := range schemaList(s["allOf"]) {
+		failures = sc.validate(sub, v, path, failures)
+	}
+	if anyOf := schemaList(s["anyOf"]); len(anyOf) > 0 && sc.countMatching(anyOf, v, path) == 0 {
+		fail("value does not match any schema in anyOf")
+	}
+	if oneOf := schemaList(s["oneOf"]); len(oneOf) > 0 {
+		if n := sc.countMatching(oneOf, v, path); n != 1 {
+			fail("value matches %d schemas in oneOf, expected exactly one", n)
+		}
+	}
+	switch tv := v.(type) {
+	case map[string]interface{}:
+		props, _ := s["properties"].(map[string]interface{})
+		for _, name := range schemaList(s["required"]) {
+			if n, ok := name.(string); ok {
+				if _, ok := tv[n]; !ok {
+					fail("missing required property %q", n)
+				}
+			}
+		}
+		names := make([]string, 0, len(tv))
+		for name := range tv {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if ps, ok := props[name]; ok {
+				failures = sc.validate(ps, tv[name], path+"."+name, failures)
+			} else if ap, ok := s["additionalProperties"]; ok {
+				if b, ok := ap.(bool); ok && !b {
+					failures = append(failures, SchemaFailure{Path: path + "." + name, Message: "additional property is not allowed"})
+				} else {
+					failures = sc.validate(ap, tv[name], path+"."+name, failures)
+				}
+			}
+		}
+	case []interface{}:
+		if n, ok := s["minItems"].(float64); ok && float64(len(tv)) < n {
+			fail("expected at least %v items, got %d", n, len(tv))
+		}
+		if n, ok := s["maxItems"].(float64); ok && float64(len(tv)) > n {
+			fail("expected at most %v items, got %d", n, len(tv))
+		}
+		if items, ok := s["items"]; ok {
+			for i, item := range tv {
+				failures = sc.validate(items, item, fmt.Sprintf("%s[%d]", path, i), failures)
+			}
+		}
+	case string:
+		length := float64(utf8.RuneCountInString(tv))
+		if n, ok := s["minLength"].(float64); ok && length < n {
+			fail("expected at least %v characters, got %v", n, length)
+		}
+		if n, ok := s["maxLength"].(float64); ok && length > n {
+			fail("expected at most %v characters, got %v", n, length)
+		}
+		if p, ok := s["pattern"].(string); ok && !sc.patterns[p].MatchString(tv) {
+			fail("value does not match pattern %q", p)
+		}
+	case float64:
+		if n, ok := s["minimum"].(float64); ok && tv < n {
+			fail("value %v is less than the minimum %v", tv, n)
+		}
+		if n, ok := s["maximum"].(float64); ok && tv > n {
+			fail("value %v is greater than the maximum %v", tv, n)
+		}
+	}
+	return failures
+}
+
+// countMatching returns the number of the passed schemas the value conforms to.
+func (sc *Schema) countMatching(schemas []interface{}, v interface{}, path string) int {
+	n := 0
+	for _, sub := range schemas {
+		if len(sc.validate(sub, v, path, nil)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// schemaList returns the passed schema keyword value as a list.
+func schemaList(v interface{}) []interface{} {
+	l, _ := v.([]interface{})
+	return l
+}
+
+// matchesSchemaType reports whether the value is of the passed schema type, or one of the passed
+// schema types.
+func matchesSchemaType(t interface{}, v interface{}) bool {
+	switch tt := t.(type) {
+	case string:
+		vt := jsonTypeOf(v)
+		if tt == "integer" {
+			f, ok := v.(float64)
+			return ok && f == math.Trunc(f)
+		}
+		return tt == vt || (tt == "number" && vt == "integer")
+	case []interface{}:
+		for _, e := range tt {
+			if matchesSchemaType(e, v) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// jsonTypeOf returns the JSON Schema type name of the passed decoded JSON value.
+func jsonTypeOf(v interface{}) string {
+	switch tv := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if tv == math.Trunc(tv) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
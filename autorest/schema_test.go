@@ -0,0 +1,127 @@
+package autorest
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["name", "count"],
+	"properties": {
+		"name": {"type": "string", "minLength": 1, "pattern": "^[a-z]+$"},
+		"count": {"type": "integer", "minimum": 0},
+		"kind": {"enum": ["a", "b"]},
+		"tags": {"type": "array", "maxItems": 2, "items": {"type": "string"}}
+	},
+	"additionalProperties": false
+}`
+
+func TestByValidatingAgainstSchema(t *testing.T) {
+	body := `{"name": "abc", "count": 2, "kind": "a", "tags": ["x"]}`
+	r := mocks.NewResponseWithContent(body)
+	var v map[string]interface{}
+	if err := Respond(r, ByValidatingAgainstSchema([]byte(testSchema)), ByUnmarshallingJSON(&v), ByClosing()); err != nil {
+		t.Fatalf("autorest: ByValidatingAgainstSchema failed for a valid body (%v)", err)
+	}
+	if v["name"] != "abc" {
+		t.Fatalf("autorest: ByValidatingAgainstSchema did not leave the body readable -- %v", v)
+	}
+}
+
+func TestByValidatingAgainstSchemaListsFailures(t *testing.T) {
+	body := `{"name": "ABC", "count": 1.5, "kind": "c", "tags": ["x", 2, "z"], "extra": true}`
+	r := mocks.NewResponseWithContent(body)
+	err := Respond(r, ByValidatingAgainstSchema([]byte(testSchema)), ByClosing())
+	var sve SchemaValidationError
+	if !errors.As(err, &sve) {
+		t.Fatalf("autorest: ByValidatingAgainstSchema returned an unexpected error -- %v", err)
+	}
+	paths := []string{}
+	for _, f := range sve.Failures {
+		paths = append(paths, f.Path)
+	}
+	expected := []string{"$.count", "$.extra", "$.kind", "$.name", "$.tags", "$.tags[1]"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("autorest: ByValidatingAgainstSchema returned the wrong failures -- expected %v, received %v", expected, sve.Failures)
+	}
+}
+
+func TestByValidatingAgainstSchemaRequired(t *testing.T) {
+	r := mocks.NewResponseWithContent(`{"name": "abc"}`)
+	err := Respond(r, ByValidatingAgainstSchema([]byte(testSchema)), ByClosing())
+	var sve SchemaValidationError
+	if !errors.As(err, &sve) || len(sve.Failures) != 1 || sve.Failures[0].Path != "$" {
+		t.Fatalf("autorest: ByValidatingAgainstSchema failed to report a missing property -- %v", err)
+	}
+}
+
+func TestByValidatingAgainstSchemaOneOf(t *testing.T) {
+	schema := []byte(`{"oneOf": [{"type": "string"}, {"type": "integer"}]}`)
+	if err := Respond(mocks.NewResponseWithContent(`42`), ByValidatingAgainstSchema(schema), ByClosing()); err != nil {
+		t.Fatalf("autorest: ByValidatingAgainstSchema failed for a valid body (%v)", err)
+	}
+	if err := Respond(mocks.NewResponseWithContent(`true`), ByValidatingAgainstSchema(schema), ByClosing()); err == nil {
+		t.Fatal("autorest: ByValidatingAgainstSchema failed to report a value matching no schema in oneOf")
+	}
+}
+
+func TestByValidatingAgainstSchemaInvalidSchema(t *testing.T) {
+	err := Respond(mocks.NewResponseWithContent(`{}`), ByValidatingAgainstSchema([]byte(`{`)), ByClosing())
+	var sve SchemaValidationError
+	if err == nil || errors.As(err, &sve) {
+		t.Fatalf("autorest: ByValidatingAgainstSchema returned an unexpected error for an invalid schema -- %v", err)
+	}
+}
+
+func TestCompileSchemaRejectsUnsupportedKeywords(t *testing.T) {
+	for _, schema := range []string{
+		`{"$ref": "#/$defs/name"}`,
+		`{"$defs": {"name": {"type": "string"}}}`,
+		`{"definitions": {"name": {"type": "string"}}}`,
+		`{"type": "string", "format": "date-time"}`,
+		`{"properties": {"when": {"type": "string", "format": "date-time"}}}`,
+		`{"items": {"anyOf": [{"$ref": "#"}]}}`,
+		`{"pattern": "["}`,
+	} {
+		if _, err := CompileSchema([]byte(schema)); err == nil {
+			t.Fatalf("autorest: CompileSchema accepted the unsupported schema %s", schema)
+		}
+	}
+}
+
+func TestCompileSchemaAllowsAnnotations(t *testing.T) {
+	schema := `{"$schema": "https://json-schema.org/draft/2020-12/schema", "title": "T", "description": "D",
+		"properties": {"format": {"type": "string", "default": "json", "examples": ["json"]}}}`
+	sc, err := CompileSchema([]byte(schema))
+	if err != nil {
+		t.Fatalf("autorest: CompileSchema returned an unexpected error (%v)", err)
+	}
+	if err := Respond(mocks.NewResponseWithContent(`{"format": 1}`), ByValidatingAgainst(sc), ByClosing()); err == nil {
+		t.Fatal("autorest: ByValidatingAgainst failed to report a value of the wrong type")
+	}
+}
+
+func TestByValidatingAgainstSchemaUnsupportedKeyword(t *testing.T) {
+	err := Respond(mocks.NewResponseWithContent(`"x"`), ByValidatingAgainstSchema([]byte(`{"format": "uri"}`)), ByClosing())
+	if err == nil {
+		t.Fatal("autorest: ByValidatingAgainstSchema ignored an unsupported keyword")
+	}
+}
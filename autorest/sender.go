@@ -15,15 +15,22 @@ package autorest
 //  limitations under the License.
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"net"
 	"net/http"
 	"net/http/cookiejar"
-	"strconv"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -117,46 +124,357 @@ func Send(r *http.Request, decorators ...SendDecorator) (*http.Response, error)
 	return SendWithSender(sender(tls.RenegotiateNever), r, decorators...)
 }
 
+// SendWithContext sends the passed http.Request bound to the passed context, through the provided
+// Sender, returning the http.Response and possible error. Cancelling the context aborts the request
+// as well as any retry or polling performed by the SendDecorators, which then return ctx.Err().
+func SendWithContext(ctx context.Context, s Sender, r *http.Request, decorators ...SendDecorator) (*http.Response, error) {
+	return SendWithSender(s, r.WithContext(ctx), decorators...)
+}
+
 // SendWithSender sends the passed http.Request, through the provided Sender, returning the
 // http.Response and possible error. It also accepts a, possibly empty, set of SendDecorators which
 // it will apply the http.Client before invoking the Do method.
 //
 // SendWithSender will not poll or retry requests.
 func SendWithSender(s Sender, r *http.Request, decorators ...SendDecorator) (*http.Response, error) {
-	return DecorateSender(s, decorators...).Do(r)
+	return DecorateSender(withRequestTransport(s), decorators...).Do(r)
 }
 
 func sender(renengotiation tls.RenegotiationSupport) Sender {
 	// note that we can't init defaultSenders in init() since it will
 	// execute before calling code has had a chance to enable tracing
 	defaultSenders[renengotiation].init.Do(func() {
-		// copied from http.DefaultTransport with a TLS minimum version.
-		transport := &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
-			ForceAttemptHTTP2:     true,
-			MaxIdleConns:          100,
-			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-			TLSClientConfig: &tls.Config{
-				MinVersion:    tls.VersionTLS12,
-				Renegotiation: renengotiation,
-			},
-		}
-		var roundTripper http.RoundTripper = transport
-		if tracing.IsEnabled() {
-			roundTripper = tracing.NewTransport(transport)
-		}
-		j, _ := cookiejar.New(nil)
-		defaultSenders[renengotiation].sender = &http.Client{Jar: j, Transport: roundTripper}
+		defaultSenders[renengotiation].sender = NewSender(WithSenderRenegotiation(renengotiation))
 	})
 	return defaultSenders[renengotiation].sender
 }
 
+// SenderConfig holds the settings used by NewSender to create an http.Client.
+type SenderConfig struct {
+	// Timeout is the time limit for requests, including reading the response body. Zero means no
+	// limit, which is the default since long running operations are polled.
+	Timeout time.Duration
+
+	// DialTimeout is the time limit for establishing a connection.
+	DialTimeout time.Duration
+
+	// KeepAlive is the interval between keep-alive probes of active connections.
+	KeepAlive time.Duration
+
+	// TLSHandshakeTimeout is the time limit for the TLS handshake.
+	TLSHandshakeTimeout time.Duration
+
+	// ResponseHeaderTimeout, if non-zero, is the time limit for reading the response headers after
+	// writing the request.
+	ResponseHeaderTimeout time.Duration
+
+	// IdleConnTimeout is how long an idle connection remains open.
+	IdleConnTimeout time.Duration
+
+	// MaxIdleConns is the maximum number of idle connections across all hosts.
+	MaxIdleConns int
+
+	// MaxIdleConnsPerHost is the maximum number of idle connections kept per host.
+	MaxIdleConnsPerHost int
+
+	// ForceAttemptHTTP2 enables HTTP/2.
+	ForceAttemptHTTP2 bool
+
+	// Proxy returns the proxy to use for a request; nil means no proxy.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// Renegotiation controls client-side TLS renegotiation.
+	Renegotiation tls.RenegotiationSupport
+
+	// Jar, if not nil, stores the cookies of responses and adds them to requests.
+	Jar http.CookieJar
+
+	// Certificates are presented to servers that request a client certificate.
+	Certificates []tls.Certificate
+
+	// RootCAs are trusted, in addition to the system roots, to verify server certificates.
+	RootCAs []*x509.Certificate
+}
+
+// SenderOption configures the http.Client created by NewSender.
+type SenderOption func(*SenderConfig)
+
+// WithSenderTimeout sets the time limit for requests, including reading the response body.
+func WithSenderTimeout(d time.Duration) SenderOption {
+	return func(sc *SenderConfig) {
+		sc.Timeout = d
+	}
+}
+
+// WithSenderDialTimeout sets the time limit for establishing a connection.
+func WithSenderDialTimeout(d time.Duration) SenderOption {
+	return func(sc *SenderConfig) {
+		sc.DialTimeout = d
+	}
+}
+
+// WithSenderKeepAlive sets the interval between keep-alive probes of active connections.
+func WithSenderKeepAlive(d time.Duration) SenderOption {
+	return func(sc *SenderConfig) {
+		sc.KeepAlive = d
+	}
+}
+
+// WithSenderTLSHandshakeTimeout sets the time limit for the TLS handshake.
+func WithSenderTLSHandshakeTimeout(d time.Duration) SenderOption {
+	return func(sc *SenderConfig) {
+		sc.TLSHandshakeTimeout = d
+	}
+}
+
+// WithSenderResponseHeaderTimeout sets the time limit for reading the response headers.
+func WithSenderResponseHeaderTimeout(d time.Duration) SenderOption {
+	return func(sc *SenderConfig) {
+		sc.ResponseHeaderTimeout = d
+	}
+}
+
+// WithSenderIdleConnTimeout sets how long an idle connection remains open.
+func WithSenderIdleConnTimeout(d time.Duration) SenderOption {
+	return func(sc *SenderConfig) {
+		sc.IdleConnTimeout = d
+	}
+}
+
+// WithSenderMaxIdleConns sets the maximum number of idle connections across all hosts.
+func WithSenderMaxIdleConns(n int) SenderOption {
+	return func(sc *SenderConfig) {
+		sc.MaxIdleConns = n
+	}
+}
+
+// WithSenderMaxIdleConnsPerHost sets the maximum number of idle connections kept per host.
+func WithSenderMaxIdleConnsPerHost(n int) SenderOption {
+	return func(sc *SenderConfig) {
+		sc.MaxIdleConnsPerHost = n
+	}
+}
+
+// WithSenderHTTP2 enables or disables HTTP/2.
+func WithSenderHTTP2(enabled bool) SenderOption {
+	return func(sc *SenderConfig) {
+		sc.ForceAttemptHTTP2 = enabled
+	}
+}
+
+// WithSenderProxy sets the func returning the proxy to use for a request; nil disables proxies.
+func WithSenderProxy(proxy func(*http.Request) (*url.URL, error)) SenderOption {
+	return func(sc *SenderConfig) {
+		sc.Proxy = proxy
+	}
+}
+
+// WithSenderRenegotiation sets the client-side TLS renegotiation support.
+func WithSenderRenegotiation(renegotiation tls.RenegotiationSupport) SenderOption {
+	return func(sc *SenderConfig) {
+		sc.Renegotiation = renegotiation
+	}
+}
+
+// WithSenderCookieJar sets the cookie jar; nil disables cookies.
+func WithSenderCookieJar(jar http.CookieJar) SenderOption {
+	return func(sc *SenderConfig) {
+		sc.Jar = jar
+	}
+}
+
+// WithSenderClientCertificate adds certificates to present to servers that request a client
+// certificate.
+func WithSenderClientCertificate(certs ...tls.Certificate) SenderOption {
+	return func(sc *SenderConfig) {
+		sc.Certificates = append(sc.Certificates, certs...)
+	}
+}
+
+// WithSenderRootCAs adds certificate authorities to trust, in addition to the system roots, when
+// verifying server certificates.
+func WithSenderRootCAs(certs ...*x509.Certificate) SenderOption {
+	return func(sc *SenderConfig) {
+		sc.RootCAs = append(sc.RootCAs, certs...)
+	}
+}
+
+// NewSender returns an http.Client configured by the passed SenderOptions, applied in order over
+// the defaults used by the package's own Sender: a 30 second dial timeout and keep-alive, a 10
+// second TLS handshake timeout, 100 idle connections kept for 90 seconds of which up to 10 per
+// host, HTTP/2, the proxy from the environment, TLS 1.2 or later with the system roots and a
+// cookie jar. The transport honors DoWithProxy and, when tracing is enabled, is instrumented.
+func NewSender(options ...SenderOption) *http.Client {
+	sc := SenderConfig{
+		DialTimeout:         30 * time.Second,
+		KeepAlive:           30 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		ForceAttemptHTTP2:   true,
+		Proxy:               http.ProxyFromEnvironment,
+		Renegotiation:       tls.RenegotiateNever,
+	}
+	sc.Jar, _ = cookiejar.New(nil)
+	for _, option := range options {
+		option(&sc)
+	}
+	transport := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   sc.DialTimeout,
+			KeepAlive: sc.KeepAlive,
+		}).DialContext,
+		ForceAttemptHTTP2:     sc.ForceAttemptHTTP2,
+		MaxIdleConns:          sc.MaxIdleConns,
+		MaxIdleConnsPerHost:   sc.MaxIdleConnsPerHost,
+		IdleConnTimeout:       sc.IdleConnTimeout,
+		TLSHandshakeTimeout:   sc.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: sc.ResponseHeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig: &tls.Config{
+			MinVersion:    tls.VersionTLS12,
+			Renegotiation: sc.Renegotiation,
+			Certificates:  sc.Certificates,
+		},
+	}
+	if len(sc.RootCAs) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		for _, cert := range sc.RootCAs {
+			pool.AddCert(cert)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	transport.Proxy = ProxyWithOverride(sc.Proxy)
+	client := &http.Client{Jar: sc.Jar, Transport: instrumented(transport), Timeout: sc.Timeout}
+	if client.Transport != transport {
+		// remember the transport behind the instrumentation for withRequestTransport
+		instrumentedTransports.Store(client, transport)
+	}
+	return client
+}
+
+// instrumented returns the passed transport, instrumented when tracing is enabled.
+func instrumented(t *http.Transport) http.RoundTripper {
+	if tracing.IsEnabled() {
+		return tracing.NewTransport(t)
+	}
+	return t
+}
+
+// instrumentedTransports maps the http.Clients created by NewSender with an instrumented transport
+// to the underlying http.Transport.
+var instrumentedTransports sync.Map
+
+// hostOverride is the value WithHostOverride adds to the context of the requests it prepares: the
+// override host and the transports dedicated to it, one for each http.Transport the requests are
+// sent with, which live as long as the PrepareDecorator.
+type hostOverride struct {
+	host       string
+	mu         sync.Mutex
+	transports map[*http.Transport]http.RoundTripper
+}
+
+// withRequestTransport wraps the passed Sender so that, when it is an http.Client with an
+// http.Transport, requests prepared with WithHostOverride are sent through a clone of its transport
+// dedicated to the override host and requests traced by DoTracing through an instrumented
+// transport. Since transports pool connections by the host named in the URL, sharing one would let
+// connections made to the override host be reused by requests without the override, and the other
+// way round. Other Senders receive the request unchanged.
+func withRequestTransport(s Sender) Sender {
+	return SenderFunc(func(r *http.Request) (*http.Response, error) {
+		ho, override := r.Context().Value(ctxHostOverride{}).(*hostOverride)
+		traced := r.Context().Value(ctxTraced{}) != nil && tracing.IsEnabled()
+		if !override && !traced {
+			return s.Do(r)
+		}
+		hc, ok := s.(*http.Client)
+		if !ok {
+			return s.Do(r)
+		}
+		t, ok := hc.Transport.(*http.Transport)
+		if !ok {
+			v, found := instrumentedTransports.Load(hc)
+			if !found || !override {
+				return s.Do(r)
+			}
+			t = v.(*http.Transport)
+		}
+		c := *hc
+		if override {
+			c.Transport = ho.transport(t)
+		} else {
+			c.Transport = tracedTransport(t)
+		}
+		return c.Do(r)
+	})
+}
+
+// transport returns the clone of the passed transport that connects to the override host, bypassing
+// any proxy, creating it on first use. When the override does not include a port the port of the
+// original address is used.
+func (ho *hostOverride) transport(t *http.Transport) http.RoundTripper {
+	ho.mu.Lock()
+	defer ho.mu.Unlock()
+	if rt, ok := ho.transports[t]; ok {
+		return rt
+	}
+	host := ho.host
+	clone := t.Clone()
+	dial := clone.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	clone.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if _, _, err := net.SplitHostPort(host); err == nil {
+			addr = host
+		} else if _, port, err := net.SplitHostPort(addr); err == nil {
+			addr = net.JoinHostPort(host, port)
+		}
+		return dial(ctx, network, addr)
+	}
+	clone.Proxy = nil
+	if ho.transports == nil {
+		ho.transports = map[*http.Transport]http.RoundTripper{}
+	}
+	rt := instrumented(clone)
+	ho.transports[t] = rt
+	return rt
+}
+
+// used as a key type in context.WithValue()
+type ctxProxy struct{}
+
+// DoWithProxy returns a SendDecorator that sends requests through the proxy at the passed URL, or
+// directly if the URL is nil, instead of the proxy configured on the transport. Combine it with DoIf
+// to route only matching requests (e.g. data-plane traffic) through a different egress path. The
+// override is honored by the default sender, senders created with NewSender and any http.Transport
+// whose Proxy is wrapped with ProxyWithOverride.
+func DoWithProxy(proxyURL *url.URL) SendDecorator {
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			return s.Do(r.WithContext(context.WithValue(r.Context(), ctxProxy{}, proxyURL)))
+		})
+	}
+}
+
+// ProxyWithOverride wraps the passed proxy function, typically an http.Transport's Proxy, so that
+// requests sent through DoWithProxy use the proxy passed to it. A nil proxy function means no proxy.
+func ProxyWithOverride(proxy func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(r *http.Request) (*url.URL, error) {
+		if u, ok := r.Context().Value(ctxProxy{}).(*url.URL); ok {
+			return u, nil
+		}
+		if proxy == nil {
+			return nil, nil
+		}
+		return proxy(r)
+	}
+}
+
 // AfterDelay returns a SendDecorator that delays for the passed time.Duration before
 // invoking the Sender. The delay may be terminated by closing the optional channel on the
 // http.Request. If canceled, no further Senders are invoked.
@@ -180,14 +498,168 @@ func AsIs() SendDecorator {
 	}
 }
 
+// DoIf returns a SendDecorator that applies the passed SendDecorator only when condition returns
+// true for the http.Request being sent; otherwise the request is passed unmodified to the Sender.
+func DoIf(condition func(*http.Request) bool, decorator SendDecorator) SendDecorator {
+	return func(s Sender) Sender {
+		decorated := decorator(s)
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			if condition(r) {
+				return decorated.Do(r)
+			}
+			return s.Do(r)
+		})
+	}
+}
+
+// DoRateLimit returns a SendDecorator that limits the rate of requests to rps requests per second
+// with bursts of up to burst requests, using a token bucket shared by all requests sent through
+// the returned SendDecorator. Requests over the limit wait for a token; the wait may be canceled
+// by cancelling the context on the http.Request, in which case the context's error is returned.
+// A rate of zero or less disables the limit. To limit each host separately, create one
+// SendDecorator per host and select between them with DoIf.
+func DoRateLimit(rps float64, burst int) SendDecorator {
+	if rps <= 0 {
+		return AsIs()
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	tb := &tokenBucket{rate: rps, burst: float64(burst), tokens: float64(burst), last: DefaultClock.Now()}
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			if d := tb.reserve(); d > 0 {
+				select {
+				case <-DefaultClock.After(d):
+				case <-r.Context().Done():
+					tb.cancel()
+					return nil, r.Context().Err()
+				}
+			}
+			return s.Do(r)
+		})
+	}
+}
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token and returns how long to wait before it becomes available.
+func (tb *tokenBucket) reserve() time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	now := DefaultClock.Now()
+	tb.tokens = math.Min(tb.burst, tb.tokens+now.Sub(tb.last).Seconds()*tb.rate)
+	tb.last = now
+	tb.tokens--
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// take takes a token if one is available, without waiting.
+func (tb *tokenBucket) take() bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	now := DefaultClock.Now()
+	tb.tokens = math.Min(tb.burst, tb.tokens+now.Sub(tb.last).Seconds()*tb.rate)
+	tb.last = now
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+	return true
+}
+
+// cancel returns a token taken by reserve that was not used.
+func (tb *tokenBucket) cancel() {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.tokens = math.Min(tb.burst, tb.tokens+1)
+}
+
+// ThrottleState records, per host, until when a service has asked clients to stop sending
+// requests with a 429 response carrying a Retry-After header. It is safe for concurrent use. The
+// zero value is an empty ThrottleState ready to use.
+type ThrottleState struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+// NewThrottleState creates an empty ThrottleState.
+func NewThrottleState() *ThrottleState {
+	return &ThrottleState{until: map[string]time.Time{}}
+}
+
+// Wait returns how long requests to the passed host must still wait.
+func (ts *ThrottleState) Wait(host string) time.Duration {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	until, ok := ts.until[host]
+	if !ok {
+		return 0
+	}
+	d := until.Sub(DefaultClock.Now())
+	if d <= 0 {
+		delete(ts.until, host)
+		return 0
+	}
+	return d
+}
+
+// throttle records that requests to the passed host must wait for the passed duration.
+func (ts *ThrottleState) throttle(host string, d time.Duration) {
+	until := DefaultClock.Now().Add(d)
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.until == nil {
+		ts.until = map[string]time.Time{}
+	}
+	if until.After(ts.until[host]) {
+		ts.until[host] = until
+	}
+}
+
+// DoThrottle returns a SendDecorator that shares throttling across requests: when a response has
+// status code 429 and a Retry-After header (see GetRetryAfter), later requests to the same host
+// sent through the passed ThrottleState wait out the remaining interval before being sent. The
+// wait may be canceled by cancelling the context on the http.Request, in which case the context's
+// error is returned. Place it before the retry decorators in the list passed to SendWithSender.
+func DoThrottle(ts *ThrottleState) SendDecorator {
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			if d := ts.Wait(r.URL.Host); d > 0 {
+				logger.Instance.Writef(logger.LogInfo, "DoThrottle: waiting %s for %s\n", d, r.URL.Host)
+				if !delay(d, r.Context().Done()) {
+					return nil, r.Context().Err()
+				}
+			}
+			resp, err := s.Do(r)
+			if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+				if d := retryAfterDelay(resp); d > 0 {
+					ts.throttle(r.URL.Host, d)
+				}
+			}
+			return resp, err
+		})
+	}
+}
+
 // DoCloseIfError returns a SendDecorator that first invokes the passed Sender after which
-// it closes the response if the passed Sender returns an error and the response body exists.
+// it drains and closes the response (see DrainResponseBody) if the passed Sender returns an error
+// and the response body exists.
 func DoCloseIfError() SendDecorator {
 	return func(s Sender) Sender {
 		return SenderFunc(func(r *http.Request) (*http.Response, error) {
 			resp, err := s.Do(r)
 			if err != nil {
-				Respond(resp, ByDiscardingBody(), ByClosing())
+				DrainResponseBody(resp)
 			}
 			return resp, err
 		})
@@ -230,54 +702,387 @@ func DoErrorUnlessStatusCode(codes ...int) SendDecorator {
 	}
 }
 
+// DoErrorIfStatusCodeInRange returns a SendDecorator that emits an error if the response StatusCode
+// is within one of the passed ranges. Since these are artificial errors, the response body may still
+// require closing.
+func DoErrorIfStatusCodeInRange(ranges ...StatusCodeRange) SendDecorator {
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			resp, err := s.Do(r)
+			if err == nil && ResponseHasStatusCodeInRange(resp, ranges...) {
+				err = NewErrorWithResponse("autorest", "DoErrorIfStatusCodeInRange", resp, "%v %v failed with %s",
+					resp.Request.Method,
+					resp.Request.URL,
+					resp.Status)
+			}
+			return resp, err
+		})
+	}
+}
+
+// DoErrorUnlessStatusCodeInRange returns a SendDecorator that emits an error unless the response
+// StatusCode is within one of the passed ranges. Since these are artificial errors, the response body
+// may still require closing.
+func DoErrorUnlessStatusCodeInRange(ranges ...StatusCodeRange) SendDecorator {
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			resp, err := s.Do(r)
+			if err == nil && !ResponseHasStatusCodeInRange(resp, ranges...) {
+				err = NewErrorWithResponse("autorest", "DoErrorUnlessStatusCodeInRange", resp, "%v %v failed with %s",
+					resp.Request.Method,
+					resp.Request.URL,
+					resp.Status)
+			}
+			return resp, err
+		})
+	}
+}
+
 // DoPollForStatusCodes returns a SendDecorator that polls if the http.Response contains one of the
 // passed status codes. It expects the http.Response to contain a Location header providing the
 // URL at which to poll (using GET) and will poll until the time passed is equal to or greater than
 // the supplied duration. It will delay between requests for the duration specified in the
 // RetryAfter header or, if the header is absent, the passed delay. Polling may be canceled by
-// closing the optional channel on the http.Request.
+// closing the optional channel on the http.Request. To poll Azure-AsyncOperation status monitors use
+// DoPollForAsyncOperation, and to poll 201 Created responses use DoPollForCreated.
 func DoPollForStatusCodes(duration time.Duration, delay time.Duration, codes ...int) SendDecorator {
 	return func(s Sender) Sender {
-		return SenderFunc(func(r *http.Request) (resp *http.Response, err error) {
-			resp, err = s.Do(r)
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			resp, err := s.Do(r)
+			if err != nil {
+				return resp, err
+			}
+			return PollUntilDone(r.Context(), s, resp, delay, codes...)
+		})
+	}
+}
 
-			if err == nil && ResponseHasStatusCode(resp, codes...) {
-				r, err = NewPollingRequestWithContext(r.Context(), resp)
-
-				for err == nil && ResponseHasStatusCode(resp, codes...) {
-					Respond(resp,
-						ByDiscardingBody(),
-						ByClosing())
-					resp, err = SendWithSender(s, r,
-						AfterDelay(GetRetryAfter(resp, delay)))
-				}
+// PollUntilDone polls, using the passed Sender, the long-running operation started by the passed
+// response while the responses contain one of the passed status codes, as DoPollForStatusCodes
+// does, and returns the final response. It returns the passed response if its status code is not
+// among those passed. It returns ctx.Err() as soon as the context is done, whether it is waiting
+// between polls or a poll is in flight.
+func PollUntilDone(ctx context.Context, s Sender, resp *http.Response, delay time.Duration, codes ...int) (*http.Response, error) {
+	return PollUntilDoneWithStrategy(ctx, s, resp, RetryAfterPolling(FixedPolling(delay)), codes...)
+}
+
+// DoPollWithStrategy returns a SendDecorator that polls, as DoPollForStatusCodes does, if the
+// http.Response contains one of the passed status codes, waiting before each poll for the delay
+// chosen by the passed PollingStrategy.
+func DoPollWithStrategy(strategy PollingStrategy, codes ...int) SendDecorator {
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			resp, err := s.Do(r)
+			if err != nil {
+				return resp, err
 			}
+			return PollUntilDoneWithStrategy(r.Context(), s, resp, strategy, codes...)
+		})
+	}
+}
 
-			return resp, err
+// PollUntilDoneWithStrategy is PollUntilDone waiting before each poll for the delay chosen by the
+// passed PollingStrategy, e.g. ExponentialPolling so long operations don't poll the status endpoint
+// at a fixed, short interval.
+func PollUntilDoneWithStrategy(ctx context.Context, s Sender, resp *http.Response, strategy PollingStrategy, codes ...int) (*http.Response, error) {
+	return PollUntilDoneWithStatusCodes(ctx, s, resp, strategy, PollingStatusCodes{InProgress: codes})
+}
+
+// DoPollWithStatusCodes returns a SendDecorator that polls, as DoPollWithStrategy does, if the
+// http.Response contains one of the passed InProgress status codes, classifying the responses to
+// the polling requests with the passed PollingStatusCodes. See PollUntilDoneWithStatusCodes.
+func DoPollWithStatusCodes(strategy PollingStrategy, codes PollingStatusCodes) SendDecorator {
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			resp, err := s.Do(r)
+			if err != nil {
+				return resp, err
+			}
+			return PollUntilDoneWithStatusCodes(r.Context(), s, resp, strategy, codes)
 		})
 	}
 }
 
+// PollUntilDoneWithStatusCodes is PollUntilDoneWithStrategy polling while the responses contain one
+// of the InProgress status codes. It stops once a response contains one of the Succeeded status
+// codes, or returns an error once one contains one of the Failed status codes; the status code takes
+// precedence over any operation status or provisioning state in the response body. If Succeeded
+// codes are passed, a response whose status code is not among those passed is also an error.
+func PollUntilDoneWithStatusCodes(ctx context.Context, s Sender, resp *http.Response, strategy PollingStrategy, codes PollingStatusCodes) (*http.Response, error) {
+	return pollUntilDone(ctx, s, resp, strategy, codes, false, false)
+}
+
+// DoPollForAsyncOperation returns a SendDecorator that polls, per the Azure Resource Manager
+// long-running operation guidelines, the operation started by a 201 Created or 202 Accepted
+// response carrying an Azure-AsyncOperation header: the status monitor at that URL is polled
+// (using GET) until the OperationStatus in its body is terminal, returning an error if the
+// operation Failed or was Canceled. Once it has Succeeded, the result is fetched with a final GET
+// of the original URL for PUT and PATCH requests, or of the Location header, if any, for POST
+// requests; otherwise the status monitor's response is returned. Responses without the header are
+// polled at their Location while they contain one of the passed status codes, as
+// DoPollWithStrategy does. The delay before each poll is chosen by the passed PollingStrategy.
+func DoPollForAsyncOperation(strategy PollingStrategy, codes ...int) SendDecorator {
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			resp, err := s.Do(r)
+			if err != nil {
+				return resp, err
+			}
+			return pollUntilDone(r.Context(), s, resp, strategy, PollingStatusCodes{InProgress: codes}, true, false)
+		})
+	}
+}
+
+// DoPollForCreated returns a SendDecorator that polls, as DoPollWithStrategy does, the long-running
+// operations for which ResponseRequiresPolling returns true, including the 201 Created with a
+// Location header that many Azure Resource Manager PUT operations return while the resource is
+// provisioned. The Location of a 201 Created is polled until it returns a 200 OK with a terminal
+// properties.provisioningState (or none), returning an error if provisioning Failed or was
+// Canceled. If no status codes are passed, 202 Accepted is polled.
+func DoPollForCreated(strategy PollingStrategy, codes ...int) SendDecorator {
+	if len(codes) == 0 {
+		codes = []int{http.StatusAccepted}
+	}
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			resp, err := s.Do(r)
+			if err != nil || !ResponseRequiresPolling(resp, codes...) {
+				return resp, err
+			}
+			return pollUntilDone(r.Context(), s, resp, strategy, PollingStatusCodes{InProgress: codes}, false, true)
+		})
+	}
+}
+
+// pollUntilDone implements PollUntilDoneWithStatusCodes, also polling Azure-AsyncOperation status
+// monitors as DoPollForAsyncOperation describes when asyncOperation is true, and 201 Created
+// responses as DoPollForCreated describes when created is true.
+func pollUntilDone(ctx context.Context, s Sender, resp *http.Response, strategy PollingStrategy, codes PollingStatusCodes, asyncOperation, created bool) (*http.Response, error) {
+	asyncOperation = asyncOperation && resp.Header.Get(HeaderAzureAsyncOperation) != "" &&
+		ResponseHasStatusCode(resp, http.StatusCreated, http.StatusAccepted)
+	created = created && !asyncOperation && isCreatedWithLocation(resp)
+	if !asyncOperation && !created && !ResponseHasStatusCode(resp, codes.InProgress...) {
+		return resp, nil
+	}
+	var r, final *http.Request
+	var err error
+	if asyncOperation {
+		final, err = finalOperationRequest(ctx, resp)
+		if err == nil {
+			r, err = newPollingRequest(ctx, resp, resp.Header.Get(HeaderAzureAsyncOperation))
+		}
+	} else {
+		r, err = NewPollingRequestWithContext(ctx, resp)
+	}
+	for poll, attempt := err == nil, 1; poll; attempt++ {
+		DrainResponseBody(resp)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return resp, ctxErr
+		}
+		resp, err = SendWithSender(s, r,
+			AfterDelay(strategy.NextDelay(attempt, resp)))
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			if resp != nil {
+				DrainResponseBody(resp)
+			}
+			return resp, ctxErr
+		}
+		if err != nil {
+			break
+		}
+		switch {
+		case ResponseHasStatusCode(resp, codes.InProgress...) || (created && ResponseHasStatusCode(resp, http.StatusCreated, http.StatusAccepted)):
+			poll = true
+		case ResponseHasStatusCode(resp, codes.Failed...):
+			poll, err = false, NewErrorWithResponse("autorest", "DoPollForStatusCodes", resp, "long-running operation failed with %s", resp.Status)
+		case ResponseHasStatusCode(resp, codes.Succeeded...):
+			poll = false
+		case resp.StatusCode == http.StatusOK && asyncOperation:
+			poll, err = pollOperationStatus(resp)
+		case resp.StatusCode == http.StatusOK && created:
+			poll, err = pollProvisioningState(resp)
+		case len(codes.Succeeded) > 0:
+			poll, err = false, NewErrorWithResponse("autorest", "DoPollForStatusCodes", resp, "long-running operation returned unexpected status %s", resp.Status)
+		default:
+			poll = false
+		}
+	}
+	if err != nil || final == nil {
+		return resp, err
+	}
+	DrainResponseBody(resp)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return resp, ctxErr
+	}
+	return SendWithSender(s, final)
+}
+
+// finalOperationRequest returns the request fetching the result of the Azure-AsyncOperation started
+// by the passed response once it has succeeded: a GET of the original URL for PUT and PATCH
+// requests or of the Location header for POST requests. It returns nil for other requests, whose
+// result is the status monitor's response.
+func finalOperationRequest(ctx context.Context, resp *http.Response) (*http.Request, error) {
+	if resp.Request == nil {
+		return nil, nil
+	}
+	switch resp.Request.Method {
+	case http.MethodPut, http.MethodPatch:
+		return newPollingRequest(ctx, resp, resp.Request.URL.String())
+	case http.MethodPost:
+		if location := GetLocation(resp); location != "" {
+			return newPollingRequest(ctx, resp, location)
+		}
+	}
+	return nil, nil
+}
+
+// DoPollForProvisioningState returns a SendDecorator that, for PUT and PATCH requests whose
+// response is a 200 OK or 201 Created holding a resource with a non-terminal
+// properties.provisioningState, polls the resource (using GET) until its provisioning state is
+// Succeeded, Failed or Canceled, as many Azure Resource Manager resources complete in place
+// without returning 202 Accepted. It returns an error if provisioning Failed or was Canceled. It
+// will delay between requests for the duration specified in the RetryAfter header or, if the header
+// is absent, the passed delay. Polling stops when the request context is done.
+func DoPollForProvisioningState(delay time.Duration) SendDecorator {
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			resp, err := s.Do(r)
+			if err != nil || (r.Method != http.MethodPut && r.Method != http.MethodPatch) {
+				return resp, err
+			}
+			var req *http.Request
+			for ResponseHasStatusCode(resp, http.StatusOK, http.StatusCreated) {
+				state, err := GetProvisioningState(resp)
+				if err != nil {
+					return resp, err
+				}
+				if state == "" || strings.EqualFold(state, OperationStatusSucceeded) {
+					return resp, nil
+				}
+				if IsTerminalProvisioningState(state) {
+					return resp, NewErrorWithResponse("autorest", "DoPollForProvisioningState", resp, "provisioning %s", state)
+				}
+				if req == nil {
+					req = r.Clone(r.Context())
+					req.Method = http.MethodGet
+					req.Body = nil
+					req.GetBody = nil
+					req.ContentLength = 0
+					req.Header.Del(headerContentType)
+					req.Header.Del(headerContentLength)
+				}
+				DrainResponseBody(resp)
+				if ctxErr := r.Context().Err(); ctxErr != nil {
+					return resp, ctxErr
+				}
+				resp, err = SendWithSender(s, req,
+					AfterDelay(GetRetryAfter(resp, delay)))
+				if ctxErr := r.Context().Err(); ctxErr != nil {
+					return resp, ctxErr
+				}
+				if err != nil {
+					return resp, err
+				}
+			}
+			return resp, nil
+		})
+	}
+}
+
+// pollOperationStatus returns true if the OperationStatus in the passed response is not terminal,
+// or an error if the operation Failed or was Canceled.
+func pollOperationStatus(resp *http.Response) (bool, error) {
+	status, err := GetOperationStatus(resp)
+	if err != nil {
+		return false, err
+	}
+	if !status.IsTerminal() {
+		return true, nil
+	}
+	if status.HasSucceeded() {
+		return false, nil
+	}
+	if status.Error != nil {
+		return false, NewErrorWithResponse("autorest", "DoPollForStatusCodes", resp, "long-running operation %s: %s: %s", status.Status, status.Error.Code, status.Error.Message)
+	}
+	return false, NewErrorWithResponse("autorest", "DoPollForStatusCodes", resp, "long-running operation %s", status.Status)
+}
+
+// pollProvisioningState returns true if the properties.provisioningState of the resource in the
+// passed response is not terminal, or an error if provisioning Failed or was Canceled.
+func pollProvisioningState(resp *http.Response) (bool, error) {
+	state, err := GetProvisioningState(resp)
+	if err != nil {
+		return false, err
+	}
+	if state == "" || strings.EqualFold(state, OperationStatusSucceeded) {
+		return false, nil
+	}
+	if !IsTerminalProvisioningState(state) {
+		return true, nil
+	}
+	return false, NewErrorWithResponse("autorest", "DoPollForStatusCodes", resp, "provisioning %s", state)
+}
+
+// used as a key type in context.WithValue()
+type ctxAttempt struct{}
+
+// withAttempt records on the request the 1-based number of the attempt the retry decorators are
+// making, for SendDecorators further down the chain.
+func withAttempt(r *http.Request, attempt int) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), ctxAttempt{}, attempt))
+}
+
+// sendAttempt sends the passed request as the passed attempt, recording it for GetAttempts when
+// the request is sent through DoTimeAttempts.
+func sendAttempt(s Sender, r *http.Request, attempt int) (*http.Response, error) {
+	r = withAttempt(r, attempt)
+	log, ok := r.Context().Value(ctxAttemptLog{}).(*attemptLog)
+	if !ok {
+		return s.Do(r)
+	}
+	start := DefaultClock.Now()
+	resp, err := s.Do(r)
+	log.add(attempt, start, resp, err)
+	return resp, err
+}
+
+// requestAttempt returns the number of the attempt recorded with withAttempt, or one if the request
+// is not being retried.
+func requestAttempt(r *http.Request) int {
+	if a, ok := r.Context().Value(ctxAttempt{}).(int); ok {
+		return a
+	}
+	return 1
+}
+
 // DoRetryForAttempts returns a SendDecorator that retries a failed request for up to the specified
 // number of attempts, exponentially backing off between requests using the supplied backoff
-// time.Duration (which may be zero). Retrying may be canceled by closing the optional channel on
-// the http.Request.
+// time.Duration (which may be zero). Retrying stops once the context on the http.Request is
+// canceled, returning the context's error.
 func DoRetryForAttempts(attempts int, backoff time.Duration) SendDecorator {
 	return func(s Sender) Sender {
 		return SenderFunc(func(r *http.Request) (resp *http.Response, err error) {
-			rr := NewRetriableRequest(r)
 			for attempt := 0; attempt < attempts; attempt++ {
-				err = rr.Prepare()
+				if ctxErr := r.Context().Err(); ctxErr != nil {
+					DrainResponseBody(resp)
+					return nil, ctxErr
+				}
+				var req *http.Request
+				req, err = CloneRequest(r)
 				if err != nil {
 					return resp, err
 				}
 				DrainResponseBody(resp)
-				resp, err = s.Do(rr.Request())
+				resp, err = sendAttempt(s, req, attempt+1)
 				if err == nil {
 					return resp, err
 				}
 				logger.Instance.Writef(logger.LogError, "DoRetryForAttempts: received error for attempt %d: %v\n", attempt+1, err)
-				if !DelayForBackoff(backoff, attempt, r.Context().Done()) {
+				if attempt+1 == attempts || !retryAllowed(r) {
+					return resp, err
+				}
+				if !delayForRetry(r, attempt+1, resp, err, retryDelay(resp, backoff, 0, attempt)) {
 					return nil, r.Context().Err()
 				}
 			}
@@ -292,9 +1097,14 @@ var Count429AsRetry = true
 // Max429Delay is the maximum duration to wait between retries on a 429 if no Retry-After header was received.
 var Max429Delay time.Duration
 
+// MaxRetryAfterDelay is the maximum duration to wait for a delay requested by a response's Retry-After
+// header. The default value of zero does not cap the delay.
+var MaxRetryAfterDelay time.Duration
+
 // DoRetryForStatusCodes returns a SendDecorator that retries for specified statusCodes for up to the specified
 // number of attempts, exponentially backing off between requests using the supplied backoff
 // time.Duration (which may be zero). Retrying may be canceled by cancelling the context on the http.Request.
+// If no statusCodes are specified, StatusCodesForRetry is used. Intermediate response bodies are drained and closed.
 // NOTE: Code http.StatusTooManyRequests (429) will *not* be counted against the number of attempts.
 func DoRetryForStatusCodes(attempts int, backoff time.Duration, codes ...int) SendDecorator {
 	return func(s Sender) Sender {
@@ -308,6 +1118,7 @@ func DoRetryForStatusCodes(attempts int, backoff time.Duration, codes ...int) Se
 // specified number of attempts, exponentially backing off between requests using the supplied backoff
 // time.Duration (which may be zero). To cap the maximum possible delay between iterations specify a value greater
 // than zero for cap. Retrying may be canceled by cancelling the context on the http.Request.
+// If no statusCodes are specified, StatusCodesForRetry is used.
 func DoRetryForStatusCodesWithCap(attempts int, backoff, cap time.Duration, codes ...int) SendDecorator {
 	return func(s Sender) Sender {
 		return SenderFunc(func(r *http.Request) (*http.Response, error) {
@@ -317,15 +1128,21 @@ func DoRetryForStatusCodesWithCap(attempts int, backoff, cap time.Duration, code
 }
 
 func doRetryForStatusCodesImpl(s Sender, r *http.Request, count429 bool, attempts int, backoff, cap time.Duration, codes ...int) (resp *http.Response, err error) {
-	rr := NewRetriableRequest(r)
+	if len(codes) == 0 {
+		codes = StatusCodesForRetry
+	}
 	// Increment to add the first call (attempts denotes number of retries)
 	for attempt, delayCount := 0, 0; attempt < attempts+1; {
-		err = rr.Prepare()
+		if ctxErr := r.Context().Err(); ctxErr != nil {
+			return resp, ctxErr
+		}
+		var req *http.Request
+		req, err = CloneRequest(r)
 		if err != nil {
 			return
 		}
 		DrainResponseBody(resp)
-		resp, err = s.Do(rr.Request())
+		resp, err = sendAttempt(s, req, delayCount+1)
 		// we want to retry if err is not nil (e.g. transient network failure).  note that for failed authentication
 		// resp and err will both have a value, so in this case we don't want to retry as it will never succeed.
 		if err == nil && !ResponseHasStatusCode(resp, codes...) || IsTokenRefreshError(err) {
@@ -334,18 +1151,21 @@ func doRetryForStatusCodesImpl(s Sender, r *http.Request, count429 bool, attempt
 		if err != nil {
 			logger.Instance.Writef(logger.LogError, "DoRetryForStatusCodes: received error for attempt %d: %v\n", attempt+1, err)
 		}
-		delayed := DelayWithRetryAfter(resp, r.Context().Done())
 		// if this was a 429 set the delay cap as specified.
 		// applicable only in the absence of a retry-after header.
 		if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
 			cap = Max429Delay
 		}
-		if !delayed && !DelayForBackoffWithCap(backoff, cap, delayCount, r.Context().Done()) {
-			return resp, r.Context().Err()
-		}
 		// when count429 == false don't count a 429 against the number
 		// of attempts so that we continue to retry until it succeeds
-		if count429 || (resp == nil || resp.StatusCode != http.StatusTooManyRequests) {
+		counted := count429 || resp == nil || resp.StatusCode != http.StatusTooManyRequests
+		if counted && attempt+1 == attempts+1 || !retryAllowed(r) {
+			return resp, err
+		}
+		if !delayForRetry(r, delayCount+1, resp, err, retryDelay(resp, backoff, cap, delayCount)) {
+			return resp, r.Context().Err()
+		}
+		if counted {
 			attempt++
 		}
 		// delay count is tracked separately from attempts to
@@ -355,79 +1175,426 @@ func doRetryForStatusCodesImpl(s Sender, r *http.Request, count429 bool, attempt
 	return resp, err
 }
 
-// DelayWithRetryAfter invokes time.After for the duration specified in the "Retry-After" header.
-// The value of Retry-After can be either the number of seconds or a date in RFC1123 format.
+// DelayWithRetryAfter invokes DefaultClock.After for the duration specified in the "retry-after-ms",
+// "x-ms-retry-after-ms" or "Retry-After" header (see GetRetryAfter), capped by MaxRetryAfterDelay.
+// The value of Retry-After can be either the number of seconds or an HTTP-date.
 // The function returns true after successfully waiting for the specified duration.  If there is
 // no Retry-After header or the wait is cancelled the return value is false.
 func DelayWithRetryAfter(resp *http.Response, cancel <-chan struct{}) bool {
 	if resp == nil {
 		return false
 	}
-	var dur time.Duration
-	ra := resp.Header.Get("Retry-After")
-	if retryAfter, _ := strconv.Atoi(ra); retryAfter > 0 {
-		dur = time.Duration(retryAfter) * time.Second
-	} else if t, err := time.Parse(time.RFC1123, ra); err == nil {
-		dur = t.Sub(time.Now())
+	if dur := retryAfterDelay(resp); dur > 0 {
+		return delay(dur, cancel)
 	}
-	if dur > 0 {
-		select {
-		case <-time.After(dur):
-			return true
-		case <-cancel:
-			return false
-		}
+	return false
+}
+
+// retryAfterDelay returns the delay requested by the passed response, capped by MaxRetryAfterDelay,
+// or zero if there is none.
+func retryAfterDelay(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	dur, _ := retryAfter(resp)
+	if MaxRetryAfterDelay > 0 && dur > MaxRetryAfterDelay {
+		dur = MaxRetryAfterDelay
+	}
+	return dur
+}
+
+// OnRetryFunc is called by the retry SendDecorators after a failed attempt, before waiting delay to
+// make the next one. attempt is the 1-based number of the failed attempt and resp and err are
+// what it returned. To stop retrying, cancel the context on the http.Request.
+type OnRetryFunc func(attempt int, resp *http.Response, err error, delay time.Duration)
+
+// used as a key type in context.WithValue()
+type ctxRetryCallback struct{}
+
+// WithRetryCallback returns a context holding the passed OnRetryFunc, which DoRetryForAttempts,
+// DoRetryForDuration, DoRetryForStatusCodes and DoRetryForStatusCodesWithCap call for requests
+// that carry the context. If onRetry is nil the context is unchanged.
+func WithRetryCallback(ctx context.Context, onRetry OnRetryFunc) context.Context {
+	if onRetry == nil {
+		return ctx
 	}
+	return context.WithValue(ctx, ctxRetryCallback{}, onRetry)
+}
+
+// RetryBudget limits the rate of retries across all the requests that share it, so that during a
+// widespread outage retries don't multiply the load on the service. Each retry takes a token;
+// tokens are refilled at a fixed rate up to a maximum. When no token is available, the retry
+// SendDecorators return the result of the failed attempt instead of retrying.
+type RetryBudget struct {
+	tb *tokenBucket
+}
+
+// NewRetryBudget creates a RetryBudget allowing, on average, rps retries per second with bursts of
+// up to burst retries.
+func NewRetryBudget(rps float64, burst int) *RetryBudget {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RetryBudget{tb: &tokenBucket{rate: rps, burst: float64(burst), tokens: float64(burst), last: DefaultClock.Now()}}
+}
+
+// Allow takes a token for a retry, returning false if none is available.
+func (rb *RetryBudget) Allow() bool {
+	return rb.tb.take()
+}
+
+// used as a key type in context.WithValue()
+type ctxRetryBudget struct{}
+
+// WithRetryBudget returns a context holding the passed RetryBudget, which DoRetryForAttempts,
+// DoRetryForDuration, DoRetryUntil, DoRetryForStatusCodes and DoRetryForStatusCodesWithCap consult
+// before each retry of requests that carry the context. If rb is nil the context is unchanged.
+// Client.Send adds the Client's RetryBudget.
+func WithRetryBudget(ctx context.Context, rb *RetryBudget) context.Context {
+	if rb == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxRetryBudget{}, rb)
+}
+
+// retryAllowed reports whether the RetryBudget in the request's context, if any, allows a retry.
+func retryAllowed(r *http.Request) bool {
+	rb, ok := r.Context().Value(ctxRetryBudget{}).(*RetryBudget)
+	if !ok || rb.Allow() {
+		return true
+	}
+	logger.Instance.Writeln(logger.LogWarning, "retryAllowed: retry budget exhausted")
 	return false
 }
 
+// retryDelay returns the delay before the next attempt: the delay requested by the response's
+// Retry-After or else the exponential backoff for the passed zero-based attempt.
+func retryDelay(resp *http.Response, backoff, cap time.Duration, backoffAttempt int) time.Duration {
+	if d := retryAfterDelay(resp); d > 0 {
+		return d
+	}
+	return backoffDelay(backoff, cap, backoffAttempt)
+}
+
+// delayForRetry waits the passed delay before the next attempt to send the passed request, after
+// reporting the failed attempt to any OnRetryFunc in the request's context. It returns false if
+// the wait was canceled.
+func delayForRetry(r *http.Request, attempt int, resp *http.Response, err error, d time.Duration) bool {
+	if onRetry, ok := r.Context().Value(ctxRetryCallback{}).(OnRetryFunc); ok {
+		onRetry(attempt, resp, err, d)
+	}
+	logger.Instance.Writef(logger.LogInfo, "delayForRetry: sleeping for %s\n", d)
+	return delay(d, r.Context().Done())
+}
+
+// ErrRetryDeadlineExceeded is returned, wrapping the error of the last attempt, by DoRetryForDuration
+// and DoRetryUntil when waiting for and making another attempt would not complete before the deadline.
+var ErrRetryDeadlineExceeded = errors.New("autorest: retry deadline exceeded")
+
+type retryDeadlineError struct {
+	err error
+}
+
+func (e retryDeadlineError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrRetryDeadlineExceeded, e.err)
+}
+
+func (e retryDeadlineError) Is(target error) bool {
+	return target == ErrRetryDeadlineExceeded
+}
+
+func (e retryDeadlineError) Unwrap() error {
+	return e.err
+}
+
 // DoRetryForDuration returns a SendDecorator that retries the request until the total time is equal
 // to or greater than the specified duration, exponentially backing off between requests using the
-// supplied backoff time.Duration (which may be zero). Retrying may be canceled by closing the
-// optional channel on the http.Request.
+// supplied backoff time.Duration (which may be zero). If the next backoff plus an attempt taking as
+// long as the last one would end after the duration, it stops early, rather than sleeping, and
+// returns an error wrapping ErrRetryDeadlineExceeded. Retrying stops once the context on the http.Request is canceled,
+// returning the context's error.
 func DoRetryForDuration(d time.Duration, backoff time.Duration) SendDecorator {
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			return doRetryUntil(s, r, DefaultClock.Now().Add(d), backoff)
+		})
+	}
+}
+
+// DoRetryUntil returns a SendDecorator that, like DoRetryForDuration, retries the request until the
+// passed deadline.
+func DoRetryUntil(deadline time.Time, backoff time.Duration) SendDecorator {
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			return doRetryUntil(s, r, deadline, backoff)
+		})
+	}
+}
+
+func doRetryUntil(s Sender, r *http.Request, end time.Time, backoff time.Duration) (resp *http.Response, err error) {
+	for attempt := 0; DefaultClock.Now().Before(end); attempt++ {
+		if ctxErr := r.Context().Err(); ctxErr != nil {
+			DrainResponseBody(resp)
+			return nil, ctxErr
+		}
+		var req *http.Request
+		req, err = CloneRequest(r)
+		if err != nil {
+			return resp, err
+		}
+		DrainResponseBody(resp)
+		start := DefaultClock.Now()
+		resp, err = sendAttempt(s, req, attempt+1)
+		if err == nil {
+			return resp, err
+		}
+		elapsed := DefaultClock.Now().Sub(start)
+		logger.Instance.Writef(logger.LogError, "DoRetryForDuration: received error for attempt %d: %v\n", attempt+1, err)
+		d := retryDelay(resp, backoff, 0, attempt)
+		if d > 0 && DefaultClock.Now().Add(d+elapsed).After(end) {
+			return resp, retryDeadlineError{err: err}
+		}
+		if !retryAllowed(r) {
+			return resp, err
+		}
+		if !delayForRetry(r, attempt+1, resp, err, d) {
+			return nil, r.Context().Err()
+		}
+	}
+	if resp == nil && err == nil {
+		// the deadline passed before the first attempt
+		return nil, ErrRetryDeadlineExceeded
+	}
+	return resp, err
+}
+
+// DoFailover returns a SendDecorator that, when an attempt fails, resends the request to each of the
+// passed endpoints in turn, replacing the request URL's host (which may include a port) with the
+// endpoint. The classify func reports whether a response or error is a failure warranting failover;
+// if nil, errors and responses with one of the StatusCodesForRetry fail over. The result of the last
+// attempt is returned. Only requests that are safe to send to another replica, such as reads, should
+// use failover.
+func DoFailover(endpoints []string, classify func(*http.Response, error) bool) SendDecorator {
+	if classify == nil {
+		classify = func(resp *http.Response, err error) bool {
+			return err != nil || ResponseHasStatusCode(resp, StatusCodesForRetry...)
+		}
+	}
 	return func(s Sender) Sender {
 		return SenderFunc(func(r *http.Request) (resp *http.Response, err error) {
-			rr := NewRetriableRequest(r)
-			end := time.Now().Add(d)
-			for attempt := 0; time.Now().Before(end); attempt++ {
-				err = rr.Prepare()
+			for i := 0; i <= len(endpoints); i++ {
+				if i > 0 {
+					if !classify(resp, err) {
+						return resp, err
+					}
+					logger.Instance.Writef(logger.LogInfo, "DoFailover: failing over from %s to %s\n", r.URL.Host, endpoints[i-1])
+				}
+				if ctxErr := r.Context().Err(); ctxErr != nil {
+					DrainResponseBody(resp)
+					return nil, ctxErr
+				}
+				var req *http.Request
+				req, err = CloneRequest(r)
 				if err != nil {
 					return resp, err
 				}
 				DrainResponseBody(resp)
-				resp, err = s.Do(rr.Request())
-				if err == nil {
-					return resp, err
-				}
-				logger.Instance.Writef(logger.LogError, "DoRetryForDuration: received error for attempt %d: %v\n", attempt+1, err)
-				if !DelayForBackoff(backoff, attempt, r.Context().Done()) {
-					return nil, r.Context().Err()
+				if i > 0 {
+					u := *req.URL
+					u.Host = endpoints[i-1]
+					req.URL = &u
+					req.Host = ""
 				}
+				resp, err = sendAttempt(s, req, i+1)
 			}
 			return resp, err
 		})
 	}
 }
 
+// RedactedHeaders are the request headers whose values WithSanitizedLogging replaces with
+// "**REDACTED**".
+var RedactedHeaders = []string{headerAuthorization, headerAuxAuthorization}
+
+// RedactedQueryParameters are the query parameters whose values WithLogging and WithSanitizedLogging
+// replace with "REDACTED", such as the signature of a SAS token.
+var RedactedQueryParameters = []string{"sig"}
+
+const redacted = "**REDACTED**"
+
 // WithLogging returns a SendDecorator that implements simple before and after logging of the
-// request.
+// request. The values of RedactedQueryParameters are removed from the logged URL.
 func WithLogging(logger *log.Logger) SendDecorator {
 	return func(s Sender) Sender {
 		return SenderFunc(func(r *http.Request) (*http.Response, error) {
-			logger.Printf("Sending %s %s", r.Method, r.URL)
+			u := redactURL(r.URL, RedactedQueryParameters)
+			logger.Printf("Sending %s %s", r.Method, u)
 			resp, err := s.Do(r)
 			if err != nil {
-				logger.Printf("%s %s received error '%v'", r.Method, r.URL, err)
+				logger.Printf("%s %s received error '%v'", r.Method, u, err)
 			} else {
-				logger.Printf("%s %s received %s", r.Method, r.URL, resp.Status)
+				logger.Printf("%s %s received %s", r.Method, u, resp.Status)
 			}
 			return resp, err
 		})
 	}
 }
 
-// DelayForBackoff invokes time.After for the supplied backoff duration raised to the power of
+// WithSanitizedLogging returns a SendDecorator that, like WithLogging, logs the request before and
+// after sending it and also logs the request headers. The values of RedactedHeaders and
+// RedactedQueryParameters, together with any headers or query parameters named in redact, are
+// redacted. Names are not case sensitive.
+func WithSanitizedLogging(logger *log.Logger, redact ...string) SendDecorator {
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			u := redactURL(r.URL, append(append([]string{}, redact...), RedactedQueryParameters...))
+			logger.Printf("Sending %s %s", r.Method, u)
+			headers := append(append([]string{}, redact...), RedactedHeaders...)
+			keys := make([]string, 0, len(r.Header))
+			for k := range r.Header {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				v := strings.Join(r.Header[k], ", ")
+				if containsFold(headers, k) {
+					v = redacted
+				}
+				logger.Printf("%s: %s", k, v)
+			}
+			resp, err := s.Do(r)
+			if err != nil {
+				logger.Printf("%s %s received error '%v'", r.Method, u, err)
+			} else {
+				logger.Printf("%s %s received %s", r.Method, u, resp.Status)
+			}
+			return resp, err
+		})
+	}
+}
+
+// DoDump returns a SendDecorator that writes the request and response, as produced by
+// httputil.DumpRequestOut and httputil.DumpResponse, to the passed io.Writer. Bodies are written up
+// to maxBody bytes, a negative value writing them in full. The request body is read from a clone of
+// the request, leaving the passed http.Request untouched, and is omitted if it cannot be replayed
+// (see CloneRequest); the response body is restored for the caller. The values of RedactedHeaders
+// and RedactedQueryParameters are redacted.
+func DoDump(w io.Writer, maxBody int) SendDecorator {
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			dr, cerr := CloneRequest(r)
+			if cerr != nil {
+				dr = r.Clone(r.Context())
+				dr.Body = nil
+			}
+			dr.URL = redactedURL(r.URL, RedactedQueryParameters)
+			for k := range dr.Header {
+				if containsFold(RedactedHeaders, k) {
+					dr.Header[k] = []string{redacted}
+				}
+			}
+			d, err := httputil.DumpRequestOut(dr, false)
+			if err != nil {
+				return nil, err
+			}
+			w.Write(d)
+			if cerr != nil {
+				fmt.Fprintln(w, "... body omitted, it cannot be replayed")
+			} else if dr.Body != nil && dr.Body != http.NoBody {
+				_, err = dumpBody(w, dr.Body, maxBody)
+				dr.Body.Close()
+				if err != nil {
+					return nil, err
+				}
+			}
+			resp, err := s.Do(r)
+			if err != nil {
+				fmt.Fprintf(w, "\n%s %s received error '%v'\n", r.Method, redactURL(r.URL, RedactedQueryParameters), err)
+			}
+			if resp != nil {
+				if d, derr := httputil.DumpResponse(resp, false); derr == nil {
+					fmt.Fprintln(w)
+					w.Write(d)
+					if resp.Body, derr = dumpBody(w, resp.Body, maxBody); derr != nil && err == nil {
+						err = derr
+					}
+				}
+			}
+			return resp, err
+		})
+	}
+}
+
+// dumpBody writes up to max bytes of the passed body to w, a negative max writing all of it, and
+// returns a body that replays the bytes read followed by the remainder.
+func dumpBody(w io.Writer, body io.ReadCloser, max int) (io.ReadCloser, error) {
+	if body == nil || body == http.NoBody {
+		return body, nil
+	}
+	var head []byte
+	var err error
+	if max < 0 {
+		head, err = io.ReadAll(body)
+	} else {
+		head, err = io.ReadAll(io.LimitReader(body, int64(max)+1))
+	}
+	if err != nil {
+		return body, err
+	}
+	if max >= 0 && len(head) > max {
+		w.Write(head[:max])
+		fmt.Fprintf(w, "\n... body truncated after %d bytes\n", max)
+	} else {
+		w.Write(head)
+		fmt.Fprintln(w)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), body), body}, nil
+}
+
+// redactURL returns the passed URL as a string with the values of the named query parameters
+// replaced.
+func redactURL(u *url.URL, params []string) string {
+	if u == nil {
+		return fmt.Sprint(u)
+	}
+	return redactedURL(u, params).String()
+}
+
+// redactedURL returns the passed URL, or a copy of it with the values of the named query
+// parameters replaced.
+func redactedURL(u *url.URL, params []string) *url.URL {
+	if u == nil || u.RawQuery == "" {
+		return u
+	}
+	q := u.Query()
+	found := false
+	for k := range q {
+		if containsFold(params, k) {
+			q[k] = []string{"REDACTED"}
+			found = true
+		}
+	}
+	if !found {
+		return u
+	}
+	c := *u
+	c.RawQuery = q.Encode()
+	return &c
+}
+
+func containsFold(s []string, v string) bool {
+	for _, i := range s {
+		if strings.EqualFold(i, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// DelayForBackoff invokes DefaultClock.After for the supplied backoff duration raised to the power of
 // passed attempt (i.e., an exponential backoff delay). Backoff duration is in seconds and can set
 // to zero for no delay. The delay may be canceled by closing the passed channel. If terminated early,
 // returns false.
@@ -437,20 +1604,49 @@ func DelayForBackoff(backoff time.Duration, attempt int, cancel <-chan struct{})
 	return DelayForBackoffWithCap(backoff, 0, attempt, cancel)
 }
 
-// DelayForBackoffWithCap invokes time.After for the supplied backoff duration raised to the power of
+// DelayForBackoffWithCap invokes DefaultClock.After for the supplied backoff duration raised to the power of
 // passed attempt (i.e., an exponential backoff delay). Backoff duration is in seconds and can set
 // to zero for no delay. To cap the maximum possible delay specify a value greater than zero for cap.
 // The delay may be canceled by closing the passed channel. If terminated early, returns false.
 // Note: Passing attempt 1 will result in doubling "backoff" duration. Treat this as a zero-based attempt
 // count.
 func DelayForBackoffWithCap(backoff, cap time.Duration, attempt int, cancel <-chan struct{}) bool {
+	d := backoffDelay(backoff, cap, attempt)
+	logger.Instance.Writef(logger.LogInfo, "DelayForBackoffWithCap: sleeping for %s\n", d)
+	return delay(d, cancel)
+}
+
+// DelayForBackoffWithContext waits for the supplied backoff duration raised to the power of the
+// passed zero-based attempt, capped by cap if it is greater than zero, as DelayForBackoffWithCap
+// does. It returns the context's error as soon as the passed context is canceled, and nil once the
+// delay has elapsed.
+func DelayForBackoffWithContext(ctx context.Context, backoff, cap time.Duration, attempt int) error {
+	if !DelayForBackoffWithCap(backoff, cap, attempt, ctx.Done()) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// backoffDelay returns the exponential backoff delay for the passed zero-based attempt.
+func backoffDelay(backoff, cap time.Duration, attempt int) time.Duration {
 	d := time.Duration(backoff.Seconds()*math.Pow(2, float64(attempt))) * time.Second
 	if cap > 0 && d > cap {
 		d = cap
 	}
-	logger.Instance.Writef(logger.LogInfo, "DelayForBackoffWithCap: sleeping for %s\n", d)
+	return d
+}
+
+// delay waits for the passed duration and returns true, or returns false as soon as the passed
+// channel is closed.
+func delay(d time.Duration, cancel <-chan struct{}) bool {
+	// a closed channel takes precedence over a zero delay
+	select {
+	case <-cancel:
+		return false
+	default:
+	}
 	select {
-	case <-time.After(d):
+	case <-DefaultClock.After(d):
 		return true
 	case <-cancel:
 		return false
@@ -0,0 +1,63 @@
+//go:build go1.21
+// +build go1.21
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package autorest
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const headerRequestID = "x-ms-request-id"
+
+// WithStructuredLogging returns a SendDecorator that logs a record for each request it sends with
+// the keys method, url, status, latency, attempt and, when the service returns one, request_id.
+// Requests that fail are logged at the error level, all others at the info level. The values of
+// RedactedQueryParameters are removed from the logged URL. Decorators passed to SendWithSender
+// enclose those listed before them, so list it ahead of any retry decorator to log every attempt
+// rather than only the final outcome.
+func WithStructuredLogging(logger *slog.Logger) SendDecorator {
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := s.Do(r)
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("url", redactURL(r.URL, RedactedQueryParameters)),
+			}
+			if resp != nil {
+				attrs = append(attrs, slog.Int("status", resp.StatusCode))
+			}
+			attrs = append(attrs,
+				slog.Duration("latency", time.Since(start)),
+				slog.Int("attempt", requestAttempt(r)))
+			if resp != nil {
+				if id := resp.Header.Get(headerRequestID); id != "" {
+					attrs = append(attrs, slog.String("request_id", id))
+				}
+			}
+			level := slog.LevelInfo
+			if err != nil {
+				level = slog.LevelError
+				attrs = append(attrs, slog.String("error", err.Error()))
+			}
+			logger.LogAttrs(r.Context(), level, "HTTP request", attrs...)
+			return resp, err
+		})
+	}
+}
@@ -0,0 +1,100 @@
+//go:build go1.21
+// +build go1.21
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package autorest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+func TestWithStructuredLogging(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, nil))
+
+	client := mocks.NewSender()
+	failed := mocks.NewResponseWithStatus("503 Service Unavailable", http.StatusServiceUnavailable)
+	mocks.SetResponseHeader(failed, headerRequestID, "71FDB9F4-5E49-4C12-B266-DE7B4FD999A6")
+	client.AppendResponse(failed)
+	client.AppendResponse(mocks.NewResponseWithStatus("200 OK", http.StatusOK))
+
+	r, err := SendWithSender(client, mocks.NewRequestWithParams(http.MethodGet, "https://microsoft.com/a?sig=s3cr3t", nil),
+		WithStructuredLogging(logger),
+		DoRetryForStatusCodes(3, 0, http.StatusServiceUnavailable))
+	if err != nil {
+		t.Fatalf("autorest: WithStructuredLogging returned an unexpected error (%v)", err)
+	}
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+
+	var records []map[string]interface{}
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var record map[string]interface{}
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("autorest: WithStructuredLogging wrote an invalid record (%v)", err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("autorest: WithStructuredLogging wrote %d records, expected 2", len(records))
+	}
+	first := records[0]
+	for k, v := range map[string]interface{}{
+		"method":     "GET",
+		"url":        "https://microsoft.com/a?sig=REDACTED",
+		"status":     float64(http.StatusServiceUnavailable),
+		"attempt":    float64(1),
+		"request_id": "71FDB9F4-5E49-4C12-B266-DE7B4FD999A6",
+	} {
+		if first[k] != v {
+			t.Fatalf("autorest: WithStructuredLogging logged %s=%v, expected %v", k, first[k], v)
+		}
+	}
+	if _, ok := first["latency"]; !ok {
+		t.Fatal("autorest: WithStructuredLogging failed to log the latency")
+	}
+	if records[1]["attempt"] != float64(2) || records[1]["status"] != float64(http.StatusOK) {
+		t.Fatalf("autorest: WithStructuredLogging logged %v for the second attempt", records[1])
+	}
+}
+
+func TestWithStructuredLoggingLogsErrors(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, nil))
+
+	client := mocks.NewSender()
+	client.AppendResponse(nil)
+	client.SetError(fmt.Errorf("Faux Error"))
+
+	SendWithSender(client, mocks.NewRequest(), WithStructuredLogging(logger))
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("autorest: WithStructuredLogging wrote an invalid record (%v)", err)
+	}
+	if record["level"] != "ERROR" || record["error"] != "Faux Error" {
+		t.Fatalf("autorest: WithStructuredLogging logged %v for a failed request", record)
+	}
+}
@@ -17,12 +17,26 @@ package autorest
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -247,6 +261,26 @@ func TestAsIs(t *testing.T) {
 		ByClosing())
 }
 
+func TestDoIf(t *testing.T) {
+	client := mocks.NewSender()
+	client.AppendAndRepeatResponse(mocks.NewResponseWithStatus("500 InternalServerError", http.StatusInternalServerError), 2)
+
+	isGet := func(r *http.Request) bool { return r.Method == http.MethodGet }
+	s := DecorateSender(client, DoIf(isGet, DoErrorIfStatusCode(http.StatusInternalServerError)))
+
+	resp, err := s.Do(mocks.NewRequest())
+	if err == nil {
+		t.Fatal("autorest: DoIf failed to apply the decorator when the condition was met")
+	}
+	Respond(resp, ByDiscardingBody(), ByClosing())
+
+	resp, err = s.Do(mocks.NewRequestWithParams(http.MethodPut, "https://microsoft.com/a/b/c/", nil))
+	if err != nil {
+		t.Fatalf("autorest: DoIf applied the decorator when the condition was not met (%v)", err)
+	}
+	Respond(resp, ByDiscardingBody(), ByClosing())
+}
+
 func TestDoCloseIfError(t *testing.T) {
 	client := mocks.NewSender()
 	client.AppendResponse(mocks.NewResponseWithStatus("400 BadRequest", http.StatusBadRequest))
@@ -365,6 +399,70 @@ func TestDoErrorUnlessStatusCodeIgnoresStatusCodes(t *testing.T) {
 		ByClosing())
 }
 
+func TestDoErrorIfStatusCodeInRange(t *testing.T) {
+	client := mocks.NewSender()
+	client.AppendResponse(mocks.NewResponseWithStatus("502 Bad Gateway", http.StatusBadGateway))
+
+	r, err := SendWithSender(client, mocks.NewRequest(),
+		DoErrorIfStatusCodeInRange(StatusCodeRange{500, 599}),
+		DoCloseIfError())
+	if err == nil {
+		t.Fatal("autorest: DoErrorIfStatusCodeInRange failed to emit an error for a status code in range")
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestDoErrorIfStatusCodeInRangeIgnoresStatusCodes(t *testing.T) {
+	client := mocks.NewSender()
+	client.AppendResponse(newAcceptedResponse())
+
+	r, err := SendWithSender(client, mocks.NewRequest(),
+		DoErrorIfStatusCodeInRange(StatusCodeRange{500, 599}),
+		DoCloseIfError())
+	if err != nil {
+		t.Fatal("autorest: DoErrorIfStatusCodeInRange emitted an error for a status code out of range")
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestDoErrorUnlessStatusCodeInRange(t *testing.T) {
+	client := mocks.NewSender()
+	client.AppendResponse(mocks.NewResponseWithStatus("400 BadRequest", http.StatusBadRequest))
+
+	r, err := SendWithSender(client, mocks.NewRequest(),
+		DoErrorUnlessStatusCodeInRange(StatusCodeRange{200, 299}),
+		DoCloseIfError())
+	if err == nil {
+		t.Fatal("autorest: DoErrorUnlessStatusCodeInRange failed to emit an error for a status code out of range")
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestDoErrorUnlessStatusCodeInRangeIgnoresStatusCodes(t *testing.T) {
+	client := mocks.NewSender()
+	client.AppendResponse(newAcceptedResponse())
+
+	r, err := SendWithSender(client, mocks.NewRequest(),
+		DoErrorUnlessStatusCodeInRange(StatusCodeRange{200, 299}),
+		DoCloseIfError())
+	if err != nil {
+		t.Fatal("autorest: DoErrorUnlessStatusCodeInRange emitted an error for a status code in range")
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
 func TestDoRetryForAttemptsStopsAfterSuccess(t *testing.T) {
 	client := mocks.NewSender()
 
@@ -403,6 +501,42 @@ func TestDoRetryForAttemptsStopsAfterAttempts(t *testing.T) {
 	}
 }
 
+func TestDoRetryForAttemptsDoesNotModifyRequest(t *testing.T) {
+	client := mocks.NewSender()
+	client.SetAndRepeatError(fmt.Errorf("Faux Error"), 2)
+
+	req := mocks.NewRequestWithContent("Hello Gopher")
+	attempts := 0
+	r, err := SendWithSender(client, req,
+		func(s Sender) Sender {
+			return SenderFunc(func(r *http.Request) (*http.Response, error) {
+				attempts++
+				if r.Header.Get("X-Attempt") != "" {
+					t.Fatalf("autorest: DoRetryForAttempts reused a request modified by a previous attempt")
+				}
+				r.Header.Set("X-Attempt", strconv.Itoa(attempts))
+				if b, _ := io.ReadAll(r.Body); string(b) != "Hello Gopher" {
+					t.Fatalf("autorest: DoRetryForAttempts sent body %q on attempt %d", b, attempts)
+				}
+				return s.Do(r)
+			})
+		},
+		DoRetryForAttempts(5, time.Duration(0)))
+	if err != nil {
+		t.Fatalf("autorest: DoRetryForAttempts returned an unexpected error (%v)", err)
+	}
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+
+	if attempts != 3 {
+		t.Fatalf("autorest: expected 3 attempts, got %d", attempts)
+	}
+	if req.Header.Get("X-Attempt") != "" {
+		t.Fatal("autorest: DoRetryForAttempts modified the original request")
+	}
+}
+
 func TestDoRetryForAttemptsReturnsResponse(t *testing.T) {
 	client := mocks.NewSender()
 	client.SetError(fmt.Errorf("Faux Error"))
@@ -504,6 +638,63 @@ func TestDoRetryForDurationReturnsResponse(t *testing.T) {
 		ByClosing())
 }
 
+func TestDoRetryForDurationStopsBeforeDeadline(t *testing.T) {
+	client := mocks.NewSender()
+	client.SetAndRepeatError(fmt.Errorf("Faux Error"), -1)
+
+	start := time.Now()
+	r, err := SendWithSender(client, mocks.NewRequest(),
+		DoRetryForDuration(time.Second, 10*time.Second),
+		DoCloseIfError())
+	if !errors.Is(err, ErrRetryDeadlineExceeded) {
+		t.Fatalf("autorest: DoRetryForDuration returned an unexpected error -- expected %v, received %v", ErrRetryDeadlineExceeded, err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("autorest: DoRetryForDuration slept past the deadline")
+	}
+	if client.Attempts() != 1 {
+		t.Fatalf("autorest: DoRetryForDuration made an unexpected number of attempts -- expected 1, actual %v", client.Attempts())
+	}
+	if r == nil {
+		t.Fatal("autorest: DoRetryForDuration failed to return the underlying response")
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestDoRetryUntil(t *testing.T) {
+	client := mocks.NewSender()
+	client.SetAndRepeatError(fmt.Errorf("Faux Error"), 2)
+
+	r, err := SendWithSender(client, mocks.NewRequest(),
+		DoRetryUntil(time.Now().Add(time.Second), time.Millisecond))
+	if err != nil {
+		t.Fatalf("autorest: DoRetryUntil returned an unexpected error (%v)", err)
+	}
+	if client.Attempts() != 3 {
+		t.Fatalf("autorest: DoRetryUntil made an unexpected number of attempts -- expected 3, actual %v", client.Attempts())
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestDoRetryUntilPastDeadline(t *testing.T) {
+	client := mocks.NewSender()
+
+	_, err := SendWithSender(client, mocks.NewRequest(),
+		DoRetryUntil(time.Now().Add(-time.Second), time.Millisecond))
+	if err != ErrRetryDeadlineExceeded {
+		t.Fatalf("autorest: DoRetryUntil returned an unexpected error -- expected %v, received %v", ErrRetryDeadlineExceeded, err)
+	}
+	if client.Attempts() != 0 {
+		t.Fatalf("autorest: DoRetryUntil sent a request after the deadline -- attempts %v", client.Attempts())
+	}
+}
+
 func TestDelayForBackoff(t *testing.T) {
 	d := 2 * time.Second
 	start := time.Now()
@@ -541,6 +732,29 @@ func TestDelayForBackoff_Cancels(t *testing.T) {
 	}
 }
 
+func TestDelayForBackoffWithContext(t *testing.T) {
+	fc := withFakeClock(t)
+	start := fc.Now()
+	if err := DelayForBackoffWithContext(context.Background(), time.Minute, 3*time.Minute, 2); err != nil {
+		t.Fatalf("autorest: DelayForBackoffWithContext returned an unexpected error (%v)", err)
+	}
+	if elapsed := fc.Now().Sub(start); elapsed != 3*time.Minute {
+		t.Fatalf("autorest: DelayForBackoffWithContext delayed for an unexpected duration -- expected %v, received %v", 3*time.Minute, elapsed)
+	}
+}
+
+func TestDelayForBackoffWithContext_Cancels(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(5*time.Millisecond, cancel)
+	start := time.Now()
+	if err := DelayForBackoffWithContext(ctx, time.Minute, 0, 0); err != context.Canceled {
+		t.Fatalf("autorest: DelayForBackoffWithContext returned an unexpected error -- expected %v, received %v", context.Canceled, err)
+	}
+	if time.Since(start) >= time.Minute {
+		t.Fatal("autorest: DelayForBackoffWithContext failed to cancel")
+	}
+}
+
 func TestDelayForBackoffWithinReason(t *testing.T) {
 	d := 5 * time.Second
 	maxCoefficient := 2
@@ -583,45 +797,55 @@ func TestDoPollForStatusCodes_PollsForSpecifiedStatusCodes(t *testing.T) {
 		ByClosing())
 }
 
-func TestDoPollForStatusCodes_CanBeCanceled(t *testing.T) {
-	cancel := make(chan struct{})
-	delay := 5 * time.Second
+func newAsyncOperationResponse() *http.Response {
+	resp := newAcceptedResponse()
+	mocks.SetResponseHeader(resp, HeaderAzureAsyncOperation, mocks.TestAzureAsyncURL)
+	return resp
+}
 
-	r := mocks.NewResponse()
-	mocks.SetAcceptedHeaders(r)
+func TestDoPollForStatusCodes_IgnoresAzureAsyncOperation(t *testing.T) {
 	client := mocks.NewSender()
-	client.AppendAndRepeatResponse(r, 100)
+	client.AppendResponse(newAsyncOperationResponse())
+	client.AppendResponse(mocks.NewResponseWithContent(`{"id": "r1"}`))
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-	start := time.Now()
-	go func() {
-		wg.Done()
-		r, _ := SendWithSender(client, mocks.NewRequest(),
-			DoPollForStatusCodes(time.Millisecond, time.Millisecond, http.StatusAccepted))
-		Respond(r,
-			ByDiscardingBody(),
-			ByClosing())
-	}()
-	wg.Wait()
-	close(cancel)
-	time.Sleep(5 * time.Millisecond)
-	if time.Since(start) >= delay {
-		t.Fatalf("autorest: Sender#DoPollForStatusCodes failed to cancel")
+	var polled []string
+	inspect := func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			polled = append(polled, r.URL.String())
+			return s.Do(r)
+		})
+	}
+	r, err := SendWithSender(client, mocks.NewRequest(),
+		inspect,
+		DoPollForStatusCodes(time.Millisecond, time.Millisecond, http.StatusAccepted))
+	if err != nil {
+		t.Fatalf("autorest: Sender#DoPollForStatusCodes returned an unexpected error (%v)", err)
+	}
+	if len(polled) != 2 || polled[1] != mocks.TestURL {
+		t.Fatalf("autorest: Sender#DoPollForStatusCodes did not poll the Location URL -- %v", polled)
 	}
-}
 
-func TestDoPollForStatusCodes_ClosesAllNonreturnedResponseBodiesWhenPolling(t *testing.T) {
-	resp := newAcceptedResponse()
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
 
+func TestDoPollForAsyncOperation(t *testing.T) {
 	client := mocks.NewSender()
-	client.AppendAndRepeatResponse(resp, 2)
-
-	r, _ := SendWithSender(client, mocks.NewRequest(),
-		DoPollForStatusCodes(time.Millisecond, time.Millisecond, http.StatusAccepted))
+	client.AppendResponse(newAsyncOperationResponse())
+	client.AppendResponse(mocks.NewResponseWithContent(`{"status": "InProgress"}`))
+	client.AppendResponse(mocks.NewResponseWithContent(`{"status": "Succeeded"}`))
 
-	if resp.Body.(*mocks.Body).IsOpen() || resp.Body.(*mocks.Body).CloseAttempts() < 2 {
-		t.Fatalf("autorest: Sender#DoPollForStatusCodes did not close unreturned response bodies")
+	r, err := SendWithSender(client, mocks.NewRequestWithParams(http.MethodDelete, mocks.TestURL, nil),
+		DoPollForAsyncOperation(FixedPolling(time.Millisecond), http.StatusAccepted))
+	if err != nil {
+		t.Fatalf("autorest: Sender#DoPollForAsyncOperation returned an unexpected error (%v)", err)
+	}
+	if client.Attempts() != 3 {
+		t.Fatalf("autorest: Sender#DoPollForAsyncOperation stopped polling before the operation completed -- %d attempts", client.Attempts())
+	}
+	if r.Request.URL.String() != mocks.TestAzureAsyncURL {
+		t.Fatalf("autorest: Sender#DoPollForAsyncOperation returned the response of %v, expected the status monitor", r.Request.URL)
 	}
 
 	Respond(r,
@@ -629,15 +853,56 @@ func TestDoPollForStatusCodes_ClosesAllNonreturnedResponseBodiesWhenPolling(t *t
 		ByClosing())
 }
 
-func TestDoPollForStatusCodes_LeavesLastResponseBodyOpen(t *testing.T) {
-	client := mocks.NewSender()
-	client.AppendResponse(newAcceptedResponse())
+func TestDoPollForAsyncOperationFetchesResult(t *testing.T) {
+	cases := []struct {
+		method   string
+		location string
+		expected string
+	}{
+		{http.MethodPut, "https://microsoft.com/a/b/c/other", "https://microsoft.com/a/b/c/resource"},
+		{http.MethodPatch, "", "https://microsoft.com/a/b/c/resource"},
+		{http.MethodPost, "https://microsoft.com/a/b/c/result", "https://microsoft.com/a/b/c/result"},
+	}
+	for _, c := range cases {
+		client := mocks.NewSender()
+		resp := mocks.NewResponseWithStatus("201 Created", http.StatusCreated)
+		mocks.SetResponseHeader(resp, HeaderAzureAsyncOperation, mocks.TestAzureAsyncURL)
+		if c.location != "" {
+			mocks.SetResponseHeader(resp, HeaderLocation, c.location)
+		}
+		client.AppendResponse(resp)
+		client.AppendResponse(mocks.NewResponseWithContent(`{"status": "Succeeded"}`))
+		client.AppendResponse(mocks.NewResponseWithContent(`{"id": "r1"}`))
 
-	r, _ := SendWithSender(client, mocks.NewRequest(),
-		DoPollForStatusCodes(time.Millisecond, time.Millisecond, http.StatusAccepted))
+		req := mocks.NewRequestWithParams(c.method, "https://microsoft.com/a/b/c/resource", strings.NewReader(`{}`))
+		r, err := SendWithSender(client, req,
+			DoPollForAsyncOperation(FixedPolling(time.Millisecond)))
+		if err != nil {
+			t.Fatalf("autorest: Sender#DoPollForAsyncOperation returned an unexpected error for %s (%v)", c.method, err)
+		}
+		if r.Request.Method != http.MethodGet || r.Request.URL.String() != c.expected {
+			t.Fatalf("autorest: Sender#DoPollForAsyncOperation fetched %s %v for %s, expected GET %s", r.Request.Method, r.Request.URL, c.method, c.expected)
+		}
+		var v map[string]interface{}
+		if err := Respond(r, ByUnmarshallingJSON(&v), ByClosing()); err != nil || v["id"] != "r1" {
+			t.Fatalf("autorest: Sender#DoPollForAsyncOperation returned an unexpected body for %s -- %v (%v)", c.method, v, err)
+		}
+	}
+}
 
-	if !r.Body.(*mocks.Body).IsOpen() {
-		t.Fatalf("autorest: Sender#DoPollForStatusCodes did not leave open the body of the last response")
+func TestDoPollForAsyncOperationReturnsErrorForFailedOperation(t *testing.T) {
+	client := mocks.NewSender()
+	client.AppendResponse(newAsyncOperationResponse())
+	client.AppendResponse(mocks.NewResponseWithContent(`{"status": "Failed", "error": {"code": "Conflict", "message": "busy"}}`))
+
+	req := mocks.NewRequestWithParams(http.MethodPut, mocks.TestURL, strings.NewReader(`{}`))
+	r, err := SendWithSender(client, req,
+		DoPollForAsyncOperation(FixedPolling(time.Millisecond), http.StatusAccepted))
+	if err == nil || !strings.Contains(err.Error(), "Conflict") {
+		t.Fatalf("autorest: Sender#DoPollForAsyncOperation failed to return the operation error -- %v", err)
+	}
+	if client.Attempts() != 2 {
+		t.Fatalf("autorest: Sender#DoPollForAsyncOperation fetched the result of a failed operation -- %d attempts", client.Attempts())
 	}
 
 	Respond(r,
@@ -645,17 +910,30 @@ func TestDoPollForStatusCodes_LeavesLastResponseBodyOpen(t *testing.T) {
 		ByClosing())
 }
 
-func TestDoPollForStatusCodes_StopsPollingAfterAnError(t *testing.T) {
+func newProvisioningStateResponse(state string) *http.Response {
+	return mocks.NewResponseWithContent(fmt.Sprintf(`{"id": "r1", "properties": {"provisioningState": %q}}`, state))
+}
+
+func newCreatedResponse(state string) *http.Response {
+	resp := newProvisioningStateResponse(state)
+	resp.StatusCode = http.StatusCreated
+	resp.Status = "201 Created"
+	mocks.SetResponseHeader(resp, HeaderLocation, mocks.TestURL)
+	return resp
+}
+
+func TestDoPollForStatusCodes_IgnoresCreated(t *testing.T) {
 	client := mocks.NewSender()
-	client.AppendAndRepeatResponse(newAcceptedResponse(), 5)
-	client.SetError(fmt.Errorf("Faux Error"))
-	client.SetEmitErrorAfter(1)
+	client.AppendResponse(newCreatedResponse("Creating"))
 
-	r, _ := SendWithSender(client, mocks.NewRequest(),
+	req := mocks.NewRequestWithParams(http.MethodPut, mocks.TestURL, strings.NewReader(`{}`))
+	r, err := SendWithSender(client, req,
 		DoPollForStatusCodes(time.Millisecond, time.Millisecond, http.StatusAccepted))
-
-	if client.Attempts() > 2 {
-		t.Fatalf("autorest: Sender#DoPollForStatusCodes failed to stop polling after receiving an error")
+	if err != nil {
+		t.Fatalf("autorest: Sender#DoPollForStatusCodes returned an unexpected error (%v)", err)
+	}
+	if client.Attempts() != 1 || r.StatusCode != http.StatusCreated {
+		t.Fatalf("autorest: Sender#DoPollForStatusCodes polled a status code not among those passed -- %d attempts", client.Attempts())
 	}
 
 	Respond(r,
@@ -663,17 +941,21 @@ func TestDoPollForStatusCodes_StopsPollingAfterAnError(t *testing.T) {
 		ByClosing())
 }
 
-func TestDoPollForStatusCodes_ReturnsPollingError(t *testing.T) {
+func TestDoPollForCreated(t *testing.T) {
 	client := mocks.NewSender()
-	client.AppendAndRepeatResponse(newAcceptedResponse(), 5)
-	client.SetError(fmt.Errorf("Faux Error"))
-	client.SetEmitErrorAfter(1)
-
-	r, err := SendWithSender(client, mocks.NewRequest(),
-		DoPollForStatusCodes(time.Millisecond, time.Millisecond, http.StatusAccepted))
+	client.AppendResponse(newCreatedResponse("Creating"))
+	client.AppendResponse(newCreatedResponse("Creating"))
+	client.AppendResponse(newProvisioningStateResponse("Updating"))
+	client.AppendResponse(newProvisioningStateResponse("Succeeded"))
 
-	if err == nil {
-		t.Fatalf("autorest: Sender#DoPollForStatusCodes failed to return error from polling")
+	req := mocks.NewRequestWithParams(http.MethodPut, mocks.TestURL, mocks.NewBody(`{}`))
+	r, err := SendWithSender(client, req,
+		DoPollForCreated(FixedPolling(time.Millisecond)))
+	if err != nil {
+		t.Fatalf("autorest: Sender#DoPollForCreated returned an unexpected error (%v)", err)
+	}
+	if client.Attempts() != 4 || r.StatusCode != http.StatusOK {
+		t.Fatalf("autorest: Sender#DoPollForCreated stopped polling before provisioning completed -- %d attempts", client.Attempts())
 	}
 
 	Respond(r,
@@ -681,16 +963,16 @@ func TestDoPollForStatusCodes_ReturnsPollingError(t *testing.T) {
 		ByClosing())
 }
 
-func TestWithLogging_Logs(t *testing.T) {
-	buf := &bytes.Buffer{}
-	logger := log.New(buf, "autorest: ", 0)
+func TestDoPollForCreatedReturnsErrorForFailedProvisioning(t *testing.T) {
 	client := mocks.NewSender()
+	client.AppendResponse(newCreatedResponse("Creating"))
+	client.AppendResponse(newProvisioningStateResponse("Failed"))
 
-	r, _ := SendWithSender(client, &http.Request{},
-		WithLogging(logger))
-
-	if buf.String() == "" {
-		t.Fatal("autorest: Sender#WithLogging failed to log the request")
+	req := mocks.NewRequestWithParams(http.MethodPut, mocks.TestURL, mocks.NewBody(`{}`))
+	r, err := SendWithSender(client, req,
+		DoPollForCreated(FixedPolling(time.Millisecond)))
+	if err == nil || !strings.Contains(err.Error(), "Failed") {
+		t.Fatalf("autorest: Sender#DoPollForCreated failed to return an error for failed provisioning -- %v", err)
 	}
 
 	Respond(r,
@@ -698,21 +980,22 @@ func TestWithLogging_Logs(t *testing.T) {
 		ByClosing())
 }
 
-func TestWithLogging_HandlesMissingResponse(t *testing.T) {
-	buf := &bytes.Buffer{}
-	logger := log.New(buf, "autorest: ", 0)
+func TestDoPollForProvisioningState(t *testing.T) {
 	client := mocks.NewSender()
-	client.AppendResponse(nil)
-	client.SetError(fmt.Errorf("Faux Error"))
+	client.AppendResponse(newProvisioningStateResponse("Creating"))
+	client.AppendResponse(newProvisioningStateResponse("Updating"))
+	client.AppendResponse(newProvisioningStateResponse("Succeeded"))
 
-	r, err := SendWithSender(client, &http.Request{},
-		WithLogging(logger))
-
-	if r != nil || err == nil {
-		t.Fatal("autorest: Sender#WithLogging returned a valid response -- expecting nil")
+	req := mocks.NewRequestWithParams(http.MethodPut, mocks.TestURL, mocks.NewBody(`{}`))
+	r, err := SendWithSender(client, req, DoPollForProvisioningState(time.Millisecond))
+	if err != nil {
+		t.Fatalf("autorest: DoPollForProvisioningState returned an unexpected error (%v)", err)
 	}
-	if buf.String() == "" {
-		t.Fatal("autorest: Sender#WithLogging failed to log the request for a nil response")
+	if client.Attempts() != 3 {
+		t.Fatalf("autorest: DoPollForProvisioningState stopped polling before provisioning completed -- %d attempts", client.Attempts())
+	}
+	if r.Request.Method != http.MethodGet {
+		t.Fatalf("autorest: DoPollForProvisioningState polled with %s instead of GET", r.Request.Method)
 	}
 
 	Respond(r,
@@ -720,28 +1003,357 @@ func TestWithLogging_HandlesMissingResponse(t *testing.T) {
 		ByClosing())
 }
 
-func TestDoRetryForStatusCodesWithSuccess(t *testing.T) {
+func TestDoPollForProvisioningState_ReturnsErrorWhenFailed(t *testing.T) {
 	client := mocks.NewSender()
-	client.AppendAndRepeatResponse(mocks.NewResponseWithStatus("408 Request Timeout", http.StatusRequestTimeout), 2)
-	client.AppendResponse(mocks.NewResponseWithStatus("200 OK", http.StatusOK))
+	client.AppendResponse(newProvisioningStateResponse("Creating"))
+	client.AppendResponse(newProvisioningStateResponse("Failed"))
 
-	r, _ := SendWithSender(client, mocks.NewRequest(),
-		DoRetryForStatusCodes(5, time.Duration(2*time.Second), http.StatusRequestTimeout),
-	)
+	req := mocks.NewRequestWithParams(http.MethodPatch, mocks.TestURL, mocks.NewBody(`{}`))
+	r, err := SendWithSender(client, req, DoPollForProvisioningState(time.Millisecond))
+	if err == nil || !strings.Contains(err.Error(), "Failed") {
+		t.Fatalf("autorest: DoPollForProvisioningState failed to return an error for failed provisioning -- %v", err)
+	}
 
 	Respond(r,
 		ByDiscardingBody(),
 		ByClosing())
-
-	if client.Attempts() != 3 {
-		t.Fatalf("autorest: Sender#DoRetryForStatusCodes -- Got: StatusCode %v in %v attempts; Want: StatusCode 200 OK in 2 attempts -- ",
-			r.Status, client.Attempts()-1)
-	}
 }
 
-func TestDoRetryForStatusCodesWithNoSuccess(t *testing.T) {
+func TestDoPollForProvisioningState_IgnoresOtherRequests(t *testing.T) {
 	client := mocks.NewSender()
-	client.AppendAndRepeatResponse(mocks.NewResponseWithStatus("504 Gateway Timeout", http.StatusGatewayTimeout), 5)
+	client.AppendResponse(newProvisioningStateResponse("Creating"))
+
+	r, err := SendWithSender(client, mocks.NewRequest(), DoPollForProvisioningState(time.Millisecond))
+	if err != nil || client.Attempts() != 1 {
+		t.Fatalf("autorest: DoPollForProvisioningState polled for a GET request -- %d attempts (%v)", client.Attempts(), err)
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestDoPollForStatusCodes_CanBeCanceled(t *testing.T) {
+	cancel := make(chan struct{})
+	delay := 5 * time.Second
+
+	r := mocks.NewResponse()
+	mocks.SetAcceptedHeaders(r)
+	client := mocks.NewSender()
+	client.AppendAndRepeatResponse(r, 100)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	start := time.Now()
+	go func() {
+		wg.Done()
+		r, _ := SendWithSender(client, mocks.NewRequest(),
+			DoPollForStatusCodes(time.Millisecond, time.Millisecond, http.StatusAccepted))
+		Respond(r,
+			ByDiscardingBody(),
+			ByClosing())
+	}()
+	wg.Wait()
+	close(cancel)
+	time.Sleep(5 * time.Millisecond)
+	if time.Since(start) >= delay {
+		t.Fatalf("autorest: Sender#DoPollForStatusCodes failed to cancel")
+	}
+}
+
+func TestPollUntilDone(t *testing.T) {
+	client := mocks.NewSender()
+	client.AppendAndRepeatResponse(newAcceptedResponse(), 2)
+	client.AppendResponse(mocks.NewResponse())
+
+	r, err := PollUntilDone(context.Background(), client, newAcceptedResponse(), time.Millisecond, http.StatusAccepted)
+	if err != nil {
+		t.Fatalf("autorest: PollUntilDone returned an unexpected error (%v)", err)
+	}
+	if r.StatusCode != http.StatusOK || client.Attempts() != 3 {
+		t.Fatalf("autorest: PollUntilDone stopped polling early -- %d attempts", client.Attempts())
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestPollUntilDone_ReturnsContextErrorBetweenPolls(t *testing.T) {
+	accepted := mocks.NewResponseWithStatus("202 Accepted", http.StatusAccepted)
+	mocks.SetLocationHeader(accepted, mocks.TestURL)
+	client := mocks.NewSender()
+	client.AppendAndRepeatResponse(accepted, 100)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	r, err := PollUntilDone(ctx, client, accepted, time.Hour, http.StatusAccepted)
+	if err != context.Canceled {
+		t.Fatalf("autorest: PollUntilDone returned an unexpected error -- expected %v, received %v", context.Canceled, err)
+	}
+	if time.Since(start) > time.Minute || client.Attempts() != 0 {
+		t.Fatalf("autorest: PollUntilDone failed to abort the delay between polls -- %d attempts", client.Attempts())
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestPollUntilDone_AbandonsInFlightPoll(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := SenderFunc(func(r *http.Request) (*http.Response, error) {
+		cancel()
+		<-r.Context().Done()
+		return nil, r.Context().Err()
+	})
+
+	_, err := PollUntilDone(ctx, s, newAcceptedResponse(), 0, http.StatusAccepted)
+	if err != context.Canceled {
+		t.Fatalf("autorest: PollUntilDone returned an unexpected error -- expected %v, received %v", context.Canceled, err)
+	}
+}
+
+func TestDoPollForStatusCodes_ClosesAllNonreturnedResponseBodiesWhenPolling(t *testing.T) {
+	resp := newAcceptedResponse()
+
+	client := mocks.NewSender()
+	client.AppendAndRepeatResponse(resp, 2)
+
+	r, _ := SendWithSender(client, mocks.NewRequest(),
+		DoPollForStatusCodes(time.Millisecond, time.Millisecond, http.StatusAccepted))
+
+	if resp.Body.(*mocks.Body).IsOpen() || resp.Body.(*mocks.Body).CloseAttempts() < 2 {
+		t.Fatalf("autorest: Sender#DoPollForStatusCodes did not close unreturned response bodies")
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestDoPollForStatusCodes_LeavesLastResponseBodyOpen(t *testing.T) {
+	client := mocks.NewSender()
+	client.AppendResponse(newAcceptedResponse())
+
+	r, _ := SendWithSender(client, mocks.NewRequest(),
+		DoPollForStatusCodes(time.Millisecond, time.Millisecond, http.StatusAccepted))
+
+	if !r.Body.(*mocks.Body).IsOpen() {
+		t.Fatalf("autorest: Sender#DoPollForStatusCodes did not leave open the body of the last response")
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestDoPollForStatusCodes_StopsPollingAfterAnError(t *testing.T) {
+	client := mocks.NewSender()
+	client.AppendAndRepeatResponse(newAcceptedResponse(), 5)
+	client.SetError(fmt.Errorf("Faux Error"))
+	client.SetEmitErrorAfter(1)
+
+	r, _ := SendWithSender(client, mocks.NewRequest(),
+		DoPollForStatusCodes(time.Millisecond, time.Millisecond, http.StatusAccepted))
+
+	if client.Attempts() > 2 {
+		t.Fatalf("autorest: Sender#DoPollForStatusCodes failed to stop polling after receiving an error")
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestDoPollForStatusCodes_ReturnsPollingError(t *testing.T) {
+	client := mocks.NewSender()
+	client.AppendAndRepeatResponse(newAcceptedResponse(), 5)
+	client.SetError(fmt.Errorf("Faux Error"))
+	client.SetEmitErrorAfter(1)
+
+	r, err := SendWithSender(client, mocks.NewRequest(),
+		DoPollForStatusCodes(time.Millisecond, time.Millisecond, http.StatusAccepted))
+
+	if err == nil {
+		t.Fatalf("autorest: Sender#DoPollForStatusCodes failed to return error from polling")
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestWithLogging_Logs(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := log.New(buf, "autorest: ", 0)
+	client := mocks.NewSender()
+
+	r, _ := SendWithSender(client, &http.Request{},
+		WithLogging(logger))
+
+	if buf.String() == "" {
+		t.Fatal("autorest: Sender#WithLogging failed to log the request")
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestWithLogging_HandlesMissingResponse(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := log.New(buf, "autorest: ", 0)
+	client := mocks.NewSender()
+	client.AppendResponse(nil)
+	client.SetError(fmt.Errorf("Faux Error"))
+
+	r, err := SendWithSender(client, &http.Request{},
+		WithLogging(logger))
+
+	if r != nil || err == nil {
+		t.Fatal("autorest: Sender#WithLogging returned a valid response -- expecting nil")
+	}
+	if buf.String() == "" {
+		t.Fatal("autorest: Sender#WithLogging failed to log the request for a nil response")
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestWithLoggingRedactsSASSignature(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := log.New(buf, "autorest: ", 0)
+
+	r, _ := SendWithSender(mocks.NewSender(),
+		mocks.NewRequestWithParams(http.MethodGet, "https://account.blob.core.windows.net/c/b?sv=2019-02-02&sig=c2VjcmV0", nil),
+		WithLogging(logger))
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+
+	if strings.Contains(buf.String(), "c2VjcmV0") || !strings.Contains(buf.String(), "sig=REDACTED") {
+		t.Fatalf("autorest: Sender#WithLogging failed to redact the SAS signature -- %s", buf.String())
+	}
+}
+
+func TestWithSanitizedLogging(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := log.New(buf, "autorest: ", 0)
+
+	req := mocks.NewRequestWithParams(http.MethodGet, "https://microsoft.com/a?code=s3cr3t&api-version=1", nil)
+	req.Header.Set("Authorization", "Bearer t0ken")
+	req.Header.Set("x-ms-authorization-auxiliary", "Bearer aux")
+	req.Header.Set("X-Api-Key", "k3y")
+	req.Header.Set("X-Visible", "shown")
+	r, _ := SendWithSender(mocks.NewSender(), req,
+		WithSanitizedLogging(logger, "x-api-key", "code"))
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+
+	out := buf.String()
+	for _, secret := range []string{"t0ken", "aux", "k3y", "s3cr3t"} {
+		if strings.Contains(out, secret) {
+			t.Fatalf("autorest: Sender#WithSanitizedLogging logged %q -- %s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "X-Visible: shown") || !strings.Contains(out, "Authorization: **REDACTED**") {
+		t.Fatalf("autorest: Sender#WithSanitizedLogging failed to log the request headers -- %s", out)
+	}
+}
+
+func TestDoDump(t *testing.T) {
+	buf := &bytes.Buffer{}
+	var sent string
+	client := SenderFunc(func(r *http.Request) (*http.Response, error) {
+		b, _ := io.ReadAll(r.Body)
+		sent = string(b)
+		resp := mocks.NewResponseWithContent(`{"name":"Rob Pike","age":42}`)
+		resp.Request = r
+		return resp, nil
+	})
+
+	req := mocks.NewRequestWithParams(http.MethodPut, "https://microsoft.com/a", strings.NewReader(`{"name":"Gopher"}`))
+	req.Header.Set("Authorization", "Bearer t0ken")
+	r, err := SendWithSender(client, req, DoDump(buf, 8))
+	if err != nil {
+		t.Fatalf("autorest: DoDump returned an unexpected error (%v)", err)
+	}
+	b, _ := io.ReadAll(r.Body)
+	r.Body.Close()
+
+	if sent != `{"name":"Gopher"}` {
+		t.Fatalf("autorest: DoDump failed to restore the request body, sent %q", sent)
+	}
+	if string(b) != `{"name":"Rob Pike","age":42}` {
+		t.Fatalf("autorest: DoDump failed to restore the response body, got %q", b)
+	}
+	out := buf.String()
+	for _, expected := range []string{"PUT /a HTTP/1.1", `{"name":`, "body truncated after 8 bytes", "200 OK", "Authorization: **REDACTED**"} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("autorest: DoDump output is missing %q -- %s", expected, out)
+		}
+	}
+	if strings.Contains(out, "Gopher") || strings.Contains(out, "t0ken") {
+		t.Fatalf("autorest: DoDump wrote more than expected -- %s", out)
+	}
+}
+
+func TestDoDumpRedactsQueryAndLeavesRequestBody(t *testing.T) {
+	buf := &bytes.Buffer{}
+	body := strings.NewReader("Hello Gopher")
+	req := mocks.NewRequestWithParams(http.MethodPut, "https://microsoft.com/a?sv=2019-02-02&sig=s3cret", body)
+	original := req.Body
+	var sent string
+	client := SenderFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Body != original {
+			t.Fatal("autorest: DoDump replaced the request body")
+		}
+		b, _ := io.ReadAll(r.Body)
+		sent = string(b)
+		return mocks.NewResponse(), nil
+	})
+	if _, err := SendWithSender(client, req, DoDump(buf, -1)); err != nil {
+		t.Fatalf("autorest: DoDump returned an unexpected error (%v)", err)
+	}
+	if sent != "Hello Gopher" {
+		t.Fatalf("autorest: DoDump consumed the request body, sent %q", sent)
+	}
+	out := buf.String()
+	if strings.Contains(out, "s3cret") {
+		t.Fatalf("autorest: DoDump leaked a redacted query parameter -- %s", out)
+	}
+	if !strings.Contains(out, "sig=REDACTED") || !strings.Contains(out, "Hello Gopher") {
+		t.Fatalf("autorest: DoDump output is missing the redacted URL or body -- %s", out)
+	}
+}
+
+func TestDoRetryForStatusCodesWithSuccess(t *testing.T) {
+	client := mocks.NewSender()
+	client.AppendAndRepeatResponse(mocks.NewResponseWithStatus("408 Request Timeout", http.StatusRequestTimeout), 2)
+	client.AppendResponse(mocks.NewResponseWithStatus("200 OK", http.StatusOK))
+
+	r, _ := SendWithSender(client, mocks.NewRequest(),
+		DoRetryForStatusCodes(5, time.Duration(2*time.Second), http.StatusRequestTimeout),
+	)
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+
+	if client.Attempts() != 3 {
+		t.Fatalf("autorest: Sender#DoRetryForStatusCodes -- Got: StatusCode %v in %v attempts; Want: StatusCode 200 OK in 2 attempts -- ",
+			r.Status, client.Attempts()-1)
+	}
+}
+
+func TestDoRetryForStatusCodesWithNoSuccess(t *testing.T) {
+	client := mocks.NewSender()
+	client.AppendAndRepeatResponse(mocks.NewResponseWithStatus("504 Gateway Timeout", http.StatusGatewayTimeout), 5)
 
 	r, _ := SendWithSender(client, mocks.NewRequest(),
 		DoRetryForStatusCodes(2, time.Duration(2*time.Second), http.StatusGatewayTimeout),
@@ -756,6 +1368,26 @@ func TestDoRetryForStatusCodesWithNoSuccess(t *testing.T) {
 	}
 }
 
+func TestDoRetryForStatusCodesDefaultsToStatusCodesForRetry(t *testing.T) {
+	client := mocks.NewSender()
+	client.AppendResponse(mocks.NewResponseWithStatus("503 Service Unavailable", http.StatusServiceUnavailable))
+	client.AppendResponse(mocks.NewResponseWithStatus("502 Bad Gateway", http.StatusBadGateway))
+	client.AppendResponse(mocks.NewResponseWithStatus("200 OK", http.StatusOK))
+
+	r, _ := SendWithSender(client, mocks.NewRequest(),
+		DoRetryForStatusCodes(5, time.Duration(0)),
+	)
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+
+	if client.Attempts() != 3 || r.StatusCode != http.StatusOK {
+		t.Fatalf("autorest: Sender#DoRetryForStatusCodes -- Got: StatusCode %v in %v attempts; Want: StatusCode 200 OK in 3 attempts",
+			r.Status, client.Attempts())
+	}
+}
+
 func TestDoRetryForStatusCodes_CodeNotInRetryList(t *testing.T) {
 	client := mocks.NewSender()
 	client.AppendAndRepeatResponse(mocks.NewResponseWithStatus("204 No Content", http.StatusNoContent), 1)
@@ -801,7 +1433,8 @@ func TestDelayWithRetryAfterWithSuccess(t *testing.T) {
 	Count429AsRetry = false
 	defer func() { Count429AsRetry = true }()
 	after, retries := 2, 2
-	totalSecs := after * retries
+	// no delay follows the final attempt
+	totalSecs := after * (retries - 1)
 
 	client := mocks.NewSender()
 	resp := mocks.NewResponseWithStatus("429 Too many requests", http.StatusTooManyRequests)
@@ -834,7 +1467,8 @@ func TestDelayWithRetryAfterWithSuccess(t *testing.T) {
 
 func TestDelayWithRetryAfterWithFail(t *testing.T) {
 	after, retries := 2, 2
-	totalSecs := after * retries
+	// no delay follows the final attempt
+	totalSecs := after * (retries - 1)
 
 	client := mocks.NewSender()
 	resp := mocks.NewResponseWithStatus("429 Too many requests", http.StatusTooManyRequests)
@@ -851,6 +1485,9 @@ func TestDelayWithRetryAfterWithFail(t *testing.T) {
 	if time.Since(start) < d {
 		t.Fatal("autorest: DelayWithRetryAfter failed stopped too soon")
 	}
+	if time.Since(start) >= d+time.Duration(after)*time.Second {
+		t.Fatal("autorest: DelayWithRetryAfter failed delayed after the final attempt")
+	}
 
 	Respond(r,
 		ByDiscardingBody(),
@@ -1058,3 +1695,699 @@ func TestWithSendDecorators(t *testing.T) {
 		t.Fatalf("expected length of one but got %d", l)
 	}
 }
+
+func TestSendWithSenderHostOverride(t *testing.T) {
+	var host string
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+	}))
+	defer s.Close()
+	_, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+
+	var dialed []string
+	transport := s.Client().Transport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+	client := &http.Client{Transport: transport}
+
+	// example.com is in the test server's certificate but must not be resolved
+	override := WithHostOverride("127.0.0.1")
+	for i := 0; i < 2; i++ {
+		r, err := Prepare(mocks.NewRequestWithParams(http.MethodGet, "https://example.com:"+port+"/", nil),
+			override)
+		if err != nil {
+			t.Fatalf("autorest: WithHostOverride failed (%v)", err)
+		}
+		resp, err := SendWithSender(client, r)
+		if err != nil {
+			t.Fatalf("autorest: SendWithSender failed to honor the host override (%v)", err)
+		}
+		Respond(resp, ByDiscardingBody(), ByClosing())
+		if expected := "example.com:" + port; host != expected {
+			t.Fatalf("autorest: SendWithSender sent Host %q, expected %q", host, expected)
+		}
+	}
+	// the connection made to the override host is reused by the second request
+	if len(dialed) != 1 || dialed[0] != "127.0.0.1:"+port {
+		t.Fatalf("autorest: SendWithSender dialed %v, expected a single dial to 127.0.0.1:%s", dialed, port)
+	}
+
+	// a request without the override must not reuse the connection made to the override host
+	dialed = nil
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		return nil, fmt.Errorf("faux dial error")
+	}
+	_, err := SendWithSender(client, mocks.NewRequestWithParams(http.MethodGet, "https://example.com:"+port+"/", nil))
+	if err == nil {
+		t.Fatal("autorest: SendWithSender reused the connection made to the override host")
+	}
+	if len(dialed) != 1 || dialed[0] != "example.com:"+port {
+		t.Fatalf("autorest: SendWithSender dialed %v, expected example.com:%s", dialed, port)
+	}
+}
+
+func TestHostOverrideTransportKeepsOverridePort(t *testing.T) {
+	var addr string
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, a string) (net.Conn, error) {
+			addr = a
+			return nil, fmt.Errorf("faux error")
+		},
+		Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: "proxy:3128"}),
+	}
+	client := &http.Client{Transport: transport}
+
+	r, _ := Prepare(mocks.NewRequestWithParams(http.MethodGet, "https://example.com/", nil), WithHostOverride("10.0.0.4:8443"))
+	SendWithSender(client, r)
+	if addr != "10.0.0.4:8443" {
+		t.Fatalf("autorest: SendWithSender dialed %q, expected %q", addr, "10.0.0.4:8443")
+	}
+
+	// requests with an override bypass the proxy
+	r, _ = Prepare(mocks.NewRequestWithParams(http.MethodGet, "https://example.com/", nil), WithHostOverride("10.0.0.4"))
+	SendWithSender(client, r)
+	if addr != "10.0.0.4:443" {
+		t.Fatalf("autorest: SendWithSender dialed %q, expected %q", addr, "10.0.0.4:443")
+	}
+}
+
+func TestSendWithContextCancelsRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		decorator SendDecorator
+		err       error
+	}{
+		{"DoRetryForAttempts", DoRetryForAttempts(5, 0), fmt.Errorf("Faux Error")},
+		{"DoRetryForDuration", DoRetryForDuration(time.Minute, 0), fmt.Errorf("Faux Error")},
+		{"DoRetryForStatusCodes", DoRetryForStatusCodes(5, 0, http.StatusInternalServerError), nil},
+		{"DoRetryForStatusCodesWithCap", DoRetryForStatusCodesWithCap(5, 0, 0, http.StatusInternalServerError), nil},
+		{"DoPollForStatusCodes", DoPollForStatusCodes(time.Minute, 0, http.StatusInternalServerError), nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			attempts := 0
+			s := SenderFunc(func(r *http.Request) (*http.Response, error) {
+				attempts++
+				cancel()
+				resp := mocks.NewResponseWithStatus("500 InternalServerError", http.StatusInternalServerError)
+				resp.Request = r
+				mocks.SetResponseHeader(resp, "Location", "https://microsoft.com/a/b/c/")
+				return resp, test.err
+			})
+			_, err := SendWithContext(ctx, s, mocks.NewRequest(), test.decorator)
+			if err != context.Canceled {
+				t.Fatalf("autorest: %s returned %v, expected %v", test.name, err, context.Canceled)
+			}
+			if attempts != 1 {
+				t.Fatalf("autorest: %s made %d attempts after the context was canceled", test.name, attempts)
+			}
+		})
+	}
+}
+
+func TestDelayForBackoffPrefersCancel(t *testing.T) {
+	cancel := make(chan struct{})
+	close(cancel)
+	for i := 0; i < 100; i++ {
+		if DelayForBackoff(0, 0, cancel) {
+			t.Fatal("autorest: DelayForBackoff ignored a closed channel")
+		}
+	}
+}
+
+func TestDelayWithRetryAfterCappedByMaxRetryAfterDelay(t *testing.T) {
+	MaxRetryAfterDelay = 10 * time.Millisecond
+	defer func() { MaxRetryAfterDelay = 0 }()
+
+	resp := mocks.NewResponseWithStatus("429 Too many requests", http.StatusTooManyRequests)
+	mocks.SetResponseHeader(resp, HeaderRetryAfter, "60")
+
+	start := time.Now()
+	if !DelayWithRetryAfter(resp, nil) {
+		t.Fatal("autorest: DelayWithRetryAfter failed to delay")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("autorest: DelayWithRetryAfter ignored MaxRetryAfterDelay")
+	}
+}
+
+func TestDoRetryForAttemptsHonorsRetryAfter(t *testing.T) {
+	client := mocks.NewSender()
+	resp := mocks.NewResponseWithStatus("503 Service Unavailable", http.StatusServiceUnavailable)
+	mocks.SetResponseHeader(resp, HeaderRetryAfterMs, "200")
+	client.AppendResponse(resp)
+	client.SetError(fmt.Errorf("Faux Error"))
+	client.SetEmitErrorAfter(0)
+
+	start := time.Now()
+	r, _ := SendWithSender(client, mocks.NewRequest(),
+		DoRetryForAttempts(2, time.Duration(0)))
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+
+	if time.Since(start) < 200*time.Millisecond {
+		t.Fatal("autorest: DoRetryForAttempts failed to honor the Retry-After delay")
+	}
+	if client.Attempts() != 2 {
+		t.Fatalf("autorest: DoRetryForAttempts made %d attempts, expected 2", client.Attempts())
+	}
+}
+
+func TestDoRateLimit(t *testing.T) {
+	client := mocks.NewSender()
+	limit := DoRateLimit(20, 2)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		r, err := SendWithSender(client, mocks.NewRequest(), limit)
+		if err != nil {
+			t.Fatalf("autorest: DoRateLimit returned an unexpected error (%v)", err)
+		}
+		Respond(r,
+			ByDiscardingBody(),
+			ByClosing())
+	}
+	// the burst covers two requests, the other two wait 50ms each
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("autorest: DoRateLimit sent 4 requests in %v, expected at least 100ms", elapsed)
+	}
+	if client.Attempts() != 4 {
+		t.Fatalf("autorest: DoRateLimit made %d attempts, expected 4", client.Attempts())
+	}
+}
+
+func TestDoRateLimitCanBeCancelled(t *testing.T) {
+	client := mocks.NewSender()
+	limit := DoRateLimit(0.01, 1)
+	r, _ := SendWithSender(client, mocks.NewRequest(), limit)
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := SendWithContext(ctx, client, mocks.NewRequest(), limit)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("autorest: DoRateLimit returned %v, expected %v", err, context.DeadlineExceeded)
+	}
+	if client.Attempts() != 1 {
+		t.Fatalf("autorest: DoRateLimit made %d attempts, expected 1", client.Attempts())
+	}
+}
+
+func TestDoRetryForStatusCodesReusesConnections(t *testing.T) {
+	attempts := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":{"code":"ServerBusy","message":"try again"}}`))
+		}
+	}))
+	defer s.Close()
+
+	reused := 0
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				reused++
+			}
+		},
+	})
+	r, err := SendWithContext(ctx, s.Client(), mocks.NewRequestWithParams(http.MethodGet, s.URL, nil),
+		DoRetryForStatusCodes(3, 0, http.StatusServiceUnavailable))
+	if err != nil {
+		t.Fatalf("autorest: DoRetryForStatusCodes returned an unexpected error (%v)", err)
+	}
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+	if attempts != 3 || reused != 2 {
+		t.Fatalf("autorest: DoRetryForStatusCodes reused %d connections over %d attempts, expected 2 over 3", reused, attempts)
+	}
+}
+
+func TestWithRetryCallback(t *testing.T) {
+	client := mocks.NewSender()
+	busy := mocks.NewResponseWithStatus("503 Service Unavailable", http.StatusServiceUnavailable)
+	mocks.SetResponseHeader(busy, HeaderRetryAfterMs, "20")
+	client.AppendResponse(busy)
+	client.AppendResponse(mocks.NewResponseWithStatus("502 Bad Gateway", http.StatusBadGateway))
+	client.AppendResponse(mocks.NewResponseWithStatus("200 OK", http.StatusOK))
+
+	type retry struct {
+		attempt int
+		code    int
+		delay   time.Duration
+	}
+	var retries []retry
+	ctx := WithRetryCallback(context.Background(), func(attempt int, resp *http.Response, err error, delay time.Duration) {
+		retries = append(retries, retry{attempt, resp.StatusCode, delay})
+	})
+
+	r, err := SendWithContext(ctx, client, mocks.NewRequest(),
+		DoRetryForStatusCodes(3, 0, http.StatusServiceUnavailable, http.StatusBadGateway))
+	if err != nil {
+		t.Fatalf("autorest: DoRetryForStatusCodes returned an unexpected error (%v)", err)
+	}
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+
+	expected := []retry{
+		{1, http.StatusServiceUnavailable, 20 * time.Millisecond},
+		{2, http.StatusBadGateway, 0},
+	}
+	if !reflect.DeepEqual(retries, expected) {
+		t.Fatalf("autorest: WithRetryCallback observed %v, expected %v", retries, expected)
+	}
+}
+
+func TestWithRetryCallbackSkipsFinalAttempt(t *testing.T) {
+	for name, d := range map[string]SendDecorator{
+		"DoRetryForAttempts":    DoRetryForAttempts(3, time.Hour),
+		"DoRetryForStatusCodes": DoRetryForStatusCodes(2, time.Hour, http.StatusServiceUnavailable),
+	} {
+		t.Run(name, func(t *testing.T) {
+			fc := withFakeClock(t)
+			start := fc.Now()
+			client := mocks.NewSender()
+			client.AppendAndRepeatResponse(mocks.NewResponseWithStatus("503 Service Unavailable", http.StatusServiceUnavailable), 3)
+			client.SetAndRepeatError(fmt.Errorf("Faux Error"), 3)
+
+			retries := 0
+			ctx := WithRetryCallback(context.Background(), func(attempt int, resp *http.Response, err error, delay time.Duration) {
+				retries++
+			})
+			r, _ := SendWithContext(ctx, client, mocks.NewRequest(), d)
+			Respond(r,
+				ByDiscardingBody(),
+				ByClosing())
+
+			if client.Attempts() != 3 {
+				t.Fatalf("autorest: %s made %d attempts, expected 3", name, client.Attempts())
+			}
+			if retries != client.Attempts()-1 {
+				t.Fatalf("autorest: %s reported %d retries for %d attempts", name, retries, client.Attempts())
+			}
+			if elapsed := fc.Now().Sub(start); elapsed != 3*time.Hour {
+				t.Fatalf("autorest: %s delayed for %v, expected %v", name, elapsed, 3*time.Hour)
+			}
+		})
+	}
+}
+
+func TestWithRetryCallbackCanAbort(t *testing.T) {
+	client := mocks.NewSender()
+	client.SetAndRepeatError(fmt.Errorf("Faux Error"), 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = WithRetryCallback(ctx, func(attempt int, resp *http.Response, err error, delay time.Duration) {
+		if attempt == 2 {
+			cancel()
+		}
+	})
+
+	_, err := SendWithContext(ctx, client, mocks.NewRequest(),
+		DoRetryForAttempts(5, 0))
+	if err != context.Canceled {
+		t.Fatalf("autorest: DoRetryForAttempts returned %v, expected %v", err, context.Canceled)
+	}
+	if client.Attempts() != 2 {
+		t.Fatalf("autorest: DoRetryForAttempts made %d attempts, expected 2", client.Attempts())
+	}
+}
+
+func TestDoFailover(t *testing.T) {
+	var hosts []string
+	s := SenderFunc(func(r *http.Request) (*http.Response, error) {
+		hosts = append(hosts, r.URL.Host)
+		if r.URL.Host == "secondary.example.com" {
+			return mocks.NewResponseWithStatus("200 OK", http.StatusOK), nil
+		}
+		return mocks.NewResponseWithStatus("503 Service Unavailable", http.StatusServiceUnavailable), nil
+	})
+
+	r, err := SendWithSender(s, mocks.NewRequestWithParams(http.MethodGet, "https://primary.example.com/path", nil),
+		DoFailover([]string{"secondary.example.com", "tertiary.example.com"}, nil))
+	if err != nil {
+		t.Fatalf("autorest: DoFailover returned an unexpected error (%v)", err)
+	}
+	if r.StatusCode != http.StatusOK {
+		t.Fatalf("autorest: DoFailover returned an unexpected status code -- expected %v, received %v", http.StatusOK, r.StatusCode)
+	}
+	if expected := []string{"primary.example.com", "secondary.example.com"}; !reflect.DeepEqual(hosts, expected) {
+		t.Fatalf("autorest: DoFailover sent to unexpected hosts -- expected %v, received %v", expected, hosts)
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestDoFailoverReturnsLastFailure(t *testing.T) {
+	client := mocks.NewSender()
+	client.SetAndRepeatError(fmt.Errorf("Faux Error"), -1)
+
+	r, err := SendWithSender(client, mocks.NewRequest(),
+		DoFailover([]string{"secondary.example.com", "tertiary.example.com"}, nil))
+	if err == nil {
+		t.Fatal("autorest: DoFailover failed to return the last error")
+	}
+	if client.Attempts() != 3 {
+		t.Fatalf("autorest: DoFailover made an unexpected number of attempts -- expected 3, actual %v", client.Attempts())
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestDoFailoverUsesClassify(t *testing.T) {
+	client := mocks.NewSender()
+	client.AppendAndRepeatResponse(mocks.NewResponseWithStatus("503 Service Unavailable", http.StatusServiceUnavailable), 3)
+
+	r, err := SendWithSender(client, mocks.NewRequest(),
+		DoFailover([]string{"secondary.example.com"}, func(resp *http.Response, err error) bool {
+			return err != nil
+		}))
+	if err != nil {
+		t.Fatalf("autorest: DoFailover returned an unexpected error (%v)", err)
+	}
+	if client.Attempts() != 1 {
+		t.Fatalf("autorest: DoFailover failed over despite classify -- attempts %v", client.Attempts())
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestNewSenderDefaults(t *testing.T) {
+	c := NewSender()
+	transport := c.Transport.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != 10 || transport.MaxIdleConns != 100 || !transport.ForceAttemptHTTP2 {
+		t.Fatalf("autorest: NewSender created a transport with unexpected connection settings -- %+v", transport)
+	}
+	if transport.Proxy == nil || transport.TLSClientConfig.MinVersion != tls.VersionTLS12 {
+		t.Fatal("autorest: NewSender created a transport without a proxy or minimum TLS version")
+	}
+	if c.Jar == nil || c.Timeout != 0 {
+		t.Fatalf("autorest: NewSender created a client with unexpected settings -- %+v", c)
+	}
+}
+
+func TestNewSenderOptions(t *testing.T) {
+	c := NewSender(
+		WithSenderTimeout(time.Minute),
+		WithSenderTLSHandshakeTimeout(5*time.Second),
+		WithSenderResponseHeaderTimeout(20*time.Second),
+		WithSenderIdleConnTimeout(time.Second),
+		WithSenderMaxIdleConns(7),
+		WithSenderMaxIdleConnsPerHost(3),
+		WithSenderHTTP2(false),
+		WithSenderProxy(nil),
+		WithSenderRenegotiation(tls.RenegotiateOnceAsClient),
+		WithSenderCookieJar(nil))
+	transport := c.Transport.(*http.Transport)
+	if c.Timeout != time.Minute || c.Jar != nil {
+		t.Fatalf("autorest: NewSender ignored the client options -- %+v", c)
+	}
+	if transport.TLSHandshakeTimeout != 5*time.Second || transport.ResponseHeaderTimeout != 20*time.Second ||
+		transport.IdleConnTimeout != time.Second || transport.MaxIdleConns != 7 || transport.MaxIdleConnsPerHost != 3 ||
+		transport.ForceAttemptHTTP2 || transport.TLSClientConfig.Renegotiation != tls.RenegotiateOnceAsClient {
+		t.Fatalf("autorest: NewSender ignored the transport options -- %+v", transport)
+	}
+	if u, err := transport.Proxy(mocks.NewRequest()); u != nil || err != nil {
+		t.Fatalf("autorest: NewSender ignored the proxy option -- received %v, %v", u, err)
+	}
+}
+
+func TestNewSenderSendsRequests(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer s.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, s.URL, nil)
+	r, err := SendWithSender(NewSender(WithSenderDialTimeout(time.Second), WithSenderKeepAlive(time.Second)), req)
+	if err != nil {
+		t.Fatalf("autorest: NewSender returned an unexpected error (%v)", err)
+	}
+	if r.StatusCode != http.StatusNoContent {
+		t.Fatalf("autorest: NewSender returned an unexpected status code -- expected %v, received %v", http.StatusNoContent, r.StatusCode)
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func newTestClientCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("autorest: failed to generate a key (%v)", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "autorest-test-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("autorest: failed to create a certificate (%v)", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestNewSenderClientCertificateAndRootCAs(t *testing.T) {
+	var clientCN string
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			clientCN = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	s.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	s.StartTLS()
+	defer s.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, s.URL, nil)
+	if r, err := SendWithSender(NewSender(), req); err == nil {
+		Respond(r, ByDiscardingBody(), ByClosing())
+		t.Fatal("autorest: NewSender trusted an unknown certificate authority")
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, s.URL, nil)
+	r, err := SendWithSender(NewSender(
+		WithSenderRootCAs(s.Certificate()),
+		WithSenderClientCertificate(newTestClientCertificate(t))), req)
+	if err != nil {
+		t.Fatalf("autorest: NewSender returned an unexpected error (%v)", err)
+	}
+	if clientCN != "autorest-test-client" {
+		t.Fatalf("autorest: NewSender failed to present the client certificate -- received %q", clientCN)
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestDoWithProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	c := NewSender(WithSenderProxy(nil))
+	r, err := SendWithSender(c, mocks.NewRequestWithParams(http.MethodGet, "http://data.example.com/blob", nil),
+		DoIf(func(r *http.Request) bool {
+			return r.URL.Host == "data.example.com"
+		}, DoWithProxy(proxyURL)))
+	if err != nil {
+		t.Fatalf("autorest: DoWithProxy returned an unexpected error (%v)", err)
+	}
+	if proxied != "http://data.example.com/blob" {
+		t.Fatalf("autorest: DoWithProxy failed to route the request through the proxy -- received %q", proxied)
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestProxyWithOverride(t *testing.T) {
+	configured, _ := url.Parse("http://configured.example.com:8080")
+	override, _ := url.Parse("http://override.example.com:8080")
+	proxy := ProxyWithOverride(http.ProxyURL(configured))
+
+	if u, _ := proxy(mocks.NewRequest()); u != configured {
+		t.Fatalf("autorest: ProxyWithOverride returned an unexpected proxy -- expected %v, received %v", configured, u)
+	}
+	for _, expected := range []*url.URL{override, nil} {
+		var seen *url.URL
+		_, _ = SendWithSender(SenderFunc(func(r *http.Request) (*http.Response, error) {
+			seen, _ = proxy(r)
+			return mocks.NewResponse(), nil
+		}), mocks.NewRequest(), DoWithProxy(expected))
+		if seen != expected {
+			t.Fatalf("autorest: ProxyWithOverride ignored DoWithProxy -- expected %v, received %v", expected, seen)
+		}
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	fc := withFakeClock(t)
+	rb := NewRetryBudget(1, 2)
+	if !rb.Allow() || !rb.Allow() {
+		t.Fatal("autorest: RetryBudget denied a retry within its burst")
+	}
+	if rb.Allow() {
+		t.Fatal("autorest: RetryBudget allowed a retry beyond its burst")
+	}
+	fc.Advance(time.Second)
+	if !rb.Allow() {
+		t.Fatal("autorest: RetryBudget failed to refill")
+	}
+}
+
+func TestRetryBudgetLimitsRetries(t *testing.T) {
+	withFakeClock(t)
+	rb := NewRetryBudget(0.001, 2)
+	for _, tc := range []struct {
+		name      string
+		decorator SendDecorator
+	}{
+		{"DoRetryForAttempts", DoRetryForAttempts(5, 0)},
+		{"DoRetryForStatusCodes", DoRetryForStatusCodes(5, 0, http.StatusServiceUnavailable)},
+		{"DoRetryForDuration", DoRetryForDuration(time.Hour, 0)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rb.tb.tokens = 2
+			client := mocks.NewSender()
+			client.SetAndRepeatError(fmt.Errorf("Faux Error"), -1)
+
+			req := mocks.NewRequest()
+			req = req.WithContext(WithRetryBudget(req.Context(), rb))
+			r, err := SendWithSender(client, req, tc.decorator)
+			if err == nil {
+				t.Fatal("autorest: Mock client failed to emit errors")
+			}
+			if client.Attempts() != 3 {
+				t.Fatalf("autorest: %s made an unexpected number of attempts -- expected 3, actual %v", tc.name, client.Attempts())
+			}
+
+			Respond(r,
+				ByDiscardingBody(),
+				ByClosing())
+		})
+	}
+}
+
+func TestRetryBudgetNotConsumedByLastAttempt(t *testing.T) {
+	rb := NewRetryBudget(0.001, 5)
+	client := mocks.NewSender()
+	client.SetAndRepeatError(fmt.Errorf("Faux Error"), -1)
+
+	req := mocks.NewRequest()
+	req = req.WithContext(WithRetryBudget(req.Context(), rb))
+	r, _ := SendWithSender(client, req, DoRetryForAttempts(3, 0))
+	if client.Attempts() != 3 {
+		t.Fatalf("autorest: DoRetryForAttempts made an unexpected number of attempts -- expected 3, actual %v", client.Attempts())
+	}
+	if remaining := int(rb.tb.tokens); remaining != 3 {
+		t.Fatalf("autorest: RetryBudget has an unexpected number of tokens -- expected 3, actual %v", remaining)
+	}
+
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+}
+
+func TestDoThrottle(t *testing.T) {
+	fc := withFakeClock(t)
+	ts := NewThrottleState()
+	client := mocks.NewSender()
+	throttled := mocks.NewResponseWithStatus("429 Too Many Requests", http.StatusTooManyRequests)
+	mocks.SetResponseHeader(throttled, HeaderRetryAfter, "30")
+	client.AppendResponse(throttled)
+	client.AppendAndRepeatResponse(mocks.NewResponseWithStatus("200 OK", http.StatusOK), 2)
+
+	r, err := SendWithSender(client, mocks.NewRequest(), DoThrottle(ts))
+	if err != nil {
+		t.Fatalf("autorest: DoThrottle returned an unexpected error (%v)", err)
+	}
+	Respond(r, ByDiscardingBody(), ByClosing())
+	if d := ts.Wait("microsoft.com"); d != 30*time.Second {
+		t.Fatalf("autorest: DoThrottle recorded an unexpected wait -- expected %v, received %v", 30*time.Second, d)
+	}
+	if d := ts.Wait("example.com"); d != 0 {
+		t.Fatalf("autorest: DoThrottle throttled an unrelated host for %v", d)
+	}
+
+	start := fc.Now()
+	r, err = SendWithSender(client, mocks.NewRequest(), DoThrottle(ts))
+	if err != nil {
+		t.Fatalf("autorest: DoThrottle returned an unexpected error (%v)", err)
+	}
+	Respond(r, ByDiscardingBody(), ByClosing())
+	if elapsed := fc.Now().Sub(start); elapsed != 30*time.Second {
+		t.Fatalf("autorest: DoThrottle waited an unexpected duration -- expected %v, received %v", 30*time.Second, elapsed)
+	}
+
+	start = fc.Now()
+	r, _ = SendWithSender(client, mocks.NewRequest(), DoThrottle(ts))
+	Respond(r, ByDiscardingBody(), ByClosing())
+	if elapsed := fc.Now().Sub(start); elapsed != 0 {
+		t.Fatalf("autorest: DoThrottle waited after the throttling interval -- %v", elapsed)
+	}
+}
+
+func TestDoThrottleZeroValue(t *testing.T) {
+	withFakeClock(t)
+	ts := &ThrottleState{}
+	client := mocks.NewSender()
+	throttled := mocks.NewResponseWithStatus("429 Too Many Requests", http.StatusTooManyRequests)
+	mocks.SetResponseHeader(throttled, HeaderRetryAfter, "30")
+	client.AppendResponse(throttled)
+
+	r, err := SendWithSender(client, mocks.NewRequest(), DoThrottle(ts))
+	if err != nil {
+		t.Fatalf("autorest: DoThrottle returned an unexpected error (%v)", err)
+	}
+	Respond(r, ByDiscardingBody(), ByClosing())
+	if d := ts.Wait("microsoft.com"); d != 30*time.Second {
+		t.Fatalf("autorest: DoThrottle recorded an unexpected wait -- expected %v, received %v", 30*time.Second, d)
+	}
+}
+
+func TestDoThrottleCancels(t *testing.T) {
+	ts := NewThrottleState()
+	ts.throttle("microsoft.com", time.Hour)
+	client := mocks.NewSender()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := SendWithContext(ctx, client, mocks.NewRequest(), DoThrottle(ts))
+	if err != context.Canceled {
+		t.Fatalf("autorest: DoThrottle returned an unexpected error -- expected %v, received %v", context.Canceled, err)
+	}
+	if client.Attempts() != 0 {
+		t.Fatal("autorest: DoThrottle sent a request while throttled")
+	}
+}
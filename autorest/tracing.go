@@ -0,0 +1,67 @@
+package autorest
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/Azure/go-autorest/tracing"
+)
+
+// used as a key type in context.WithValue()
+type ctxTraced struct{}
+
+// tracedTransports holds the instrumented transports used for requests traced by DoTracing, keyed
+// by the underlying http.Transport.
+var tracedTransports sync.Map
+
+// DoTracing returns a SendDecorator that starts a span, using the Tracer registered with the
+// github.com/Azure/go-autorest/tracing package, for each attempt to send a request, naming it after
+// the method and attempt number and ending it with the attempt's status code and error. Requests
+// sent by an http.Client over an http.Transport go through the Tracer's instrumented transport,
+// which injects the W3C traceparent header; senders created with NewSender are instrumented
+// already. DoTracing has no effect unless a Tracer is registered. SendWithSender applies its
+// decorators in order, so the first listed is innermost: list DoTracing before the retry decorators
+// to trace each attempt in its own span, or after them for one span covering every attempt.
+func DoTracing() SendDecorator {
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (*http.Response, error) {
+			if !tracing.IsEnabled() {
+				return s.Do(r)
+			}
+			ctx := tracing.StartSpan(r.Context(), fmt.Sprintf("github.com/Azure/go-autorest/autorest.DoTracing/%s/attempt-%d", r.Method, requestAttempt(r)))
+			resp, err := s.Do(r.Clone(context.WithValue(ctx, ctxTraced{}, true)))
+			code := 0
+			if resp != nil {
+				code = resp.StatusCode
+			}
+			tracing.EndSpan(ctx, code, err)
+			return resp, err
+		})
+	}
+}
+
+// tracedTransport returns the instrumented transport for the passed http.Transport, creating it on
+// first use.
+func tracedTransport(t *http.Transport) http.RoundTripper {
+	if rt, ok := tracedTransports.Load(t); ok {
+		return rt.(http.RoundTripper)
+	}
+	rt, _ := tracedTransports.LoadOrStore(t, tracing.NewTransport(t))
+	return rt.(http.RoundTripper)
+}
@@ -0,0 +1,127 @@
+package autorest
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/mocks"
+	"github.com/Azure/go-autorest/tracing"
+)
+
+type mockSpan struct {
+	name  string
+	id    int
+	code  int
+	err   error
+	ended bool
+}
+
+type ctxMockSpan struct{}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (rt roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return rt(r)
+}
+
+// mockTracer is a tracing.Tracer whose transport injects a traceparent for the span in the context.
+type mockTracer struct {
+	spans []*mockSpan
+}
+
+func (mt *mockTracer) NewTransport(base *http.Transport) http.RoundTripper {
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if span, ok := r.Context().Value(ctxMockSpan{}).(*mockSpan); ok {
+			r = r.Clone(r.Context())
+			r.Header.Set("traceparent", fmt.Sprintf("00-4bf92f3577b34da6a3ce929d0e0e4736-%016x-01", span.id))
+		}
+		return base.RoundTrip(r)
+	})
+}
+
+func (mt *mockTracer) StartSpan(ctx context.Context, name string) context.Context {
+	span := &mockSpan{name: name, id: len(mt.spans) + 1}
+	mt.spans = append(mt.spans, span)
+	return context.WithValue(ctx, ctxMockSpan{}, span)
+}
+
+func (mt *mockTracer) EndSpan(ctx context.Context, httpStatusCode int, err error) {
+	span := ctx.Value(ctxMockSpan{}).(*mockSpan)
+	span.code, span.err, span.ended = httpStatusCode, err, true
+}
+
+func TestDoTracing(t *testing.T) {
+	var traceparents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents = append(traceparents, r.Header.Get("traceparent"))
+		if len(traceparents) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	tracer := &mockTracer{}
+	tracing.Register(tracer)
+	defer tracing.Register(nil)
+
+	req := mocks.NewRequestWithParams(http.MethodGet, server.URL+"/a?sig=s3cr3t", nil)
+	r, err := SendWithSender(&http.Client{Transport: &http.Transport{}}, req,
+		DoTracing(),
+		DoRetryForStatusCodes(3, 0, http.StatusServiceUnavailable))
+	if err != nil {
+		t.Fatalf("autorest: DoTracing returned an unexpected error (%v)", err)
+	}
+	Respond(r,
+		ByDiscardingBody(),
+		ByClosing())
+
+	if len(tracer.spans) != 2 || len(traceparents) != 2 {
+		t.Fatalf("autorest: DoTracing started %d spans for %d attempts, expected 2", len(tracer.spans), len(traceparents))
+	}
+	for i, span := range tracer.spans {
+		if !span.ended || !strings.HasSuffix(span.name, fmt.Sprintf("GET/attempt-%d", i+1)) {
+			t.Fatalf("autorest: DoTracing recorded span %+v for attempt %d", span, i+1)
+		}
+		if expected := fmt.Sprintf("00-4bf92f3577b34da6a3ce929d0e0e4736-%016x-01", span.id); traceparents[i] != expected {
+			t.Fatalf("autorest: DoTracing sent traceparent %q for attempt %d, expected %q", traceparents[i], i+1, expected)
+		}
+	}
+	if code := tracer.spans[0].code; code != http.StatusServiceUnavailable {
+		t.Fatalf("autorest: DoTracing ended the first span with %d, expected 503", code)
+	}
+	if code := tracer.spans[1].code; code != http.StatusOK {
+		t.Fatalf("autorest: DoTracing ended the second span with %d, expected 200", code)
+	}
+	if req.Header.Get("traceparent") != "" {
+		t.Fatal("autorest: DoTracing modified the caller's request")
+	}
+}
+
+func TestDoTracingWithoutTracer(t *testing.T) {
+	client := mocks.NewSender()
+	r, err := SendWithSender(client, mocks.NewRequest(), DoTracing())
+	if err != nil {
+		t.Fatalf("autorest: DoTracing returned an unexpected error (%v)", err)
+	}
+	if r.StatusCode != http.StatusOK || client.Attempts() != 1 {
+		t.Fatalf("autorest: DoTracing changed the response without a registered Tracer")
+	}
+}
@@ -16,6 +16,7 @@ package autorest
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
@@ -204,6 +205,69 @@ func ChangeToGet(req *http.Request) *http.Request {
 	return req
 }
 
+// CloneRequest returns a deep copy of the passed http.Request suitable for sending on its own: the
+// headers, URL and context are copied and the clone receives its own reader over the request body.
+// If the http.Request has a body but no GetBody function, GetBody is set on the passed http.Request
+// so that it, and any further clones, can replay the body. A body implementing io.ReaderAt and
+// io.Seeker, like *bytes.Reader, *strings.Reader and *os.File, is replayed independently from its
+// current offset; any other body is read into memory once. An error is returned if the body cannot
+// be read.
+func CloneRequest(req *http.Request) (*http.Request, error) {
+	clone := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return clone, nil
+	}
+	if req.GetBody == nil {
+		getBody, err := replayableBody(req)
+		if err != nil {
+			return nil, err
+		}
+		req.GetBody = getBody
+		clone.GetBody = getBody
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, NewErrorWithError(err, "autorest", "CloneRequest", nil, "Failure replaying the request body")
+	}
+	clone.Body = body
+	return clone, nil
+}
+
+// replayableBody returns a GetBody function for the body of the passed http.Request, returning a
+// new reader over the body from its current offset on each call. A body that cannot be read from
+// an offset is buffered and replaced on the http.Request.
+func replayableBody(req *http.Request) (func() (io.ReadCloser, error), error) {
+	rs, ok := req.Body.(interface {
+		io.ReaderAt
+		io.Seeker
+	})
+	if !ok {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, NewErrorWithError(err, "autorest", "CloneRequest", nil, "The request body cannot be replayed")
+		}
+		req.Body = io.NopCloser(bytes.NewReader(b))
+		return func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		}, nil
+	}
+	offset, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, NewErrorWithError(err, "autorest", "CloneRequest", nil, "Failure replaying the request body")
+	}
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = rs.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		return nil, NewErrorWithError(err, "autorest", "CloneRequest", nil, "Failure replaying the request body")
+	}
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(io.NewSectionReader(rs, offset, size-offset)), nil
+	}, nil
+}
+
 // IsTemporaryNetworkError returns true if the specified error is a temporary network error or false
 // if it's not.  If the error doesn't implement the net.Error interface the return value is true.
 func IsTemporaryNetworkError(err error) bool {
@@ -213,16 +277,35 @@ func IsTemporaryNetworkError(err error) bool {
 	return false
 }
 
-// DrainResponseBody reads the response body then closes it.
+// MaxDrainBytes is the largest number of bytes DrainResponseBody reads from a response body before
+// closing it. Reading a body to its end lets the underlying connection be reused; bodies larger
+// than this are abandoned together with their connection rather than downloaded.
+var MaxDrainBytes int64 = 64 << 10
+
+// DrainResponseBody reads up to MaxDrainBytes of the response body then closes it.
 func DrainResponseBody(resp *http.Response) error {
 	if resp != nil && resp.Body != nil {
-		_, err := io.Copy(io.Discard, resp.Body)
+		_, err := io.CopyN(io.Discard, resp.Body, MaxDrainBytes)
 		resp.Body.Close()
+		if err == io.EOF {
+			err = nil
+		}
 		return err
 	}
 	return nil
 }
 
+// newUUID returns a random (version 4) UUID in its canonical string form.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
 func setHeader(r *http.Request, key, value string) {
 	if r.Header == nil {
 		r.Header = make(http.Header)
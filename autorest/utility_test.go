@@ -519,6 +519,155 @@ func (md *mockDrain) Close() error {
 	return nil
 }
 
+func TestCloneRequest(t *testing.T) {
+	req := mocks.NewRequestWithParams("PUT", "https://microsoft.com/a/b/c/", strings.NewReader("Hello Gopher"))
+	req.Header.Set("X-Foo", "bar")
+
+	clone, err := CloneRequest(req)
+	if err != nil {
+		t.Fatalf("autorest: CloneRequest failed with error (%v)", err)
+	}
+	clone.Header.Set("X-Foo", "baz")
+	clone.URL.Path = "/other"
+	if req.Header.Get("X-Foo") != "bar" || req.URL.Path == "/other" {
+		t.Fatal("autorest: CloneRequest returned a request sharing state with the original")
+	}
+
+	for _, r := range []*http.Request{clone, req} {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("autorest: CloneRequest failed with error (%v)", err)
+		}
+		if string(b) != "Hello Gopher" {
+			t.Fatalf("autorest: CloneRequest body was %q, expected %q", b, "Hello Gopher")
+		}
+	}
+
+	another, err := CloneRequest(req)
+	if err != nil {
+		t.Fatalf("autorest: CloneRequest failed with error (%v)", err)
+	}
+	if b, _ := io.ReadAll(another.Body); string(b) != "Hello Gopher" {
+		t.Fatalf("autorest: CloneRequest body was %q, expected %q", b, "Hello Gopher")
+	}
+}
+
+func TestCloneRequestWithoutBody(t *testing.T) {
+	clone, err := CloneRequest(mocks.NewRequestWithParams("GET", "https://microsoft.com/a/b/c/", nil))
+	if err != nil {
+		t.Fatalf("autorest: CloneRequest failed with error (%v)", err)
+	}
+	if clone.Body != nil {
+		t.Fatal("autorest: CloneRequest added a body to a request without one")
+	}
+}
+
+type seekableBody struct {
+	*strings.Reader
+	closed bool
+}
+
+func (sb *seekableBody) Close() error {
+	sb.closed = true
+	return nil
+}
+
+func TestCloneRequestSeekableBody(t *testing.T) {
+	sb := &seekableBody{Reader: strings.NewReader("skip:Hello Gopher")}
+	sb.Seek(int64(len("skip:")), io.SeekStart)
+	req := mocks.NewRequestWithParams("PUT", "https://microsoft.com/a/b/c/", sb)
+
+	for i := 0; i < 2; i++ {
+		clone, err := CloneRequest(req)
+		if err != nil {
+			t.Fatalf("autorest: CloneRequest failed with error (%v)", err)
+		}
+		b, _ := io.ReadAll(clone.Body)
+		clone.Body.Close()
+		if string(b) != "Hello Gopher" {
+			t.Fatalf("autorest: CloneRequest body was %q, expected %q", b, "Hello Gopher")
+		}
+	}
+	if sb.closed {
+		t.Fatal("autorest: CloneRequest closed the seekable body")
+	}
+}
+
+func TestCloneRequestSeekableBodiesAreIndependent(t *testing.T) {
+	sb := &seekableBody{Reader: strings.NewReader("Hello Gopher")}
+	req := mocks.NewRequestWithParams("PUT", "https://microsoft.com/a/b/c/", sb)
+
+	first, err := CloneRequest(req)
+	if err != nil {
+		t.Fatalf("autorest: CloneRequest failed with error (%v)", err)
+	}
+	second, err := CloneRequest(req)
+	if err != nil {
+		t.Fatalf("autorest: CloneRequest failed with error (%v)", err)
+	}
+	io.ReadAll(first.Body)
+	if b, _ := io.ReadAll(second.Body); string(b) != "Hello Gopher" {
+		t.Fatalf("autorest: CloneRequest body was %q, expected %q", b, "Hello Gopher")
+	}
+	if b, _ := io.ReadAll(req.Body); string(b) != "Hello Gopher" {
+		t.Fatalf("autorest: CloneRequest consumed the original body, read %q", b)
+	}
+}
+
+func TestCloneRequestBuffersBody(t *testing.T) {
+	req := mocks.NewRequestWithParams("PUT", "https://microsoft.com/a/b/c/", io.NopCloser(io.MultiReader(strings.NewReader("Hello Gopher"))))
+	first, err := CloneRequest(req)
+	if err != nil {
+		t.Fatalf("autorest: CloneRequest failed with error (%v)", err)
+	}
+	second, err := CloneRequest(req)
+	if err != nil {
+		t.Fatalf("autorest: CloneRequest failed with error (%v)", err)
+	}
+	for _, r := range []*http.Request{first, second, req} {
+		if b, _ := io.ReadAll(r.Body); string(b) != "Hello Gopher" {
+			t.Fatalf("autorest: CloneRequest body was %q, expected %q", b, "Hello Gopher")
+		}
+	}
+}
+
+type failingBody struct{}
+
+func (failingBody) Read([]byte) (int, error) { return 0, fmt.Errorf("faux error") }
+
+func TestCloneRequestUnreadableBody(t *testing.T) {
+	req := mocks.NewRequestWithParams("PUT", "https://microsoft.com/a/b/c/", io.NopCloser(failingBody{}))
+	if _, err := CloneRequest(req); err == nil {
+		t.Fatal("autorest: CloneRequest failed to return an error for a body that cannot be replayed")
+	}
+}
+
+func TestCloneRequestGetBodyError(t *testing.T) {
+	req := mocks.NewRequestWithContent("Hello Gopher")
+	req.GetBody = func() (io.ReadCloser, error) {
+		return nil, fmt.Errorf("faux error")
+	}
+	if _, err := CloneRequest(req); err == nil {
+		t.Fatal("autorest: CloneRequest failed to return an error for a body that cannot be replayed")
+	}
+}
+
+func TestDrainResponseBodyStopsAtMaxDrainBytes(t *testing.T) {
+	defer func(max int64) { MaxDrainBytes = max }(MaxDrainBytes)
+	MaxDrainBytes = 4
+
+	body := &seekableBody{Reader: strings.NewReader("Hello Gopher")}
+	if err := DrainResponseBody(&http.Response{Body: body}); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if !body.closed {
+		t.Fatal("DrainResponseBody failed to close the body")
+	}
+	if body.Len() != len("Hello Gopher")-4 {
+		t.Fatalf("DrainResponseBody read past MaxDrainBytes, %d bytes remained", body.Len())
+	}
+}
+
 func TestDrainResponseBody(t *testing.T) {
 	err := DrainResponseBody(nil)
 	if err != nil {